@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/xregistry/server/cmds/xr/xrlib"
@@ -12,6 +15,7 @@ import (
 var depth = 0
 var ConfigFile = EnvString("XR_CONFORM_CONFIG", "")
 var ShowLogs = EnvBool("XR_SHOWLOGS", false)
+var WriteTests = EnvBool("XR_CONFORM_WRITE", false)
 
 func conformFunc(cmd *cobra.Command, args []string) {
 	if len(args) > 0 {
@@ -34,6 +38,15 @@ func conformFunc(cmd *cobra.Command, args []string) {
 		WrapAt = 0
 	}
 
+	report, _ := cmd.Flags().GetString("report")
+	reportFile, _ := cmd.Flags().GetString("report-file")
+	if report != "" && !ArrayContains(ReportFormats, report) {
+		Error("--report must be one of: %s", strings.Join(ReportFormats, ", "))
+	}
+	if reportFile != "" && report == "" {
+		Error("--report-file requires --report")
+	}
+
 	runFunc, _ := cmd.Flags().GetString("run")
 	if runFunc == "" {
 		td.Run(TestRegistry)
@@ -51,12 +64,26 @@ func conformFunc(cmd *cobra.Command, args []string) {
 		td.Run(fn)
 	}
 
+	td.Duration = time.Since(td.Start)
+
 	// td.Dump("")
 	if depth <= 0 {
 		// Can't actually do zero, so zero = -1 (all)
 		depth = 9999999
 	}
-	td.Print(os.Stdout, "", ShowLogs, depth-1)
+
+	// A report w/o a file replaces the normal output
+	if report != "" && (reportFile == "" || reportFile == "-") {
+		Error(td.WriteReport(os.Stdout, report))
+	} else {
+		td.Print(os.Stdout, "", ShowLogs, depth-1)
+	}
+
+	if report != "" && reportFile != "" && reportFile != "-" {
+		buf := &bytes.Buffer{}
+		Error(td.WriteReport(buf, report))
+		Error(os.WriteFile(reportFile, buf.Bytes(), 0644))
+	}
 
 	if td.ExitCode() != 0 {
 		os.Exit(td.ExitCode())
@@ -75,8 +102,14 @@ func addConformCmd(parent *cobra.Command) {
 	conformCmd.Flags().IntVarP(&depth, "depth", "d", depth, "Console depth")
 	conformCmd.Flags().BoolVarP(&tdDebug, "tdDebug", "t", tdDebug, "td debug")
 	conformCmd.Flags().Bool("failfast", false, "Stop on first failure")
+	conformCmd.Flags().BoolVarP(&WriteTests, "write", "", WriteTests,
+		"Also run the tests that write (in a new scratch Registry)")
 	conformCmd.Flags().StringP("run", "r", "", "Run function")
 	conformCmd.Flags().BoolP("nowrap", "", false, "Don't wrap output")
+	conformCmd.Flags().String("report", "",
+		"Report format: "+strings.Join(ReportFormats, ", "))
+	conformCmd.Flags().String("report-file", "",
+		"Write the report to this file (\"-\" or none = stdout)")
 
 	conformCmd.Flags().MarkHidden("run")
 	conformCmd.Flags().MarkHidden("tdDebug")
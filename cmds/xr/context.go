@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xregistry/server/cmds/xr/xrlib"
+	. "github.com/xregistry/server/common"
+)
+
+// Contexts live in the config file as:
+// context: NAME                           # the current context
+// context.NAME.server.url: URL
+// context.NAME.registry: REGNAME          # adds "/reg-REGNAME" to the URL
+// context.NAME.header.HEADER: VALUE
+// context.NAME.auth.token: TOKEN          # "Authorization: Bearer TOKEN"
+// context.NAME.auth.user: USER            # Basic auth, with auth.password
+// context.NAME.auth.password: PASSWORD
+// context.NAME.auth.helper: CMD           # CMD's output is the token
+
+var CurrentContext = ""
+
+func addContextCmd(parent *cobra.Command) {
+	contextCmd := &cobra.Command{
+		Use:     "context",
+		Short:   "Manage named server contexts",
+		GroupID: "Admin",
+	}
+	contextCmd.Long = contextCmd.Short + "\n" + `
+A context is a named set of settings (server URL, registry name, HTTP headers
+and credentials) that are saved in the config file. The current one is used
+unless --context, XR_CONTEXT, --server or XR_SERVER say otherwise.`
+	parent.AddCommand(contextCmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the contexts",
+		Run:   contextListFunc,
+	}
+	contextCmd.AddCommand(listCmd)
+
+	useCmd := &cobra.Command{
+		Use:   "use NAME",
+		Short: "Make NAME the current context",
+		Run:   contextUseFunc,
+	}
+	contextCmd.AddCommand(useCmd)
+
+	setCmd := &cobra.Command{
+		Use:   "set NAME",
+		Short: "Create or update a context",
+		Run:   contextSetFunc,
+	}
+	setCmd.Long = setCmd.Short + "\n" + `
+Only the specified settings are changed. Use an empty value (e.g. --token "")
+to remove a setting. The credential helper's output is used as the token, or
+as the whole "Authorization" header value if it has more than one word.`
+	setCmd.Flags().String("url", "", "xRegistry server URL")
+	setCmd.Flags().String("registry", "",
+		"Name of the registry on the server (/reg-NAME)")
+	setCmd.Flags().StringArray("header", nil,
+		"HTTP header to include: NAME=VALUE")
+	setCmd.Flags().String("token", "", "Bearer token")
+	setCmd.Flags().String("user", "", "User name for basic auth")
+	setCmd.Flags().String("password", "", "Password for basic auth")
+	setCmd.Flags().String("credential-helper", "",
+		"Command that prints the token to use")
+	contextCmd.AddCommand(setCmd)
+
+	deleteCmd := &cobra.Command{
+		Use:   "delete NAME",
+		Short: "Delete a context",
+		Run:   contextDeleteFunc,
+	}
+	contextCmd.AddCommand(deleteCmd)
+}
+
+// GetContexts returns the (sorted) names of the contexts in the config
+func GetContexts() []string {
+	names := map[string]bool{}
+	for key := range UserConfig {
+		if rest, ok := strings.CutPrefix(key, "context."); ok {
+			if name, _, _ := strings.Cut(rest, "."); name != "" {
+				names[name] = true
+			}
+		}
+	}
+	return SortedKeys(names)
+}
+
+func GetContextConfig(context string, name string) string {
+	return GetConfig("context." + context + "." + name)
+}
+
+// UseContext sets CurrentContext to "context", or to the config file's
+// current one if it's empty
+func UseContext(context string) *XRError {
+	if context == "" {
+		context = GetConfig("context")
+	}
+	CurrentContext = ""
+	if context == "" {
+		return nil
+	}
+	if !ArrayContains(GetContexts(), context) {
+		return NewXRError("client_error", "",
+			"error_detail="+fmt.Sprintf("Unknown context: %s", context))
+	}
+	CurrentContext = context
+	return nil
+}
+
+// GetContextServer returns the full server URL of "context", including
+// its registry name, or "" if it doesn't have one
+func GetContextServer(context string) string {
+	if context == "" {
+		return ""
+	}
+	server := CleanServerURL(GetContextConfig(context, "server.url"))
+	if server == "" {
+		return ""
+	}
+	if regName := GetContextConfig(context, "registry"); regName != "" {
+		server = strings.TrimRight(server, "/") + "/reg-" + regName
+	}
+	return server
+}
+
+// AddContextAuth makes sure that all requests to the context's server
+// include its headers and credentials. If the context has no server then
+// they're not used at all, since we don't know who they're meant for.
+func AddContextAuth(context string) {
+	if context == "" {
+		return
+	}
+
+	server := GetContextServer(context)
+	if server == "" {
+		return
+	}
+
+	headers := map[string]string{}
+	prefix := "context." + context + ".header."
+	for key, value := range UserConfig {
+		if name, ok := strings.CutPrefix(key, prefix); ok && name != "" {
+			headers[name] = value
+		}
+	}
+
+	if token := GetContextConfig(context, "auth.token"); token != "" {
+		headers["Authorization"] = "Bearer " + token
+	} else if user := GetContextConfig(context, "auth.user"); user != "" {
+		headers["Authorization"] = "Basic " +
+			base64.StdEncoding.EncodeToString([]byte(user+":"+
+				GetContextConfig(context, "auth.password")))
+	}
+
+	xrlib.AddServerAuth(&xrlib.ServerAuth{
+		URL:     server,
+		Headers: headers,
+		Helper:  GetContextConfig(context, "auth.helper"),
+	})
+}
+
+// contextValue is for showing a context's setting, "-" when not set
+func contextValue(context string, name string) string {
+	if val := GetContextConfig(context, name); val != "" {
+		return val
+	}
+	return "-"
+}
+
+func contextAuthType(context string) string {
+	switch {
+	case GetContextConfig(context, "auth.helper") != "":
+		return "helper"
+	case GetContextConfig(context, "auth.token") != "":
+		return "token"
+	case GetContextConfig(context, "auth.user") != "":
+		return "basic"
+	}
+	return "-"
+}
+
+func contextListFunc(cmd *cobra.Command, args []string) {
+	if len(args) != 0 {
+		Error("Too many arguments")
+	}
+
+	contexts := GetContexts()
+	if len(contexts) == 0 {
+		fmt.Printf("No contexts defined\n")
+		return
+	}
+
+	current := GetConfig("context")
+	itw := NewTabWriter(os.Stdout, nil, 0, 1, 3, ' ', 0)
+	fmt.Fprintln(itw, "CURRENT\tNAME\tSERVER\tREGISTRY\tAUTH")
+	for _, name := range contexts {
+		fmt.Fprintf(itw, "%s\t%s\t%s\t%s\t%s\n",
+			xrlib.BoolStr(name == current, "*", ""), name,
+			contextValue(name, "server.url"), contextValue(name, "registry"),
+			contextAuthType(name))
+	}
+	itw.Flush()
+}
+
+func contextUseFunc(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		Error("A context NAME must be specified")
+	}
+
+	name := args[0]
+	if !ArrayContains(GetContexts(), name) {
+		Error("Unknown context: %s", name)
+	}
+	Error(SaveConfigToFile("context", name))
+	Verbose("Now using context: %s", name)
+}
+
+func contextSetFunc(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		Error("A context NAME must be specified")
+	}
+
+	name := args[0]
+	if name == "" || strings.ContainsAny(name, ".:# \t") {
+		Error("Invalid context name %q, it can't contain '.', ':', '#' "+
+			"or spaces", name)
+	}
+
+	settings := [][2]string{
+		{"url", "server.url"},
+		{"registry", "registry"},
+		{"token", "auth.token"},
+		{"user", "auth.user"},
+		{"password", "auth.password"},
+		{"credential-helper", "auth.helper"},
+	}
+
+	changed := false
+	for _, setting := range settings {
+		if !cmd.Flags().Changed(setting[0]) {
+			continue
+		}
+		value, _ := cmd.Flags().GetString(setting[0])
+		Error(SaveConfigToFile("context."+name+"."+setting[1], value))
+		changed = true
+	}
+
+	headers, _ := cmd.Flags().GetStringArray("header")
+	for _, header := range headers {
+		key, value, _ := strings.Cut(header, "=")
+		key = strings.TrimSpace(key)
+		if key == "" {
+			Error("Invalid --header value %q, must be NAME=VALUE", header)
+		}
+		Error(SaveConfigToFile("context."+name+".header."+key, value))
+		changed = true
+	}
+
+	if !changed {
+		Error("Nothing to set, use one of: --url, --registry, --header, " +
+			"--token, --user, --password, --credential-helper")
+	}
+	Verbose("Updated context: %s", name)
+}
+
+func contextDeleteFunc(cmd *cobra.Command, args []string) {
+	if len(args) != 1 {
+		Error("A context NAME must be specified")
+	}
+
+	name := args[0]
+	if !ArrayContains(GetContexts(), name) {
+		Error("Unknown context: %s", name)
+	}
+
+	for _, key := range SortedKeys(UserConfig) {
+		if strings.HasPrefix(key, "context."+name+".") {
+			Error(SaveConfigToFile(key, ""))
+		}
+	}
+	if GetConfig("context") == name {
+		Error(SaveConfigToFile("context", ""))
+	}
+	Verbose("Deleted context: %s", name)
+}
@@ -2,7 +2,6 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
 	"os"
 	"regexp"
 	"strconv"
@@ -32,7 +31,8 @@ Notes:
 `
 
 	createCmd.Flags().StringP("output", "o", "none",
-		"Output format (none*, json) when xReg metadata")
+		"Output format (none*, "+xrlib.OutputFormatsText()+") when xReg "+
+			"metadata")
 	createCmd.Flag("output").DefValue = "" // hide default text
 	createCmd.Flags().BoolP("details", "m", false, "Data is resource metadata")
 	createCmd.Flags().StringP("data", "d", "",
@@ -67,8 +67,9 @@ Notes:
   force it to be a string
 `
 
-	upsertCmd.Flags().StringP("output", "o",
-		"none", "Output format (none*, json) when xReg metadata")
+	upsertCmd.Flags().StringP("output", "o", "none",
+		"Output format (none*, "+xrlib.OutputFormatsText()+") when xReg "+
+			"metadata")
 	upsertCmd.Flag("output").DefValue = "" // hide default text
 	upsertCmd.Flags().BoolP("details", "m", false, "Data is resource metadata")
 	upsertCmd.Flags().StringP("data", "d", "",
@@ -101,7 +102,8 @@ Notes:
 `
 
 	updateCmd.Flags().StringP("output", "o", "none",
-		"Output format (none*, json) when xReg metadata")
+		"Output format (none*, "+xrlib.OutputFormatsText()+") when xReg "+
+			"metadata")
 	updateCmd.Flag("output").DefValue = "" // hide default text
 	updateCmd.Flags().BoolP("details", "m", false, "Data is resource metadata")
 	updateCmd.Flags().StringP("data", "d", "",
@@ -156,6 +158,9 @@ func createFunc(cmd *cobra.Command, args []string) {
 	force, _ := cmd.Flags().GetBool("force")
 	ignores, _ := cmd.Flags().GetStringArray("ignore")
 	output, _ := cmd.Flags().GetString("output")
+	if output != "none" && !xrlib.IsOutputFormat(output) {
+		Error("--output must be one of: " + xrlib.OutputFormatsText("none"))
+	}
 	isDomainDoc := false
 
 	data, _ := cmd.Flags().GetString("data")
@@ -424,13 +429,14 @@ func createFunc(cmd *cobra.Command, args []string) {
 
 	// TODO allow for GET output to be shown via -o and inline/doc/filter...
 	if xid.ResourceID == "" || isMetadata {
-		if err := json.Unmarshal(res.Body, &objects); err != nil {
+		object := any(nil)
+		if err := json.Unmarshal(res.Body, &object); err != nil {
 			Error(NewXRError("parsing_response", path,
 				"error_detail="+err.Error()))
 		}
 
-		if output == "json" {
-			fmt.Printf("%s\n", xrlib.PrettyPrint(objects, "", "  "))
+		if output != "none" {
+			Error(xrlib.WriteOutput(os.Stdout, xid.String(), object, output))
 			return
 		}
 
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+)
+
+func TestGroupCRUD(td *TD) {
+	td.DependsOn(TestScratchModel)
+	path := "/" + CONF_GROUPS
+
+	res := confDo(td, "PUT", path+"/g1", `{"name":"g1 name"}`)
+	confMustJSON(td, res, 201, "PUT %s/g1", path)
+	td.ObjReqMustEq(res.JSON, CONF_GROUP+"id", "g1")
+	td.ObjReqMustEq(res.JSON, "xid", path+"/g1")
+	td.ObjReqMustNe(res.JSON, "self", "")
+	td.ObjReqMustEq(res.JSON, "epoch", 1)
+	td.ObjReqMustEq(res.JSON, "name", "g1 name")
+	td.ObjReqMustEq(res.JSON, "createdat", "ts")
+	td.ObjReqMustEq(res.JSON, "modifiedat", "ts")
+	td.ObjReqMustNe(res.JSON, CONF_RESOURCES+"url", "")
+	td.ObjReqMustEq(res.JSON, CONF_RESOURCES+"count", 0)
+
+	res = confDo(td, "GET", path+"/g1", "")
+	confMustJSON(td, res, 200, "GET %s/g1", path)
+	td.ObjReqMustEq(res.JSON, CONF_GROUP+"id", "g1")
+	td.ObjReqMustEq(res.JSON, "epoch", 1)
+	td.ObjReqMustEq(res.JSON, "name", "g1 name")
+
+	// PATCH only changes what's in the body
+	res = confDo(td, "PATCH", path+"/g1", `{"description":"desc"}`)
+	confMustJSON(td, res, 200, "PATCH %s/g1", path)
+	td.ObjReqMustEq(res.JSON, "epoch", 2)
+	td.ObjReqMustEq(res.JSON, "name", "g1 name")
+	td.ObjReqMustEq(res.JSON, "description", "desc")
+
+	// PUT replaces everything
+	res = confDo(td, "PUT", path+"/g1", `{"description":"new"}`)
+	confMustJSON(td, res, 200, "PUT %s/g1", path)
+	td.ObjReqMustEq(res.JSON, "epoch", 3)
+	td.ObjMustNotExist(res.JSON, "name")
+	td.ObjReqMustEq(res.JSON, "description", "new")
+	td.ObjReqMustGe(res.JSON, "modifiedat", res.JSON["createdat"])
+
+	res = confDo(td, "POST", path, `{"g2":{},"g3":{"name":"g3"}}`)
+	confMustJSON(td, res, 200, "POST %s", path)
+	td.ObjReqMustEq(confMap(td, res.JSON, "g2"), CONF_GROUP+"id", "g2")
+	td.ObjReqMustEq(confMap(td, res.JSON, "g3"), "name", "g3")
+
+	res = confDo(td, "GET", path, "")
+	confMustJSON(td, res, 200, "GET %s", path)
+	for _, id := range []string{"g1", "g2", "g3"} {
+		td.ObjMustExist(res.JSON, id)
+	}
+
+	res = confDo(td, "DELETE", path+"/g2", "")
+	td.HTTPStatusMustEqual(res, 204, "DELETE %s/g2", path)
+	res = confDo(td, "GET", path+"/g2", "")
+	confMustError(td, res, 404, "not_found", "GET %s/g2", path)
+
+	// Delete via the collection
+	res = confDo(td, "DELETE", path, `{"g3":{}}`)
+	td.HTTPStatusMustEqual(res, 204, "DELETE %s {g3}", path)
+	res = confDo(td, "GET", path+"/g3", "")
+	confMustError(td, res, 404, "not_found", "GET %s/g3", path)
+
+	res = confDo(td, "GET", path+"/g1", "")
+	confMustJSON(td, res, 200, "GET %s/g1", path)
+}
+
+func TestResourceCRUD(td *TD) {
+	td.DependsOn(TestScratchModel)
+	details := confDetails(td)
+	path := "/" + CONF_GROUPS + "/rg/" + CONF_RESOURCES
+
+	res := confDo(td, "PUT", path+"/r1"+details, `{"name":"r1"}`)
+	confMustJSON(td, res, 201, "PUT %s/r1%s", path, details)
+	td.ObjReqMustEq(res.JSON, CONF_RESOURCE+"id", "r1")
+	td.ObjReqMustEq(res.JSON, "xid", path+"/r1")
+	td.ObjReqMustNe(res.JSON, "versionid", "")
+	td.ObjReqMustEq(res.JSON, "epoch", 1)
+	td.ObjReqMustEq(res.JSON, "isdefault", true)
+	td.ObjReqMustEq(res.JSON, "name", "r1")
+	td.ObjReqMustEq(res.JSON, "createdat", "ts")
+	td.ObjReqMustEq(res.JSON, "modifiedat", "ts")
+	td.ObjReqMustEq(res.JSON, "versionscount", 1)
+	td.ObjReqMustNe(res.JSON, "metaurl", "")
+	td.ObjReqMustNe(res.JSON, "versionsurl", "")
+
+	// The 1st version is its own ancestor
+	v1 := fmt.Sprintf("%v", res.JSON["versionid"])
+	td.ObjReqMustEq(res.JSON, "ancestorid", v1)
+
+	// Group was created too
+	res = confDo(td, "GET", "/"+CONF_GROUPS+"/rg", "")
+	confMustJSON(td, res, 200, "GET /%s/rg", CONF_GROUPS)
+	td.ObjReqMustEq(res.JSON, CONF_RESOURCES+"count", 1)
+
+	res = confDo(td, "GET", path+"/r1/meta", "")
+	confMustJSON(td, res, 200, "GET %s/r1/meta", path)
+	td.ObjReqMustEq(res.JSON, CONF_RESOURCE+"id", "r1")
+	td.ObjReqMustEq(res.JSON, "xid", path+"/r1/meta")
+	td.ObjReqMustEq(res.JSON, "defaultversionid", v1)
+	td.ObjReqMustNe(res.JSON, "defaultversionurl", "")
+	td.ObjMustExist(res.JSON, "defaultversionsticky")
+	sticky := res.JSON["defaultversionsticky"] == true
+
+	res = confDo(td, "POST", path+"/r1/versions", `{"v2":{"name":"v2"}}`)
+	confMustJSON(td, res, 200, "POST %s/r1/versions", path)
+	v2 := confMap(td, res.JSON, "v2")
+	td.ObjReqMustEq(v2, "versionid", "v2")
+	td.ObjReqMustEq(v2, "xid", path+"/r1/versions/v2")
+	td.ObjReqMustEq(v2, "ancestorid", v1)
+	td.ObjReqMustEq(v2, "isdefault", !sticky)
+
+	if !sticky {
+		res = confDo(td, "GET", path+"/r1"+details, "")
+		confMustJSON(td, res, 200, "GET %s/r1%s", path, details)
+		td.ObjReqMustEq(res.JSON, "versionid", "v2")
+		td.ObjReqMustEq(res.JSON, "name", "v2")
+		td.ObjReqMustEq(res.JSON, "versionscount", 2)
+	}
+
+	res = confDo(td, "GET", path+"/r1/versions", "")
+	confMustJSON(td, res, 200, "GET %s/r1/versions", path)
+	td.ObjMustExist(res.JSON, v1)
+	td.ObjMustExist(res.JSON, "v2")
+
+	res = confDo(td, "PUT", path+"/r1/versions/v3"+details, `{}`)
+	confMustJSON(td, res, 201, "PUT %s/r1/versions/v3%s", path, details)
+	td.ObjReqMustEq(res.JSON, "versionid", "v3")
+	td.ObjReqMustEq(res.JSON, "epoch", 1)
+	td.ObjReqMustEq(res.JSON, "ancestorid", "v2")
+
+	res = confDo(td, "DELETE", path+"/r1/versions/v3", "")
+	td.HTTPStatusMustEqual(res, 204, "DELETE %s/r1/versions/v3", path)
+	res = confDo(td, "GET", path+"/r1/versions/v3"+details, "")
+	confMustError(td, res, 404, "not_found", "GET %s/r1/versions/v3", path)
+
+	res = confDo(td, "DELETE", path+"/r1", "")
+	td.HTTPStatusMustEqual(res, 204, "DELETE %s/r1", path)
+	res = confDo(td, "GET", path+"/r1"+details, "")
+	confMustError(td, res, 404, "not_found", "GET %s/r1", path)
+	res = confDo(td, "GET", path+"/r1/versions/v2"+details, "")
+	confMustError(td, res, 404, "not_found", "GET %s/r1/versions/v2", path)
+}
+
+func TestDefaultVersion(td *TD) {
+	td.DependsOn(TestScratchModel)
+	rm := confResourceModel(td)
+	details := confDetails(td)
+	path := "/" + CONF_GROUPS + "/dg/" + CONF_RESOURCES + "/d1"
+
+	if !rm.GetSetVersionId() {
+		td.Skip("'setversionid' is false")
+		return
+	}
+	if !confStickyEnabled(td) {
+		td.Skip("'defaultversionsticky' can't be true")
+		return
+	}
+
+	for _, vid := range []string{"v1", "v2"} {
+		res := confDo(td, "PUT", path+"/versions/"+vid+details, `{}`)
+		confMustJSON(td, res, 201, "PUT %s/versions/%s%s", path, vid, details)
+	}
+
+	// Not sticky so newest is the default
+	res := confDo(td, "GET", path+"/meta", "")
+	confMustJSON(td, res, 200, "GET %s/meta", path)
+	td.ObjReqMustEq(res.JSON, "defaultversionsticky", false)
+	td.ObjReqMustEq(res.JSON, "defaultversionid", "v2")
+
+	res = confDo(td, "PATCH", path+"/meta",
+		`{"defaultversionid":"v1","defaultversionsticky":true}`)
+	confMustJSON(td, res, 200, "PATCH %s/meta", path)
+	td.ObjReqMustEq(res.JSON, "defaultversionid", "v1")
+	td.ObjReqMustEq(res.JSON, "defaultversionsticky", true)
+
+	// Sticky, so a new version doesn't change it
+	res = confDo(td, "PUT", path+"/versions/v3"+details, `{}`)
+	confMustJSON(td, res, 201, "PUT %s/versions/v3%s", path, details)
+	td.ObjReqMustEq(res.JSON, "isdefault", false)
+
+	res = confDo(td, "GET", path+"/meta", "")
+	confMustJSON(td, res, 200, "GET %s/meta", path)
+	td.ObjReqMustEq(res.JSON, "defaultversionid", "v1")
+
+	res = confDo(td, "GET", path+"/versions/v1"+details, "")
+	confMustJSON(td, res, 200, "GET %s/versions/v1%s", path, details)
+	td.ObjReqMustEq(res.JSON, "isdefault", true)
+
+	res = confDo(td, "GET", path+details, "")
+	confMustJSON(td, res, 200, "GET %s%s", path, details)
+	td.ObjReqMustEq(res.JSON, "versionid", "v1")
+
+	// Unknown version
+	res = confDo(td, "PATCH", path+"/meta",
+		`{"defaultversionid":"vx","defaultversionsticky":true}`)
+	td.HTTPStatusMustEqual(res, 400, "PATCH %s/meta (unknown version)", path)
+
+	// Not sticky any more, back to the newest
+	res = confDo(td, "PATCH", path+"/meta", `{"defaultversionsticky":false}`)
+	confMustJSON(td, res, 200, "PATCH %s/meta", path)
+	td.ObjReqMustEq(res.JSON, "defaultversionsticky", false)
+	td.ObjReqMustEq(res.JSON, "defaultversionid", "v3")
+}
+
+func TestAncestors(td *TD) {
+	td.DependsOn(TestScratchModel)
+	rm := confResourceModel(td)
+	details := confDetails(td)
+	path := "/" + CONF_GROUPS + "/ag/" + CONF_RESOURCES + "/a1"
+
+	if !rm.GetSetVersionId() {
+		td.Skip("'setversionid' is false")
+		return
+	}
+
+	res := confDo(td, "PUT", path+"/versions/v1"+details, `{}`)
+	confMustJSON(td, res, 201, "PUT %s/versions/v1%s", path, details)
+	td.ObjReqMustEq(res.JSON, "ancestorid", "v1")
+
+	// Defaults to the newest
+	res = confDo(td, "POST", path+"/versions", `{"v2":{}}`)
+	confMustJSON(td, res, 200, "POST %s/versions", path)
+	td.ObjReqMustEq(confMap(td, res.JSON, "v2"), "ancestorid", "v1")
+
+	res = confDo(td, "POST", path+"/versions", `{"v4":{"ancestorid":"vx"}}`)
+	confMustError(td, res, 400, "unknown_id", "POST %s/versions (bad ancestor)",
+		path)
+
+	if rm.GetSingleVersionRoot() {
+		td.Skip("'singleversionroot' is true, can't branch")
+		return
+	}
+
+	res = confDo(td, "POST", path+"/versions", `{"v3":{"ancestorid":"v1"}}`)
+	confMustJSON(td, res, 200, "POST %s/versions", path)
+	td.ObjReqMustEq(confMap(td, res.JSON, "v3"), "ancestorid", "v1")
+
+	// Versions that pointed to a deleted one become roots
+	res = confDo(td, "DELETE", path+"/versions/v1", "")
+	td.HTTPStatusMustEqual(res, 204, "DELETE %s/versions/v1", path)
+
+	res = confDo(td, "GET", path+"/versions", "")
+	confMustJSON(td, res, 200, "GET %s/versions", path)
+	td.ObjMustNotExist(res.JSON, "v1")
+	td.ObjReqMustEq(confMap(td, res.JSON, "v2"), "ancestorid", "v2")
+	td.ObjReqMustEq(confMap(td, res.JSON, "v3"), "ancestorid", "v3")
+}
+
+func TestEpochs(td *TD) {
+	td.DependsOn(TestScratchModel)
+	path := "/" + CONF_GROUPS + "/e1"
+
+	res := confDo(td, "PUT", path, `{}`)
+	confMustJSON(td, res, 201, "PUT %s", path)
+	td.ObjReqMustEq(res.JSON, "epoch", 1)
+
+	res = confDo(td, "PATCH", path, `{"epoch":1,"description":"x"}`)
+	confMustJSON(td, res, 200, "PATCH %s (epoch=1)", path)
+	td.ObjReqMustEq(res.JSON, "epoch", 2)
+
+	res = confDo(td, "PATCH", path, `{"epoch":1,"description":"y"}`)
+	confMustError(td, res, 400, "mismatched_epoch", "PATCH %s (epoch=1)",
+		path)
+
+	res = confDo(td, "PUT", path, `{"description":"y"}`)
+	confMustJSON(td, res, 200, "PUT %s", path)
+	td.ObjReqMustEq(res.JSON, "epoch", 3)
+
+	if !td.GetRegistry().Capabilities.FlagEnabled("epoch") {
+		td.Skip("?epoch not supported")
+		return
+	}
+
+	res = confDo(td, "DELETE", path+"?epoch=1", "")
+	confMustError(td, res, 400, "mismatched_epoch", "DELETE %s?epoch=1", path)
+
+	res = confDo(td, "DELETE", path+"?epoch=3", "")
+	td.HTTPStatusMustEqual(res, 204, "DELETE %s?epoch=3", path)
+}
+
+func TestXrefs(td *TD) {
+	td.DependsOn(TestScratchModel)
+	details := confDetails(td)
+	path := "/" + CONF_GROUPS + "/xg/" + CONF_RESOURCES
+
+	res := confDo(td, "PUT", path+"/target"+details, `{"name":"target"}`)
+	confMustJSON(td, res, 201, "PUT %s/target%s", path, details)
+	targetVID := res.JSON["versionid"]
+
+	res = confDo(td, "PUT", path+"/src/meta",
+		`{"xref":"`+path+`/target"}`)
+	confMustJSON(td, res, 201, "PUT %s/src/meta", path)
+	td.ObjReqMustEq(res.JSON, "xref", path+"/target")
+
+	// Everything but its IDs come from the target
+	res = confDo(td, "GET", path+"/src"+details, "")
+	confMustJSON(td, res, 200, "GET %s/src%s", path, details)
+	td.ObjReqMustEq(res.JSON, CONF_RESOURCE+"id", "src")
+	td.ObjReqMustEq(res.JSON, "xid", path+"/src")
+	td.ObjReqMustEq(res.JSON, "versionid", targetVID)
+	td.ObjReqMustEq(res.JSON, "name", "target")
+
+	res = confDo(td, "PUT", path+"/bad/meta",
+		`{"xref":"`+path[1:]+`/target"}`)
+	confMustError(td, res, 400, "malformed_xref", "PUT %s/bad/meta", path)
+
+	res = confDo(td, "PATCH", path+"/src/meta", `{"xref":null}`)
+	confMustJSON(td, res, 200, "PATCH %s/src/meta (xref=null)", path)
+	td.ObjMustNotExist(res.JSON, "xref")
+
+	res = confDo(td, "GET", path+"/src"+details, "")
+	confMustJSON(td, res, 200, "GET %s/src%s", path, details)
+	td.ObjReqMustEq(res.JSON, CONF_RESOURCE+"id", "src")
+	td.ObjReqMustGe(res.JSON, "versionscount", 1)
+}
+
+func TestHeaderMode(td *TD) {
+	td.DependsOn(TestScratchModel)
+	path := "/" + CONF_GROUPS + "/hg/" + CONF_RESOURCES + "/h1"
+
+	if !confResourceModel(td).GetHasDocument() {
+		td.Skip("'hasdocument' is false")
+		return
+	}
+
+	res := confDo(td, "PUT", path, "hello world",
+		"Content-Type: text/plain",
+		"xRegistry-name: h1 name")
+	td.HTTPStatusMustEqual(res, 201, "PUT %s", path)
+	td.HTTPHeaderMustEqual(res, "xRegistry-"+CONF_RESOURCE+"id", "h1",
+		"'PUT %s' MUST return xRegistry-%sid", path, CONF_RESOURCE)
+	td.HTTPHeaderMustEqual(res, "xRegistry-epoch", "1",
+		"'PUT %s' MUST return xRegistry-epoch", path)
+
+	// No $details, so the body is the document
+	res = confDo(td, "GET", path, "")
+	td.HTTPStatusMustEqual(res, 200, "GET %s", path)
+	td.MustEqual("hello world", string(res.Body),
+		"'GET %s' MUST return the document", path)
+	td.HTTPHeaderMustEqual(res, "Content-Type", "text/plain",
+		"'GET %s' MUST return the Content-Type", path)
+	td.HTTPHeaderMustEqual(res, "xRegistry-name", "h1 name",
+		"'GET %s' MUST return xRegistry-name", path)
+	td.HTTPHeaderMustEqual(res, "xRegistry-xid", path,
+		"'GET %s' MUST return xRegistry-xid", path)
+
+	res = confDo(td, "GET", path+"$details", "")
+	confMustJSON(td, res, 200, "GET %s$details", path)
+	td.ObjReqMustEq(res.JSON, CONF_RESOURCE+"id", "h1")
+	td.ObjReqMustEq(res.JSON, "name", "h1 name")
+	td.ObjReqMustEq(res.JSON, "contenttype", "text/plain")
+	td.ObjMustNotExist(res.JSON, CONF_RESOURCE)
+	td.MustEqual("", res.Header.Get("xRegistry-name"),
+		"'GET %s$details' MUST NOT return xRegistry headers", path)
+}
+
+func TestErrors(td *TD) {
+	td.DependsOn(TestScratchModel)
+	path := "/" + CONF_GROUPS
+
+	res := confDo(td, "GET", path+"/missing", "")
+	confMustError(td, res, 404, "not_found", "GET %s/missing", path)
+	td.ObjReqMustEq(res.JSON, "subject", path+"/missing")
+
+	res = confDo(td, "GET", "/"+CONF_GROUPS+"x", "")
+	confMustError(td, res, 404, "not_found", "GET /%sx", CONF_GROUPS)
+
+	res = confDo(td, "PUT", path+"/err1", `{"`+CONF_GROUP+`id":"other"}`)
+	confMustError(td, res, 400, "mismatched_id", "PUT %s/err1 (bad id)", path)
+
+	res = confDo(td, "PUT", path+"/err1", `{"name":`)
+	confMustError(td, res, 400, "parsing_data", "PUT %s/err1 (bad json)",
+		path)
+
+	// None of those should have created it
+	res = confDo(td, "GET", path+"/err1", "")
+	confMustError(td, res, 404, "not_found", "GET %s/err1", path)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TestQueries checks the query parameters (flags) that the Registry says
+// it supports via its capabilities
+func TestQueries(td *TD) {
+	td.DependsOn(TestScratchModel)
+	path := "/" + CONF_GROUPS
+
+	// Names are in a different order than the IDs so sorting is visible
+	for i, name := range []string{"b", "a", "c"} {
+		id := fmt.Sprintf("q%d", i+1)
+		res := confDo(td, "PUT", path+"/"+id, `{"name":"`+name+`"}`)
+		confMustJSON(td, res, 201, "PUT %s/%s", path, id)
+	}
+
+	td.Run(TestQueryFilter)
+	td.Run(TestQueryInline)
+	td.Run(TestQuerySort)
+	td.Run(TestQueryDoc)
+}
+
+func TestQueryFilter(td *TD) {
+	path := "/" + CONF_GROUPS
+
+	if !td.GetRegistry().Capabilities.FlagEnabled("filter") {
+		td.Skip(`"filter" flag not enabled`)
+		return
+	}
+
+	res := confDo(td, "GET", path+"?filter=name=a", "")
+	confMustJSON(td, res, 200, "GET %s?filter=name=a", path)
+	td.ObjMustExist(res.JSON, "q2")
+	td.ObjMustNotExist(res.JSON, "q1")
+	td.ObjMustNotExist(res.JSON, "q3")
+
+	// Multiple filters are OR'd
+	res = confDo(td, "GET", path+"?filter=name=a&filter=name=c", "")
+	confMustJSON(td, res, 200, "GET %s?filter=name=a&filter=name=c", path)
+	td.ObjMustExist(res.JSON, "q2")
+	td.ObjMustExist(res.JSON, "q3")
+	td.ObjMustNotExist(res.JSON, "q1")
+
+	// Comma separated ones are AND'd
+	res = confDo(td, "GET", path+"?filter=name=a,"+CONF_GROUP+"id=q1", "")
+	confMustJSON(td, res, 200, "GET %s?filter=name=a,%sid=q1", path,
+		CONF_GROUP)
+	td.Must(len(res.JSON) == 0, "'GET %s?filter=name=a,%sid=q1' MUST "+
+		"return an empty map", path, CONF_GROUP)
+}
+
+func TestQueryInline(td *TD) {
+	path := "/" + CONF_GROUPS
+
+	res := confDo(td, "GET", path+"/q1", "")
+	confMustJSON(td, res, 200, "GET %s/q1", path)
+	td.ObjMustNotExist(res.JSON, CONF_RESOURCES)
+
+	if !td.GetRegistry().Capabilities.FlagEnabled("inline") {
+		td.Skip(`"inline" flag not enabled`)
+		return
+	}
+
+	res = confDo(td, "GET", path+"/q1?inline="+CONF_RESOURCES, "")
+	confMustJSON(td, res, 200, "GET %s/q1?inline=%s", path, CONF_RESOURCES)
+	td.Must(len(confMap(td, res.JSON, CONF_RESOURCES)) == 0,
+		"%q MUST be an empty map", CONF_RESOURCES)
+}
+
+func TestQuerySort(td *TD) {
+	path := "/" + CONF_GROUPS
+
+	if !td.GetRegistry().Capabilities.FlagEnabled("sort") {
+		td.Skip(`"sort" flag not enabled`)
+		return
+	}
+
+	// Only look at our "q" groups, other tests may have left some around
+	order := func(query string) string {
+		res := confDo(td, "GET", path+"?"+query, "")
+		confMustJSON(td, res, 200, "GET %s?%s", path, query)
+		ids := []string{}
+		for _, key := range jsonKeys(res.Body) {
+			if strings.HasPrefix(key, "q") {
+				ids = append(ids, key)
+			}
+		}
+		return strings.Join(ids, ",")
+	}
+
+	td.MustEqual("q2,q1,q3", order("sort=name"),
+		"'GET %s?sort=name' MUST be sorted ascending", path)
+	td.MustEqual("q3,q1,q2", order("sort=name=desc"),
+		"'GET %s?sort=name=desc' MUST be sorted descending", path)
+
+	res := confDo(td, "GET", path+"/q1?sort=name", "")
+	confMustError(td, res, 400, "sort_noncollection", "GET %s/q1?sort=name",
+		path)
+}
+
+func TestQueryDoc(td *TD) {
+	path := "/" + CONF_GROUPS
+
+	if !td.GetRegistry().Capabilities.FlagEnabled("doc") {
+		td.Skip(`"doc" flag not enabled`)
+		return
+	}
+
+	res := confDo(td, "GET", path+"?doc", "")
+	confMustJSON(td, res, 200, "GET %s?doc", path)
+	q1 := confMap(td, res.JSON, "q1")
+	td.ObjReqMustEq(q1, "self", "#/q1")
+	td.ObjReqMustEq(q1, "xid", path+"/q1")
+}
@@ -12,6 +12,7 @@ func TestRegistry(td *TD) {
 	td.Run(TestCapabilities)
 	td.Run(TestModel)
 	td.Run(TestRoot)
+	td.Run(TestEntities)
 }
 
 func TestCapabilities(td *TD) {
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/xregistry/server/cmds/xr/xrlib"
+	. "github.com/xregistry/server/common"
+)
+
+// The Group and Resource types that are added to the scratch Registry's
+// model for the tests that create/change entities
+const CONF_GROUPS = "xrcgroups"
+const CONF_GROUP = "xrcgroup"
+const CONF_RESOURCES = "xrcresources"
+const CONF_RESOURCE = "xrcresource"
+
+// TestEntities runs all of the tests that need to write to a Registry. So
+// that the one being tested is never changed, they use a scratch Registry
+// on the same server (PUT/DELETE /reg-ID), and are skipped if the server
+// won't let us create one. They also only run when the user asks for them
+// (--write).
+func TestEntities(td *TD) {
+	td.DependsOn(TestModel)
+
+	if !WriteTests {
+		td.Skip("Tests that write to the Registry need --write")
+		return
+	}
+
+	orig := td.GetRegistry()
+	defer func() {
+		if td.GetProp("scratch") != nil {
+			td.Run(TestScratchCleanup)
+		}
+		td.SetRegistry(orig)
+	}()
+	if !confScratchRegistry(td) {
+		return
+	}
+
+	reg := td.GetRegistry()
+	if reg.Capabilities == nil ||
+		!reg.Capabilities.IsAvailableMutable("modelsource") ||
+		!reg.Capabilities.IsAvailableMutable("entities") {
+		td.Skip("The model and entities aren't mutable")
+		return
+	}
+
+	td.DependsOn(TestScratchModel)
+
+	td.Run(TestGroupCRUD)
+	td.Run(TestResourceCRUD)
+	td.Run(TestDefaultVersion)
+	td.Run(TestAncestors)
+	td.Run(TestEpochs)
+	td.Run(TestXrefs)
+	td.Run(TestHeaderMode)
+	td.Run(TestQueries)
+	td.Run(TestErrors)
+}
+
+// confScratchRegistry creates an empty Registry on the same server as the
+// one being tested, and makes it the one the tests use. Returns false, and
+// skips the test, if the server doesn't support that.
+func confScratchRegistry(td *TD) bool {
+	server := strings.TrimRight(td.GetRegistry().GetServerURL(), "/")
+	if i := strings.LastIndex(server, "/reg-"); i >= 0 {
+		server = server[:i]
+	}
+	if !strings.HasPrefix(server, "http") {
+		server = "http://" + server
+	}
+	u := server + "/reg-" + SCRATCH_REGISTRY_PREFIX + NewUUID()
+
+	res, _ := xrlib.HttpDo(VerboseCount > 2, "PUT", u, confHeaders(),
+		[]byte("{}"))
+	if res == nil || res.Code != 201 {
+		td.Skip("The server can't create a scratch Registry (PUT %s), "+
+			"so the tests that write to one can't be run", u)
+		return false
+	}
+
+	// From here on we need to delete it when we're done
+	td.Set("scratch", u)
+
+	reg, xErr := xrlib.GetRegistry(u)
+	td.NoErrorStop(xErr, "Loading the scratch Registry (%s) MUST work", u)
+	td.SetRegistry(reg)
+	return true
+}
+
+// TestScratchModel adds our Group/Resource types to the Registry's model
+func TestScratchModel(td *TD) {
+	reg := td.GetRegistry()
+
+	res := confDo(td, "GET", "/modelsource", "")
+	confMustJSON(td, res, 200, "GET /modelsource")
+
+	src := map[string]any{}
+	td.NoErrorStop(json.Unmarshal(res.Body, &src),
+		"'GET /modelsource' MUST return a JSON object")
+
+	groups, _ := src["groups"].(map[string]any)
+	if groups == nil {
+		groups = map[string]any{}
+		src["groups"] = groups
+	}
+	groups[CONF_GROUPS] = map[string]any{
+		"singular": CONF_GROUP,
+		"resources": map[string]any{
+			CONF_RESOURCES: map[string]any{
+				"singular": CONF_RESOURCE,
+			},
+		},
+	}
+
+	res = confDo(td, "PUT", "/modelsource", ToJSON(src))
+	td.HTTPStatusMustEqual(res, 200, "PUT /modelsource")
+
+	td.NoErrorStop(reg.RefreshModel(), "Refreshing the model MUST work")
+	rm := reg.Model.FindResourceModel(CONF_GROUPS, CONF_RESOURCES)
+	td.Must(rm != nil, "Model MUST include %s/%s", CONF_GROUPS,
+		CONF_RESOURCES)
+}
+
+// TestScratchCleanup deletes the scratch Registry, and everything in it
+func TestScratchCleanup(td *TD) {
+	u := td.GetProp("scratch").(string)
+	td.Set("scratch", nil)
+
+	res, _ := xrlib.HttpDo(VerboseCount > 2, "DELETE", u, confHeaders(), nil)
+	td.HTTPStatusMustEqual(res, 204, "DELETE %s", u)
+}
+
+// confResourceModel returns the model of our test Resource type
+func confResourceModel(td *TD) *xrlib.ResourceModel {
+	reg := td.GetRegistry()
+	rm := reg.Model.FindResourceModel(CONF_GROUPS, CONF_RESOURCES)
+	if rm == nil {
+		td.FailNow("Resource type %s/%s isn't in the model", CONF_GROUPS,
+			CONF_RESOURCES)
+	}
+	return rm
+}
+
+// confDetails returns "$details" if our Resources have documents, so
+// that "path" refers to the metadata and not the document
+func confDetails(td *TD) string {
+	if confResourceModel(td).GetHasDocument() {
+		return "$details"
+	}
+	return ""
+}
+
+// confStickyEnabled says whether "defaultversionsticky" can be true
+func confStickyEnabled(td *TD) bool {
+	attr := confResourceModel(td).MetaAttributes["defaultversionsticky"]
+	if attr == nil || attr.Enum == nil {
+		return true
+	}
+	for _, val := range attr.Enum {
+		if val == true {
+			return true
+		}
+	}
+	return false
+}
+
+// confDo sends a request to the Registry being tested, "headers" are any
+// extra "Name: value" HTTP headers to include
+func confDo(td *TD, verb, path, body string, headers ...string) *xrlib.HttpResponse {
+	reg := td.GetRegistry()
+
+	u, xErr := reg.URLWithPath(path)
+	td.NoErrorStop(xErr, "Bad path: %s", path)
+
+	hdrs := confHeaders(headers...)
+
+	bodyBytes := []byte(nil)
+	if body != "" {
+		bodyBytes = []byte(body)
+	}
+
+	res, _ := xrlib.HttpDo(VerboseCount > 2, verb, u.String(), hdrs, bodyBytes)
+	if res == nil || res.Code == 0 {
+		td.FailNow("'%s %s' MUST return a response", verb, path)
+	}
+	return res
+}
+
+// confHeaders returns the HTTP headers to send, the user's plus any extra
+// "Name: value" ones in "headers"
+func confHeaders(headers ...string) map[string]string {
+	hdrs := map[string]string{}
+	for k, v := range xrlib.HTTPHeaders {
+		hdrs[k] = v
+	}
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		hdrs[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return hdrs
+}
+
+// confMustJSON stops the test if "res" isn't a "code" response with a JSON
+// object as its body. Nothing else can be checked without one.
+func confMustJSON(td *TD, res *xrlib.HttpResponse, code int, args ...any) {
+	td.HTTPStatusMustEqual(res, code, args...)
+	if res.JSON == nil {
+		td.Log("Body:\n%s", string(res.Body))
+		td.FailNow("'%s' MUST return a JSON object",
+			fmt.Sprintf(args[0].(string), args[1:]...))
+	}
+}
+
+// confMustError checks that "res" is an error of type "errType"
+func confMustError(td *TD, res *xrlib.HttpResponse, code int, errType string,
+	args ...any) {
+
+	confMustJSON(td, res, code, args...)
+	td.ObjReqMustEq(res.JSON, "type", CORE_SPECURL+"#"+errType)
+	td.ObjReqMustNe(res.JSON, "title", "")
+}
+
+// confMap returns the "name" attribute of "obj" as a map, or stops the
+// test if it's not there
+func confMap(td *TD, obj map[string]any, name string) map[string]any {
+	val, ok := obj[name].(map[string]any)
+	if !ok {
+		td.FailNow("%q MUST be present and be a map", name)
+	}
+	return val
+}
+
+// jsonKeys returns the top-level keys of the JSON object in "body", in
+// the order in which they appear. Needed to check the order of the
+// entities of a collection since a Go map loses it.
+func jsonKeys(body []byte) []string {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+
+	keys := []string{}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		keys = append(keys, tok.(string))
+
+		val := json.RawMessage{}
+		if err := dec.Decode(&val); err != nil {
+			return nil
+		}
+	}
+	return keys
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"github.com/xregistry/server/cmds/xr/xrlib"
+	. "github.com/xregistry/server/common"
+)
+
+func addDiffCmd(parent *cobra.Command) {
+	diffCmd := &cobra.Command{
+		Use:   "diff XID|@FILE [XID|@FILE]",
+		Short: "Show the differences between two entities",
+		Long: `Show the differences between two entities.
+
+Each one can be an XID in the Registry or "@FILE", a snapshot of an entity
+from a previous "xr get". Attribute differences are always shown, and when
+both are Resources/Versions with documents then so are document
+differences.
+
+With "--since EPOCH" only one XID is given, and it shows the entities at
+or under it whose "epoch" is greater than EPOCH.`,
+		Run:     diffFunc,
+		GroupID: "Entities",
+	}
+	diffCmd.Flags().Int("since", 0,
+		"Show entities with an epoch greater than this")
+	diffCmd.Flags().StringP("output", "o", "table", "Output format: json, table*")
+	diffCmd.Flag("output").DefValue = "" // hide default text
+
+	parent.AddCommand(diffCmd)
+}
+
+type diffSide struct {
+	attrs  any
+	doc    []byte
+	hasDoc bool
+}
+
+func diffFunc(cmd *cobra.Command, args []string) {
+	since, _ := cmd.Flags().GetInt("since")
+	output, _ := cmd.Flags().GetString("output")
+	if !ArrayContains([]string{"table", "json"}, output) {
+		Error("--output must be one of: json, table")
+	}
+
+	if cmd.Flags().Changed("since") {
+		if len(args) != 1 {
+			Error("Only one XID is allowed with --since")
+		}
+		diffSince(args[0], since, output)
+		return
+	}
+
+	if len(args) != 2 {
+		Error("Two entities must be specified")
+	}
+
+	oldSide := getDiffSide(args[0])
+	newSide := getDiffSide(args[1])
+
+	attrDiffs := xrlib.DiffObjects(oldSide.attrs, newSide.attrs)
+
+	docDiffs := []string(nil)
+	if oldSide.hasDoc && newSide.hasDoc {
+		if !utf8.Valid(oldSide.doc) || !utf8.Valid(newSide.doc) {
+			if string(oldSide.doc) != string(newSide.doc) {
+				docDiffs = []string{"Binary documents differ"}
+			}
+		} else {
+			docDiffs = xrlib.DiffLines(string(oldSide.doc),
+				string(newSide.doc), 2)
+		}
+	}
+
+	if output == "json" {
+		res := map[string]any{"attributes": attrDiffs}
+		if oldSide.hasDoc && newSide.hasDoc {
+			res["document"] = docDiffs
+		}
+		fmt.Printf("%s\n", xrlib.PrettyPrint(res, "", "  "))
+		return
+	}
+
+	if len(attrDiffs) == 0 && len(docDiffs) == 0 {
+		fmt.Printf("No differences\n")
+		return
+	}
+	if len(attrDiffs) > 0 {
+		fmt.Printf("%s\n", xrlib.TablizeDiff(attrDiffs))
+	}
+	if len(docDiffs) > 0 {
+		if len(attrDiffs) > 0 {
+			fmt.Printf("\n")
+		}
+		fmt.Printf("Document:\n%s\n", strings.Join(docDiffs, "\n"))
+	}
+}
+
+// getDiffSide gets the attributes (and doc) of an XID or "@FILE" snapshot
+func getDiffSide(arg string) *diffSide {
+	side := &diffSide{}
+
+	if arg != "" && arg[0] == '@' {
+		buf, xErr := xrlib.ReadFile(arg[1:])
+		Error(xErr)
+		Error(json.Unmarshal(buf, &side.attrs),
+			"Error parsing %q: %s", arg[1:], "err")
+		return side
+	}
+
+	reg := getDiffRegistry()
+	xid, err := ParseXid(xidWithSlash(arg))
+	Error(err)
+
+	rm, xErr := xrlib.GetResourceModelFrom(xid, reg)
+	Error(xErr)
+
+	path := xid.String()
+	if xid.ResourceID != "" && rm.HasDoc() && xid.IsEntity {
+		res, xErr := reg.HttpDo(VerboseCount > 1, "GET", path, nil)
+		Error(xErr)
+		side.doc, side.hasDoc = res.Body, true
+		path += "$details"
+	}
+
+	res, xErr := reg.HttpDo(VerboseCount > 1, "GET", path, nil)
+	Error(xErr)
+	err = json.Unmarshal(res.Body, &side.attrs)
+	Error(err, NewXRError("parsing_response", path,
+		"error_detail="+Err2String(err)))
+	return side
+}
+
+var diffRegistry *xrlib.Registry
+
+func getDiffRegistry() *xrlib.Registry {
+	if diffRegistry == nil {
+		if GetServer() == "" {
+			Error("No Server address provided. Try either -s or XR_SERVER " +
+				"env var")
+		}
+		reg, xErr := xrlib.GetRegistry(GetServer())
+		Error(xErr)
+		diffRegistry = reg
+	}
+	return diffRegistry
+}
+
+func xidWithSlash(str string) string {
+	if len(str) == 0 || str[0] != '/' {
+		str = "/" + str
+	}
+	return str
+}
+
+type sinceEntity struct {
+	XID        string `json:"xid"`
+	Epoch      int    `json:"epoch"`
+	ModifiedAt string `json:"modifiedat,omitempty"`
+}
+
+// diffSince shows the entities at/under "xidStr" whose epoch is > "since"
+func diffSince(xidStr string, since int, output string) {
+	reg := getDiffRegistry()
+	xid, err := ParseXid(xidWithSlash(xidStr))
+	Error(err)
+
+	rm, xErr := xrlib.GetResourceModelFrom(xid, reg)
+	Error(xErr)
+
+	path := xid.String()
+	if xid.ResourceID != "" && rm.HasDoc() && xid.IsEntity {
+		path += "$details"
+	}
+	path = AddQuery(path, "inline=*")
+
+	res, xErr := reg.HttpDo(VerboseCount > 1, "GET", path, nil)
+	Error(xErr)
+
+	obj := any(nil)
+	err = json.Unmarshal(res.Body, &obj)
+	Error(err, NewXRError("parsing_response", path,
+		"error_detail="+Err2String(err)))
+
+	entities := []*sinceEntity{}
+	var walk func(val any)
+	walk = func(val any) {
+		switch v := val.(type) {
+		case map[string]any:
+			xidVal, _ := v["xid"].(string)
+			epoch, ok := v["epoch"].(float64)
+			if xidVal != "" && ok && int(epoch) > since {
+				modAt, _ := v["modifiedat"].(string)
+				entities = append(entities,
+					&sinceEntity{xidVal, int(epoch), modAt})
+			}
+			for _, sub := range v {
+				walk(sub)
+			}
+		case []any:
+			for _, sub := range v {
+				walk(sub)
+			}
+		}
+	}
+	walk(obj)
+
+	sort.Slice(entities, func(i, j int) bool {
+		return entities[i].XID < entities[j].XID
+	})
+
+	if output == "json" {
+		fmt.Printf("%s\n", xrlib.PrettyPrint(entities, "", "  "))
+		return
+	}
+
+	if len(entities) == 0 {
+		fmt.Printf("No entities with an epoch greater than %d\n", since)
+		return
+	}
+
+	itw := NewTabWriter(os.Stdout, nil, 0, 1, 3, ' ', 0)
+	fmt.Fprintln(itw, "XID\tEPOCH\tMODIFIEDAT")
+	for _, e := range entities {
+		fmt.Fprintf(itw, "%s\t%d\t%s\n", e.XID, e.Epoch, e.ModifiedAt)
+	}
+	itw.Flush()
+}
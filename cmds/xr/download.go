@@ -606,8 +606,10 @@ func downloadFunc(cmd *cobra.Command, args []string) {
 				"model":               &AvailableObject{Mutable: false},
 				"modelsource":         &AvailableObject{Mutable: false},
 			}
+			caps.Encodings = nil
 			caps.Flags = nil
 			caps.Pagination = false
+			caps.Ranges = false
 			caps.ShortSelf = false
 			obj["capabilities"] = caps
 		}
@@ -659,8 +661,10 @@ func downloadFunc(cmd *cobra.Command, args []string) {
 				"model":               &AvailableObject{Mutable: false},
 				"modelsource":         &AvailableObject{Mutable: false},
 			}
+			caps.Encodings = nil
 			caps.Flags = nil
 			caps.Pagination = false
+			caps.Ranges = false
 			data, _ = json.MarshalIndent(caps, "", "  ")
 		}
 
@@ -3,13 +3,16 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 	// "text/tabwriter"
 
 	// log "github.com/duglin/dlog"
 	"github.com/spf13/cobra"
 	"github.com/xregistry/server/cmds/xr/xrlib"
 	. "github.com/xregistry/server/common"
+	"golang.org/x/term"
 )
 
 func addGetCmd(parent *cobra.Command) {
@@ -21,10 +24,19 @@ func addGetCmd(parent *cobra.Command) {
 	}
 	getCmd.Flags().StringArrayP("filter", "f", nil, "Filter: expr[,expr]")
 	getCmd.Flags().StringArrayP("inline", "i", nil, "Inline entities: *, ...")
+	getCmd.Flags().StringArray("sort", nil, "Sort: [-]attr[=order][,...]")
+	getCmd.Flags().StringArray("fields", nil, "Attributes to show: attr[,...]")
+	getCmd.Flags().StringArray("facet", nil, "Show value counts: attr[,...]")
 	getCmd.Flags().Bool("doc", false, "Retieve document view of entities")
-	getCmd.Flags().StringP("output", "o", "json", "Output format: json*, table")
+	getCmd.Flags().StringP("output", "o", "json",
+		"Output format: json*, table, "+
+			strings.Join(xrlib.OutputFormats[1:], ", "))
 	getCmd.Flag("output").DefValue = "" // hide default text
 	getCmd.Flags().BoolP("details", "m", false, "Show resource metadata")
+	getCmd.Flags().BoolP("watch", "w", false,
+		"Keep polling and show the output again whenever it changes")
+	getCmd.Flags().Duration("interval", 2*time.Second,
+		"How often to poll when using --watch")
 
 	parent.AddCommand(getCmd)
 }
@@ -39,10 +51,16 @@ func getFunc(cmd *cobra.Command, args []string) {
 
 	filters, _ := cmd.Flags().GetStringArray("filter")
 	inlines, _ := cmd.Flags().GetStringArray("inline")
+	sorts, _ := cmd.Flags().GetStringArray("sort")
+	fields, _ := cmd.Flags().GetStringArray("fields")
+	facets, _ := cmd.Flags().GetStringArray("facet")
 	docView, _ := cmd.Flags().GetBool("doc")
 	output, _ := cmd.Flags().GetString("output")
-	if !ArrayContains([]string{"table", "json"}, output) {
-		Error("--output must be one of: json, table")
+	if output != "table" && !xrlib.IsOutputFormat(output) {
+		Error("--output must be one of: " + xrlib.OutputFormatsText("table"))
+	}
+	if len(facets) > 0 && output == "table" {
+		Error("--facet can't be used with --output=table")
 	}
 
 	if len(args) == 0 {
@@ -59,7 +77,6 @@ func getFunc(cmd *cobra.Command, args []string) {
 	if len(xidStr) > 0 && xidStr[0] != '/' {
 		xidStr = "/" + xidStr
 	}
-	object := any(nil)
 	xid, err := ParseXid(xidStr)
 	Error(err)
 	resIsJSON := true
@@ -71,7 +88,9 @@ func getFunc(cmd *cobra.Command, args []string) {
 	hasDetails, _ := cmd.Flags().GetBool("details")
 
 	// If we have doc + ../rID or ../vID (but not .../versions) then...
-	if xid.ResourceID != "" && rm.HasDoc() && xid.IsEntity {
+	// unless we're asking for facets, which are always JSON
+	if xid.ResourceID != "" && rm.HasDoc() && xid.IsEntity &&
+		len(facets) == 0 {
 		if hasDetails == false {
 			resIsJSON = false
 		} else {
@@ -90,15 +109,91 @@ func getFunc(cmd *cobra.Command, args []string) {
 		path = AddQuery(path, "filter="+strings.Join(filters, ","))
 	}
 
+	if len(fields) > 0 {
+		path = AddQuery(path, "fields="+strings.Join(fields, ","))
+	}
+
+	if len(sorts) > 0 {
+		path = AddQuery(path, "sort="+strings.Join(sorts, ","))
+	}
+
+	if len(facets) > 0 {
+		path = AddQuery(path, "facet="+strings.Join(facets, ","))
+	}
+
 	if cmd.Flags().Changed("inline") && len(inlines) == 0 {
 		path = AddQuery(path, "inline")
 	} else if len(inlines) > 0 {
 		path = AddQuery(path, "inline="+strings.Join(inlines, ","))
 	}
 
+	if watch, _ := cmd.Flags().GetBool("watch"); watch {
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if interval <= 0 {
+			Error("--interval must be greater than zero")
+		}
+		watchGet(reg, xid, path, output, resIsJSON, interval)
+		return
+	}
+
 	res, xErr := reg.HttpDo(VerboseCount > 1, "GET", path, nil)
 	Error(xErr)
 
+	showGet(xid, path, res, output, resIsJSON)
+}
+
+// watchGet polls "path" and shows it each time the response changes.
+// Any change under the entity changes the response, not just its own
+// "epoch", so that's what we compare. Errors (e.g. it was deleted) are
+// shown once and then we keep waiting for it to come back.
+func watchGet(reg *xrlib.Registry, xid *Xid, path string, output string,
+	resIsJSON bool, interval time.Duration) {
+
+	isTerm := term.IsTerminal(int(os.Stdout.Fd()))
+	last := ""
+
+	for first := true; ; first = false {
+		if !first {
+			time.Sleep(interval)
+		}
+
+		res, xErr := reg.HttpDo(VerboseCount > 1, "GET", path, nil)
+		current := ""
+		if xErr != nil {
+			current = "error: " + xErr.String()
+		} else {
+			current = fmt.Sprintf("%d:%s", res.Code, string(res.Body))
+		}
+		if current == last {
+			continue
+		}
+		last = current
+
+		if isTerm {
+			fmt.Print("\033[H\033[2J") // clear screen
+		} else if !first {
+			fmt.Print("\n")
+		}
+
+		header := fmt.Sprintf("--- %s %s", time.Now().Format(time.RFC3339),
+			xid.String())
+		if xErr == nil && res.JSON != nil && res.JSON["epoch"] != nil {
+			header += fmt.Sprintf(" (epoch: %v)", res.JSON["epoch"])
+		}
+		fmt.Fprintln(os.Stderr, header)
+
+		if xErr != nil {
+			ShowError(xErr)
+			continue
+		}
+		showGet(xid, path, res, output, resIsJSON)
+	}
+}
+
+func showGet(xid *Xid, path string, res *xrlib.HttpResponse, output string,
+	resIsJSON bool) {
+
+	object := any(nil)
 	path = strings.TrimRight(GetServer(), "/") + "/" +
 		strings.TrimLeft(path, "/")
 
@@ -124,14 +219,15 @@ func getFunc(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	err := json.Unmarshal(res.Body, &object)
+	Error(err, NewXRError("parsing_response", path,
+		"error_detail="+Err2String(err)).
+		SetDetail("Response: "+string(res.Body)+"."))
+
 	if output == "table" {
-		err = json.Unmarshal(res.Body, &object)
-		Error(err, NewXRError("parsing_response", path,
-			"error_detail="+Err2String(err)).
-			SetDetail("Response: "+string(res.Body)+"."))
 		fmt.Printf("%s\n", xrlib.Tablize(xid.String(), object))
 		return
 	}
 
-	Error("Unknown output format: %s", output)
+	Error(xrlib.WriteOutput(os.Stdout, xid.String(), object, output))
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xregistry/server/cmds/xr/xrlib"
+	. "github.com/xregistry/server/common"
+	"gopkg.in/yaml.v3"
+)
+
+func modelLintFunc(cmd *cobra.Command, args []string) {
+	output, _ := cmd.Flags().GetString("output")
+	if !ArrayContains([]string{"text", "json", "sarif"}, output) {
+		Error("--output must be one of: text, json, sarif")
+	}
+	failOn, _ := cmd.Flags().GetString("fail-on")
+	if !slices.Contains(xrlib.LintLevels, failOn) {
+		Error("--fail-on must be one of: %s",
+			strings.Join(xrlib.LintLevels, ", "))
+	}
+
+	config := xrlib.LintConfig{}
+	if rulesFile, _ := cmd.Flags().GetString("rules"); rulesFile != "" {
+		buf, xErr := xrlib.ReadFile(rulesFile)
+		Error(xErr)
+		Error(yaml.Unmarshal(buf, &config), "Error parsing %q: %s",
+			rulesFile, "err")
+	}
+	ruleFlags, _ := cmd.Flags().GetStringArray("rule")
+	for _, ruleFlag := range ruleFlags {
+		id, level, ok := strings.Cut(ruleFlag, "=")
+		if !ok {
+			Error("Invalid --rule value %q, must be RULE=LEVEL", ruleFlag)
+		}
+		config[strings.TrimSpace(id)] = strings.TrimSpace(level)
+	}
+	Error(config.Verify())
+
+	if listRules, _ := cmd.Flags().GetBool("list-rules"); listRules {
+		itw := NewTabWriter(os.Stdout, nil, 0, 1, 3, ' ', 0)
+		fmt.Fprintln(itw, "RULE\tLEVEL\tDESCRIPTION")
+		for _, rule := range xrlib.LintRules {
+			fmt.Fprintf(itw, "%s\t%s\t%s\n", rule.ID, config.Level(rule),
+				rule.Description)
+		}
+		itw.Flush()
+		return
+	}
+
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+
+	findings := []*xrlib.LintFinding{}
+	for _, fileName := range args {
+		src, xErr := xrlib.ReadFile(fileName)
+		Error(xErr)
+
+		buf, xErr := ProcessIncludes(fileName, src, true)
+		if xErr == nil {
+			var list []*xrlib.LintFinding
+			list, xErr = xrlib.LintModel(buf, src, config)
+			for _, finding := range list {
+				finding.File = fileName
+				findings = append(findings, finding)
+			}
+		}
+		if xErr != nil {
+			if len(args) > 1 {
+				xErr.SetDetailf("Found at: %s.", fileName)
+			}
+			Error(xErr)
+		}
+	}
+
+	switch output {
+	case "json":
+		fmt.Printf("%s\n", xrlib.PrettyPrint(findings, "", "  "))
+	case "sarif":
+		Error(xrlib.WriteLintSARIF(os.Stdout, findings, config))
+	default:
+		counts := map[string]int{}
+		for _, f := range findings {
+			where := f.File
+			if f.Line > 0 {
+				where += fmt.Sprintf(":%d", f.Line)
+			}
+			fmt.Printf("%s: %s: %s (%s)\n", where, f.Level, f.Message, f.Rule)
+			counts[f.Level]++
+		}
+		if len(findings) == 0 {
+			fmt.Printf("No problems found\n")
+		} else {
+			fmt.Printf("\n%d problem%s (errors: %d, warnings: %d, "+
+				"notes: %d)\n",
+				len(findings), xrlib.BoolStr(len(findings) == 1, "", "s"),
+				counts[xrlib.LINT_ERROR], counts[xrlib.LINT_WARNING],
+				counts[xrlib.LINT_NOTE])
+		}
+	}
+
+	if failOn != xrlib.LINT_OFF {
+		for _, f := range findings {
+			if xrlib.LintLevelAtLeast(f.Level, failOn) {
+				os.Exit(1)
+			}
+		}
+	}
+}
@@ -45,15 +45,71 @@ func addModelCmd(parent *cobra.Command) {
 		"Generate full model definition")
 	modelCmd.AddCommand(verifyCmd)
 
+	lintCmd := &cobra.Command{
+		Use:   "lint [- | FILE...]",
+		Short: "Check xRegistry model documents for style problems",
+		Run:   modelLintFunc,
+	}
+	lintCmd.Long = lintCmd.Short + "\n" + `
+Each rule has a level (off, note, warning, error) that can be changed via
+--rule RULE=LEVEL or a --rules file (yaml or json) of "RULE: LEVEL" pairs.
+Use --list-rules to see them all.
+
+Findings can be suppressed via comments in the model file:
+- "# lint-ignore RULE[,RULE...]" at the end of a line is for that line,
+  on a line by itself it's for the next line
+- "# lint-ignore-file RULE[,RULE...]" is for the entire file
+`
+	lintCmd.Flags().StringP("output", "o", "text",
+		"Output format: text*, json, sarif")
+	lintCmd.Flag("output").DefValue = "" // hide default text
+	lintCmd.Flags().String("rules", "", "File of rule levels: RULE: LEVEL")
+	lintCmd.Flags().StringArray("rule", nil, "Set a rule's level: RULE=LEVEL")
+	lintCmd.Flags().String("fail-on", xrlib.LINT_ERROR,
+		"Fail if there are findings at this level or above (off=never)")
+	lintCmd.Flags().Bool("list-rules", false, "List the rules")
+	modelCmd.AddCommand(lintCmd)
+
 	updateCmd := &cobra.Command{
 		Use:   "update [- | FILE | -d]",
 		Short: "Update the registry's model",
 		Run:   modelUpdateFunc,
 	}
+	updateCmd.Long = updateCmd.Short + "\n" + `
+The model can include a "$migrate" array of operations that change the
+existing data to match the new model. They're run before the data is checked
+against the new model. Each is of the form:
+  { "op": "OP", "target": "XIDTYPE", "attribute": "NAME", ... }
+where OP is one of:
+  rename   rename the attribute to "to"
+  move     move the attribute into the "to" object
+  default  set the attribute to "value" if it's not set
+  map      change the attribute's value per the "values" map (old->new)
+  delete   remove the attribute
+and XIDTYPE is one of: /, /GROUPS, /GROUPS/RESOURCES,
+/GROUPS/RESOURCES/versions or /GROUPS/RESOURCES/meta.`
 	updateCmd.Flags().StringP("data", "d", "",
 		"Data(json), @FILE, @URL, @-(stdin)")
 	modelCmd.AddCommand(updateCmd)
 
+	planCmd := &cobra.Command{
+		Use:   "plan [- | FILE | -d]",
+		Short: "Show what a model update would do to the registry's data",
+		Run:   modelPlanFunc,
+	}
+	planCmd.Long = planCmd.Short + "\n" + `
+Nothing is changed. The plan shows the Group and Resource types that would be
+deleted (and how many entities they have), the entities that would be changed
+by the model's "$migrate" operations (see "model update"), the entities that
+would no longer be valid, and the attributes whose values would be defaulted,
+dropped or coerced by the new model.`
+	planCmd.Flags().StringP("data", "d", "",
+		"Data(json), @FILE, @URL, @-(stdin)")
+	planCmd.Flags().StringP("output", "o", "table",
+		"Output format: table*, json")
+	planCmd.Flag("output").DefValue = "" // hide default text
+	modelCmd.AddCommand(planCmd)
+
 	getCmd := &cobra.Command{
 		Use:   "get",
 		Short: "Retrieve details about the registry's model",
@@ -374,6 +430,23 @@ func VerifyModel(fileName string, buf []byte, skipTarget, fullModel bool) *XRErr
 }
 
 func modelUpdateFunc(cmd *cobra.Command, args []string) {
+	if GetServer() == "" {
+		Error("No Server address provided. Try either -s or XR_SERVER env var")
+	}
+
+	reg, xErr := xrlib.GetRegistry(GetServer())
+	Error(xErr)
+
+	buf := getModelData(cmd, args)
+
+	_, xErr = reg.HttpDo(VerboseCount > 1, "PUT", "/modelsource", []byte(buf))
+	Error(xErr)
+	Verbose("Model updated")
+}
+
+// getModelData returns the model source from either FILE or the -d flag,
+// with any includes already processed
+func getModelData(cmd *cobra.Command, args []string) []byte {
 	var buf []byte
 	var xErr *XRError
 
@@ -385,13 +458,6 @@ func modelUpdateFunc(cmd *cobra.Command, args []string) {
 		Error("Only one FILE is allowed to be specified")
 	}
 
-	if GetServer() == "" {
-		Error("No Server address provided. Try either -s or XR_SERVER env var")
-	}
-
-	reg, xErr := xrlib.GetRegistry(GetServer())
-	Error(xErr)
-
 	fileName := ""
 	if len(args) > 0 {
 		fileName = args[0]
@@ -417,10 +483,7 @@ func modelUpdateFunc(cmd *cobra.Command, args []string) {
 	if len(buf) == 0 {
 		Error("Missing model data")
 	}
-
-	_, xErr = reg.HttpDo(VerboseCount > 1, "PUT", "/modelsource", []byte(buf))
-	Error(xErr)
-	Verbose("Model updated")
+	return buf
 }
 
 func modelGetFunc(cmd *cobra.Command, args []string) {
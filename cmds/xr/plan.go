@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xregistry/server/cmds/xr/xrlib"
+	. "github.com/xregistry/server/common"
+)
+
+// ModelPlan is what the server returns for PUT /modelsource?dryrun
+type ModelPlan struct {
+	Valid        bool `json:"valid"`
+	DeletedTypes []struct {
+		Type      string `json:"type"`
+		Groups    int    `json:"groups"`
+		Resources int    `json:"resources"`
+		Versions  int    `json:"versions"`
+	} `json:"deletedtypes"`
+	Migrated []struct {
+		XID        string   `json:"xid"`
+		Epoch      int      `json:"epoch"`
+		Attributes []string `json:"attributes"`
+	} `json:"migrated"`
+	Invalid []struct {
+		XID    string `json:"xid"`
+		Error  string `json:"error"`
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"invalid"`
+	Changes []struct {
+		XID       string `json:"xid"`
+		Attribute string `json:"attribute"`
+		Change    string `json:"change"`
+		Old       any    `json:"old"`
+		New       any    `json:"new"`
+	} `json:"changes"`
+}
+
+func modelPlanFunc(cmd *cobra.Command, args []string) {
+	output, _ := cmd.Flags().GetString("output")
+	if !ArrayContains([]string{"table", "json"}, output) {
+		Error("--output must be one of: table, json")
+	}
+
+	if GetServer() == "" {
+		Error("No Server address provided. Try either -s or XR_SERVER env var")
+	}
+
+	reg, xErr := xrlib.GetRegistry(GetServer())
+	Error(xErr)
+
+	buf := getModelData(cmd, args)
+
+	res, xErr := reg.HttpDo(VerboseCount > 1, "PUT", "/modelsource?dryrun",
+		buf)
+	Error(xErr)
+
+	if output == "json" {
+		fmt.Printf("%s\n", strings.TrimSpace(string(res.Body)))
+		return
+	}
+
+	plan := ModelPlan{}
+	Error(json.Unmarshal(res.Body, &plan), "Error parsing plan: %s", "err")
+
+	if len(plan.DeletedTypes) > 0 {
+		fmt.Printf("Deleted types:\n")
+		itw := NewTabWriter(os.Stdout, nil, 0, 1, 3, ' ', 0)
+		fmt.Fprintln(itw, "TYPE\tGROUPS\tRESOURCES\tVERSIONS")
+		for _, dt := range plan.DeletedTypes {
+			fmt.Fprintf(itw, "%s\t%d\t%d\t%d\n", dt.Type, dt.Groups,
+				dt.Resources, dt.Versions)
+		}
+		itw.Flush()
+		fmt.Printf("\n")
+	}
+
+	if len(plan.Migrated) > 0 {
+		fmt.Printf("Migrated entities:\n")
+		itw := NewTabWriter(os.Stdout, nil, 0, 1, 3, ' ', 0)
+		fmt.Fprintln(itw, "XID\tEPOCH\tATTRIBUTES")
+		for _, me := range plan.Migrated {
+			fmt.Fprintf(itw, "%s\t%d\t%s\n", me.XID, me.Epoch,
+				strings.Join(me.Attributes, ", "))
+		}
+		itw.Flush()
+		fmt.Printf("\n")
+	}
+
+	if len(plan.Invalid) > 0 {
+		fmt.Printf("Invalid entities:\n")
+		itw := NewTabWriter(os.Stdout, nil, 0, 1, 3, ' ', 0)
+		fmt.Fprintln(itw, "XID\tERROR\tDETAILS")
+		for _, inv := range plan.Invalid {
+			details := inv.Title
+			if inv.Detail != "" {
+				details += " " + inv.Detail
+			}
+			fmt.Fprintf(itw, "%s\t%s\t%s\n", inv.XID, inv.Error, details)
+		}
+		itw.Flush()
+		fmt.Printf("\n")
+	}
+
+	if len(plan.Changes) > 0 {
+		fmt.Printf("Attribute changes:\n")
+		itw := NewTabWriter(os.Stdout, nil, 0, 1, 3, ' ', 0)
+		fmt.Fprintln(itw, "XID\tATTRIBUTE\tCHANGE\tOLD\tNEW")
+		for _, c := range plan.Changes {
+			fmt.Fprintf(itw, "%s\t%s\t%s\t%s\t%s\n", c.XID, c.Attribute,
+				c.Change, planValue(c.Old), planValue(c.New))
+		}
+		itw.Flush()
+		fmt.Printf("\n")
+	}
+
+	if len(plan.DeletedTypes)+len(plan.Migrated)+len(plan.Invalid)+
+		len(plan.Changes) == 0 {
+		fmt.Printf("No changes to existing data\n")
+	}
+
+	if plan.Valid {
+		fmt.Printf("The model can be applied\n")
+	} else {
+		fmt.Printf("The model can't be applied as is\n")
+	}
+}
+
+// planValue shows an attribute's value, "-" when it isn't set
+func planValue(val any) string {
+	if IsNil(val) {
+		return "-"
+	}
+	buf, _ := json.Marshal(val)
+	return string(buf)
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xregistry/server/cmds/xr/xrlib"
+	. "github.com/xregistry/server/common"
+)
+
+func addPushCmd(parent *cobra.Command) {
+	pushCmd := &cobra.Command{
+		Use:   "push DIR [XID]",
+		Short: "Push a directory of entities (from 'download') to the registry",
+		Long: `Push a directory of entities to the registry.
+
+DIR uses the same layout as "xr download" creates:
+  GROUPS/GID/INDEX                         Group attributes
+  GROUPS/GID/RESOURCES/RID$details         Resource attributes
+  GROUPS/GID/RESOURCES/RID/INDEX           Resource document
+  GROUPS/GID/RESOURCES/RID                 Resource document (a plain file)
+  GROUPS/GID/RESOURCES/RID/versions/VID... Versions, same as Resources
+
+If a Resource has a "versions" directory then each Version is managed
+individually, otherwise just the Resource's default Version is. "meta" and
+"*.hdr" files are ignored, as are server-managed attributes (e.g. "epoch").
+When not specified, "contenttype" and "format" are inferred from the file
+extension of the Resource (or Version) ID.
+
+The local files are compared to the entities at (or under) XID and a plan of
+what needs to be created, updated (and with --prune, deleted) is shown before
+it's applied.`,
+		Run:     pushFunc,
+		GroupID: "Entities",
+	}
+	pushCmd.Flags().StringP("index", "i", "index.html",
+		"Directory index file name (index.html*)")
+	pushCmd.Flag("index").DefValue = "" // hide default text
+	pushCmd.Flags().BoolP("prune", "", false,
+		"Delete entities that aren't in DIR")
+	pushCmd.Flags().BoolP("dry-run", "", false,
+		"Show the plan but don't apply it")
+
+	parent.AddCommand(pushCmd)
+}
+
+const (
+	PUSH_CREATE    = "create"
+	PUSH_UPDATE    = "update"
+	PUSH_DELETE    = "delete"
+	PUSH_UNCHANGED = "unchanged"
+)
+
+// pushEntity is a Group, Resource or Version, either from DIR or the server
+type pushEntity struct {
+	xid *Xid
+	gm  *xrlib.GroupModel
+	rm  *xrlib.ResourceModel // nil for Groups
+
+	attrs  map[string]any
+	doc    []byte
+	hasDoc bool
+
+	// No attribute file, so only the doc and inferred attributes are managed
+	partial bool
+
+	// Resources w/"versions" dir - the Versions are pushed, not the Resource
+	versioned bool
+}
+
+type pushStep struct {
+	action string
+	entity *pushEntity
+}
+
+func pushFunc(cmd *cobra.Command, args []string) {
+	if GetServer() == "" {
+		Error("No Server address provided. Try either -s or XR_SERVER env var")
+	}
+
+	if len(args) == 0 {
+		Error("Missing the DIR argument")
+	}
+	if len(args) > 2 {
+		Error("Only one XID is allowed to be specified")
+	}
+
+	reg, xErr := xrlib.GetRegistry(GetServer())
+	Error(xErr)
+
+	dir := args[0]
+	stat, err := os.Stat(dir)
+	if os.IsNotExist(err) || !stat.IsDir() {
+		Error(NewXRError("client_error", dir,
+			"error_detail="+dir+" must be an existing directory"))
+	}
+
+	xidStr := "/"
+	if len(args) == 2 {
+		xidStr = xidWithSlash(args[1])
+	}
+	xid, err := ParseXid(xidStr)
+	Error(err)
+	if xid.Type == ENTITY_META || xid.Type == ENTITY_VERSION_TYPE ||
+		xid.Type == ENTITY_VERSION {
+		Error("Using 'push' on Versions or 'meta' isn't allowed. Try " +
+			"using it on the Resource instead")
+	}
+
+	indexFile, _ := cmd.Flags().GetString("index")
+	prune, _ := cmd.Flags().GetBool("prune")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	local := pushReadDir(reg, dir, indexFile, xid)
+	live := pushReadRegistry(reg, xid)
+
+	plan := pushPlan(reg, local, live, prune)
+
+	counts := map[string]int{}
+	itw := NewTabWriter(os.Stdout, nil, 0, 1, 3, ' ', 0)
+	fmt.Fprintln(itw, "ACTION\tXID")
+	for _, step := range plan {
+		counts[step.action]++
+		if step.action != PUSH_UNCHANGED {
+			fmt.Fprintf(itw, "%s\t%s\n", step.action, step.entity.xid.String())
+		}
+	}
+
+	if len(plan) == counts[PUSH_UNCHANGED] {
+		fmt.Printf("No changes, %d unchanged\n", counts[PUSH_UNCHANGED])
+		return
+	}
+
+	itw.Flush()
+	fmt.Printf("\nPlan: %d to create, %d to update, %d to delete, "+
+		"%d unchanged\n", counts[PUSH_CREATE], counts[PUSH_UPDATE],
+		counts[PUSH_DELETE], counts[PUSH_UNCHANGED])
+
+	if dryRun {
+		return
+	}
+
+	for _, step := range plan {
+		pushApply(reg, step)
+	}
+}
+
+// inScope says whether "xidStr" is "scope" or one of its children
+func inScope(scope *Xid, xidStr string) bool {
+	str := scope.String()
+	return str == "/" || xidStr == str || strings.HasPrefix(xidStr, str+"/")
+}
+
+// pushReadDir walks DIR and returns the entities in it (that are in scope)
+func pushReadDir(reg *xrlib.Registry, dir string, indexFile string, scope *Xid) map[string]*pushEntity {
+	entities := map[string]*pushEntity{}
+
+	for _, gm := range SortedKeys(reg.Model.Groups) {
+		gModel := reg.Model.Groups[gm]
+		gDir := filepath.Join(dir, gModel.Plural)
+		gIDs, _ := pushListDir(gDir)
+
+		for _, gID := range SortedKeys(gIDs) {
+			gXid, err := ParseXid("/" + gModel.Plural + "/" + gID)
+			Error(err)
+			if inScope(scope, gXid.String()) {
+				e := &pushEntity{
+					xid:   gXid,
+					gm:    gModel,
+					attrs: pushReadJSON(filepath.Join(gDir, gID, indexFile)),
+				}
+				e.partial = e.attrs == nil
+				pushCleanAttrs(e)
+				entities[gXid.String()] = e
+			}
+
+			for _, rName := range SortedKeys(gModel.Resources) {
+				rm := gModel.Resources[rName]
+				rDir := filepath.Join(gDir, gID, rm.Plural)
+
+				for _, rID := range pushEntityIDs(rDir, rm, indexFile) {
+					rXid, err := ParseXid(gXid.String() + "/" + rm.Plural +
+						"/" + rID)
+					Error(err)
+
+					vDir := filepath.Join(rDir, rID, "versions")
+					vIDs := pushEntityIDs(vDir, rm, indexFile)
+
+					if inScope(scope, rXid.String()) {
+						e := pushReadEntity(rXid, gModel, rm, rDir, rID, indexFile)
+						e.versioned = len(vIDs) > 0
+						pushInfer(e, rID)
+						entities[rXid.String()] = e
+					}
+
+					for _, vID := range vIDs {
+						vXid, err := ParseXid(rXid.String() + "/versions/" + vID)
+						Error(err)
+						if !inScope(scope, vXid.String()) {
+							continue
+						}
+						e := pushReadEntity(vXid, gModel, rm, vDir, vID, indexFile)
+						pushInfer(e, vID, rID)
+						entities[vXid.String()] = e
+					}
+				}
+			}
+		}
+	}
+
+	return entities
+}
+
+// pushListDir returns the names of the sub-dirs and files in "dir". A
+// missing "dir" (or a file) is the same as an empty one.
+func pushListDir(dir string) (map[string]bool, map[string]bool) {
+	dirs, files := map[string]bool{}, map[string]bool{}
+	if stat, err := os.Stat(dir); err != nil || !stat.IsDir() {
+		return dirs, files
+	}
+	entries, err := os.ReadDir(dir)
+	Error(err)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs[entry.Name()] = true
+		} else {
+			files[entry.Name()] = true
+		}
+	}
+	return dirs, files
+}
+
+// pushEntityIDs returns the IDs of the Resources (or Versions) in "dir".
+// Each is a directory, an "ID$details" file, or for Resources with
+// documents, a plain file holding just the document.
+func pushEntityIDs(dir string, rm *xrlib.ResourceModel, indexFile string) []string {
+	dirs, files := pushListDir(dir)
+	ids := map[string]bool{}
+	for name := range dirs {
+		ids[name] = true
+	}
+	for name := range files {
+		if base, ok := strings.CutSuffix(name, "$details"); ok {
+			ids[base] = true
+			continue
+		}
+		if !rm.HasDoc() || name == indexFile || strings.HasSuffix(name, ".hdr") {
+			continue
+		}
+		// Skip files created by "download --md2html"
+		if base, ok := strings.CutSuffix(name, ".html"); ok &&
+			dirs[base+".md"] {
+			continue
+		}
+		ids[name] = true
+	}
+	return SortedKeys(ids)
+}
+
+// pushReadJSON returns the JSON object in "file", or nil if it's not there
+func pushReadJSON(file string) map[string]any {
+	buf, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	Error(err)
+
+	obj := map[string]any{}
+	Error(json.Unmarshal(buf, &obj), "Error parsing %q: %s", file, "err")
+	return obj
+}
+
+// pushReadEntity gets a Resource's (or Version's) attributes and document
+func pushReadEntity(xid *Xid, gm *xrlib.GroupModel, rm *xrlib.ResourceModel, dir string, id string, indexFile string) *pushEntity {
+	e := &pushEntity{xid: xid, gm: gm, rm: rm}
+	e.attrs = pushReadJSON(filepath.Join(dir, id+"$details"))
+
+	if !rm.HasDoc() {
+		if e.attrs == nil {
+			e.attrs = pushReadJSON(filepath.Join(dir, id, indexFile))
+		}
+		e.partial = e.attrs == nil
+		pushCleanAttrs(e)
+		return e
+	}
+
+	for _, file := range []string{filepath.Join(dir, id, indexFile),
+		filepath.Join(dir, id)} {
+		if stat, err := os.Stat(file); err == nil && !stat.IsDir() {
+			buf, err := os.ReadFile(file)
+			Error(err)
+			e.doc, e.hasDoc = buf, true
+			break
+		}
+	}
+
+	// Allow the doc to be inlined in the $details file too
+	e.partial = e.attrs == nil
+	if !e.partial {
+		if val, ok := e.attrs[rm.Singular+"base64"]; ok {
+			str, _ := val.(string)
+			buf, err := base64.StdEncoding.DecodeString(str)
+			Error(err, "Error decoding %q in %q: %s", rm.Singular+"base64",
+				xid.String(), "err")
+			e.doc, e.hasDoc = buf, true
+		} else if val, ok := e.attrs[rm.Singular]; ok {
+			if str, ok := val.(string); ok {
+				e.doc = []byte(str)
+			} else {
+				e.doc = []byte(ToJSON(val))
+			}
+			e.hasDoc = true
+		}
+	}
+
+	pushCleanAttrs(e)
+	return e
+}
+
+// pushCleanAttrs removes the attributes that are managed by the server, or
+// that aren't managed by "push" (e.g. nested collections), so that what's
+// left can be compared
+func pushCleanAttrs(e *pushEntity) {
+	if e.attrs == nil {
+		e.attrs = map[string]any{}
+	}
+	obj := e.attrs
+
+	for _, key := range []string{"self", "shortself", "xid", "epoch",
+		"createdat", "modifiedat", "isdefault", "ancestorid", "versionid",
+		"formatvalidated", "formatvalidatedreason", "meta", "metaurl",
+		"versions", "versionsurl", "versionscount"} {
+		delete(obj, key)
+	}
+
+	if e.rm == nil {
+		delete(obj, e.gm.Singular+"id")
+		for _, rm := range e.gm.Resources {
+			delete(obj, rm.Plural)
+			delete(obj, rm.Plural+"url")
+			delete(obj, rm.Plural+"count")
+		}
+		return
+	}
+
+	delete(obj, e.rm.Singular+"id")
+	delete(obj, e.rm.Singular)
+	delete(obj, e.rm.Singular+"base64")
+}
+
+// Extensions used to infer "contenttype", and "format" if the model has it
+var pushExtensions = []struct{ ext, contentType, format string }{
+	{".schema.json", "application/schema+json", "JsonSchema"},
+	{".json", "application/json", ""},
+	{".avsc", "application/json", "Avro"},
+	{".proto", "application/x-protobuf", "Protobuf"},
+	{".xsd", "application/xml", "XMLSchema"},
+	{".xml", "application/xml", ""},
+	{".yaml", "application/yaml", ""},
+	{".yml", "application/yaml", ""},
+	{".md", "text/markdown", ""},
+	{".txt", "text/plain", ""},
+}
+
+// pushInfer sets "contenttype" and "format", if not already set, based on
+// the first of "names" with a known file extension
+func pushInfer(e *pushEntity, names ...string) {
+	if !e.hasDoc {
+		return
+	}
+	for _, name := range names {
+		for _, ext := range pushExtensions {
+			if !strings.HasSuffix(strings.ToLower(name), ext.ext) {
+				continue
+			}
+			if _, ok := e.attrs["contenttype"]; !ok {
+				e.attrs["contenttype"] = ext.contentType
+			}
+			if _, ok := e.attrs["format"]; !ok && ext.format != "" &&
+				e.rm.VersionAttributes["format"] != nil {
+				e.attrs["format"] = ext.format
+			}
+			return
+		}
+	}
+}
+
+// pushReadRegistry returns the entities in the registry that are in scope
+func pushReadRegistry(reg *xrlib.Registry, scope *Xid) map[string]*pushEntity {
+	entities := map[string]*pushEntity{}
+
+	add := func(gm *xrlib.GroupModel, rm *xrlib.ResourceModel, objAny any) {
+		obj, _ := objAny.(map[string]any)
+		xidStr, _ := obj["xid"].(string)
+		if xidStr == "" || !inScope(scope, xidStr) {
+			return
+		}
+		xid, err := ParseXid(xidStr)
+		Error(err)
+		e := &pushEntity{xid: xid, gm: gm, rm: rm, attrs: obj}
+		pushCleanAttrs(e)
+		entities[xidStr] = e
+	}
+
+	for _, gName := range SortedKeys(reg.Model.Groups) {
+		gm := reg.Model.Groups[gName]
+		if scope.Type != ENTITY_REGISTRY && scope.Group != gm.Plural {
+			continue
+		}
+
+		inline := []string{}
+		for _, rName := range SortedKeys(gm.Resources) {
+			inline = append(inline, rName, rName+".versions")
+		}
+		path := "/" + gm.Plural
+		if len(inline) > 0 {
+			path = AddQuery(path, "inline="+strings.Join(inline, ","))
+		}
+
+		res, xErr := reg.HttpDo(VerboseCount > 2, "GET", path, nil)
+		Error(xErr)
+		groups := map[string]map[string]any{}
+		err := json.Unmarshal(res.Body, &groups)
+		Error(err, NewXRError("parsing_response", path,
+			"error_detail="+Err2String(err)))
+
+		for _, gObj := range groups {
+			// Grab the nested collections before they're removed by "add"
+			resources := map[*xrlib.ResourceModel]map[string]any{}
+			for _, rm := range gm.Resources {
+				resources[rm], _ = gObj[rm.Plural].(map[string]any)
+			}
+			add(gm, nil, gObj)
+
+			for rm, rObjs := range resources {
+				for _, rObjAny := range rObjs {
+					rObj, _ := rObjAny.(map[string]any)
+					versions, _ := rObj["versions"].(map[string]any)
+					add(gm, rm, rObj)
+					for _, vObj := range versions {
+						add(gm, rm, vObj)
+					}
+				}
+			}
+		}
+	}
+
+	return entities
+}
+
+// pushPlan compares what's in DIR with what's in the registry. Parents are
+// created before their children, and deleting a parent deletes its children
+// so they don't need their own step.
+func pushPlan(reg *xrlib.Registry, local, live map[string]*pushEntity, prune bool) []*pushStep {
+	plan := []*pushStep{}
+
+	for _, key := range SortedKeys(local) {
+		e := local[key]
+		if e.versioned {
+			continue
+		}
+		action := PUSH_CREATE
+		if old := live[key]; old != nil {
+			action = PUSH_UNCHANGED
+			if !pushSameAttrs(e, old) || (e.hasDoc && !pushSameDoc(reg, e)) {
+				action = PUSH_UPDATE
+			}
+		}
+		plan = append(plan, &pushStep{action, e})
+	}
+
+	if !prune {
+		return plan
+	}
+
+	deleted := []string{}
+	for _, key := range SortedKeys(live) {
+		if local[key] != nil {
+			continue
+		}
+
+		isChild := false
+		for _, parent := range deleted {
+			if strings.HasPrefix(key, parent+"/") {
+				isChild = true
+				break
+			}
+		}
+		if isChild {
+			continue
+		}
+
+		// Versions are only pruned when they're managed individually
+		if e := live[key]; e.xid.Type == ENTITY_VERSION {
+			r := local[key[:strings.LastIndex(key, "/versions/")]]
+			if r == nil || !r.versioned {
+				continue
+			}
+		}
+
+		deleted = append(deleted, key)
+		plan = append(plan, &pushStep{PUSH_DELETE, live[key]})
+	}
+
+	return plan
+}
+
+func pushSameAttrs(e, old *pushEntity) bool {
+	if e.partial {
+		for k, v := range e.attrs {
+			if !reflect.DeepEqual(v, old.attrs[k]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// The server might default these so only compare them if set locally
+	oldAttrs := maps.Clone(old.attrs)
+	for _, k := range []string{"contenttype", "format"} {
+		if _, ok := e.attrs[k]; !ok {
+			delete(oldAttrs, k)
+		}
+	}
+	return reflect.DeepEqual(e.attrs, oldAttrs)
+}
+
+func pushSameDoc(reg *xrlib.Registry, e *pushEntity) bool {
+	res, xErr := reg.HttpDo(VerboseCount > 2, "GET", e.xid.String(), nil)
+	Error(xErr)
+	return bytes.Equal(res.Body, e.doc)
+}
+
+func pushApply(reg *xrlib.Registry, step *pushStep) {
+	e := step.entity
+	path := e.xid.String()
+
+	if step.action == PUSH_DELETE {
+		_, xErr := reg.HttpDo(VerboseCount > 2, "DELETE", path, nil)
+		Error(xErr)
+		Verbose("Deleted: %s", path)
+		return
+	}
+	if step.action == PUSH_UNCHANGED {
+		return
+	}
+
+	body := maps.Clone(e.attrs)
+	if e.rm != nil && e.rm.HasDoc() {
+		path += "$details"
+		if e.hasDoc {
+			body[e.rm.Singular+"base64"] =
+				base64.StdEncoding.EncodeToString(e.doc)
+		}
+	}
+
+	// Partial updates shouldn't erase the attributes we don't know about
+	verb := "PUT"
+	if step.action == PUSH_UPDATE && e.partial {
+		verb = "PATCH"
+	}
+
+	_, xErr := reg.HttpDo(VerboseCount > 2, verb, path, []byte(ToJSON(body)))
+	Error(xErr)
+	if step.action == PUSH_CREATE {
+		Verbose("Created: %s", e.xid.String())
+	} else {
+		Verbose("Updated: %s", e.xid.String())
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
@@ -25,7 +26,9 @@ func addSetCmd(parent *cobra.Command) {
 - Use escaped double-quotes (e.g. \"5\") to force it to be a string
 `
 
-	setCmd.Flags().StringP("output", "o", "json", "Output format: json, table")
+	setCmd.Flags().StringP("output", "o", "json",
+		"Output format: "+xrlib.OutputFormatsText("table"))
+	setCmd.Flag("output").DefValue = "" // hide default text
 	setCmd.Flags().BoolP("details", "m", false, "Show resource metadata")
 	// Note that -m is ignored because we'll automatically add $details (or not)
 	// for them, but we include the flag for consistency. Meaning, some folks
@@ -47,8 +50,8 @@ func setFunc(cmd *cobra.Command, args []string) {
 	}
 
 	output, _ := cmd.Flags().GetString("output")
-	if !ArrayContains([]string{"table", "json"}, output) {
-		Error("--output must be one of: json, table")
+	if output != "table" && !xrlib.IsOutputFormat(output) {
+		Error("--output must be one of: " + xrlib.OutputFormatsText("table"))
 	}
 
 	xidStr := args[0]
@@ -174,15 +177,10 @@ func setFunc(cmd *cobra.Command, args []string) {
 
 	Error(json.Unmarshal(res.Body, &object))
 
-	if output == "json" {
-		fmt.Printf("%s\n", xrlib.PrettyPrint(object, "", "  "))
-		return
-	}
-
 	if output == "table" {
 		fmt.Printf("%s\n", xrlib.Tablize(xid.String(), object))
 		return
 	}
 
-	Error("Unknown output format: %s", output)
+	Error(xrlib.WriteOutput(os.Stdout, xid.String(), object, output))
 }
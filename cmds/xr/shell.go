@@ -0,0 +1,657 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/xregistry/server/cmds/xr/xrlib"
+	. "github.com/xregistry/server/common"
+	"golang.org/x/term"
+)
+
+func addShellCmd(parent *cobra.Command) {
+	shellCmd := &cobra.Command{
+		Use:   "shell [XID]",
+		Short: "Run an interactive shell",
+		Long: `Run an interactive shell.
+
+Each line is either one of the shell commands below, or any other "xr"
+command (without the "xr"). XID arguments can be relative to the current
+XID, and when a command's optional XID is left off then the current XID is
+used. Tab completes commands, flags, IDs and attribute names.
+
+Shell commands:
+  cd [XID]    Change the current XID ("/" if not specified)
+  ls [XID]    List what's under the current XID (or XID)
+  pwd         Show the current XID
+  history     Show the command history
+  help        Show this text, and the list of "xr" commands
+  exit, quit  Exit the shell (so will Ctrl-D)`,
+		Run:     shellFunc,
+		GroupID: "Admin",
+	}
+	shellCmd.Flags().String("history", "",
+		"History file ($HOME/.xr_history), \"\" for none")
+	shellCmd.Flag("history").DefValue = "" // hide default text
+
+	parent.AddCommand(shellCmd)
+}
+
+var SHELL_BUILTINS = []string{"cd", "exit", "help", "history", "ls", "pwd",
+	"quit"}
+
+// Flags whose values are attribute names, for tab completion
+var SHELL_ATTR_FLAGS = []string{"--del", "--facet", "--fields", "--filter",
+	"-f", "--set", "--sort"}
+
+const SHELL_HISTORY_MAX = 1000
+
+type xrShell struct {
+	root   *cobra.Command
+	server string
+	config string
+	cwd    *Xid
+
+	reg   *xrlib.Registry
+	stale bool // model might have changed, refresh it before using it again
+
+	history *shellHistory
+	out     io.Writer // where to show tab completion choices
+}
+
+func shellFunc(cmd *cobra.Command, args []string) {
+	if GetServer() == "" {
+		Error("No Server address provided. Try either -s or XR_SERVER env var")
+	}
+	if len(args) > 1 {
+		Error("Only one XID is allowed to be specified")
+	}
+
+	sh := &xrShell{
+		root:    cmd.Root(),
+		server:  GetServer(),
+		history: &shellHistory{},
+		out:     os.Stdout,
+	}
+	sh.config, _ = cmd.Flags().GetString("config")
+	sh.cwd, _ = ParseXid("/")
+
+	if len(args) == 1 {
+		Error(sh.cd(args[0]))
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		// Not interactive, just run each line and don't save the history
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			sh.history.Add(scanner.Text())
+			if !sh.run(scanner.Text()) {
+				break
+			}
+		}
+		return
+	}
+
+	if cmd.Flags().Changed("history") {
+		sh.history.file, _ = cmd.Flags().GetString("history")
+	} else if home, _ := os.UserHomeDir(); home != "" {
+		sh.history.file = home + "/.xr_history"
+	}
+	Error(sh.history.Load())
+
+	t := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, "")
+	t.History = sh.history
+	t.AutoCompleteCallback = sh.complete
+	sh.out = t
+
+	for {
+		if width, height, err := term.GetSize(fd); err == nil {
+			t.SetSize(width, height)
+		}
+		t.SetPrompt("xr:" + sh.cwd.String() + "> ")
+
+		state, err := term.MakeRaw(fd)
+		Error(err)
+		line, err := t.ReadLine()
+		term.Restore(fd, state)
+
+		if err == io.EOF {
+			fmt.Println()
+			break
+		}
+		Error(err)
+
+		if !sh.run(line) {
+			break
+		}
+	}
+}
+
+// run executes one line, and returns false when it's time to exit
+func (sh *xrShell) run(line string) bool {
+	args := SplitCommandLine(line)
+	if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+		return true
+	}
+
+	switch args[0] {
+	case "exit", "quit":
+		return false
+	case "pwd":
+		fmt.Println(sh.cwd.String())
+	case "cd":
+		if len(args) > 2 {
+			ShowError("Only one XID is allowed to be specified")
+			break
+		}
+		target := "/"
+		if len(args) == 2 {
+			target = args[1]
+		}
+		ShowError(sh.cd(target))
+	case "ls":
+		sh.ls(args[1:])
+	case "history":
+		for i := sh.history.Len() - 1; i >= 0; i-- {
+			fmt.Printf("%5d  %s\n", sh.history.Len()-i, sh.history.At(i))
+		}
+	case "help":
+		if len(args) == 1 {
+			if cmd, _, err := sh.root.Find([]string{"shell"}); err == nil {
+				fmt.Printf("%s\n\n", cmd.Long[strings.Index(cmd.Long,
+					"Shell commands:"):])
+			}
+		}
+		sh.exec(args)
+	default:
+		sh.exec(args)
+	}
+	return true
+}
+
+// exec runs an "xr" command as a child process so that its errors (which
+// exit) and flags don't mess with the shell
+func (sh *xrShell) exec(args []string) {
+	sub, _, err := sh.root.Find(args)
+	if err != nil || sub == sh.root {
+		ShowError("Unknown command: %s", args[0])
+		return
+	}
+	if sub.Name() == "shell" {
+		ShowError("Already in a shell")
+		return
+	}
+
+	args = sh.resolveArgs(sub, args)
+	if sh.config != "" {
+		args = append(args, "--config", sh.config)
+	}
+
+	exe, err := os.Executable()
+	if ShowError(err) {
+		return
+	}
+	child := exec.Command(exe, args...)
+	child.Env = append(os.Environ(), "XR_SERVER="+sh.server,
+		"XR_CONTEXT="+CurrentContext)
+	child.Stdin, child.Stdout, child.Stderr = os.Stdin, os.Stdout, os.Stderr
+
+	// Let Ctrl-C stop the child (e.g. "get --watch") but not the shell
+	sigCH := make(chan os.Signal, 1)
+	signal.Notify(sigCH, os.Interrupt)
+	child.Run() // it'll show its own errors
+	signal.Stop(sigCH)
+
+	sh.stale = true
+}
+
+// resolve turns a (possibly relative) XID into an absolute one. Anything
+// that isn't an XID (e.g. "@FILE") is left alone.
+func (sh *xrShell) resolve(arg string) string {
+	if arg == "" || arg[0] == '@' || arg == "-" ||
+		strings.HasPrefix(arg, "http:") || strings.HasPrefix(arg, "https:") {
+		return arg
+	}
+
+	xidStr, query, hasQuery := strings.Cut(arg, "?")
+	if xidStr == "" || xidStr[0] != '/' {
+		xidStr = sh.cwd.String() + "/" + xidStr
+	}
+	xidStr = path.Clean(xidStr)
+	if hasQuery {
+		xidStr += "?" + query
+	}
+	return xidStr
+}
+
+// resolveArgs makes the XID args of "sub" absolute, and adds the current
+// XID if the command's optional XID wasn't specified. Which args are XIDs
+// comes from the command's "Use" text, e.g. "push DIR [XID]".
+func (sh *xrShell) resolveArgs(sub *cobra.Command, args []string) []string {
+	specs := strings.Fields(sub.Use)[1:]
+	isXid := func(pos int) bool {
+		if pos < len(specs) {
+			return strings.Contains(specs[pos], "XID")
+		}
+		last := len(specs) - 1
+		return last >= 0 && strings.Contains(specs[last], "XID...")
+	}
+
+	depth := 0
+	for c := sub; c != sh.root && c != nil; c = c.Parent() {
+		depth++
+	}
+
+	res := append([]string{}, args[:depth]...)
+	pos, onlyArgs := 0, false
+	for i := depth; i < len(args); i++ {
+		arg := args[i]
+		if !onlyArgs && arg == "--" {
+			onlyArgs = true
+			res = append(res, arg)
+			continue
+		}
+		if !onlyArgs && len(arg) > 1 && arg[0] == '-' {
+			res = append(res, arg)
+			if shellFlagTakesValue(sub, arg) && i+1 < len(args) {
+				i++
+				res = append(res, args[i])
+			}
+			continue
+		}
+		if isXid(pos) {
+			arg = sh.resolve(arg)
+		}
+		res = append(res, arg)
+		pos++
+	}
+
+	if pos < len(specs) && strings.HasPrefix(specs[pos], "[XID") &&
+		sh.cwd.String() != "/" {
+		res = append(res, sh.cwd.String())
+	}
+	return res
+}
+
+// shellFlagTakesValue says whether "arg" (e.g. "-d", "--data") is a flag
+// whose value is the next arg
+func shellFlagTakesValue(cmd *cobra.Command, arg string) bool {
+	if strings.Contains(arg, "=") {
+		return false
+	}
+
+	if name, ok := strings.CutPrefix(arg, "--"); ok {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			flag = cmd.InheritedFlags().Lookup(name)
+		}
+		return flag != nil && flag.NoOptDefVal == ""
+	}
+
+	// "-abc", if "b" takes a value then it's "c", if "c" does it's next arg
+	shorts := arg[1:]
+	for i, ch := range shorts {
+		flag := cmd.Flags().ShorthandLookup(string(ch))
+		if flag == nil {
+			flag = cmd.InheritedFlags().ShorthandLookup(string(ch))
+		}
+		if flag != nil && flag.NoOptDefVal == "" {
+			return i == len(shorts)-1
+		}
+	}
+	return false
+}
+
+func (sh *xrShell) registry() (*xrlib.Registry, *XRError) {
+	if sh.reg == nil {
+		reg, xErr := xrlib.GetRegistry(sh.server)
+		if xErr != nil {
+			return nil, xErr
+		}
+		sh.reg, sh.stale = reg, false
+	}
+	if sh.stale {
+		if xErr := sh.reg.RefreshModel(); xErr != nil {
+			return nil, xErr
+		}
+		sh.stale = false
+	}
+	return sh.reg, nil
+}
+
+// cd changes the current XID, but only to something that exists
+func (sh *xrShell) cd(target string) *XRError {
+	xidStr := sh.resolve(target)
+	xid, err := ParseXid(xidStr)
+	if err != nil {
+		return NewXRError("malformed_xid", "", "xid="+xidStr,
+			"error_detail="+err.Error())
+	}
+	if xid.Type == ENTITY_MODEL {
+		return NewXRError("client_error", xidStr,
+			"error_detail=Can't 'cd' into the model")
+	}
+
+	reg, xErr := sh.registry()
+	if xErr != nil {
+		return xErr
+	}
+
+	path := xid.String()
+	if xid.Type == ENTITY_RESOURCE || xid.Type == ENTITY_VERSION {
+		rm, xErr := xrlib.GetResourceModelFrom(xid, reg)
+		if xErr != nil {
+			return xErr
+		}
+		if rm.HasDoc() {
+			path += "$details"
+		}
+	}
+	if _, xErr = reg.HttpDo(VerboseCount > 2, "GET", path, nil); xErr != nil {
+		return xErr
+	}
+
+	sh.cwd = xid
+	return nil
+}
+
+func (sh *xrShell) ls(args []string) {
+	xid := sh.cwd
+	if len(args) > 1 {
+		ShowError("Only one XID is allowed to be specified")
+		return
+	}
+	if len(args) == 1 {
+		var err error
+		xid, err = ParseXid(sh.resolve(args[0]))
+		if ShowError(err) {
+			return
+		}
+	}
+
+	names, xErr := sh.children(xid)
+	if ShowError(xErr) {
+		return
+	}
+	for _, name := range names {
+		fmt.Println(name)
+	}
+}
+
+// children returns the names of the things under "xid". Ones that can be
+// cd'd into end with "/".
+func (sh *xrShell) children(xid *Xid) ([]string, *XRError) {
+	reg, xErr := sh.registry()
+	if xErr != nil {
+		return nil, xErr
+	}
+
+	names := []string{}
+	switch xid.Type {
+	case ENTITY_REGISTRY:
+		for _, plural := range SortedKeys(reg.Model.Groups) {
+			names = append(names, plural+"/")
+		}
+
+	case ENTITY_GROUP:
+		gm, xErr := reg.FindGroupModel(xid.Group)
+		if xErr != nil {
+			return nil, xErr
+		}
+		if gm == nil {
+			return nil, NewXRError("not_found", xid.Group).
+				SetDetailf("Unknown Group type: %s.", xid.Group)
+		}
+		for _, plural := range SortedKeys(gm.Resources) {
+			names = append(names, plural+"/")
+		}
+
+	case ENTITY_RESOURCE:
+		names = append(names, "meta", "versions/")
+
+	case ENTITY_GROUP_TYPE, ENTITY_RESOURCE_TYPE, ENTITY_VERSION_TYPE:
+		res, xErr := reg.HttpDo(VerboseCount > 2, "GET", xid.String(), nil)
+		if xErr != nil {
+			return nil, xErr
+		}
+		suffix := "/"
+		if xid.Type == ENTITY_VERSION_TYPE {
+			suffix = ""
+		}
+		for _, id := range SortedKeys(res.JSON) {
+			names = append(names, id+suffix)
+		}
+	}
+	return names, nil
+}
+
+// attrNames returns the names of the attributes "xid" can have
+func (sh *xrShell) attrNames(xid *Xid) []string {
+	reg, xErr := sh.registry()
+	if xErr != nil {
+		return nil
+	}
+	model, xErr := reg.GetModel()
+	if xErr != nil {
+		return nil
+	}
+
+	attrs := map[string]*xrlib.Attribute(nil)
+	switch xid.Type {
+	case ENTITY_REGISTRY:
+		_, attrs = model.GetPropsOrdered()
+	case ENTITY_GROUP:
+		if gm := model.Groups[xid.Group]; gm != nil {
+			_, attrs = gm.GetPropsOrdered()
+		}
+	case ENTITY_RESOURCE, ENTITY_META, ENTITY_VERSION:
+		rm, xErr := xrlib.GetResourceModelFrom(xid, reg)
+		if xErr != nil || rm == nil {
+			return nil
+		}
+		switch xid.Type {
+		case ENTITY_RESOURCE:
+			_, attrs = rm.GetPropsOrdered()
+		case ENTITY_META:
+			_, attrs = rm.GetMetaPropsOrdered()
+		default:
+			_, attrs = rm.GetVersionPropsOrdered()
+		}
+	}
+
+	names := []string{}
+	for _, name := range SortedKeys(attrs) {
+		if name != "*" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// candidates returns all possible values for "word" given the "words"
+// before it
+func (sh *xrShell) candidates(words []string, word string) []string {
+	if len(words) == 0 {
+		res := append([]string{}, SHELL_BUILTINS...)
+		for _, cmd := range sh.root.Commands() {
+			if !cmd.Hidden && cmd.Name() != "shell" {
+				res = append(res, cmd.Name())
+			}
+		}
+		return res
+	}
+
+	sub := sh.root
+	if !ArrayContains(SHELL_BUILTINS, words[0]) {
+		sub, _, _ = sh.root.Find(words)
+	}
+
+	if sub.HasSubCommands() && sub != sh.root {
+		res := []string{}
+		for _, cmd := range sub.Commands() {
+			if !cmd.Hidden {
+				res = append(res, cmd.Name())
+			}
+		}
+		return res
+	}
+
+	if strings.HasPrefix(word, "-") && sub != sh.root {
+		res := []string{}
+		for _, flags := range []*pflag.FlagSet{sub.Flags(),
+			sub.InheritedFlags()} {
+			flags.VisitAll(func(flag *pflag.Flag) {
+				if !flag.Hidden {
+					res = append(res, "--"+flag.Name)
+				}
+			})
+		}
+		return res
+	}
+
+	if ArrayContains(SHELL_ATTR_FLAGS, words[len(words)-1]) {
+		xid := sh.cwd
+		for _, w := range words[1:] {
+			if !strings.HasPrefix(w, "-") {
+				if tmp, err := ParseXid(sh.resolve(w)); err == nil {
+					xid = tmp
+				}
+				break
+			}
+		}
+		return sh.attrNames(xid)
+	}
+
+	// Anything else is assumed to be an XID
+	dir := word[:strings.LastIndex(word, "/")+1]
+	xid, err := ParseXid(sh.resolve(dir + "."))
+	if err != nil {
+		return nil
+	}
+	names, xErr := sh.children(xid)
+	if xErr != nil {
+		return nil
+	}
+	for i, name := range names {
+		names[i] = dir + name
+	}
+	return names
+}
+
+// complete is the terminal's tab completion callback
+func (sh *xrShell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+
+	head, tail := line[:pos], line[pos:]
+	start := strings.LastIndex(head, " ") + 1
+	word := head[start:]
+
+	matches := []string{}
+	for _, c := range sh.candidates(strings.Fields(head[:start]), word) {
+		if strings.HasPrefix(c, word) {
+			matches = append(matches, c)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	sort.Strings(matches)
+
+	common := matches[0]
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(m, common) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	if len(matches) == 1 && !strings.HasSuffix(common, "/") {
+		common += " "
+	} else if common == word {
+		// Nothing more to add so show the choices
+		dir := word[:strings.LastIndex(word, "/")+1]
+		for i, m := range matches {
+			matches[i] = strings.TrimPrefix(m, dir)
+		}
+		fmt.Fprintf(sh.out, "%s\r\n", strings.Join(matches, "  "))
+	}
+
+	head = head[:start] + common
+	return head + tail, len(head), true
+}
+
+// shellHistory is the terminal's command history, saved in "file" (if set)
+type shellHistory struct {
+	entries []string // oldest first
+	file    string
+}
+
+func (h *shellHistory) Load() error {
+	if h.file == "" {
+		return nil
+	}
+	buf, err := os.ReadFile(h.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	for _, line := range lines {
+		h.add(line)
+	}
+
+	// Don't let the file grow forever
+	if len(lines) > SHELL_HISTORY_MAX {
+		return os.WriteFile(h.file,
+			[]byte(strings.Join(h.entries, "\n")+"\n"), 0600)
+	}
+	return nil
+}
+
+func (h *shellHistory) add(entry string) bool {
+	if strings.TrimSpace(entry) == "" ||
+		(len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return false
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > SHELL_HISTORY_MAX {
+		h.entries = h.entries[len(h.entries)-SHELL_HISTORY_MAX:]
+	}
+	return true
+}
+
+func (h *shellHistory) Add(entry string) {
+	if !h.add(entry) || h.file == "" {
+		return
+	}
+	f, err := os.OpenFile(h.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err == nil {
+		fmt.Fprintln(f, entry)
+		f.Close()
+	}
+}
+
+func (h *shellHistory) Len() int {
+	return len(h.entries)
+}
+
+func (h *shellHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
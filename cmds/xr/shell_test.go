@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	. "github.com/xregistry/server/common"
+)
+
+func shellSample(cwd string) *xrShell {
+	root := &cobra.Command{Use: "xr"}
+	root.PersistentFlags().CountP("verbose", "v", "")
+	root.PersistentFlags().StringP("server", "s", "", "")
+
+	get := &cobra.Command{Use: "get [XID]", Run: func(*cobra.Command, []string) {}}
+	get.Flags().StringP("output", "o", "json", "")
+	get.Flags().BoolP("details", "m", false, "")
+	root.AddCommand(get)
+
+	push := &cobra.Command{Use: "push DIR [XID]", Run: func(*cobra.Command, []string) {}}
+	push.Flags().Bool("prune", false, "")
+	root.AddCommand(push)
+
+	diff := &cobra.Command{Use: "diff XID|@FILE [XID|@FILE]", Run: func(*cobra.Command, []string) {}}
+	root.AddCommand(diff)
+
+	model := &cobra.Command{Use: "model", Run: func(*cobra.Command, []string) {}}
+	model.AddCommand(&cobra.Command{Use: "verify [- | FILE...]", Run: func(*cobra.Command, []string) {}})
+	root.AddCommand(model)
+
+	sh := &xrShell{root: root, history: &shellHistory{}}
+	sh.cwd, _ = ParseXid(cwd)
+	return sh
+}
+
+func TestShellResolve(t *testing.T) {
+	sh := shellSample("/dirs/d1")
+	tests := []struct{ arg, exp string }{
+		{".", "/dirs/d1"},
+		{"..", "/dirs"},
+		{"../..", "/"},
+		{"files/f1", "/dirs/d1/files/f1"},
+		{"files/f1/", "/dirs/d1/files/f1"},
+		{"files/f1$details", "/dirs/d1/files/f1$details"},
+		{"/dirs/d2", "/dirs/d2"},
+		{"files?inline=*", "/dirs/d1/files?inline=*"},
+		{"@file.json", "@file.json"},
+		{"-", "-"},
+		{"http://example.com/x", "http://example.com/x"},
+	}
+	for _, test := range tests {
+		if got := sh.resolve(test.arg); got != test.exp {
+			t.Errorf("resolve(%q): expected %q, got %q", test.arg, test.exp, got)
+		}
+	}
+}
+
+func TestShellResolveArgs(t *testing.T) {
+	tests := []struct{ cwd, line, exp string }{
+		{"/dirs/d1", "get", "get /dirs/d1"},
+		{"/", "get", "get"},
+		{"/dirs/d1", "get files", "get /dirs/d1/files"},
+		{"/dirs/d1", "get -o table files", "get -o table /dirs/d1/files"},
+		{"/dirs/d1", "get -mo table", "get -mo table /dirs/d1"},
+		{"/dirs/d1", "get -om table", "get -om /dirs/d1/table"},
+		{"/dirs/d1", "get --output=table -v ..", "get --output=table -v /dirs"},
+		{"/dirs/d1", "get -s http://x f1", "get -s http://x /dirs/d1/f1"},
+		{"/dirs/d1", "push mydir --prune", "push mydir --prune /dirs/d1"},
+		{"/dirs/d1", "push mydir files", "push mydir /dirs/d1/files"},
+		{"/dirs/d1", "diff @a.json f1", "diff @a.json /dirs/d1/f1"},
+		{"/dirs/d1", "model verify f1", "model verify f1"},
+		{"/dirs/d1", "get -- -x", "get -- /dirs/d1/-x"},
+	}
+	for _, test := range tests {
+		sh := shellSample(test.cwd)
+		args := SplitCommandLine(test.line)
+		sub, _, err := sh.root.Find(args)
+		if err != nil {
+			t.Fatalf("%q: %s", test.line, err)
+		}
+		got := strings.Join(sh.resolveArgs(sub, args), " ")
+		if got != test.exp {
+			t.Errorf("%q: expected %q, got %q", test.line, test.exp, got)
+		}
+	}
+}
+
+func TestShellComplete(t *testing.T) {
+	sh := shellSample("/")
+	out := &bytes.Buffer{}
+	sh.out = out
+
+	tests := []struct {
+		line, exp string
+		pos       int
+		choices   string
+	}{
+		{line: "ge", exp: "get ", pos: 4},
+		{line: "p", exp: "p", pos: 1, choices: "push  pwd\r\n"},
+		{line: "pu x", exp: "push  x", pos: 5},
+		{line: "mod", exp: "model ", pos: 6},
+		{line: "model ver", exp: "model verify ", pos: 13},
+		{line: "get --out", exp: "get --output ", pos: 13},
+		{line: "push --p", exp: "push --prune ", pos: 13},
+		{line: "zz", exp: "", pos: 0},
+	}
+	for _, test := range tests {
+		out.Reset()
+		pos := strings.Index(test.line, " x")
+		if pos < 0 {
+			pos = len(test.line)
+		}
+		line, newPos, ok := sh.complete(test.line, pos, '\t')
+		if ok != (test.exp != "") || line != test.exp || newPos != test.pos {
+			t.Errorf("%q: expected %q/%d, got %q/%d/%v", test.line,
+				test.exp, test.pos, line, newPos, ok)
+		}
+		if out.String() != test.choices {
+			t.Errorf("%q: expected choices %q, got %q", test.line,
+				test.choices, out.String())
+		}
+	}
+
+	if _, _, ok := sh.complete("ge", 2, 'x'); ok {
+		t.Errorf("Only tab should complete")
+	}
+}
+
+func TestShellHistory(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "history")
+	h := &shellHistory{file: file}
+	if err := h.Load(); err != nil {
+		t.Fatalf("Load: %s", err)
+	}
+
+	for _, line := range []string{"ls", "ls", " ", "cd dirs", "pwd"} {
+		h.Add(line)
+	}
+	if h.Len() != 3 || h.At(0) != "pwd" || h.At(2) != "ls" {
+		t.Fatalf("Bad history: %q", h.entries)
+	}
+
+	buf, _ := os.ReadFile(file)
+	if string(buf) != "ls\ncd dirs\npwd\n" {
+		t.Fatalf("Bad history file: %q", string(buf))
+	}
+
+	h = &shellHistory{file: file}
+	if err := h.Load(); err != nil || h.Len() != 3 || h.At(0) != "pwd" {
+		t.Fatalf("Bad reloaded history(%v): %q", err, h.entries)
+	}
+
+	// The file should be trimmed when it gets too big
+	lines := strings.Repeat("x\ny\n", SHELL_HISTORY_MAX)
+	os.WriteFile(file, []byte(lines), 0600)
+	h = &shellHistory{file: file}
+	if err := h.Load(); err != nil || h.Len() != SHELL_HISTORY_MAX {
+		t.Fatalf("Bad trimmed history(%v): %d", err, h.Len())
+	}
+	buf, _ = os.ReadFile(file)
+	if strings.Count(string(buf), "\n") != SHELL_HISTORY_MAX {
+		t.Fatalf("History file wasn't trimmed")
+	}
+}
@@ -70,6 +70,9 @@ type TD struct {
 	NumFail int
 	NumWarn int
 	NumSkip int
+
+	Start    time.Time
+	Duration time.Duration // Only set for TDs that are Run()
 }
 
 func NewTD(parent *TD, args ...any) *TD {
@@ -105,6 +108,7 @@ func oldNewTD(name string, parents ...*TD) *TD {
 		Status:  PASS,
 		Props:   map[string]any{},
 		NumPass: 1,
+		Start:   time.Now(),
 	}
 
 	if p != nil {
@@ -417,6 +421,7 @@ func (td *TD) Run(fn TestFn) *TD {
 	// Run it and catch any panic()
 	func() {
 		defer func() {
+			newTD.Duration = time.Since(newTD.Start)
 			if r := recover(); r != nil {
 				// Do nothing
 				// Just allow the panic() caller to exit immediately
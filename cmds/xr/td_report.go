@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Machine-readable versions of a TD tree, for CI systems and dashboards.
+// "Print" is still the human-oriented version.
+
+var ReportFormats = []string{"json", "junit", "tap"}
+
+func (td *TD) WriteReport(out io.Writer, format string) error {
+	switch format {
+	case "json":
+		return td.writeJSON(out)
+	case "junit":
+		return td.writeJUnit(out)
+	case "tap":
+		return td.writeTAP(out)
+	}
+	return fmt.Errorf("unknown report format %q, must be one of: %s",
+		format, strings.Join(ReportFormats, ", "))
+}
+
+// Path returns the names of "td" and its parents, minus the top one since
+// that's just the server's URL
+func (td *TD) Path() string {
+	names := []string{}
+	for p := td; p != nil && p.Parent != nil; p = p.Parent {
+		names = append([]string{p.TestName}, names...)
+	}
+	return strings.Join(names, "/")
+}
+
+// entryDuration is how long it took to get to "le" since the previous
+// entry (or the start of "td")
+func (td *TD) entryDuration(i int) time.Duration {
+	prev := td.Start
+	if i > 0 {
+		prev = td.Logs[i-1].Date
+		if sub := td.Logs[i-1].Subtest; sub != nil {
+			prev = sub.Start.Add(sub.Duration)
+		}
+	}
+	if prev.IsZero() || td.Logs[i].Date.Before(prev) {
+		return 0
+	}
+	return td.Logs[i].Date.Sub(prev)
+}
+
+// JSON
+// ////////////////////////////////////////////////////////////////
+
+type jsonReport struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path,omitempty"`
+	Status   string      `json:"status"`
+	NumPass  int         `json:"numpass"`
+	NumFail  int         `json:"numfail"`
+	NumWarn  int         `json:"numwarn"`
+	NumSkip  int         `json:"numskip"`
+	Start    string      `json:"start,omitempty"`
+	Duration float64     `json:"duration"` // seconds
+	Entries  []jsonEntry `json:"entries,omitempty"`
+}
+
+type jsonEntry struct {
+	Type string      `json:"type,omitempty"` // PASS, FAIL, ... LOG, MSG
+	Text string      `json:"text,omitempty"`
+	Date string      `json:"date,omitempty"`
+	Test *jsonReport `json:"test,omitempty"`
+}
+
+func (td *TD) toJSONReport() *jsonReport {
+	res := &jsonReport{
+		Name:     td.TestName,
+		Path:     td.Path(),
+		Status:   StatusText[td.Status],
+		NumPass:  td.NumPass,
+		NumFail:  td.NumFail,
+		NumWarn:  td.NumWarn,
+		NumSkip:  td.NumSkip,
+		Duration: td.Duration.Seconds(),
+		Entries:  []jsonEntry{},
+	}
+	if !td.Start.IsZero() {
+		res.Start = td.Start.Format(time.RFC3339Nano)
+	}
+
+	for _, le := range td.Logs {
+		if le.Subtest != nil {
+			res.Entries = append(res.Entries,
+				jsonEntry{Test: le.Subtest.toJSONReport()})
+			continue
+		}
+		res.Entries = append(res.Entries, jsonEntry{
+			Type: StatusText[le.Type],
+			Text: le.Text,
+			Date: le.Date.Format(time.RFC3339Nano),
+		})
+	}
+	return res
+}
+
+func (td *TD) writeJSON(out io.Writer) error {
+	buf, err := json.MarshalIndent(td.toJSONReport(), "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(append(buf, '\n'))
+	return err
+}
+
+// JUnit XML
+// Each TD is a <testsuite> and each PASS/FAIL/WARN/SKIP is a <testcase>.
+// LOGs go into the suite's <system-out>. JUnit has no "warning" so those
+// are passing testcases with the text in their <system-out>.
+// ////////////////////////////////////////////////////////////////
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+	SystemOut string      `xml:"system-out,omitempty"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure"`
+	Skipped   *junitMessage `xml:"skipped"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+func (td *TD) junitSuites(suites []junitSuite) []junitSuite {
+	suite := junitSuite{
+		Name: td.Path(),
+		Time: junitTime(td.Duration),
+	}
+	if suite.Name == "" {
+		suite.Name = td.TestName
+	}
+	if !td.Start.IsZero() {
+		suite.Timestamp = td.Start.Format(time.RFC3339)
+	}
+
+	logs := []string{}
+	children := []*TD{}
+	for i, le := range td.Logs {
+		if le.Subtest != nil {
+			children = append(children, le.Subtest)
+			continue
+		}
+		if le.Type >= LOG {
+			logs = append(logs, le.Text)
+			continue
+		}
+
+		tc := junitCase{
+			Name:      le.Text,
+			ClassName: suite.Name,
+			Time:      junitTime(td.entryDuration(i)),
+		}
+		switch le.Type {
+		case FAIL:
+			tc.Failure = &junitMessage{Message: le.Text}
+			suite.Failures++
+		case SKIP:
+			tc.Skipped = &junitMessage{Message: le.Text}
+			suite.Skipped++
+		case WARN:
+			tc.SystemOut = "WARN: " + le.Text
+		}
+		suite.Tests++
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.SystemOut = strings.Join(logs, "\n")
+
+	// Don't include empty suites, e.g. the top-level one
+	if suite.Tests > 0 || suite.SystemOut != "" {
+		suites = append(suites, suite)
+	}
+	for _, child := range children {
+		suites = child.junitSuites(suites)
+	}
+	return suites
+}
+
+func (td *TD) writeJUnit(out io.Writer) error {
+	res := junitSuites{
+		Name:   td.TestName,
+		Time:   junitTime(td.Duration),
+		Suites: td.junitSuites(nil),
+	}
+	for _, suite := range res.Suites {
+		res.Tests += suite.Tests
+		res.Failures += suite.Failures
+		res.Skipped += suite.Skipped
+	}
+
+	buf, err := xml.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(out, "%s%s\n", xml.Header, buf)
+	return err
+}
+
+// TAP (version 13)
+// One test point per PASS/FAIL/WARN/SKIP, named by the TD's path. LOGs
+// are "#" diagnostic lines. TAP has no notion of a warning, so:
+//   PASS -> "ok"
+//   FAIL -> "not ok"
+//   SKIP -> "ok ... # SKIP"
+//   WARN -> "ok ... # TODO warning", plus "severity: warning" in its YAML
+//           block. It didn't fail, but it's something that should be fixed.
+// ////////////////////////////////////////////////////////////////
+
+func (td *TD) tapLines(lines []string, num *int) []string {
+	path := td.Path()
+	if path != "" {
+		path += ": "
+	}
+
+	for i, le := range td.Logs {
+		if le.Subtest != nil {
+			lines = le.Subtest.tapLines(lines, num)
+			continue
+		}
+
+		text := strings.ReplaceAll(le.Text, "\n", " ")
+		if le.Type >= LOG {
+			for _, line := range strings.Split(le.Text, "\n") {
+				lines = append(lines, "# "+line)
+			}
+			continue
+		}
+
+		*num++
+		line := fmt.Sprintf("ok %d - %s%s", *num, path,
+			strings.ReplaceAll(text, "#", "\\#"))
+		switch le.Type {
+		case FAIL:
+			line = "not " + line
+		case SKIP:
+			line += " # SKIP"
+		case WARN:
+			line += " # TODO warning"
+		}
+		lines = append(lines, line, "  ---")
+		if le.Type == WARN {
+			lines = append(lines, "  severity: warning")
+		}
+		lines = append(lines,
+			fmt.Sprintf("  duration_ms: %.3f",
+				float64(td.entryDuration(i).Microseconds())/1000),
+			"  ...")
+	}
+	return lines
+}
+
+func (td *TD) writeTAP(out io.Writer) error {
+	num := 0
+	lines := td.tapLines(nil, &num)
+
+	_, err := fmt.Fprintf(out, "TAP version 13\n1..%d\n", num)
+	if err == nil && len(lines) > 0 {
+		_, err = fmt.Fprintf(out, "%s\n", strings.Join(lines, "\n"))
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(out, "# pass %d\n# fail %d\n# warn %d\n# skip %d\n",
+			td.NumPass, td.NumFail, td.NumWarn, td.NumSkip)
+	}
+	return err
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestPrettyPrint(t *testing.T) {
@@ -132,3 +135,118 @@ func Twiddle(in string) string {
 	in = strings.ReplaceAll(in, "B", "│")
 	return in
 }
+
+func reportSample(td *TD) {
+	td.Pass("good")
+	td.Log("some log")
+	td.Warn("hmm")
+	td.Run(reportSampleSub)
+}
+
+func reportSampleSub(td *TD) {
+	td.Skip("not today")
+	td.Fail("bad #1")
+}
+
+func TestWriteReport(t *testing.T) {
+	top := NewTD(nil, "http://localhost")
+	top.Run(reportSample)
+
+	// Make all of the times predictable
+	when := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	var fix func(td *TD)
+	fix = func(td *TD) {
+		td.Start, td.Duration = when, 0
+		for _, le := range td.Logs {
+			le.Date = when
+			if le.Subtest != nil {
+				fix(le.Subtest)
+			}
+		}
+	}
+	fix(top)
+
+	buf := &bytes.Buffer{}
+	if err := top.WriteReport(buf, "tap"); err != nil {
+		t.Fatalf("tap: %s", err)
+	}
+	exp := `TAP version 13
+1..4
+ok 1 - reportSample: good
+  ---
+  duration_ms: 0.000
+  ...
+# some log
+ok 2 - reportSample: hmm # TODO warning
+  ---
+  severity: warning
+  duration_ms: 0.000
+  ...
+ok 3 - reportSample/reportSampleSub: not today # SKIP
+  ---
+  duration_ms: 0.000
+  ...
+not ok 4 - reportSample/reportSampleSub: bad \#1
+  ---
+  duration_ms: 0.000
+  ...
+# pass 1
+# fail 4
+# warn 1
+# skip 1
+`
+	if buf.String() != exp {
+		t.Fatalf("Exp:\n%s\nGot:\n%s", exp, buf.String())
+	}
+
+	buf.Reset()
+	if err := top.WriteReport(buf, "junit"); err != nil {
+		t.Fatalf("junit: %s", err)
+	}
+	exp = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites name="http://localhost" tests="4" failures="1" skipped="1" time="0.000">
+  <testsuite name="reportSample" tests="2" failures="0" skipped="0" time="0.000" timestamp="2025-01-02T03:04:05Z">
+    <testcase name="good" classname="reportSample" time="0.000"></testcase>
+    <testcase name="hmm" classname="reportSample" time="0.000">
+      <system-out>WARN: hmm</system-out>
+    </testcase>
+    <system-out>some log</system-out>
+  </testsuite>
+  <testsuite name="reportSample/reportSampleSub" tests="2" failures="1" skipped="1" time="0.000" timestamp="2025-01-02T03:04:05Z">
+    <testcase name="not today" classname="reportSample/reportSampleSub" time="0.000">
+      <skipped message="not today"></skipped>
+    </testcase>
+    <testcase name="bad #1" classname="reportSample/reportSampleSub" time="0.000">
+      <failure message="bad #1"></failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+	if buf.String() != exp {
+		t.Fatalf("Exp:\n%s\nGot:\n%s", exp, buf.String())
+	}
+
+	buf.Reset()
+	if err := top.WriteReport(buf, "json"); err != nil {
+		t.Fatalf("json: %s", err)
+	}
+	res := map[string]any{}
+	if err := json.Unmarshal(buf.Bytes(), &res); err != nil {
+		t.Fatalf("json: %s\n%s", err, buf.String())
+	}
+	if res["status"] != "FAIL" || res["numfail"] != 4.0 ||
+		res["numpass"] != 1.0 || res["numwarn"] != 1.0 ||
+		res["numskip"] != 1.0 || len(res["entries"].([]any)) != 1 {
+		t.Fatalf("Bad json:\n%s", buf.String())
+	}
+	sample := res["entries"].([]any)[0].(map[string]any)["test"].(map[string]any)
+	if sample["path"] != "reportSample" ||
+		len(sample["entries"].([]any)) != 4 ||
+		sample["start"] != "2025-01-02T03:04:05Z" {
+		t.Fatalf("Bad json:\n%s", buf.String())
+	}
+
+	if err := top.WriteReport(buf, "foo"); err == nil {
+		t.Fatalf("Bad format should have failed")
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xregistry/server/cmds/xr/xrlib"
+	. "github.com/xregistry/server/common"
+)
+
+func addVerifyCmd(parent *cobra.Command) {
+	verifyCmd := &cobra.Command{
+		Use:     "verify XID...",
+		Short:   "Verify the digest and signature of Resource documents",
+		Run:     verifyFunc,
+		GroupID: "Entities",
+	}
+	verifyCmd.Flags().StringArrayP("key", "k", nil,
+		"Trusted public key: ID=BASE64")
+	verifyCmd.Flags().BoolP("signed", "", false,
+		"Fail if a document isn't signed")
+	verifyCmd.Flags().BoolP("server-keys", "", false,
+		"Also trust the keys in the server's model (the server vouches "+
+			"for itself)")
+
+	parent.AddCommand(verifyCmd)
+}
+
+func verifyFunc(cmd *cobra.Command, args []string) {
+	if GetServer() == "" {
+		Error("No Server address provided. Try either -s or XR_SERVER env var")
+	}
+
+	if len(args) == 0 {
+		Error("Must specify the XID of a Resource or Version")
+	}
+
+	reg, xErr := xrlib.GetRegistry(GetServer())
+	Error(xErr)
+
+	keyFlags, _ := cmd.Flags().GetStringArray("key")
+	mustBeSigned, _ := cmd.Flags().GetBool("signed")
+	serverKeys, _ := cmd.Flags().GetBool("server-keys")
+
+	keys := map[string]string{}
+	for _, k := range keyFlags {
+		id, key, found := strings.Cut(k, "=")
+		if !found || id == "" {
+			Error("--key must be of the form ID=BASE64: %s", k)
+		}
+		if _, err := ParsePublicKey(key); err != nil {
+			Error("--key %q: %s", id, err)
+		}
+		keys[id] = key
+	}
+
+	failed := false
+	for _, xidStr := range args {
+		if len(xidStr) > 0 && xidStr[0] != '/' {
+			xidStr = "/" + xidStr
+		}
+		if !verifyXID(reg, xidStr, keys, mustBeSigned, serverKeys) {
+			failed = true
+		}
+	}
+
+	if failed {
+		Error("Verification failed")
+	}
+}
+
+// verifyXID checks one Resource or Version, printing the results as it
+// goes. Returns false if anything didn't check out. Signatures are only
+// checked against "keys" (--key), unless "serverKeys" says that the keys
+// in the server's own model can be used too. Those can be changed by
+// anyone who can change the model, so they don't prove much.
+func verifyXID(reg *xrlib.Registry, xidStr string, keys map[string]string, mustBeSigned bool, serverKeys bool) bool {
+	xid, err := ParseXid(xidStr)
+	Error(err)
+
+	if xid.ResourceID == "" || !xid.IsEntity {
+		Error("%s: must reference a Resource or a Version", xidStr)
+	}
+
+	rm, xErr := xrlib.GetResourceModelFrom(xid, reg)
+	Error(xErr)
+	if !rm.HasDoc() {
+		Error("%s: Resource type %q doesn't have documents", xidStr,
+			rm.Plural)
+	}
+
+	meta, xErr := reg.HttpDo(VerboseCount > 1, "GET", xid.String()+"$details",
+		nil)
+	Error(xErr)
+
+	doc, xErr := reg.HttpDo(VerboseCount > 1, "GET", xid.String(), nil)
+	Error(xErr)
+
+	ok := true
+	digest := DocumentDigest(doc.Body)
+	fmt.Printf("%s:\n", xidStr)
+	fmt.Printf("  digest: %s\n", digest)
+
+	checked := 0
+	if hdr := doc.Header.Get("Repr-Digest"); hdr != "" {
+		checked++
+		if srvDigest, err := ParseReprDigest(hdr); err != nil {
+			fmt.Printf("  Repr-Digest: FAIL (%s)\n", err)
+			ok = false
+		} else if srvDigest != digest {
+			fmt.Printf("  Repr-Digest: FAIL (server says %s)\n", srvDigest)
+			ok = false
+		} else {
+			fmt.Printf("  Repr-Digest: ok\n")
+		}
+	}
+
+	if attr, _ := meta.JSON["digest"].(string); attr != "" {
+		checked++
+		if attr != digest {
+			fmt.Printf("  digest attribute: FAIL (%s)\n", attr)
+			ok = false
+		} else {
+			fmt.Printf("  digest attribute: ok\n")
+		}
+	}
+
+	if checked == 0 {
+		fmt.Printf("  digest: FAIL (server didn't provide one)\n")
+		ok = false
+	}
+
+	sig, _ := meta.JSON["signature"].(string)
+	keyID, _ := meta.JSON["signaturekeyid"].(string)
+	if sig == "" {
+		if mustBeSigned {
+			fmt.Printf("  signature: FAIL (not signed)\n")
+			ok = false
+		} else {
+			fmt.Printf("  signature: none\n")
+		}
+		return ok
+	}
+
+	key, from := keys[keyID], ""
+	if key == "" && serverKeys && rm.Integrity != nil {
+		key, from = rm.Integrity.TrustedKeys[keyID], ", from the server"
+	}
+	if key == "" {
+		fmt.Printf("  signature: FAIL (unknown key %q, use --key)\n", keyID)
+		return false
+	}
+
+	pub, err := ParsePublicKey(key)
+	if err == nil {
+		err = VerifyDigestSignature(pub, digest, sig)
+	}
+	if err != nil {
+		fmt.Printf("  signature: FAIL (key %q%s: %s)\n", keyID, from, err)
+		return false
+	}
+	fmt.Printf("  signature: ok (key %q%s)\n", keyID, from)
+
+	return ok
+}
@@ -21,6 +21,7 @@ var ErrJson = false
 var DefaultServer = "localhost:8080"
 var UserConfig = map[string]string{}
 var ConfigFileName = ".xrconfig"
+var ConfigFileUsed = "" // the config file that was loaded, if any
 
 // Error():
 // string, args      -> Title=sprintf(string, args...)
@@ -157,9 +158,86 @@ func LoadConfigFromFile(fn string) *XRError {
 					fn, err.Error()))
 	}
 
+	ConfigFileUsed = fn
 	return LoadConfigFromBuffer(string(buf))
 }
 
+// SaveConfigToFile sets "name" to "value" in the config file that was
+// loaded ($HOME/.xrconfig if there wasn't one), and in UserConfig. The rest
+// of the file, including comments, is left as is. An empty "value" removes
+// the property.
+func SaveConfigToFile(name string, value string) *XRError {
+	if xErr := SetConfig(name, value); xErr != nil {
+		return xErr
+	}
+
+	fn := ConfigFileUsed
+	if fn == "" {
+		path, err := os.UserHomeDir()
+		if err != nil {
+			return NewXRError("client_error", "",
+				"error_detail="+
+					fmt.Sprintf("Can't find the home directory: %s",
+						err.Error()))
+		}
+		fn = path + "/" + ConfigFileName
+	}
+
+	buf, err := os.ReadFile(fn)
+	if err != nil && !os.IsNotExist(err) {
+		return NewXRError("client_error", "",
+			"error_detail="+
+				fmt.Sprintf("Error loading config file (%s): %s",
+					fn, err.Error()))
+	}
+
+	lines := []string{}
+	found := false
+	if len(buf) > 0 {
+		for _, line := range strings.Split(strings.TrimRight(string(buf),
+			"\n"), "\n") {
+			trimmed := strings.TrimSpace(line)
+			if trimmed != "" && trimmed[0] != '#' {
+				prop, _, _ := strings.Cut(trimmed, ":")
+				if strings.TrimSpace(prop) == name {
+					if found || value == "" {
+						continue
+					}
+					line, found = name+": "+value, true
+				}
+			}
+			lines = append(lines, line)
+		}
+	}
+	if !found && value != "" {
+		lines = append(lines, name+": "+value)
+	}
+
+	data := strings.Join(lines, "\n")
+	if data != "" {
+		data += "\n"
+	}
+	if err := os.WriteFile(fn, []byte(data), 0600); err != nil {
+		return NewXRError("client_error", "",
+			"error_detail="+
+				fmt.Sprintf("Error saving config file (%s): %s",
+					fn, err.Error()))
+	}
+
+	// WriteFile only uses the perms when it creates the file, so make sure
+	// an existing one isn't readable by others once it has credentials
+	if strings.Contains(data, ".auth.") || strings.Contains(data, ".header.") {
+		if err := os.Chmod(fn, 0600); err != nil {
+			return NewXRError("client_error", "",
+				"error_detail="+
+					fmt.Sprintf("Error setting the permissions of the "+
+						"config file (%s): %s", fn, err.Error()))
+		}
+	}
+	ConfigFileUsed = fn
+	return nil
+}
+
 // Buffer syntax:
 // prop: value
 // # comment
@@ -195,6 +273,15 @@ func GetConfig(name string) string {
 	return UserConfig[name]
 }
 
+// CleanServerURL makes sure "server" starts with some variant of "http"
+func CleanServerURL(server string) string {
+	server = strings.TrimSpace(server)
+	if server != "" && !strings.HasPrefix(server, "http") {
+		server = "http://" + strings.TrimLeft(server, "/")
+	}
+	return server
+}
+
 func GetServer() string {
 	return GetConfig("server.url")
 }
@@ -390,11 +477,26 @@ func main() {
 			fn, _ := cmd.Flags().GetString("config")
 			Error(LoadConfigFromFile(fn))
 
-			// Calc Server: cmdline->env->configFile->default
+			// Which context (if any): cmdline->env->configFile
+			context, _ := cmd.Flags().GetString("context")
+			if context == "" {
+				context = os.Getenv("XR_CONTEXT")
+			}
+			// Don't let a bad context get in the way of fixing it
+			if xErr := UseContext(context); cmd.Parent() == nil ||
+				cmd.Parent().Name() != "context" {
+				Error(xErr)
+			}
+
+			// Calc Server: cmdline->env->context->configFile->default
 			server, _ := cmd.Flags().GetString("server")
 			if server == "" {
 				server = os.Getenv("XR_SERVER")
 
+				if server == "" {
+					server = GetContextServer(CurrentContext)
+				}
+
 				if server == "" {
 					server = GetServer()
 
@@ -404,13 +506,11 @@ func main() {
 				}
 			}
 
-			// Clean & make sure 'server' starts with some variant of "http"
-			server = strings.TrimSpace(server)
-			if server != "" && !strings.HasPrefix(server, "http") {
-				server = "http://" + strings.TrimLeft(server, "/")
-			}
+			SetConfig("server.url", CleanServerURL(server))
 
-			SetConfig("server.url", server)
+			// Include the context's headers/credentials on all requests
+			// to its server
+			AddContextAuth(CurrentContext)
 		},
 	}
 
@@ -419,6 +519,8 @@ func main() {
 		"Config file ($HOME/.xrconfig)")
 	xrCmd.PersistentFlags().StringP("server", "s", "",
 		"xRegistry server URL")
+	xrCmd.PersistentFlags().StringP("context", "", "",
+		"Context (from the config file) to use")
 	xrCmd.PersistentFlags().BoolVarP(&ErrJson, "errjson", "", false,
 		"Print errors as json")
 	xrCmd.PersistentFlags().BoolP("help", "?", false, "Help for xr")
@@ -459,14 +561,19 @@ func main() {
 
 	addCreateCmd(xrCmd)
 	addDeleteCmd(xrCmd)
+	addDiffCmd(xrCmd)
 	addGetCmd(xrCmd)
 	addImportCmd(xrCmd)
 	addModelCmd(xrCmd)
+	addPushCmd(xrCmd)
 	addUpdateCmd(xrCmd)
 	addUpsertCmd(xrCmd)
+	addVerifyCmd(xrCmd)
 
+	addContextCmd(xrCmd)
 	addDownloadCmd(xrCmd)
 	addServeCmd(xrCmd)
+	addShellCmd(xrCmd)
 	addConformCmd(xrCmd)
 
 	ValidateCmd(xrCmd)
@@ -0,0 +1,191 @@
+package xrlib
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	. "github.com/xregistry/server/common"
+)
+
+const (
+	DIFF_ADD    = "add"
+	DIFF_REMOVE = "remove"
+	DIFF_CHANGE = "change"
+)
+
+// AttrDiff is one attribute that's different between two entities. "Path"
+// uses the same "." and "[n]" syntax as the filter/sort query params.
+type AttrDiff struct {
+	Path string `json:"path"`
+	Op   string `json:"op"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+// DiffObjects returns the attribute-level differences going from "oldObj"
+// to "newObj", sorted by path. Maps are compared key by key and arrays
+// entry by entry, anything else is compared as a whole.
+func DiffObjects(oldObj, newObj any) []*AttrDiff {
+	diffs := diffAny("", oldObj, newObj, []*AttrDiff{})
+	sort.SliceStable(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
+}
+
+func diffAny(path string, oldVal, newVal any, diffs []*AttrDiff) []*AttrDiff {
+	oldMap, oldIsMap := oldVal.(map[string]any)
+	newMap, newIsMap := newVal.(map[string]any)
+	if oldIsMap && newIsMap {
+		keys := map[string]bool{}
+		for k := range oldMap {
+			keys[k] = true
+		}
+		for k := range newMap {
+			keys[k] = true
+		}
+		for _, k := range SortedKeys(keys) {
+			subPath := k
+			if path != "" {
+				subPath = path + "." + k
+			}
+			o, inOld := oldMap[k]
+			n, inNew := newMap[k]
+			switch {
+			case !inOld:
+				diffs = append(diffs, &AttrDiff{subPath, DIFF_ADD, nil, n})
+			case !inNew:
+				diffs = append(diffs, &AttrDiff{subPath, DIFF_REMOVE, o, nil})
+			default:
+				diffs = diffAny(subPath, o, n, diffs)
+			}
+		}
+		return diffs
+	}
+
+	oldArr, oldIsArr := oldVal.([]any)
+	newArr, newIsArr := newVal.([]any)
+	if oldIsArr && newIsArr {
+		for i := 0; i < max(len(oldArr), len(newArr)); i++ {
+			subPath := fmt.Sprintf("%s[%d]", path, i)
+			switch {
+			case i >= len(oldArr):
+				diffs = append(diffs,
+					&AttrDiff{subPath, DIFF_ADD, nil, newArr[i]})
+			case i >= len(newArr):
+				diffs = append(diffs,
+					&AttrDiff{subPath, DIFF_REMOVE, oldArr[i], nil})
+			default:
+				diffs = diffAny(subPath, oldArr[i], newArr[i], diffs)
+			}
+		}
+		return diffs
+	}
+
+	if !reflect.DeepEqual(oldVal, newVal) {
+		diffs = append(diffs, &AttrDiff{path, DIFF_CHANGE, oldVal, newVal})
+	}
+	return diffs
+}
+
+// DiffLines returns a line-by-line diff of two documents. Lines are
+// prefixed with "-" (only in old), "+" (only in new) or " " (both).
+// Unchanged lines more than "context" lines away from a change are
+// collapsed into a single "@@ line N" marker. An empty result means
+// they're the same.
+func DiffLines(oldStr, newStr string, context int) []string {
+	if oldStr == newStr {
+		return nil
+	}
+	oldLines := strings.Split(strings.TrimSuffix(oldStr, "\n"), "\n")
+	newLines := strings.Split(strings.TrimSuffix(newStr, "\n"), "\n")
+
+	// Longest common subsequence, lcs[i][j] is for old[i:] and new[j:]
+	lcs := make([][]int, len(oldLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newLines)+1)
+	}
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			if oldLines[i] == newLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type line struct {
+		op    byte
+		text  string
+		oldNo int
+	}
+	all := []line{}
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) &&
+			oldLines[i] == newLines[j]:
+			all = append(all, line{' ', oldLines[i], i + 1})
+			i, j = i+1, j+1
+		case i < len(oldLines) &&
+			(j == len(newLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			all = append(all, line{'-', oldLines[i], i + 1})
+			i++
+		default:
+			all = append(all, line{'+', newLines[j], i + 1})
+			j++
+		}
+	}
+
+	// Only keep the lines that are near a change
+	keep := make([]bool, len(all))
+	for k, l := range all {
+		if l.op == ' ' {
+			continue
+		}
+		for c := max(0, k-context); c <= min(len(all)-1, k+context); c++ {
+			keep[c] = true
+		}
+	}
+
+	res := []string{}
+	for k, l := range all {
+		if !keep[k] {
+			continue
+		}
+		if k == 0 || !keep[k-1] {
+			res = append(res, fmt.Sprintf("@@ line %d", l.oldNo))
+		}
+		res = append(res, string(l.op)+l.text)
+	}
+	return res
+}
+
+// TablizeDiff shows "diffs" as a table, one row per attribute
+func TablizeDiff(diffs []*AttrDiff) string {
+	buf := &bytes.Buffer{}
+	itw := NewTabWriter(buf, nil, 0, 1, 3, ' ', 0)
+	fmt.Fprintln(itw, "ATTRIBUTE\tCHANGE\tOLD\tNEW")
+	for _, diff := range diffs {
+		fmt.Fprintf(itw, "%s\t%s\t%s\t%s\n", diff.Path, diff.Op,
+			diffValue(diff.Old, diff.Op == DIFF_ADD),
+			diffValue(diff.New, diff.Op == DIFF_REMOVE))
+	}
+	itw.Flush()
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+func diffValue(val any, missing bool) string {
+	if missing {
+		return "-"
+	}
+	str := ToJSONOneLine(val)
+	if len(str) > 60 {
+		str = str[:57] + "..."
+	}
+	return str
+}
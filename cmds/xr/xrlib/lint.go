@@ -0,0 +1,574 @@
+package xrlib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	. "github.com/xregistry/server/common"
+)
+
+// Lint levels, in increasing order of severity. They match SARIF's levels.
+const (
+	LINT_OFF     = "off"
+	LINT_NOTE    = "note"
+	LINT_WARNING = "warning"
+	LINT_ERROR   = "error"
+)
+
+var LintLevels = []string{LINT_OFF, LINT_NOTE, LINT_WARNING, LINT_ERROR}
+
+// LintFinding is one problem found in a model. "Path" is the "."
+// separated list of keys to the model entity (e.g.
+// "groups.dirs.resources.files.attributes.foo") and "Line" is its line
+// number in the file (0 if unknown, e.g. it came from an include).
+type LintFinding struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Path    string `json:"path"`
+	Rule    string `json:"rule"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+type LintRule struct {
+	ID          string
+	Level       string // default level
+	Description string
+	check       func(l *modelLinter)
+}
+
+var LintRules = []*LintRule{
+	{"plural-singular", LINT_WARNING,
+		"Plural names should be the plural of their singular names",
+		lintPluralSingular},
+	{"attribute-description", LINT_NOTE,
+		"Extension attributes should have a description",
+		lintAttributeDescription},
+	{"strict-false", LINT_WARNING,
+		"Attributes shouldn't use \"strict\": false",
+		lintStrictFalse},
+	{"resource-defaults", LINT_NOTE,
+		"Resources with documents should have default \"compatibility\" " +
+			"and \"format\" values",
+		lintResourceDefaults},
+	{"label-keys", LINT_WARNING,
+		"Label keys should be spelled the same way everywhere",
+		lintLabelKeys},
+	{"ifvalues-unreachable", LINT_WARNING,
+		"\"ifvalues\" should only use values the attribute can have",
+		lintIfValuesUnreachable},
+}
+
+func FindLintRule(id string) *LintRule {
+	for _, rule := range LintRules {
+		if rule.ID == id {
+			return rule
+		}
+	}
+	return nil
+}
+
+// LintConfig is the set of rule levels to use, keyed by rule ID. Rules
+// that aren't in the map use their default level.
+type LintConfig map[string]string
+
+// LintLevelAtLeast says whether "level" is as severe as "min"
+func LintLevelAtLeast(level string, min string) bool {
+	return slices.Index(LintLevels, level) >= slices.Index(LintLevels, min)
+}
+
+func (lc LintConfig) Level(rule *LintRule) string {
+	if level, ok := lc[rule.ID]; ok {
+		return level
+	}
+	return rule.Level
+}
+
+// Verify makes sure all of the rule IDs and levels are known ones
+func (lc LintConfig) Verify() *XRError {
+	for _, id := range SortedKeys(lc) {
+		if FindLintRule(id) == nil {
+			return NewXRError("client_error", "",
+				"error_detail="+fmt.Sprintf("Unknown lint rule: %s", id))
+		}
+		if !slices.Contains(LintLevels, lc[id]) {
+			return NewXRError("client_error", "",
+				"error_detail="+
+					fmt.Sprintf("Lint rule %q has an invalid level %q, "+
+						"must be one of: %s", id, lc[id],
+						strings.Join(LintLevels, ", ")))
+		}
+	}
+	return nil
+}
+
+type modelLinter struct {
+	model    *Model
+	findings []*LintFinding
+	rule     *LintRule
+	level    string
+}
+
+func (l *modelLinter) report(path string, format string, args ...any) {
+	l.findings = append(l.findings, &LintFinding{
+		Path:    path,
+		Rule:    l.rule.ID,
+		Level:   l.level,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// LintModel runs the rules in "config" against the model in "buf" (which
+// must have already had its includes processed). "src" is the original
+// file, it's used for line numbers and "# lint-ignore" comments.
+func LintModel(buf []byte, src []byte, config LintConfig) ([]*LintFinding, *XRError) {
+	buf, err := RemoveSchema(buf)
+	if err != nil {
+		return nil, NewXRError("parsing_data", "/model",
+			"error_detail="+err.Error())
+	}
+
+	model := &Model{}
+	if err := json.Unmarshal(buf, model); err != nil {
+		return nil, NewXRError("parsing_data", "/model",
+			"error_detail="+err.Error())
+	}
+
+	l := &modelLinter{model: model}
+	for _, rule := range LintRules {
+		l.rule, l.level = rule, config.Level(rule)
+		if l.level != LINT_OFF {
+			rule.check(l)
+		}
+	}
+
+	lines := LintLines(RemoveComments(src))
+	ignores, fileIgnores := lintIgnores(src)
+
+	res := []*LintFinding{}
+	for _, finding := range l.findings {
+		finding.Line = lines[finding.Path]
+		if fileIgnores[finding.Rule] ||
+			(finding.Line != 0 && ignores[finding.Line][finding.Rule]) {
+			continue
+		}
+		res = append(res, finding)
+	}
+
+	slices.SortStableFunc(res, func(a, b *LintFinding) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	return res, nil
+}
+
+// LintLines returns the line number of each key in the JSON "buf", keyed
+// by its "." separated path
+func LintLines(buf []byte) map[string]int {
+	lines := map[string]int{}
+	dec := json.NewDecoder(bytes.NewReader(buf))
+
+	lineAt := func(offset int64) int {
+		return bytes.Count(buf[:min(int(offset), len(buf))], []byte("\n")) + 1
+	}
+
+	// Each stack entry is the path of an open object/array, arrays have
+	// an index (next) and objects look for a key when "key" is true
+	type level struct {
+		path  string
+		isObj bool
+		key   bool
+		next  int
+	}
+	stack := []*level{}
+	pending := "" // path of the value we're about to read
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			break // EOF or bad JSON, either way we're done
+		}
+
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.isObj && top.key {
+				if key, ok := tok.(string); ok {
+					pending = key
+					if top.path != "" {
+						pending = top.path + "." + key
+					}
+					lines[pending] = lineAt(dec.InputOffset())
+					top.key = false
+					continue
+				}
+			} else if !top.isObj {
+				pending = top.path + "[" + strconv.Itoa(top.next) + "]"
+				top.next++
+			}
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			stack = append(stack, &level{path: pending,
+				isObj: tok == json.Delim('{'), key: true})
+			continue
+		case json.Delim('}'), json.Delim(']'):
+			stack = stack[:len(stack)-1]
+		}
+
+		// Done with a value, if we're in an object then a key is next
+		if len(stack) > 0 && stack[len(stack)-1].isObj {
+			stack[len(stack)-1].key = true
+		}
+	}
+	return lines
+}
+
+var lintIgnoreRE = regexp.MustCompile(`#\s*lint-ignore(-file)?\s+([a-z0-9,\s-]+)`)
+
+// lintIgnores finds the "# lint-ignore RULE[,RULE...]" comments in "src".
+// On a line by itself it applies to the next line, otherwise to its own
+// line. "# lint-ignore-file RULE[,RULE...]" applies to the whole file.
+func lintIgnores(src []byte) (map[int]map[string]bool, map[string]bool) {
+	lines := map[int]map[string]bool{}
+	fileRules := map[string]bool{}
+
+	for i, line := range strings.Split(string(src), "\n") {
+		match := lintIgnoreRE.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+
+		rules := map[string]bool{}
+		if match[1] != "" {
+			rules = fileRules
+		}
+		for _, rule := range strings.FieldsFunc(match[2], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		}) {
+			rules[rule] = true
+		}
+		if match[1] != "" {
+			continue
+		}
+
+		lineNo := i + 1
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			lineNo++ // Comment-only line, so it's for the next one
+		}
+		if lines[lineNo] == nil {
+			lines[lineNo] = map[string]bool{}
+		}
+		for rule := range rules {
+			lines[lineNo][rule] = true
+		}
+	}
+	return lines, fileRules
+}
+
+// lintAttributes calls "fn" for each attribute in "attrs", and all of
+// their nested attributes (objects, items and ifvalues)
+func lintAttributes(path string, attrs Attributes,
+	fn func(path string, name string, attr *Attribute)) {
+
+	for _, name := range SortedKeys(attrs) {
+		attr := attrs[name]
+		if attr == nil {
+			continue
+		}
+		attrPath := path + "." + name
+		fn(attrPath, name, attr)
+
+		lintAttributes(attrPath+".attributes", attr.Attributes, fn)
+		itemPath := attrPath + ".item"
+		for item := attr.Item; item != nil; item = item.Item {
+			lintAttributes(itemPath+".attributes", item.Attributes, fn)
+			itemPath += ".item"
+		}
+		for _, val := range SortedKeys(attr.IfValues) {
+			if ifValue := attr.IfValues[val]; ifValue != nil {
+				lintAttributes(attrPath+".ifvalues."+val+".siblingattributes",
+					ifValue.SiblingAttributes, fn)
+			}
+		}
+	}
+}
+
+// lintAllAttributes calls "fn" for every attribute in the model. "singular"
+// is the singular name of the entity they're on ("" for the Registry).
+func (l *modelLinter) lintAllAttributes(fn func(path string, name string, attr *Attribute, singular string)) {
+	lintAttributes("attributes", l.model.Attributes,
+		func(path string, name string, attr *Attribute) {
+			fn(path, name, attr, "")
+		})
+
+	for _, gPlural := range SortedKeys(l.model.Groups) {
+		gm := l.model.Groups[gPlural]
+		if gm == nil {
+			continue
+		}
+		gPath := "groups." + gPlural
+		lintAttributes(gPath+".attributes", gm.Attributes,
+			func(path string, name string, attr *Attribute) {
+				fn(path, name, attr, gm.Singular)
+			})
+
+		for _, rPlural := range SortedKeys(gm.Resources) {
+			rm := gm.Resources[rPlural]
+			if rm == nil {
+				continue
+			}
+			rPath := gPath + ".resources." + rPlural
+			for _, list := range []struct {
+				key   string
+				attrs Attributes
+			}{
+				{"attributes", rm.VersionAttributes},
+				{"resourceattributes", rm.ResourceAttributes},
+				{"metaattributes", rm.MetaAttributes},
+			} {
+				lintAttributes(rPath+"."+list.key, list.attrs,
+					func(path string, name string, attr *Attribute) {
+						fn(path, name, attr, rm.Singular)
+					})
+			}
+		}
+	}
+}
+
+func lintPluralSingular(l *modelLinter) {
+	check := func(path string, what string, plural string, singular string) {
+		if singular == "" {
+			return // Model.Verify() will complain about this
+		}
+		if plural == singular {
+			l.report(path, "%s type %q has the same plural and singular name",
+				what, plural)
+			return
+		}
+		if strings.HasPrefix(plural, singular) ||
+			(strings.HasSuffix(singular, "y") &&
+				plural == singular[:len(singular)-1]+"ies") {
+			return
+		}
+		l.report(path, "%s type %q doesn't look like the plural of %q",
+			what, plural, singular)
+	}
+
+	for _, gPlural := range SortedKeys(l.model.Groups) {
+		gm := l.model.Groups[gPlural]
+		if gm == nil {
+			continue
+		}
+		gPath := "groups." + gPlural
+		check(gPath, "Group", gPlural, gm.Singular)
+
+		for _, rPlural := range SortedKeys(gm.Resources) {
+			if rm := gm.Resources[rPlural]; rm != nil {
+				check(gPath+".resources."+rPlural, "Resource", rPlural,
+					rm.Singular)
+			}
+		}
+	}
+}
+
+func lintAttributeDescription(l *modelLinter) {
+	l.lintAllAttributes(func(path string, name string, attr *Attribute, singular string) {
+		if attr.Description != "" || name == "*" || SpecProps[name] != nil ||
+			(singular != "" && name == singular+"id") {
+			return
+		}
+		l.report(path, "Attribute %q has no description", name)
+	})
+}
+
+func lintStrictFalse(l *modelLinter) {
+	l.lintAllAttributes(func(path string, name string, attr *Attribute, singular string) {
+		if attr.GetStrict() {
+			return
+		}
+		if len(attr.Enum) == 0 {
+			l.report(path, "Attribute %q has \"strict\": false but no enum, "+
+				"so it has no effect", name)
+		} else {
+			l.report(path, "Attribute %q has \"strict\": false, so its enum "+
+				"values are only suggestions", name)
+		}
+	})
+}
+
+func lintResourceDefaults(l *modelLinter) {
+	for _, gPlural := range SortedKeys(l.model.Groups) {
+		gm := l.model.Groups[gPlural]
+		if gm == nil {
+			continue
+		}
+		for _, rPlural := range SortedKeys(gm.Resources) {
+			rm := gm.Resources[rPlural]
+			if rm == nil || (rm.HasDocument != nil && !*rm.HasDocument) {
+				continue
+			}
+			for _, name := range []string{"compatibility", "format"} {
+				if attr := rm.VersionAttributes[name]; attr != nil &&
+					attr.Default != nil {
+					continue
+				}
+				if c := gm.Constraints[rPlural+"."+name]; c != nil &&
+					c.Default != nil {
+					continue
+				}
+				l.report("groups."+gPlural+".resources."+rPlural,
+					"Resource type %q has no default %q value", rPlural,
+					name)
+			}
+		}
+	}
+}
+
+func lintLabelKeys(l *modelLinter) {
+	// normalized key -> spelling -> path of the first one seen
+	spellings := map[string]map[string]string{}
+	add := func(path string, labels map[string]string) {
+		for _, key := range SortedKeys(labels) {
+			norm := strings.Map(func(r rune) rune {
+				if strings.ContainsRune("-_.:", r) {
+					return -1
+				}
+				return r
+			}, strings.ToLower(key))
+			if spellings[norm] == nil {
+				spellings[norm] = map[string]string{}
+			}
+			if _, ok := spellings[norm][key]; !ok {
+				spellings[norm][key] = path + "." + key
+			}
+		}
+	}
+
+	add("labels", l.model.Labels)
+	for _, gPlural := range SortedKeys(l.model.Groups) {
+		gm := l.model.Groups[gPlural]
+		if gm == nil {
+			continue
+		}
+		gPath := "groups." + gPlural
+		add(gPath+".labels", gm.Labels)
+		for _, rPlural := range SortedKeys(gm.Resources) {
+			if rm := gm.Resources[rPlural]; rm != nil {
+				add(gPath+".resources."+rPlural+".labels", rm.Labels)
+			}
+		}
+	}
+
+	for _, norm := range SortedKeys(spellings) {
+		keys := SortedKeys(spellings[norm])
+		if len(keys) < 2 {
+			continue
+		}
+		// Report all but the first spelling (alphabetically)
+		for _, key := range keys[1:] {
+			l.report(spellings[norm][key], "Label key %q is also spelled %q",
+				key, keys[0])
+		}
+	}
+}
+
+func lintIfValuesUnreachable(l *modelLinter) {
+	l.lintAllAttributes(func(path string, name string, attr *Attribute, singular string) {
+		for _, val := range SortedKeys(attr.IfValues) {
+			reason := ""
+			switch {
+			case !IsScalar(attr.Type):
+				reason = fmt.Sprintf("%q isn't a scalar type", attr.Type)
+			case attr.Type == BOOLEAN && val != "true" && val != "false":
+				reason = "it isn't a boolean"
+			case (attr.Type == INTEGER || attr.Type == UINTEGER) &&
+				!isLintInt(val, attr.Type == UINTEGER):
+				reason = "it isn't an " + attr.Type
+			case attr.Type == DECIMAL && !isLintDecimal(val):
+				reason = "it isn't a decimal"
+			case len(attr.Enum) > 0 && attr.GetStrict() &&
+				!slices.ContainsFunc(attr.Enum, func(e any) bool {
+					return fmt.Sprintf("%v", e) == val
+				}):
+				reason = "it isn't in the attribute's enum"
+			}
+			if reason != "" {
+				l.report(path+".ifvalues."+val, "Attribute %q can never "+
+					"have the \"ifvalues\" value %q, %s", name, val, reason)
+			}
+		}
+	})
+}
+
+func isLintInt(val string, unsigned bool) bool {
+	i, err := strconv.ParseInt(val, 10, 64)
+	return err == nil && (!unsigned || i >= 0)
+}
+
+func isLintDecimal(val string) bool {
+	_, err := strconv.ParseFloat(val, 64)
+	return err == nil
+}
+
+// WriteLintSARIF writes "findings" as a SARIF (v2.1.0) log
+func WriteLintSARIF(w io.Writer, findings []*LintFinding, config LintConfig) error {
+	rules := []map[string]any{}
+	for _, rule := range LintRules {
+		rules = append(rules, map[string]any{
+			"id":               rule.ID,
+			"shortDescription": map[string]any{"text": rule.Description},
+			"defaultConfiguration": map[string]any{
+				"level":   rule.Level,
+				"enabled": config.Level(rule) != LINT_OFF,
+			},
+		})
+	}
+
+	results := []map[string]any{}
+	for _, finding := range findings {
+		physical := map[string]any{
+			"artifactLocation": map[string]any{"uri": finding.File},
+		}
+		if finding.Line > 0 {
+			physical["region"] = map[string]any{"startLine": finding.Line}
+		}
+		results = append(results, map[string]any{
+			"ruleId":  finding.Rule,
+			"level":   finding.Level,
+			"message": map[string]any{"text": finding.Message},
+			"locations": []any{map[string]any{
+				"physicalLocation": physical,
+				"logicalLocations": []any{map[string]any{
+					"fullyQualifiedName": finding.Path,
+				}},
+			}},
+		})
+	}
+
+	sarif := map[string]any{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []any{map[string]any{
+			"tool": map[string]any{
+				"driver": map[string]any{
+					"name":           "xr model lint",
+					"informationUri": "https://github.com/xregistry/server",
+					"rules":          rules,
+				},
+			},
+			"results": results,
+		}},
+	}
+
+	_, err := fmt.Fprintf(w, "%s\n", PrettyPrint(sarif, "", "  "))
+	return err
+}
@@ -0,0 +1,277 @@
+package xrlib
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+
+	. "github.com/xregistry/server/common"
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFormats are the "--output" values that every command that shows
+// entities supports, in addition to its own ones (e.g. "table")
+var OutputFormats = []string{"json", "yaml", "jsonl", "csv", "template=TMPL",
+	"jsonpath=EXPR"}
+
+// IsOutputFormat says whether "output" is one of OutputFormats
+func IsOutputFormat(output string) bool {
+	name, _, _ := strings.Cut(output, "=")
+	for _, format := range OutputFormats {
+		if fName, _, hasArg := strings.Cut(format, "="); fName == name &&
+			hasArg == strings.Contains(output, "=") {
+			return true
+		}
+	}
+	return false
+}
+
+// OutputFormatsText is for error messages, "extra" are the command's own
+// formats (e.g. "table")
+func OutputFormatsText(extra ...string) string {
+	list := append([]string{OutputFormats[0]}, extra...)
+	return strings.Join(append(list, OutputFormats[1:]...), ", ")
+}
+
+// WriteOutput shows "object", the response from "xidStr", in the "output"
+// format. For collections, jsonl/csv/template/jsonpath are applied to each
+// entity in the collection (sorted by ID).
+func WriteOutput(w io.Writer, xidStr string, object any, output string) error {
+	format, arg, _ := strings.Cut(output, "=")
+
+	xid, err := ParseXid(xidStr)
+	if err != nil {
+		return err
+	}
+
+	entities := []any{object}
+	if m, ok := object.(map[string]any); ok && !xid.IsEntity {
+		entities = []any{}
+		for _, id := range SortedKeys(m) {
+			entities = append(entities, m[id])
+		}
+	}
+
+	switch format {
+	case "json":
+		_, err = fmt.Fprintf(w, "%s\n", PrettyPrint(object, "", "  "))
+
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err = enc.Encode(object); err == nil {
+			err = enc.Close()
+		}
+
+	case "jsonl":
+		for _, entity := range entities {
+			if err = writeJSONLine(w, entity); err != nil {
+				break
+			}
+		}
+
+	case "csv":
+		err = writeCSV(w, entities)
+
+	case "template":
+		tmpl, tErr := template.New("output").Funcs(template.FuncMap{
+			"json": func(v any) (string, error) {
+				buf, err := json.Marshal(v)
+				return string(buf), err
+			},
+		}).Parse(arg)
+		if tErr != nil {
+			return tErr
+		}
+		for _, entity := range entities {
+			buf := &bytes.Buffer{}
+			if err = tmpl.Execute(buf, entity); err != nil {
+				break
+			}
+			// Missing attributes show as "<no value>", blank is nicer
+			str := strings.ReplaceAll(buf.String(), "<no value>", "")
+			if !strings.HasSuffix(str, "\n") {
+				str += "\n"
+			}
+			if _, err = io.WriteString(w, str); err != nil {
+				break
+			}
+		}
+
+	case "jsonpath":
+		for _, entity := range entities {
+			values, pErr := JSONPath(entity, arg)
+			if pErr != nil {
+				return pErr
+			}
+			for _, val := range values {
+				if str, ok := val.(string); ok {
+					_, err = fmt.Fprintln(w, str)
+				} else {
+					err = writeJSONLine(w, val)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+	default:
+		err = fmt.Errorf("unknown output format: %s", output)
+	}
+	return err
+}
+
+func writeJSONLine(w io.Writer, val any) error {
+	buf, err := json.Marshal(val)
+	if err == nil {
+		_, err = fmt.Fprintf(w, "%s\n", buf)
+	}
+	return err
+}
+
+// writeCSV shows one entity per row, and one column per top-level
+// attribute. Complex values are shown as JSON.
+func writeCSV(w io.Writer, entities []any) error {
+	columns := map[string]bool{}
+	for _, entity := range entities {
+		if m, ok := entity.(map[string]any); ok {
+			for key := range m {
+				columns[key] = true
+			}
+		}
+	}
+	names := SortedKeys(columns)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(names); err != nil {
+		return err
+	}
+
+	for _, entity := range entities {
+		m, _ := entity.(map[string]any)
+		row := make([]string, len(names))
+		for i, name := range names {
+			switch val := m[name].(type) {
+			case nil:
+			case string:
+				row[i] = val
+			case float64:
+				row[i] = strconv.FormatFloat(val, 'f', -1, 64)
+			case bool:
+				row[i] = strconv.FormatBool(val)
+			default:
+				buf, err := json.Marshal(val)
+				if err != nil {
+					return err
+				}
+				row[i] = string(buf)
+			}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// JSONPath returns the values in "object" that match "expr". Only a subset
+// of JSONPath is supported: "$", ".name", "['name']", "[n]" (negative
+// counts from the end), "[*]" and ".*". A surrounding "{...}" (kubectl
+// style) is allowed.
+func JSONPath(object any, expr string) ([]any, error) {
+	path := strings.TrimSpace(expr)
+	if strings.HasPrefix(path, "{") && strings.HasSuffix(path, "}") {
+		path = path[1 : len(path)-1]
+	}
+	path = strings.TrimPrefix(path, "$")
+
+	// Allow "name" at the start, as if it was ".name"
+	if path != "" && path[0] != '.' && path[0] != '[' {
+		path = "." + path
+	}
+
+	current := []any{object}
+	for path != "" {
+		var next []any
+		var step string
+
+		switch {
+		case strings.HasPrefix(path, ".."):
+			return nil, fmt.Errorf("jsonpath %q: recursive descent (..) "+
+				"isn't supported", expr)
+
+		case path[0] == '.':
+			end := strings.IndexAny(path[1:], ".[")
+			if end < 0 {
+				end = len(path) - 1
+			}
+			step, path = path[1:end+1], path[end+1:]
+			if step == "" {
+				return nil, fmt.Errorf("jsonpath %q: missing name after "+
+					"\".\"", expr)
+			}
+			for _, val := range current {
+				next = append(next, jsonPathStep(val, step, false)...)
+			}
+
+		case path[0] == '[':
+			end := strings.Index(path, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("jsonpath %q: missing \"]\"", expr)
+			}
+			step, path = strings.TrimSpace(path[1:end]), path[end+1:]
+			isName := false
+			if len(step) >= 2 && (step[0] == '\'' || step[0] == '"') &&
+				step[len(step)-1] == step[0] {
+				step, isName = step[1:len(step)-1], true
+			}
+			for _, val := range current {
+				next = append(next, jsonPathStep(val, step, isName)...)
+			}
+
+		default:
+			return nil, fmt.Errorf("jsonpath %q: unexpected %q", expr, path)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// jsonPathStep applies one step (a name, index or "*") to "val"
+func jsonPathStep(val any, step string, isName bool) []any {
+	switch v := val.(type) {
+	case map[string]any:
+		if step == "*" && !isName {
+			res := []any{}
+			for _, key := range SortedKeys(v) {
+				res = append(res, v[key])
+			}
+			return res
+		}
+		if child, ok := v[step]; ok {
+			return []any{child}
+		}
+
+	case []any:
+		if step == "*" && !isName {
+			return v
+		}
+		if i, err := strconv.Atoi(step); err == nil && !isName {
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				return []any{v[i]}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package xrlib
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	. "github.com/xregistry/server/common"
+)
+
+// ServerAuth is the set of headers (e.g. credentials) to include on all
+// requests sent to a particular server, for example the ones from the
+// current "xr" context. "Helper" is a command whose output is the token to
+// use, it's run (once) the first time it's needed.
+type ServerAuth struct {
+	URL     string
+	Headers map[string]string
+	Helper  string
+
+	helped bool
+}
+
+var ServerAuths = []*ServerAuth(nil)
+
+func AddServerAuth(sa *ServerAuth) {
+	ServerAuths = append(ServerAuths, sa)
+}
+
+// ServerHeaders returns the headers from all ServerAuths whose URL is a
+// prefix of "url"
+func ServerHeaders(url string) (map[string]string, *XRError) {
+	headers := map[string]string(nil)
+
+	for _, sa := range ServerAuths {
+		if !URLUnder(url, sa.URL) {
+			continue
+		}
+		if xErr := sa.runHelper(); xErr != nil {
+			return nil, xErr
+		}
+		for key, value := range sa.Headers {
+			if headers == nil {
+				headers = map[string]string{}
+			}
+			headers[key] = value
+		}
+	}
+	return headers, nil
+}
+
+// URLUnder says whether "url" is "base" or something under it. Trailing
+// slashes on "base" are ignored.
+func URLUnder(url string, base string) bool {
+	base = strings.TrimRight(base, "/")
+	if base == "" || !strings.HasPrefix(url, base) {
+		return false
+	}
+	rest := url[len(base):]
+	return rest == "" || rest[0] == '/' || rest[0] == '?'
+}
+
+// runHelper runs the credential helper command, if there is one and it
+// hasn't been run yet, and adds its output as the Authorization header.
+// If the output is just one word then it's assumed to be a bearer token.
+func (sa *ServerAuth) runHelper() *XRError {
+	if sa.Helper == "" || sa.helped {
+		return nil
+	}
+
+	stderr := bytes.Buffer{}
+	cmd := exec.Command("sh", "-c", sa.Helper)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		detail := strings.TrimSpace(stderr.String())
+		if detail == "" {
+			detail = err.Error()
+		}
+		return NewXRError("client_error", "",
+			"error_detail="+
+				fmt.Sprintf("Error running credential helper (%s): %s",
+					sa.Helper, detail))
+	}
+
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return NewXRError("client_error", "",
+			"error_detail="+
+				fmt.Sprintf("Credential helper (%s) returned no token",
+					sa.Helper))
+	}
+	if !strings.Contains(token, " ") {
+		token = "Bearer " + token
+	}
+
+	if sa.Headers == nil {
+		sa.Headers = map[string]string{}
+	}
+	sa.Headers["Authorization"] = token
+	sa.helped = true
+	return nil
+}
@@ -104,6 +104,21 @@ func HttpDo(debug bool, verb string, url string, headers map[string]string, body
 			"error_detail="+err.Error())
 	}
 
+	// Add the headers for this server (e.g. from the current context),
+	// but let the ones passed in override them
+	if len(ServerAuths) > 0 {
+		srvHeaders, xErr := ServerHeaders(url)
+		if xErr != nil {
+			return httpRes, xErr
+		}
+		if len(srvHeaders) > 0 {
+			for key, value := range headers {
+				srvHeaders[key] = value
+			}
+			headers = srvHeaders
+		}
+	}
+
 	for key, value := range headers {
 		key = strings.TrimSpace(key)
 		if key == "" {
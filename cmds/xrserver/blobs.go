@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	// log "github.com/duglin/dlog"
+	"github.com/spf13/cobra"
+	"github.com/xregistry/server/registry"
+)
+
+// setupBlobStores configures the --blob-store stores. The first one is
+// where new documents go, the rest are only there so their documents can
+// still be read. Returns true if any of them aren't the DB itself.
+func setupBlobStores(specs []string) bool {
+	external := false
+	for i, spec := range specs {
+		store, err := registry.ParseBlobStore(spec)
+		ErrStop(err, "Error in --blob-store: %s", err)
+
+		if i == 0 {
+			registry.SetBlobStore(store)
+			Verbose("Blob store: %s", spec)
+		} else {
+			registry.AddBlobStore(store)
+			Verbose("Blob store (read): %s", spec)
+		}
+		if store.Name() != "db" {
+			external = true
+		}
+	}
+	return external
+}
+
+func addBlobsCmd(parent *cobra.Command) *cobra.Command {
+	blobsCmd := &cobra.Command{
+		Use:   "blobs",
+		Short: "Manage where documents are stored",
+	}
+	parent.AddCommand(blobsCmd)
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show how many documents each store has",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 0 {
+				Stop("Too many argument on the command line")
+			}
+
+			stats, err := registry.GetBlobStats()
+			ErrStop(err, "Error talking to the DB: %s", err)
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 1, 3, ' ', 0)
+			fmt.Fprintf(tw, "STORE\tDOCUMENTS\tBYTES\n")
+			for _, stat := range stats {
+				fmt.Fprintf(tw, "%s\t%d\t%d\n", stat.Store, stat.Count,
+					stat.Size)
+			}
+			tw.Flush()
+		},
+	}
+	blobsCmd.AddCommand(statsCmd)
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Move all documents into one store",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 0 {
+				Stop("Too many argument on the command line")
+			}
+
+			toSpec, _ := cmd.Flags().GetString("to")
+			if toSpec == "" {
+				Stop("Missing --to STORE")
+			}
+			to, err := registry.ParseBlobStore(toSpec)
+			ErrStop(err, "Error in --to: %s", err)
+
+			fromSpecs, _ := cmd.Flags().GetStringArray("from")
+			for _, spec := range fromSpecs {
+				store, err := registry.ParseBlobStore(spec)
+				ErrStop(err, "Error in --from: %s", err)
+				registry.AddBlobStore(store)
+			}
+
+			count, xErr := registry.MigrateBlobs(to,
+				func(digest string, from string, size int64) {
+					Verbose("%s: %s -> %s (%d bytes)", digest, from,
+						to.Name(), size)
+				})
+			if xErr != nil {
+				Stop("Error after moving %d document(s): %s", count, xErr)
+			}
+
+			// Clean up anything that was left behind by a server that
+			// didn't have its sweeper running
+			_, xErr = registry.SweepDeadBlobs()
+			ErrStop(xErr, "Error cleaning up old documents: %s", xErr)
+
+			fmt.Printf("Moved %d document(s) to %q\n", count, to.Name())
+		},
+	}
+	migrateCmd.Flags().StringP("to", "", "",
+		"Store to move documents into: db, fs:DIR, s3://BUCKET[/PREFIX]")
+	migrateCmd.Flags().StringArrayP("from", "", nil,
+		"Store documents are currently in (db is implied)")
+	blobsCmd.AddCommand(migrateCmd)
+
+	return blobsCmd
+}
@@ -163,5 +163,184 @@ func addRegistryCmd(parent *cobra.Command) *cobra.Command {
 	}
 	registryCmd.AddCommand(listCmd)
 
+	retentionCmd := &cobra.Command{
+		Use:   "retention ID",
+		Short: "Apply the Resource retention policies of a registry",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				Stop("Missing registry ID argument")
+			}
+			if len(args) > 1 {
+				Stop("Too many argument on the command line")
+			}
+
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			actions, err := registry.SweepRetention(args[0], time.Now(),
+				dryRun)
+			ErrStop(err, "Error applying retention policies: %s", err)
+
+			tw := tabwriter.NewWriter(os.Stdout, 0, 1, 3, ' ', 0)
+			fmt.Fprintf(tw, "ACTION\tVERSION\n")
+			for _, action := range actions {
+				fmt.Fprintf(tw, "%s\t%s\n", action.Action, action.XID)
+			}
+			tw.Flush()
+		},
+	}
+	retentionCmd.Flags().BoolP("dry-run", "", false,
+		"Just show what would be removed")
+	registryCmd.AddCommand(retentionCmd)
+
+	reindexCmd := &cobra.Command{
+		Use:   "reindex ID",
+		Short: "Rebuild the index used to ?filter on the documents of a registry",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				Stop("Missing registry ID argument")
+			}
+			if len(args) > 1 {
+				Stop("Too many argument on the command line")
+			}
+
+			stores, _ := cmd.Flags().GetStringArray("blob-store")
+			for _, spec := range stores {
+				store, err := registry.ParseBlobStore(spec)
+				ErrStop(err, "Error in --blob-store: %s", err)
+				registry.AddBlobStore(store)
+			}
+
+			count, err := registry.ReindexDocuments(args[0])
+			ErrStop(err, "Error indexing documents: %s", err)
+
+			fmt.Printf("Indexed %d document(s)\n", count)
+		},
+	}
+	reindexCmd.Flags().StringArrayP("blob-store", "", nil,
+		"Store documents are in (db is implied)")
+	registryCmd.AddCommand(reindexCmd)
+
+	rateLimitsCmd := &cobra.Command{
+		Use:   "ratelimits ID",
+		Short: "Show or set the per client rate limits of a registry",
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) == 0 {
+				Stop("Missing registry ID argument")
+			}
+			if len(args) > 1 {
+				Stop("Too many argument on the command line")
+			}
+
+			tx, err := registry.NewTx()
+			ErrStop(err, "Error talking to the DB: %s", err)
+
+			reg, err := registry.FindRegistry(tx, args[0], registry.FOR_WRITE)
+			ErrStopTx(err, tx, "Error retrieving the registry: %s", err)
+			if reg == nil {
+				StopTx(tx, "Registry %q does not exist", args[0])
+			}
+
+			cfg, cfgErr := reg.GetRateLimits()
+			ErrStopTx(cfgErr, tx, "Error in the registry's rate limits: %s",
+				cfgErr)
+
+			clear, _ := cmd.Flags().GetBool("clear")
+			changed := clear
+			if clear {
+				cfg = nil
+			}
+
+			read, _ := cmd.Flags().GetString("read")
+			write, _ := cmd.Flags().GetString("write")
+			expensive, _ := cmd.Flags().GetString("expensive")
+			keyHeader, _ := cmd.Flags().GetString("key-header")
+			keysFile, _ := cmd.Flags().GetString("keys")
+			if read != "" || write != "" || expensive != "" ||
+				cmd.Flags().Changed("key-header") || keysFile != "" {
+
+				if cfg == nil {
+					cfg = &registry.RateLimitConfig{}
+				}
+				if cfg.Limits == nil {
+					cfg.Limits = map[string]*registry.RateLimit{}
+				}
+				for class, limit := range parseRateLimits(read, write,
+					expensive) {
+					cfg.Limits[class] = limit
+				}
+				if cmd.Flags().Changed("key-header") {
+					cfg.KeyHeader = keyHeader
+				}
+				if keysFile != "" {
+					keys, err := registry.LoadRateKeys(keysFile)
+					ErrStopTx(err, tx, "Error loading --keys: %s", err)
+					cfg.Keys = keys
+				}
+				changed = true
+			}
+
+			if changed {
+				err = reg.SetRateLimits(cfg)
+				ErrStopTx(err, tx, "Error saving the rate limits: %s", err)
+				err = tx.Commit()
+				ErrStopTx(err, tx, "Error saving: %s", err)
+			} else {
+				tx.Rollback()
+			}
+
+			if cfg == nil {
+				fmt.Printf("Using the server's defaults\n")
+				return
+			}
+			tw := tabwriter.NewWriter(os.Stdout, 0, 1, 3, ' ', 0)
+			fmt.Fprintf(tw, "CLASS\tLIMIT\n")
+			for _, class := range registry.RateClasses {
+				if limit, ok := cfg.Limits[class]; ok {
+					fmt.Fprintf(tw, "%s\t%s\n", class, limit)
+				} else {
+					fmt.Fprintf(tw, "%s\t(server default)\n", class)
+				}
+			}
+			tw.Flush()
+			if cfg.KeyHeader != "" {
+				fmt.Printf("Key header: %s\n", cfg.KeyHeader)
+			}
+			if len(cfg.Keys) > 0 {
+				fmt.Printf("Keys: %d\n", len(cfg.Keys))
+			}
+		},
+	}
+	rateLimitsCmd.Flags().StringP("read", "", "",
+		"Read rate limit: RATE/sec[:BURST], 0 for none")
+	rateLimitsCmd.Flags().StringP("write", "", "",
+		"Write rate limit: RATE/sec[:BURST], 0 for none")
+	rateLimitsCmd.Flags().StringP("expensive", "", "",
+		"Export/inline=*/search rate limit: RATE/sec[:BURST], 0 for none")
+	rateLimitsCmd.Flags().StringP("key-header", "", "",
+		"Header with the client's API key")
+	rateLimitsCmd.Flags().StringP("keys", "", "",
+		"File of the API keys (one per line) that --key-header accepts")
+	rateLimitsCmd.Flags().BoolP("clear", "", false,
+		"Go back to the server's defaults")
+	registryCmd.AddCommand(rateLimitsCmd)
+
 	return registryCmd
 }
+
+// parseRateLimits turns the --rate-* style flags into Limits, skipping
+// the empty ones
+func parseRateLimits(read, write, expensive string) map[string]*registry.RateLimit {
+	res := map[string]*registry.RateLimit{}
+	for class, spec := range map[string]string{
+		registry.RATE_READ:      read,
+		registry.RATE_WRITE:     write,
+		registry.RATE_EXPENSIVE: expensive,
+	} {
+		if spec == "" {
+			continue
+		}
+		limit, err := registry.ParseRateLimit(spec)
+		ErrStop(err, "Error in %s rate limit: %s", class, err)
+		res[class] = limit
+	}
+	return res
+}
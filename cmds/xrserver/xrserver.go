@@ -1,10 +1,12 @@
 package main
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/duglin/dlog"
 	"github.com/spf13/cobra"
@@ -31,6 +33,29 @@ var DontCreate = false
 var RecreateDB = false
 var RecreateReg = false
 var UIDir = ""
+var DeprecationAction = registry.DEP_ACTION_NONE
+var DeprecationInterval = time.Hour
+var RetentionInterval = time.Duration(0)
+var SigningKeyFile = ""
+var SigningKeyID = ""
+var UserTokensFile = ""
+var TrustUserHeader = false
+var ScratchRegistries = false
+var BlobStores = []string{}
+var BlobSweepInterval = time.Minute
+var ProxyAllow = []string{}
+var ProxyDeny = []string{}
+var ProxyPrivate = false
+var ProxyTimeout = registry.PROXY_DEF_TIMEOUT
+var ProxyMaxSize = int64(registry.PROXY_DEF_MAXSIZE)
+var ProxyCacheTTL = registry.PROXY_DEF_CACHETTL
+var ProxyValidate = false
+var RateRead = ""
+var RateWrite = ""
+var RateExpensive = ""
+var RateKeyHeader = ""
+var RateKeysFile = ""
+var RateForwarded = 0
 
 func ErrStop(errAny any, args ...any) {
 	ErrStopTx(errAny, nil, args...)
@@ -142,6 +167,65 @@ func setupCmds() *cobra.Command {
 	serverCmd.Flags().StringVarP(&UIDir, "ui-dir", "", UIDir,
 		"Serve new UI from this directory (dev mode)")
 
+	serverCmd.Flags().StringVarP(&DeprecationAction, "deprecation-action", "",
+		DeprecationAction, "After removal: none*, readonly, delete")
+	serverCmd.Flag("deprecation-action").DefValue = ""
+	serverCmd.Flags().DurationVarP(&DeprecationInterval,
+		"deprecation-interval", "", DeprecationInterval,
+		"How often to check for removals (1h*)")
+	serverCmd.Flag("deprecation-interval").DefValue = "0s" // hide default text
+	serverCmd.Flags().DurationVarP(&RetentionInterval,
+		"retention-interval", "", RetentionInterval,
+		"Retention sweep interval (off*)")
+	serverCmd.Flags().StringVarP(&SigningKeyFile, "signing-key", "", SigningKeyFile,
+		"Ed25519 key file used to sign documents")
+	serverCmd.Flags().StringVarP(&SigningKeyID, "signing-key-id", "", SigningKeyID,
+		"ID of the signing key (derived from key*)")
+	serverCmd.Flags().StringVarP(&UserTokensFile, "user-tokens", "",
+		UserTokensFile, "File of \"USER TOKEN\" lines for Bearer auth")
+	serverCmd.Flags().BoolVarP(&TrustUserHeader, "trust-user-header", "",
+		TrustUserHeader, "Trust xRegistry~User w/o --user-tokens (proxy only)")
+	serverCmd.Flags().BoolVarP(&ScratchRegistries, "scratch-registries", "",
+		ScratchRegistries, "Allow PUT/DELETE of /reg-"+
+			SCRATCH_REGISTRY_PREFIX+"* registries (testing only)")
+	serverCmd.Flags().StringArrayVarP(&BlobStores, "blob-store", "", BlobStores,
+		"Document store: db*, fs:DIR, s3://BUCKET[/PREFIX] (1st is for writes)")
+	serverCmd.Flags().DurationVarP(&BlobSweepInterval,
+		"blob-sweep-interval", "", BlobSweepInterval,
+		"How often to delete unused external documents (1m*)")
+	serverCmd.Flag("blob-sweep-interval").DefValue = "0s" // hide default text
+	serverCmd.Flags().StringArrayVarP(&ProxyAllow, "proxy-allow", "", ProxyAllow,
+		"Hosts/CIDRs proxyurls can fetch from (all*)")
+	serverCmd.Flags().StringArrayVarP(&ProxyDeny, "proxy-deny", "", ProxyDeny,
+		"Hosts/CIDRs proxyurls can't fetch from")
+	serverCmd.Flags().BoolVarP(&ProxyPrivate, "proxy-private", "", ProxyPrivate,
+		"Allow proxyurls to fetch from internal addresses")
+	serverCmd.Flags().DurationVarP(&ProxyTimeout, "proxy-timeout", "",
+		ProxyTimeout, "Timeout of proxyurl fetches (10s*)")
+	serverCmd.Flag("proxy-timeout").DefValue = "0s" // hide default text
+	serverCmd.Flags().Int64VarP(&ProxyMaxSize, "proxy-max-size", "", ProxyMaxSize,
+		"Max bytes of a proxyurl document (10MB*)")
+	serverCmd.Flag("proxy-max-size").DefValue = "0" // hide default text
+	serverCmd.Flags().DurationVarP(&ProxyCacheTTL, "proxy-cache-ttl", "",
+		ProxyCacheTTL, "How long to cache proxyurl documents (1m*)")
+	serverCmd.Flag("proxy-cache-ttl").DefValue = "0s" // hide default text
+	serverCmd.Flags().BoolVarP(&ProxyValidate, "proxy-validate", "", ProxyValidate,
+		"Check proxyurl documents against their format")
+	serverCmd.Flags().StringVarP(&RateRead, "rate-read", "", RateRead,
+		"Per client read rate limit: RATE/sec[:BURST]")
+	serverCmd.Flags().StringVarP(&RateWrite, "rate-write", "", RateWrite,
+		"Per client write rate limit: RATE/sec[:BURST]")
+	serverCmd.Flags().StringVarP(&RateExpensive, "rate-expensive", "",
+		RateExpensive, "Per client export/inline=*/search rate limit: RATE/sec[:BURST]")
+	serverCmd.Flags().StringVarP(&RateKeyHeader, "rate-key-header", "",
+		RateKeyHeader, "Header with the client's API key (else it's by IP)")
+	serverCmd.Flags().StringVarP(&RateKeysFile, "rate-keys", "", RateKeysFile,
+		"File of the API keys (one per line) that --rate-key-header accepts")
+	serverCmd.Flags().IntVarP(&RateForwarded, "rate-forwarded", "", RateForwarded,
+		"# of proxies that add to X-Forwarded-For, client IP is the one "+
+			"the 1st of them added")
+	serverCmd.Flag("rate-forwarded").NoOptDefVal = "1"
+
 	serverCmd.Flags().BoolP("help-all", "", false, "Help for all commands")
 
 	runCmd := &cobra.Command{
@@ -163,9 +247,68 @@ func setupCmds() *cobra.Command {
 	runCmd.Flags().StringVarP(&RegistryName, "registry", "r", RegistryName,
 		"Default Registry name("+RegistryName+"*)")
 	runCmd.Flag("registry").DefValue = ""
+	runCmd.Flags().StringVarP(&DeprecationAction, "deprecation-action", "",
+		DeprecationAction, "After removal: none*, readonly, delete")
+	runCmd.Flag("deprecation-action").DefValue = ""
+	runCmd.Flags().DurationVarP(&DeprecationInterval,
+		"deprecation-interval", "", DeprecationInterval,
+		"How often to check for removals (1h*)")
+	runCmd.Flag("deprecation-interval").DefValue = "0s" // hide default text
+	runCmd.Flags().DurationVarP(&RetentionInterval,
+		"retention-interval", "", RetentionInterval,
+		"Retention sweep interval (off*)")
+	runCmd.Flags().StringVarP(&SigningKeyFile, "signing-key", "", SigningKeyFile,
+		"Ed25519 key file used to sign documents")
+	runCmd.Flags().StringVarP(&SigningKeyID, "signing-key-id", "", SigningKeyID,
+		"ID of the signing key (derived from key*)")
+	runCmd.Flags().StringVarP(&UserTokensFile, "user-tokens", "",
+		UserTokensFile, "File of \"USER TOKEN\" lines for Bearer auth")
+	runCmd.Flags().BoolVarP(&TrustUserHeader, "trust-user-header", "",
+		TrustUserHeader, "Trust xRegistry~User w/o --user-tokens (proxy only)")
+	runCmd.Flags().BoolVarP(&ScratchRegistries, "scratch-registries", "",
+		ScratchRegistries, "Allow PUT/DELETE of /reg-"+
+			SCRATCH_REGISTRY_PREFIX+"* registries (testing only)")
+	runCmd.Flags().StringArrayVarP(&BlobStores, "blob-store", "", BlobStores,
+		"Document store: db*, fs:DIR, s3://BUCKET[/PREFIX] (1st is for writes)")
+	runCmd.Flags().DurationVarP(&BlobSweepInterval,
+		"blob-sweep-interval", "", BlobSweepInterval,
+		"How often to delete unused external documents (1m*)")
+	runCmd.Flag("blob-sweep-interval").DefValue = "0s" // hide default text
+	runCmd.Flags().StringArrayVarP(&ProxyAllow, "proxy-allow", "", ProxyAllow,
+		"Hosts/CIDRs proxyurls can fetch from (all*)")
+	runCmd.Flags().StringArrayVarP(&ProxyDeny, "proxy-deny", "", ProxyDeny,
+		"Hosts/CIDRs proxyurls can't fetch from")
+	runCmd.Flags().BoolVarP(&ProxyPrivate, "proxy-private", "", ProxyPrivate,
+		"Allow proxyurls to fetch from internal addresses")
+	runCmd.Flags().DurationVarP(&ProxyTimeout, "proxy-timeout", "",
+		ProxyTimeout, "Timeout of proxyurl fetches (10s*)")
+	runCmd.Flag("proxy-timeout").DefValue = "0s" // hide default text
+	runCmd.Flags().Int64VarP(&ProxyMaxSize, "proxy-max-size", "", ProxyMaxSize,
+		"Max bytes of a proxyurl document (10MB*)")
+	runCmd.Flag("proxy-max-size").DefValue = "0" // hide default text
+	runCmd.Flags().DurationVarP(&ProxyCacheTTL, "proxy-cache-ttl", "",
+		ProxyCacheTTL, "How long to cache proxyurl documents (1m*)")
+	runCmd.Flag("proxy-cache-ttl").DefValue = "0s" // hide default text
+	runCmd.Flags().BoolVarP(&ProxyValidate, "proxy-validate", "", ProxyValidate,
+		"Check proxyurl documents against their format")
+	runCmd.Flags().StringVarP(&RateRead, "rate-read", "", RateRead,
+		"Per client read rate limit: RATE/sec[:BURST]")
+	runCmd.Flags().StringVarP(&RateWrite, "rate-write", "", RateWrite,
+		"Per client write rate limit: RATE/sec[:BURST]")
+	runCmd.Flags().StringVarP(&RateExpensive, "rate-expensive", "",
+		RateExpensive, "Per client export/inline=*/search rate limit: RATE/sec[:BURST]")
+	runCmd.Flags().StringVarP(&RateKeyHeader, "rate-key-header", "",
+		RateKeyHeader, "Header with the client's API key (else it's by IP)")
+	runCmd.Flags().StringVarP(&RateKeysFile, "rate-keys", "", RateKeysFile,
+		"File of the API keys (one per line) that --rate-key-header accepts")
+	runCmd.Flags().IntVarP(&RateForwarded, "rate-forwarded", "", RateForwarded,
+		"# of proxies that add to X-Forwarded-For, client IP is the one "+
+			"the 1st of them added")
+	runCmd.Flag("rate-forwarded").NoOptDefVal = "1"
 
 	serverCmd.AddCommand(runCmd)
 
+	addBlobsCmd(serverCmd)
 	addDBCmd(serverCmd)
 	addRegistryCmd(serverCmd)
 
@@ -214,10 +357,59 @@ func runFunc(cmd *cobra.Command, args []string) {
 		Stop("Too many arguments on the command line")
 	}
 
+	if !ArrayContains(registry.DeprecationActions, DeprecationAction) {
+		Stop("--deprecation-action must be one of: %s",
+			strings.Join(registry.DeprecationActions, ", "))
+	}
+
 	if RegistryName == "" {
 		Stop("Default Registry name missing, try: -r NAME")
 	}
 
+	if SigningKeyFile != "" {
+		buf, err := os.ReadFile(SigningKeyFile)
+		ErrStop(err, "Error reading signing key(%s): %s", SigningKeyFile, err)
+		key, err := ParsePrivateKey(buf)
+		ErrStop(err, "Error parsing signing key(%s): %s", SigningKeyFile, err)
+		registry.SetSigningKey(key, SigningKeyID)
+		Verbose("Signing key %q: %s", registry.SigningKeyID,
+			PublicKeyString(key.Public().(ed25519.PublicKey)))
+	} else if SigningKeyID != "" {
+		Stop("--signing-key-id requires --signing-key")
+	}
+
+	if UserTokensFile != "" {
+		err := registry.LoadUserTokens(UserTokensFile)
+		ErrStop(err, "Error loading user tokens(%s): %s", UserTokensFile, err)
+		Verbose("Loaded %d user token(s)", len(registry.UserTokens))
+	}
+	registry.TrustUserHeader = TrustUserHeader
+	registry.ScratchRegistries = ScratchRegistries
+
+	external := setupBlobStores(BlobStores)
+
+	pf := registry.NewProxyFetcher()
+	pf.Allow = ProxyAllow
+	pf.Deny = ProxyDeny
+	pf.AllowPrivate = ProxyPrivate
+	pf.Timeout = ProxyTimeout
+	pf.MaxSize = ProxyMaxSize
+	pf.CacheTTL = ProxyCacheTTL
+	pf.Validate = ProxyValidate
+	pfErr := registry.SetProxyFetcher(pf)
+	ErrStop(pfErr, "Error in --proxy-* flags: %s", pfErr)
+
+	rl := registry.NewRateLimiter()
+	rl.Forwarded = RateForwarded
+	rl.Defaults.KeyHeader = RateKeyHeader
+	if RateKeysFile != "" {
+		keys, err := registry.LoadRateKeys(RateKeysFile)
+		ErrStop(err, "Error loading --rate-keys: %s", err)
+		rl.Defaults.Keys = keys
+	}
+	rl.Defaults.Limits = parseRateLimits(RateRead, RateWrite, RateExpensive)
+	registry.SetRateLimiter(rl)
+
 	if RecreateDB {
 		if registry.DBExists(DBName) {
 			Verbose("Deleting DB: %s", DBName)
@@ -304,6 +496,11 @@ func runFunc(cmd *cobra.Command, args []string) {
 	}
 
 	registry.DefaultRegDbSID = reg.DbSID
+	registry.StartDeprecationScheduler(DeprecationInterval, DeprecationAction)
+	registry.StartRetentionSweeper(RetentionInterval)
+	if external {
+		registry.StartBlobSweeper(BlobSweepInterval)
+	}
 	registry.NewServer(APIPort).Serve()
 }
 
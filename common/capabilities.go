@@ -14,10 +14,13 @@ type Capabilities struct {
 	// THESE MUST NOT HAVE "omitempty" on them
 	Available       map[string]*AvailableObject `json:"available"`
 	Compatibilities map[string][]string         `json:"compatibilities"`
+	Encodings       []string                    `json:"encodings"`
+	FilterOperators []string                    `json:"filteroperators"`
 	Flags           []string                    `json:"flags"`
 	Formats         []string                    `json:"formats"`
 	Ignores         []string                    `json:"ignores"`
 	Pagination      bool                        `json:"pagination"`
+	Ranges          bool                        `json:"ranges"`
 	ShortSelf       bool                        `json:"shortself"`
 	SpecVersions    []string                    `json:"specversions"`
 	VersionModes    []string                    `json:"versionmodes"`
@@ -46,10 +49,13 @@ type OfferedItem struct {
 type Offered struct {
 	Available       OfferedCapability `json:"available,omitempty"`
 	Compatibilities OfferedCapability `json:"compatibilities,omitempty"`
+	Encodings       OfferedCapability `json:"encodings,omitempty"`
+	FilterOperators OfferedCapability `json:"filteroperators,omitempty"`
 	Flags           OfferedCapability `json:"flags,omitempty"`
 	Formats         OfferedCapability `json:"formats,omitempty"`
 	Ignores         OfferedCapability `json:"ignores,omitempty"`
 	Pagination      OfferedCapability `json:"pagination,omitempty"`
+	Ranges          OfferedCapability `json:"ranges,omitempty"`
 	ShortSelf       OfferedCapability `json:"shortself,omitempty"`
 	SpecVersions    OfferedCapability `json:"specversions,omitempty"`
 	VersionModes    OfferedCapability `json:"versionmodes,omitempty"`
@@ -77,13 +83,32 @@ var SupportedAvailable = map[string]*AvailableObject{
 	"modelsource": &AvailableObject{
 		Mutable: true,
 	},
+	"ratelimits": &AvailableObject{
+		Mutable: false,
+	},
 }
 
 var SupportedCompatibilities = map[string][]string{}
 
+// The content-codings (Content-Encoding/Accept-Encoding) that can be used
+// on request bodies and responses
+var SupportedEncodings = ArrayToLower([]string{"gzip", "zstd"})
+
+// The ?filter= operators beyond the spec defined ones (=, !=, <, <=, >, >=)
+// that can be turned on/off:
+//   - casesensitive: ==, !==, ~==, ^== and ==in()/==contains()
+//   - contains:      attr=contains(value) - array attr has value
+//   - haskey:        attr=haskey(key) - map attr has key
+//   - in:            attr=in(v1,v2,...), attr!=in(v1,v2,...)
+//   - prefix:        attr^=value
+//   - regex:         attr~=regex
+var SupportedFilterOperators = ArrayToLower([]string{
+	"casesensitive", "contains", "haskey", "in", "prefix", "regex"})
+
 var SupportedFlags = ArrayToLower([]string{
-	"binary", "collections", "doc", "epoch", "filter", "ignore", "inline",
-	"setdefaultversionid", "sort", "specversion"})
+	"binary", "collections", "doc", "epoch", "facet", "fields", "filter",
+	"ignore", "inline", "publish", "setdefaultversionid", "sort",
+	"specversion"})
 
 var SupportedFormats = []string{}
 
@@ -98,10 +123,13 @@ var SupportedVersionModes = ArrayToLower([]string{"manual", "createdat"})
 var DefaultCapabilities = &Capabilities{
 	Available:       SupportedAvailable,
 	Compatibilities: SupportedCompatibilities,
+	Encodings:       SupportedEncodings,
+	FilterOperators: SupportedFilterOperators,
 	Flags:           SupportedFlags,
 	Formats:         SupportedFormats,
 	Ignores:         SupportedIgnores,
 	Pagination:      false,
+	Ranges:          true,
 	ShortSelf:       false,
 	SpecVersions:    SupportedSpecVersions,
 	VersionModes:    SupportedVersionModes,
@@ -123,6 +151,8 @@ func AddSupportedFormat(format string, compats []string) {
 }
 
 func init() {
+	sort.Strings(SupportedEncodings)
+	sort.Strings(SupportedFilterOperators)
 	sort.Strings(SupportedFlags)
 	sort.Strings(SupportedFormats)
 	sort.Strings(SupportedIgnores)
@@ -181,6 +211,11 @@ func GetOffered() *Offered {
 					Attributes: map[string]*OfferedCapability{
 						"mutable": {Type: "boolean"}},
 				},
+				"ratelimits": &OfferedCapability{
+					Type: "object",
+					Attributes: map[string]*OfferedCapability{
+						"mutable": {Type: "boolean", Enum: []any{false}}},
+				},
 				".xregistry": &OfferedCapability{
 					Type: "object",
 					Attributes: map[string]*OfferedCapability{
@@ -189,6 +224,20 @@ func GetOffered() *Offered {
 			},
 		},
 		Compatibilities: OfferedCapability{}, // Do it below
+		Encodings: OfferedCapability{
+			Type: "array",
+			Item: &OfferedItem{
+				Type: "string",
+			},
+			Enum: String2AnySlice(SupportedEncodings),
+		},
+		FilterOperators: OfferedCapability{
+			Type: "array",
+			Item: &OfferedItem{
+				Type: "string",
+			},
+			Enum: String2AnySlice(SupportedFilterOperators),
+		},
 		Flags: OfferedCapability{
 			Type: "array",
 			Item: &OfferedItem{
@@ -214,6 +263,9 @@ func GetOffered() *Offered {
 			Type: "boolean",
 			Enum: []any{false},
 		},
+		Ranges: OfferedCapability{
+			Type: "boolean",
+		},
 		ShortSelf: OfferedCapability{
 			Type: "boolean",
 		},
@@ -355,6 +407,18 @@ func (c *Capabilities) Validate() *XRError {
 		}
 	}
 
+	c.Encodings, xErr = CleanArray(c.Encodings, SupportedEncodings,
+		"encodings")
+	if xErr != nil {
+		return xErr
+	}
+
+	c.FilterOperators, xErr = CleanArray(c.FilterOperators,
+		SupportedFilterOperators, "filteroperators")
+	if xErr != nil {
+		return xErr
+	}
+
 	c.Flags, xErr = CleanArray(c.Flags, SupportedFlags, "flags")
 	if xErr != nil {
 		return xErr
@@ -479,6 +543,14 @@ func (c *Capabilities) IsAvailableMutable(str string) bool {
 	return ok && avail.Mutable
 }
 
+func (c *Capabilities) EncodingEnabled(str string) bool {
+	return ArrayContains(c.Encodings, str)
+}
+
+func (c *Capabilities) FilterOperatorEnabled(str string) bool {
+	return ArrayContains(c.FilterOperators, str)
+}
+
 func (c *Capabilities) FlagEnabled(str string) bool {
 	return ArrayContains(c.Flags, str)
 }
@@ -491,6 +563,10 @@ func (c *Capabilities) PaginationEnabled() bool {
 	return c.Pagination
 }
 
+func (c *Capabilities) RangesEnabled() bool {
+	return c.Ranges
+}
+
 func (c *Capabilities) ShortSelfEnabled() bool {
 	return c.ShortSelf
 }
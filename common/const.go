@@ -19,6 +19,14 @@ const STRICTVALIDATION = false
 const CONSISTENTFORMAT = false
 const READONLY = false
 
+// Collection of pending changes under a Group that uses "approval"
+const CHANGEREQUESTS = "changerequests"
+
+// Registries whose ID starts with this can be created and deleted over
+// HTTP (PUT/DELETE /reg-ID) if the server allows it. "xr conform --write"
+// uses one so it never changes the Registry being tested.
+const SCRATCH_REGISTRY_PREFIX = "xrscratch-"
+
 // Attribute types
 const ANY = "any"
 const ARRAY = "array"
@@ -385,10 +385,62 @@ var Type2Error = map[string]*XRError{
 	},
 
 	// SERVER impl defined
+	"approval_required": &XRError{
+		Code:  400,
+		Title: `Changes to "<subject>" require approval and must be made one Version at a time.`,
+	},
+	"bad_facet": &XRError{
+		Code:  400,
+		Title: `For "<subject>", an error was found in "facet" value (<value>): <error_detail>.`,
+	},
+	"bad_fields": &XRError{
+		Code:  400,
+		Title: `For "<subject>", an error was found in "fields" value (<value>): <error_detail>.`,
+	},
+	"changerequest_closed": &XRError{
+		Code:  400,
+		Title: `The change request "<subject>" is already "<status>".`,
+	},
+	"changerequest_forbidden": &XRError{
+		Code:  403,
+		Title: `User "<user>" can't <action> the change request "<subject>": <error_detail>.`,
+	},
 	"hasdocument_enable_violation": &XRError{
 		Code:  400,
 		Title: `The request would cause Version "<subject>" to be non-compliant. The Resource model is changing "hasdocument" to "true" but this Version already has data for the reserved attribute "<name>".`,
 	},
+	"proxy_denied": &XRError{
+		Code:  403,
+		Title: `Fetching the document of "<subject>" from "<url>" isn't allowed: <error_detail>.`,
+	},
+	"proxy_error": &XRError{
+		Code:  502,
+		Title: `There was an error fetching the document of "<subject>" from "<url>": <error_detail>.`,
+	},
+	"range_not_satisfiable": &XRError{
+		Code:  416,
+		Title: `The range (<range>) isn't satisfiable for the document of "<subject>", which is <size> bytes.`,
+	},
+	"signature_invalid": &XRError{
+		Code:  400,
+		Title: `The "signature" of Version "<subject>" is invalid: <error_detail>.`,
+	},
+	"signature_required": &XRError{
+		Code:  400,
+		Title: `The document of Version "<subject>" must be signed by a trusted key.`,
+	},
+	"too_many_requests": &XRError{
+		Code:  429,
+		Title: `Too many "<class>" requests, try again in <retry> second(s).`,
+	},
+	"unsupported_encoding": &XRError{
+		Code:  415,
+		Title: `The "Content-Encoding" of the request (<encoding>) isn't supported. Allowable values include: <list>.`,
+	},
+	"version_state": &XRError{
+		Code:  400,
+		Title: `The "state" of Version "<subject>" can't be changed from "<from>" to "<to>".`,
+	},
 }
 
 func init() {
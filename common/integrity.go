@@ -0,0 +1,129 @@
+package common
+
+// Digests and signatures of Version documents. Shared by the server (which
+// computes/verifies them as documents are stored) and the "xr verify"
+// command (which re-checks them on the client side).
+//
+// A digest is always "sha256:<hex>" and a signature is the base64 encoded
+// Ed25519 signature of the digest string itself (not of the raw document),
+// so a signature can be checked without having the document in hand.
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+const DIGEST_PREFIX = "sha256:"
+
+// DocumentDigest returns the "sha256:<hex>" digest of a document
+func DocumentDigest(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return DIGEST_PREFIX + hex.EncodeToString(sum[:])
+}
+
+// DigestHeaders returns the values to use for the "Repr-Digest" (RFC 9530)
+// and the older "Digest" (RFC 3230) HTTP headers of a document with the
+// given "sha256:<hex>" digest
+func DigestHeaders(digest string) (string, string, error) {
+	sum, err := hex.DecodeString(strings.TrimPrefix(digest, DIGEST_PREFIX))
+	if err != nil || !strings.HasPrefix(digest, DIGEST_PREFIX) ||
+		len(sum) != sha256.Size {
+		return "", "", fmt.Errorf("invalid digest %q", digest)
+	}
+	b64 := base64.StdEncoding.EncodeToString(sum)
+	return "sha-256=:" + b64 + ":", "SHA-256=" + b64, nil
+}
+
+// ParseReprDigest pulls the "sha-256" value out of a "Repr-Digest" header
+// and returns it as a "sha256:<hex>" digest
+func ParseReprDigest(header string) (string, error) {
+	for _, part := range strings.Split(header, ",") {
+		alg, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if strings.ToLower(alg) != "sha-256" {
+			continue
+		}
+		val = strings.TrimSuffix(strings.TrimPrefix(val, ":"), ":")
+		sum, err := base64.StdEncoding.DecodeString(val)
+		if err != nil || len(sum) != sha256.Size {
+			return "", fmt.Errorf("invalid sha-256 value %q", val)
+		}
+		return DIGEST_PREFIX + hex.EncodeToString(sum), nil
+	}
+	return "", fmt.Errorf("no sha-256 value in %q", header)
+}
+
+// ParsePublicKey decodes a base64 encoded raw (32 byte) Ed25519 public key
+func ParsePublicKey(str string) (ed25519.PublicKey, error) {
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimSpace(str))
+	if err != nil {
+		return nil, fmt.Errorf("public key isn't valid base64: %s", err)
+	}
+	if len(buf) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("public key must be %d bytes, not %d",
+			ed25519.PublicKeySize, len(buf))
+	}
+	return ed25519.PublicKey(buf), nil
+}
+
+// ParsePrivateKey accepts either a PEM encoded PKCS#8 Ed25519 private key
+// (e.g. from "openssl genpkey -algorithm ed25519") or a base64 encoded
+// 32 byte Ed25519 seed
+func ParsePrivateKey(buf []byte) (ed25519.PrivateKey, error) {
+	if block, _ := pem.Decode(buf); block != nil {
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("private key isn't an Ed25519 key")
+		}
+		return edKey, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(buf)))
+	if err != nil {
+		return nil, fmt.Errorf("private key isn't PEM or base64: %s", err)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("private key seed must be %d bytes, not %d",
+			ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// PublicKeyString is the inverse of ParsePublicKey
+func PublicKeyString(pub ed25519.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub)
+}
+
+// PublicKeyID is the default ID used for a key when one isn't provided,
+// the first 16 hex chars of the sha256 of the public key
+func PublicKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// SignDigest returns the base64 encoded signature of "digest"
+func SignDigest(key ed25519.PrivateKey, digest string) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(digest)))
+}
+
+// VerifyDigestSignature checks that "sig" (base64) is a valid signature
+// of "digest" by the owner of "pub"
+func VerifyDigestSignature(pub ed25519.PublicKey, digest string, sig string) error {
+	buf, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("signature isn't valid base64: %s", err)
+	}
+	if !ed25519.Verify(pub, []byte(digest), buf) {
+		return fmt.Errorf("signature doesn't match the document")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package common
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+)
+
+func TestDigestHeaders(t *testing.T) {
+	digest := DocumentDigest([]byte("hello world"))
+	if digest != "sha256:b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9" {
+		t.Fatalf("Bad digest: %s", digest)
+	}
+
+	repr, legacy, err := DigestHeaders(digest)
+	if err != nil {
+		t.Fatalf("DigestHeaders: %s", err)
+	}
+	if repr != "sha-256=:uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=:" ||
+		legacy != "SHA-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=" {
+		t.Fatalf("Bad headers: %q %q", repr, legacy)
+	}
+
+	for _, hdr := range []string{repr, "sha-512=:abc=:, " + repr} {
+		res, err := ParseReprDigest(hdr)
+		if err != nil || res != digest {
+			t.Fatalf("ParseReprDigest(%q): %q %v", hdr, res, err)
+		}
+	}
+
+	for _, hdr := range []string{"", "sha-512=:abc=:", "sha-256=:abc=:"} {
+		if _, err := ParseReprDigest(hdr); err == nil {
+			t.Fatalf("ParseReprDigest(%q) should have failed", hdr)
+		}
+	}
+
+	if _, _, err := DigestHeaders("md5:1234"); err == nil {
+		t.Fatalf("DigestHeaders should have failed")
+	}
+}
+
+func TestSignDigest(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, 32))
+	pubStr := PublicKeyString(key.Public().(ed25519.PublicKey))
+
+	pub, err := ParsePublicKey(pubStr)
+	if err != nil {
+		t.Fatalf("ParsePublicKey: %s", err)
+	}
+
+	digest := DocumentDigest([]byte("hello world"))
+	sig := SignDigest(key, digest)
+	if err := VerifyDigestSignature(pub, digest, sig); err != nil {
+		t.Fatalf("Verify: %s", err)
+	}
+
+	other := DocumentDigest([]byte("hello there"))
+	if err := VerifyDigestSignature(pub, other, sig); err == nil {
+		t.Fatalf("Verify of the wrong digest should have failed")
+	}
+	if err := VerifyDigestSignature(pub, digest, "!!"); err == nil {
+		t.Fatalf("Verify of a non-base64 sig should have failed")
+	}
+
+	// Seed form of the private key
+	seed := base64.StdEncoding.EncodeToString(key.Seed())
+	key2, err := ParsePrivateKey([]byte(seed + "\n"))
+	if err != nil || !key2.Equal(key) {
+		t.Fatalf("ParsePrivateKey: %v", err)
+	}
+
+	if _, err := ParsePublicKey("abcd"); err == nil {
+		t.Fatalf("ParsePublicKey of a short key should have failed")
+	}
+	if _, err := ParsePrivateKey([]byte("abcd")); err == nil {
+		t.Fatalf("ParsePrivateKey of a short key should have failed")
+	}
+
+	if id := PublicKeyID(pub); len(id) != 16 {
+		t.Fatalf("Bad key id: %q", id)
+	}
+}
@@ -16,6 +16,7 @@ var MASK_LOGS = "MaskLogs"
 var NOMASK_INSTANCE = "NoMaskInstance"
 var MASK_CONFORM_PASS = "MaskConformPass"
 var NOMASK_SHORTSELF = "NoMaskShortSelf"
+var NOMASK_DIGEST = "NoMaskDigest"
 
 var REG_LOGDATE = `(?m)^\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2} `
 var REG_RFC3339 = `\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[-+]\d{2}:\d{2})`
@@ -25,6 +26,8 @@ var REG_DBHOST = `DB server: .*`
 var REG_INSTANCE = `"source": "[^"]*"`
 var REG_MASK_CONFORM_PASS = `(?m)^Pass: [0-9]*`
 var REG_SHORTSELF = `"shortself": "[^"]*"`
+var REG_DIGEST = `\n\s*"digest": "sha256:[0-9a-f]{64}",`
+var REG_DIGEST_LAST = `,\n\s*"digest": "sha256:[0-9a-f]{64}"(\n\s*})`
 
 var SavedREs = map[string]*regexp.Regexp{
 	REG_LOGDATE:           regexp.MustCompile(REG_LOGDATE),
@@ -35,6 +38,8 @@ var SavedREs = map[string]*regexp.Regexp{
 	REG_INSTANCE:          regexp.MustCompile(REG_INSTANCE),
 	REG_MASK_CONFORM_PASS: regexp.MustCompile(REG_MASK_CONFORM_PASS),
 	REG_SHORTSELF:         regexp.MustCompile(REG_SHORTSELF),
+	REG_DIGEST:            regexp.MustCompile(REG_DIGEST),
+	REG_DIGEST_LAST:       regexp.MustCompile(REG_DIGEST_LAST),
 }
 
 // Mask timestamps, but if (for the same input) the same TS is used, make sure
@@ -130,6 +135,13 @@ func XEqual(t *testing.T, extra string, gotAny any, expAny any, flags ...string)
 		exp = SavedREs[REG_SHORTSELF].ReplaceAllString(exp, `"shortself": "xxx"`)
 	}
 
+	// Every Version with a document has a "digest". Unless the test is
+	// looking for one, drop them so the other tests don't need to care
+	if !flagsMap[NOMASK_DIGEST] && !strings.Contains(exp, `"digest": "`) {
+		got = SavedREs[REG_DIGEST_LAST].ReplaceAllString(got, "$1")
+		got = SavedREs[REG_DIGEST].ReplaceAllString(got, "")
+	}
+
 	for pos < len(got) && pos < len(exp) && got[pos] == exp[pos] {
 		pos++
 	}
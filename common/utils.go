@@ -463,11 +463,44 @@ func JsonErrorToString(buf []byte, dec *json.Decoder, err error) string {
 	return msg
 }
 
-// var re = regexp.MustCompile(`(?m:([^#]*)#[^"]*$)`)
-var removeCommentsRE = regexp.MustCompile(`(gm:^(([^"#]|"[^"]*")*)#.*$)`)
-
+// RemoveComments removes "#" comments (from the "#" to the end of the line)
+// from the JSON in "buf". A "#" inside of a string, even one with escaped
+// quotes in it (e.g. "a \"#b"), isn't a comment. The newlines are kept so
+// that line numbers in any parsing errors still match the original.
 func RemoveComments(buf []byte) []byte {
-	return removeCommentsRE.ReplaceAll(buf, []byte("${1}"))
+	res := make([]byte, 0, len(buf))
+	inString := false
+	inComment := false
+
+	for i := 0; i < len(buf); i++ {
+		ch := buf[i]
+
+		if ch == '\n' {
+			// JSON strings can't span lines, so don't let a bad one
+			// swallow the rest of the file
+			inString = false
+			inComment = false
+		} else if inComment {
+			continue
+		} else if inString {
+			if ch == '\\' && i+1 < len(buf) && buf[i+1] != '\n' {
+				res = append(res, ch)
+				i++
+				ch = buf[i]
+			} else if ch == '"' {
+				inString = false
+			}
+		} else if ch == '"' {
+			inString = true
+		} else if ch == '#' {
+			inComment = true
+			continue
+		}
+
+		res = append(res, ch)
+	}
+
+	return res
 }
 
 type IncludeArgs struct {
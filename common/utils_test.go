@@ -457,6 +457,33 @@ func TestMatch(t *testing.T) {
 	}
 }
 
+func TestRemoveComments(t *testing.T) {
+	tests := []struct {
+		In  string
+		Out string
+	}{
+		{"", ""},
+		{"{}", "{}"},
+		{"# c\n{}", "\n{}"},
+		{"{ # c\n}", "{ \n}"},
+		{`{"a":"b#c"} # c`, `{"a":"b#c"} `},
+		{"{\n \"a\": 1, # c1\n \"b\": \"#\" # c2\n}",
+			"{\n \"a\": 1, \n \"b\": \"#\" \n}"},
+		{`{"a": "a \"#b", "x": 1}`, `{"a": "a \"#b", "x": 1}`},
+		{`{"a": "a \"#b", "x": 1} # c`, `{"a": "a \"#b", "x": 1} `},
+		{`{"a": "a\\", "x": 1} # c`, `{"a": "a\\", "x": 1} `},
+		{`{"a": "a\\\"#", "x": 1} # c`, `{"a": "a\\\"#", "x": 1} `},
+		{"{\"a\": \"b # c\n}", "{\"a\": \"b # c\n}"},
+	}
+
+	for _, test := range tests {
+		got := string(RemoveComments([]byte(test.In)))
+		if got != test.Out {
+			t.Fatalf("In: %q\nExp: %q\nGot: %q", test.In, test.Out, got)
+		}
+	}
+}
+
 func TestMakeShort(t *testing.T) {
 	tests := []struct {
 		in  []byte
@@ -0,0 +1,155 @@
+// Package registry - content-addressed document storage.
+//
+// A Version's document lives in the Blobs table, keyed by its digest, and
+// ResourceContents just maps the Version (or rather its "#contentid") to
+// that digest. So identical documents, no matter how many Versions,
+// Resources or Registries have them, are only stored once. The triggers on
+// ResourceContents (see init.sql) keep Blobs.RefCount up to date and
+// delete a Blob when the last Version using it goes away. Where the bytes
+// themselves are kept is up to the BlobStore (see blobstore.go).
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	. "github.com/xregistry/server/common"
+)
+
+// SaveDocument points the Version "versionSID" at the "size" byte document
+// in "r", only storing it if no other Version already has it. Stores that
+// are BlobStagers have "r" copied into them, and hashed, as it's read.
+// For the others "r" is read into memory first. Note that request bodies
+// are already in memory by now (see ParseRequest), so this only saves a
+// copy, it doesn't let documents be bigger than memory. Returns the
+// document's digest and whether the Version's document actually changed.
+func SaveDocument(tx *Tx, versionSID string, r io.Reader, size int64) (string, bool, error) {
+	store := GetBlobStore()
+	stager, _ := store.(BlobStager)
+
+	digest, tmp, buf := "", "", []byte(nil)
+	var err error
+	if stager != nil {
+		tmp, digest, size, err = stager.Stage(tx, r, size)
+		if err != nil {
+			return "", false, err
+		}
+		// Clean up the temp copy unless it was published below
+		defer func() {
+			if tmp != "" {
+				stager.Discard(tx, tmp)
+			}
+		}()
+	} else {
+		if buf, err = io.ReadAll(r); err != nil {
+			return "", false, err
+		}
+		digest, size = DocumentDigest(buf), int64(len(buf))
+	}
+
+	results := Query(tx, `
+        SELECT Digest FROM ResourceContents WHERE VersionSID=? FOR UPDATE`,
+		versionSID)
+	oldDigest := ""
+	hasRow := false
+	if row := results.NextRow(); row != nil {
+		oldDigest = NotNilString(row[0])
+		hasRow = true
+	}
+	results.Close()
+
+	if oldDigest == digest {
+		// Same document as before, nothing to do
+		return digest, false, nil
+	}
+
+	// Lock the Blob (if it's there) so it can't be cleaned up from under
+	// us before our ResourceContents row references it
+	results = Query(tx, `SELECT 1 FROM Blobs WHERE Digest=? FOR UPDATE`,
+		digest)
+	exists := results.NextRow() != nil
+	results.Close()
+
+	if !exists {
+		// Row first, the "db" store saves the bytes into it, and its lock
+		// keeps the sweeper away from the object until we're done
+		DoOne(tx, `
+            INSERT INTO Blobs(Digest, Size, RefCount, Store)
+            VALUES(?,?,0,?)`, digest, size, store.Name())
+
+		if err = addPendingBlob(store, digest); err != nil {
+			return "", false, err
+		}
+		if stager != nil {
+			if err = stager.Publish(tx, tmp, digest); err == nil {
+				tmp = ""
+			}
+		} else {
+			err = store.Put(tx, digest, bytes.NewBuffer(buf), size)
+		}
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	if hasRow {
+		DoOne(tx, `UPDATE ResourceContents SET Digest=? WHERE VersionSID=?`,
+			digest, versionSID)
+	} else {
+		DoOne(tx, `
+            INSERT INTO ResourceContents(VersionSID, Digest) VALUES(?,?)`,
+			versionSID, digest)
+	}
+
+	return digest, true, nil
+}
+
+// DeleteDocument removes the Version's document. The Blob itself is only
+// deleted once no other Version references it.
+func DeleteDocument(tx *Tx, versionSID string) {
+	Do(tx, `DELETE FROM ResourceContents WHERE VersionSID=?`, versionSID)
+}
+
+// OpenDocument returns a reader of the document of "contentID" (a
+// "#contentid" value), and its size, or nil if there isn't one
+func OpenDocument(tx *Tx, contentID any) (io.ReadCloser, int64, error) {
+	results := Query(tx, `
+        SELECT b.Digest, b.Store, b.Size FROM ResourceContents AS rc
+        JOIN Blobs AS b ON (b.Digest=rc.Digest)
+        WHERE rc.VersionSID=?`, contentID)
+	row := results.NextRow()
+	if row != nil && results.NextRow() != nil {
+		panic("too many results")
+	}
+	results.Close()
+
+	if row == nil {
+		return nil, 0, nil
+	}
+
+	digest, name := NotNilString(row[0]), NotNilString(row[1])
+	size := int64(NotNilInt(row[2]))
+	store := FindBlobStore(name)
+	if store == nil {
+		return nil, 0, fmt.Errorf("document %q is in blob store %q, which "+
+			"isn't configured", digest, name)
+	}
+	r, err := store.Open(tx, digest)
+	return r, size, err
+}
+
+// LoadDocument returns the document of "contentID" (a "#contentid" value)
+// or nil if there isn't one
+func LoadDocument(tx *Tx, contentID any) []byte {
+	r, _, err := OpenDocument(tx, contentID)
+	PanicIf(err != nil, "Error loading document %v: %s", contentID, err)
+	if r == nil {
+		return nil
+	}
+	defer r.Close()
+
+	buf, err := io.ReadAll(r)
+	PanicIf(err != nil, "Error reading document %v: %s", contentID, err)
+	return buf
+}
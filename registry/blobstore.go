@@ -0,0 +1,568 @@
+// Package registry - where the bytes of a document (a Blob, see blobs.go)
+// actually live.
+//
+// The Blobs table always has a row per document (for its RefCount), but
+// its content is kept by a BlobStore:
+//
+//	db                         - in Blobs.Content itself (the default)
+//	fs:DIR                     - one file per digest under DIR
+//	s3://BUCKET[/PREFIX]?...   - an S3-compatible object store
+//
+// Blobs.Store records which one has a given document, so switching the
+// store used for new documents doesn't lose track of the old ones, as long
+// as their store is still configured (see AddBlobStore). MigrateBlobs
+// moves existing documents from one store to another.
+//
+// External stores can't be cleaned up by the DB triggers, so when a Blob
+// row of one of them goes away it's queued in DeadBlobs and
+// SweepDeadBlobs removes the object later on. Objects written by a Tx that
+// never committed are found the same way, via PendingBlobs.
+package registry
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/duglin/dlog"
+	. "github.com/xregistry/server/common"
+)
+
+type BlobStore interface {
+	// Name is saved in Blobs.Store: "db", "fs" or "s3"
+	Name() string
+
+	// Put stores "size" bytes from "r" as the document "digest". Since
+	// documents are content-addressed it's ok to Put the same one twice.
+	Put(tx *Tx, digest string, r io.Reader, size int64) error
+
+	// Open returns a reader of the document "digest"
+	Open(tx *Tx, digest string) (io.ReadCloser, error)
+
+	// Delete removes the document "digest", if it's there
+	Delete(tx *Tx, digest string) error
+}
+
+// BlobStager is a BlobStore that can take a document before its digest is
+// known, so it can be copied into the store and hashed along the way
+// rather than being hashed first. See SaveDocument().
+type BlobStager interface {
+	// Stage stores "r" under a temporary name, hashing it as it's written.
+	// Returns that name, and the document's digest and size. "size" is how
+	// many bytes "r" has, or -1 if that's not known.
+	Stage(tx *Tx, r io.Reader, size int64) (string, string, int64, error)
+
+	// Publish turns the Staged document "tmp" into the document "digest"
+	Publish(tx *Tx, tmp string, digest string) error
+
+	// Discard removes the Staged document "tmp"
+	Discard(tx *Tx, tmp string) error
+}
+
+// digestWriter hashes what's written to it, for BlobStager.Stage()
+type digestWriter struct {
+	hash  hash.Hash
+	count int64
+}
+
+func newDigestWriter() *digestWriter {
+	return &digestWriter{hash: sha256.New()}
+}
+
+func (dw *digestWriter) Write(p []byte) (int, error) {
+	dw.count += int64(len(p))
+	return dw.hash.Write(p)
+}
+
+func (dw *digestWriter) Digest() string {
+	return DIGEST_PREFIX + hex.EncodeToString(dw.hash.Sum(nil))
+}
+
+// Check makes sure we got as many bytes as we were told to expect
+func (dw *digestWriter) Check(size int64) error {
+	if size >= 0 && dw.count != size {
+		return fmt.Errorf("wrote %d bytes, expected %d", dw.count, size)
+	}
+	return nil
+}
+
+var blobStoresMutex sync.RWMutex
+var blobStores = map[string]BlobStore{"db": &DBBlobStore{}}
+var blobStore BlobStore = blobStores["db"]
+
+// ParseBlobStore turns one of the specs listed above into a BlobStore
+func ParseBlobStore(spec string) (BlobStore, error) {
+	switch {
+	case spec == "db":
+		return &DBBlobStore{}, nil
+	case strings.HasPrefix(spec, "fs:"):
+		return NewFSBlobStore(spec[3:])
+	case strings.HasPrefix(spec, "s3://"):
+		return NewS3BlobStore(spec)
+	}
+	return nil, fmt.Errorf("unknown blob store %q, must be one of: db, "+
+		"fs:DIR, s3://BUCKET[/PREFIX]", spec)
+}
+
+// SetBlobStore makes "store" the one that new documents are saved in
+func SetBlobStore(store BlobStore) {
+	AddBlobStore(store)
+
+	blobStoresMutex.Lock()
+	defer blobStoresMutex.Unlock()
+	blobStore = store
+}
+
+// AddBlobStore makes the documents in "store" readable without using it
+// for new documents
+func AddBlobStore(store BlobStore) {
+	blobStoresMutex.Lock()
+	defer blobStoresMutex.Unlock()
+	blobStores[store.Name()] = store
+}
+
+// GetBlobStore returns the store used for new documents
+func GetBlobStore() BlobStore {
+	blobStoresMutex.RLock()
+	defer blobStoresMutex.RUnlock()
+	return blobStore
+}
+
+// FindBlobStore returns the configured store called "name", or nil
+func FindBlobStore(name string) BlobStore {
+	blobStoresMutex.RLock()
+	defer blobStoresMutex.RUnlock()
+	return blobStores[name]
+}
+
+// DBBlobStore keeps documents in the Blobs table itself
+type DBBlobStore struct{}
+
+func (s *DBBlobStore) Name() string { return "db" }
+
+func (s *DBBlobStore) Put(tx *Tx, digest string, r io.Reader, size int64) error {
+	// The driver wants the whole thing anyway, so don't copy it if the
+	// caller already has it in memory
+	var buf []byte
+	if b, ok := r.(*bytes.Buffer); ok {
+		buf = b.Bytes()
+	} else {
+		var err error
+		if buf, err = io.ReadAll(r); err != nil {
+			return err
+		}
+	}
+	Do(tx, `UPDATE Blobs SET Content=? WHERE Digest=?`, buf, digest)
+	return nil
+}
+
+func (s *DBBlobStore) Open(tx *Tx, digest string) (io.ReadCloser, error) {
+	results := Query(tx, `SELECT Content FROM Blobs WHERE Digest=?`, digest)
+	defer results.Close()
+
+	row := results.NextRow()
+	if row == nil {
+		return nil, fmt.Errorf("blob %q not found", digest)
+	}
+	buf, _ := (*(row[0])).([]byte)
+	return io.NopCloser(bytes.NewReader(buf)), nil
+}
+
+func (s *DBBlobStore) Delete(tx *Tx, digest string) error {
+	// Only once the Blob is owned by another store, otherwise the DB
+	// triggers take care of it
+	Do(tx, `UPDATE Blobs SET Content=NULL WHERE Digest=? AND Store<>'db'`,
+		digest)
+	return nil
+}
+
+// FSBlobStore keeps each document in its own file, DIR/ab/cd/abcd...,
+// named after the hex part of its digest
+type FSBlobStore struct {
+	Dir string
+}
+
+func NewFSBlobStore(dir string) (*FSBlobStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("missing directory in \"fs:DIR\"")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FSBlobStore{Dir: dir}, nil
+}
+
+func (s *FSBlobStore) Name() string { return "fs" }
+
+func (s *FSBlobStore) path(digest string) (string, error) {
+	name := strings.TrimPrefix(digest, DIGEST_PREFIX)
+	if len(name) < 4 || name == digest ||
+		strings.ContainsAny(name, "/\\.") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return filepath.Join(s.Dir, name[:2], name[2:4], name), nil
+}
+
+func (s *FSBlobStore) Put(tx *Tx, digest string, r io.Reader, size int64) error {
+	path, err := s.path(digest)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// Write to a temp file and rename it so a reader never sees half of it
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && n != size {
+		err = fmt.Errorf("wrote %d bytes, expected %d", n, size)
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Stage writes "r" into a temp file in DIR. Publish just renames it.
+func (s *FSBlobStore) Stage(tx *Tx, r io.Reader, size int64) (string, string, int64, error) {
+	tmp, err := os.CreateTemp(s.Dir, ".tmp-*")
+	if err != nil {
+		return "", "", 0, err
+	}
+
+	dw := newDigestWriter()
+	_, err = io.Copy(tmp, io.TeeReader(r, dw))
+	if err == nil {
+		err = dw.Check(size)
+	}
+	if cErr := tmp.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", "", 0, err
+	}
+	return filepath.Base(tmp.Name()), dw.Digest(), dw.count, nil
+}
+
+func (s *FSBlobStore) Publish(tx *Tx, tmp string, digest string) error {
+	path, err := s.path(digest)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(s.Dir, tmp), path)
+}
+
+func (s *FSBlobStore) Discard(tx *Tx, tmp string) error {
+	err := os.Remove(filepath.Join(s.Dir, tmp))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FSBlobStore) Open(tx *Tx, digest string) (io.ReadCloser, error) {
+	path, err := s.path(digest)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func (s *FSBlobStore) Delete(tx *Tx, digest string) error {
+	path, err := s.path(digest)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// addPendingBlob records that "digest" is about to be written into "store"
+// by a Tx that hasn't committed yet. It's done outside of that Tx so that
+// it survives a rollback (or crash), in which case the sweeper will delete
+// the object since no Blob says it's in "store". The caller must already
+// hold the lock on the Blob's row so the sweeper can't beat it to it.
+func addPendingBlob(store BlobStore, digest string) error {
+	if store.Name() == "db" {
+		// Rolled back with everything else
+		return nil
+	}
+	_, err := DB.Exec(`
+        INSERT IGNORE INTO PendingBlobs(Digest, Store) VALUES(?,?)`,
+		digest, store.Name())
+	return err
+}
+
+// deleteFromStore removes "digest" from "store", but only if the Blobs
+// table no longer says it's there. The Blob's row (or its gap if the row is
+// gone) stays locked until we're done so a concurrent SaveDocument() of the
+// same document waits for us rather than having its copy deleted. Returns
+// whether it was deleted.
+func deleteFromStore(store BlobStore, digest string) (bool, *XRError) {
+	tx, xErr := NewTx()
+	if xErr != nil {
+		return false, xErr
+	}
+	defer tx.Rollback()
+
+	results := Query(tx, `SELECT Store FROM Blobs WHERE Digest=? FOR UPDATE`,
+		digest)
+	row := results.NextRow()
+	results.Close()
+
+	if row != nil && NotNilString(row[0]) == store.Name() {
+		// Still in use
+		return false, nil
+	}
+
+	if err := store.Delete(tx, digest); err != nil {
+		return false, NewXRError("server_error", "/").
+			SetDetailf("Error deleting blob %q from %q: %s.", digest,
+				store.Name(), err)
+	}
+	return true, tx.Commit()
+}
+
+// SweepDeadBlobs deletes the documents of external stores that are no
+// longer referenced (DeadBlobs), or never were because the Tx that wrote
+// them didn't commit (PendingBlobs). Returns how many were deleted.
+func SweepDeadBlobs() (int, *XRError) {
+	count := 0
+	for _, table := range []string{"DeadBlobs", "PendingBlobs"} {
+		n, xErr := sweepBlobs(table)
+		count += n
+		if xErr != nil {
+			return count, xErr
+		}
+	}
+	return count, nil
+}
+
+// sweepBlobs deletes the documents listed in "table" that no Blob has,
+// and then removes them from "table"
+func sweepBlobs(table string) (int, *XRError) {
+	tx, xErr := NewTx()
+	if xErr != nil {
+		return 0, xErr
+	}
+	type deadBlob struct{ digest, store string }
+	dead := []deadBlob{}
+	results := Query(tx, `SELECT Digest, Store FROM `+table)
+	for row := results.NextRow(); row != nil; row = results.NextRow() {
+		dead = append(dead, deadBlob{NotNilString(row[0]),
+			NotNilString(row[1])})
+	}
+	results.Close()
+	tx.Rollback()
+
+	count := 0
+	for _, d := range dead {
+		store := FindBlobStore(d.store)
+		if store == nil {
+			// Leave it for a server that has that store configured
+			log.VPrintf(2, "Blob sweeper: store %q isn't configured, "+
+				"skipping %s", d.store, d.digest)
+			continue
+		}
+
+		deleted, xErr := deleteFromStore(store, d.digest)
+		if xErr != nil {
+			return count, xErr
+		}
+
+		tx, xErr := NewTx()
+		if xErr != nil {
+			return count, xErr
+		}
+		Do(tx, `DELETE FROM `+table+` WHERE Digest=? AND Store=?`,
+			d.digest, d.store)
+		if xErr = tx.Commit(); xErr != nil {
+			return count, xErr
+		}
+		if deleted {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// StartBlobSweeper runs SweepDeadBlobs every "interval"
+func StartBlobSweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	log.VPrintf(1, "Blob sweeper: every %s", interval)
+
+	go func() {
+		for {
+			time.Sleep(interval)
+			sweepBlobsAndLog()
+		}
+	}()
+}
+
+// sweepBlobsAndLog runs one pass of the blob sweeper. A panic is logged
+// rather than taking down the sweeper, or the server.
+func sweepBlobsAndLog() {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("Blob sweeper: panic: %v", rec)
+		}
+	}()
+
+	count, xErr := SweepDeadBlobs()
+	if xErr != nil {
+		log.Printf("Blob sweeper: %s", xErr)
+	}
+	if count > 0 {
+		log.VPrintf(1, "Blob sweeper: deleted %d blob(s)", count)
+	}
+}
+
+// BlobStats is how many documents, and bytes, a store has
+type BlobStats struct {
+	Store string `json:"store"`
+	Count int    `json:"count"`
+	Size  int64  `json:"size"`
+}
+
+func GetBlobStats() ([]BlobStats, *XRError) {
+	tx, xErr := NewTx()
+	if xErr != nil {
+		return nil, xErr
+	}
+	defer tx.Rollback()
+
+	stats := []BlobStats{}
+	results := Query(tx, `
+        SELECT Store, COUNT(*), SUM(Size) FROM Blobs GROUP BY Store`)
+	defer results.Close()
+	for row := results.NextRow(); row != nil; row = results.NextRow() {
+		stats = append(stats, BlobStats{
+			Store: NotNilString(row[0]),
+			Count: NotNilInt(row[1]),
+			Size:  int64(NotNilIntDef(row[2], 0)),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Store < stats[j].Store
+	})
+	return stats, nil
+}
+
+// MigrateBlobs moves every document not already in "to" into it, one
+// transaction per document so a large migration can be stopped and rerun.
+// The stores the documents are coming from must be configured. "progress",
+// if not nil, is called after each one.
+func MigrateBlobs(to BlobStore, progress func(digest string, from string, size int64)) (int, *XRError) {
+	AddBlobStore(to)
+
+	tx, xErr := NewTx()
+	if xErr != nil {
+		return 0, xErr
+	}
+	digests := []string{}
+	results := Query(tx, `SELECT Digest FROM Blobs WHERE Store<>? ORDER BY Digest`,
+		to.Name())
+	for row := results.NextRow(); row != nil; row = results.NextRow() {
+		digests = append(digests, NotNilString(row[0]))
+	}
+	results.Close()
+	tx.Rollback()
+
+	count := 0
+	for _, digest := range digests {
+		from, size, xErr := migrateBlob(to, digest)
+		if xErr != nil {
+			return count, xErr
+		}
+		if from == nil {
+			// Gone, or already moved, since we looked
+			continue
+		}
+
+		if _, xErr := deleteFromStore(from, digest); xErr != nil {
+			return count, xErr
+		}
+
+		count++
+		if progress != nil {
+			progress(digest, from.Name(), size)
+		}
+	}
+
+	return count, nil
+}
+
+// migrateBlob copies one document into "to" and points its Blob at it.
+// Returns the store it came from, or nil if there was nothing to do.
+func migrateBlob(to BlobStore, digest string) (BlobStore, int64, *XRError) {
+	tx, xErr := NewTx()
+	if xErr != nil {
+		return nil, 0, xErr
+	}
+	defer tx.Rollback()
+
+	results := Query(tx, `
+        SELECT Store, Size FROM Blobs WHERE Digest=? FOR UPDATE`, digest)
+	row := results.NextRow()
+	results.Close()
+	if row == nil || NotNilString(row[0]) == to.Name() {
+		return nil, 0, nil
+	}
+
+	name := NotNilString(row[0])
+	size := int64(NotNilInt(row[1]))
+	from := FindBlobStore(name)
+	if from == nil {
+		return nil, 0, NewXRError("server_error", "/").
+			SetDetailf("Blob %q is in store %q, which isn't configured.",
+				digest, name)
+	}
+
+	r, err := from.Open(tx, digest)
+	if err == nil {
+		if err = addPendingBlob(to, digest); err == nil {
+			err = to.Put(tx, digest, r, size)
+		}
+		r.Close()
+	}
+	if err != nil {
+		return nil, 0, NewXRError("server_error", "/").
+			SetDetailf("Error copying blob %q from %q to %q: %s.", digest,
+				name, to.Name(), err)
+	}
+
+	DoOne(tx, `UPDATE Blobs SET Store=? WHERE Digest=?`, to.Name(), digest)
+
+	if xErr = tx.Commit(); xErr != nil {
+		return nil, 0, xErr
+	}
+	return from, size, nil
+}
@@ -0,0 +1,333 @@
+package registry
+
+// S3BlobStore keeps documents in an S3-compatible object store (AWS S3,
+// MinIO, Ceph, ...) using nothing more than plain HTTP and AWS Signature
+// Version 4, so we don't need to pull in an SDK.
+//
+//	s3://BUCKET[/PREFIX][?endpoint=URL][&region=REGION]
+//
+// Without an "endpoint" AWS itself is used (virtual-host style URLs),
+// otherwise requests go to ENDPOINT/BUCKET/... (path style), which is what
+// most local stand-ins expect. Credentials come from the usual
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN env vars,
+// and the region defaults to AWS_REGION, or "us-east-1".
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	. "github.com/xregistry/server/common"
+)
+
+const S3_EMPTY_SHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type S3BlobStore struct {
+	Endpoint     string // scheme://host[:port], no trailing "/"
+	Bucket       string
+	Prefix       string // "" or ends with "/"
+	Region       string
+	PathStyle    bool
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	Client *http.Client
+}
+
+func NewS3BlobStore(spec string) (*S3BlobStore, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, fmt.Errorf("blob store %q must be of the form "+
+			"s3://BUCKET[/PREFIX]", spec)
+	}
+
+	s := &S3BlobStore{
+		Bucket:       u.Host,
+		Prefix:       strings.Trim(u.Path, "/"),
+		Region:       u.Query().Get("region"),
+		Endpoint:     strings.TrimRight(u.Query().Get("endpoint"), "/"),
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Client:       &http.Client{},
+	}
+	if s.Prefix != "" {
+		s.Prefix += "/"
+	}
+	if s.Region == "" {
+		s.Region = EnvString("AWS_REGION", "us-east-1")
+	}
+	if s.Endpoint == "" {
+		s.Endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	} else {
+		s.PathStyle = true
+		if _, err := url.Parse(s.Endpoint); err != nil {
+			return nil, fmt.Errorf("invalid endpoint %q: %s", s.Endpoint, err)
+		}
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, fmt.Errorf("blob store %q needs AWS_ACCESS_KEY_ID and "+
+			"AWS_SECRET_ACCESS_KEY to be set", spec)
+	}
+
+	return s, nil
+}
+
+func (s *S3BlobStore) Name() string { return "s3" }
+
+// objectKey is the name (under Prefix) of the object of "digest"
+func (s *S3BlobStore) objectKey(digest string) (string, error) {
+	name := strings.TrimPrefix(digest, DIGEST_PREFIX)
+	if name == digest || name == "" || strings.ContainsAny(name, "/\\.") {
+		return "", fmt.Errorf("invalid digest %q", digest)
+	}
+	return name, nil
+}
+
+func (s *S3BlobStore) objectURL(digest string) (string, error) {
+	key, err := s.objectKey(digest)
+	if err != nil {
+		return "", err
+	}
+	return s.keyURL(key), nil
+}
+
+func (s *S3BlobStore) keyURL(key string) string {
+	if s.PathStyle {
+		return s.Endpoint + "/" + s.Bucket + "/" + s.Prefix + key
+	}
+	scheme, host, _ := strings.Cut(s.Endpoint, "://")
+	return scheme + "://" + s.Bucket + "." + host + "/" + s.Prefix + key
+}
+
+func (s *S3BlobStore) do(method string, digest string, body io.Reader, size int64) (*http.Response, error) {
+	key, err := s.objectKey(digest)
+	if err != nil {
+		return nil, err
+	}
+	return s.doKey(method, key, body, size, nil)
+}
+
+func (s *S3BlobStore) doKey(method string, key string, body io.Reader, size int64, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.keyURL(key), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+
+	payload := S3_EMPTY_SHA256
+	if body != nil {
+		req.ContentLength = size
+		payload = "UNSIGNED-PAYLOAD"
+	}
+	s.Sign(req, payload, time.Now())
+
+	return s.Client.Do(req)
+}
+
+func (s *S3BlobStore) Put(tx *Tx, digest string, r io.Reader, size int64) error {
+	resp, err := s.do("PUT", digest, r, size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// Stage uploads "r" as "tmp/UUID", S3 needs to know its size up front.
+// Publish then copies it to its real name, S3 has no rename.
+func (s *S3BlobStore) Stage(tx *Tx, r io.Reader, size int64) (string, string, int64, error) {
+	if size < 0 {
+		return "", "", 0, fmt.Errorf("the size of the document must be known")
+	}
+
+	tmp := "tmp/" + NewUUID()
+	dw := newDigestWriter()
+	resp, err := s.doKey("PUT", tmp, io.TeeReader(r, dw), size, nil)
+	if err != nil {
+		return "", "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return "", "", 0, s3Error(resp)
+	}
+	if err = dw.Check(size); err != nil {
+		s.Discard(tx, tmp)
+		return "", "", 0, err
+	}
+	return tmp, dw.Digest(), dw.count, nil
+}
+
+func (s *S3BlobStore) Publish(tx *Tx, tmp string, digest string) error {
+	key, err := s.objectKey(digest)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.doKey("PUT", key, nil, 0, map[string]string{
+		"x-amz-copy-source": "/" + s.Bucket + "/" +
+			(&url.URL{Path: s.Prefix + tmp}).EscapedPath(),
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error(resp)
+	}
+
+	// A copy can fail after the 200 has been sent, in which case the
+	// error is in the body
+	buf, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if strings.Contains(string(buf), "<Error>") {
+		return fmt.Errorf("PUT %s: %s", resp.Request.URL,
+			strings.TrimSpace(string(buf)))
+	}
+
+	// A leftover temp object is just wasted space, not worth failing over
+	s.Discard(tx, tmp)
+	return nil
+}
+
+func (s *S3BlobStore) Discard(tx *Tx, tmp string) error {
+	resp, err := s.doKey("DELETE", tmp, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3BlobStore) Open(tx *Tx, digest string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", digest, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3BlobStore) Delete(tx *Tx, digest string) error {
+	resp, err := s.do("DELETE", digest, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func s3Error(resp *http.Response) error {
+	buf, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL,
+		resp.Status, strings.TrimSpace(string(buf)))
+}
+
+// Sign adds the AWS Signature Version 4 "Authorization" header (and the
+// "x-amz-*" ones it needs) to "req". All of the headers already in "req",
+// plus "Host", are signed.
+func (s *S3BlobStore) Sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, vals := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(
+			strings.Join(vals, ","))
+	}
+	names := []string{}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonHeaders := ""
+	for _, name := range names {
+		canonHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonRequest))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(sum[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	sig := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+
+		s.AccessKey+"/"+scope+", SignedHeaders="+signedHeaders+
+		", Signature="+sig)
+}
+
+func canonicalQuery(q url.Values) string {
+	keys := []string{}
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		vals := append([]string{}, q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3Escape(k)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// s3Escape is url.QueryEscape except that " " is "%20", not "+"
+func s3Escape(str string) string {
+	return strings.ReplaceAll(url.QueryEscape(str), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
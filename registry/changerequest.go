@@ -0,0 +1,700 @@
+// Package registry - change requests for Groups with an "approval" policy.
+//
+// When a Group model has an "approval" policy (see ApprovalPolicy in
+// shared_model) writes of Versions in the Groups it covers aren't applied
+// right away. Instead they're saved as a change request (the proposed
+// Version plus a diff against the current one) and a "202 Accepted" is
+// returned:
+//
+//	GET    /GROUPS/gID/changerequests
+//	GET    /GROUPS/gID/changerequests/crID
+//	POST   /GROUPS/gID/changerequests/crID/approve
+//	POST   /GROUPS/gID/changerequests/crID/reject    body: {"reason":"..."}
+//	DELETE /GROUPS/gID/changerequests/crID
+//
+// Once a change request has enough approvals it's applied through the
+// normal UpsertVersionWithObject() path, so all of the usual validation
+// happens at that point. If it fails, the approval isn't recorded either.
+// Users are identified by their "Authorization: Bearer" token (see
+// UserTokens). The "xRegistry~User" header is only used when
+// TrustUserHeader is set (see RequestUser).
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/xregistry/server/common"
+)
+
+const (
+	CR_PENDING  = "pending"
+	CR_APPLIED  = "applied"
+	CR_REJECTED = "rejected"
+)
+
+// UserTokens maps "Authorization: Bearer TOKEN" tokens to user names.
+// See LoadUserTokens.
+var UserTokens = map[string]string{}
+
+// TrustUserHeader says whether the "xRegistry~User" header is trusted
+// as-is when there are no UserTokens. Anyone can set it, so this is only
+// safe behind a proxy that sets (or strips) it. W/o either one nobody can
+// approve a change request.
+var TrustUserHeader = false
+
+// LoadUserTokens reads "USER TOKEN" lines ("#" starts a comment) into
+// UserTokens. Once loaded, the "xRegistry~User" header is ignored.
+func LoadUserTokens(file string) error {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	tokens := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected \"USER TOKEN\"", file, line)
+		}
+		if _, ok := tokens[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate token", file, line)
+		}
+		tokens[fields[1]] = fields[0]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	UserTokens = tokens
+	return nil
+}
+
+// RequestUser returns the name of the user making the request, or "" if
+// we don't know who it is
+func RequestUser(r *http.Request) string {
+	if len(UserTokens) == 0 {
+		if TrustUserHeader {
+			return r.Header.Get("xRegistry~User")
+		}
+		return ""
+	}
+
+	auth := r.Header.Get("Authorization")
+	scheme, token, _ := strings.Cut(strings.TrimSpace(auth), " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return UserTokens[strings.TrimSpace(token)]
+}
+
+// AttrDiff is one top-level attribute that the change request modifies.
+// A missing "old" means it's being added, a missing "new" means removed.
+type AttrDiff struct {
+	Name string `json:"name"`
+	Old  any    `json:"old,omitempty"`
+	New  any    `json:"new,omitempty"`
+}
+
+type ChangeRequest struct {
+	ID         string      `json:"changerequestid"`
+	Self       string      `json:"self,omitempty"` // only on output
+	Status     string      `json:"status"`
+	Method     string      `json:"method"`
+	Target     string      `json:"target"` // Version's (or new Resource's) XID
+	Requester  string      `json:"requester,omitempty"`
+	CreatedAt  string      `json:"createdat"`
+	ModifiedAt string      `json:"modifiedat"`
+	Approvals  []string    `json:"approvals,omitempty"`
+	RejectedBy string      `json:"rejectedby,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+	AppliedXID string      `json:"appliedxid,omitempty"`
+	Diff       []*AttrDiff `json:"diff"`
+
+	// What's needed to apply it
+	Proposed         map[string]any `json:"proposed"`
+	Patch            bool           `json:"patch,omitempty"`
+	DefaultVersionID string         `json:"setdefaultversionid,omitempty"`
+}
+
+// TargetParts splits the "target" XID into its Resource type, Resource ID
+// and Version ID. The Version ID is "" when the server will pick it.
+func (cr *ChangeRequest) TargetParts() (string, string, string) {
+	// /GROUPS/gID/RESOURCES/rID[/versions/vID]
+	parts := strings.Split(strings.Trim(cr.Target, "/"), "/")
+	for len(parts) < 6 {
+		parts = append(parts, "")
+	}
+	return parts[2], parts[3], parts[5]
+}
+
+// DiffAttributes compares the current attributes of a Version with the
+// proposed ones. For a PATCH only the attributes in "newObj" matter,
+// otherwise any extension missing from "newObj" will be removed too.
+// Documents ([]byte) are only compared when a new one is provided.
+func DiffAttributes(oldObj map[string]any, newObj map[string]any, patch bool) []*AttrDiff {
+	diffs := []*AttrDiff{}
+
+	for _, name := range SortedKeys(newObj) {
+		oldVal, newVal := diffValue(oldObj[name]), diffValue(newObj[name])
+		if !reflect.DeepEqual(oldVal, newVal) {
+			diffs = append(diffs, &AttrDiff{Name: name, Old: oldVal, New: newVal})
+		}
+	}
+
+	if !patch {
+		for _, name := range SortedKeys(oldObj) {
+			_, ok := newObj[name]
+			_, isDoc := oldObj[name].([]byte)
+			if ok || isDoc || strings.HasPrefix(name, "#") ||
+				SpecProps[name] != nil || IsNil(oldObj[name]) {
+				continue
+			}
+			diffs = append(diffs, &AttrDiff{Name: name,
+				Old: diffValue(oldObj[name])})
+		}
+	}
+
+	slices.SortStableFunc(diffs, func(a, b *AttrDiff) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return diffs
+}
+
+// diffValue normalizes a value so that things like map[string]string and
+// map[string]any compare the same, and documents show up as text
+func diffValue(val any) any {
+	if IsNil(val) {
+		return nil
+	}
+	if buf, ok := val.([]byte); ok {
+		if utf8.Valid(buf) {
+			return string(buf)
+		}
+		return fmt.Sprintf("(%d bytes)", len(buf))
+	}
+	var res any
+	buf, err := json.Marshal(val)
+	if err != nil || json.Unmarshal(buf, &res) != nil {
+		return val
+	}
+	return res
+}
+
+// NeedsApproval returns true if writes to the Resource type in "info"
+// need to go through a change request. "group" can be nil when it doesn't
+// exist yet.
+func NeedsApproval(info *RequestInfo, group *Group) bool {
+	ap := info.GroupModel.Approval
+	if ap == nil || info.ResourceType == "" {
+		return false
+	}
+
+	labels := map[string]any(nil)
+	if group != nil {
+		labels, _ = group.Get("labels").(map[string]any)
+	}
+	return ap.AppliesTo(info.ResourceType, labels)
+}
+
+// CheckNestedApproval rejects writes to the Registry, a Group collection
+// or a Group that include Versions for Resource types that need approval.
+// We don't look at the Group's labels here, anything that might need
+// approval has to be done one Version at a time. It also rejects changes
+// to the labels of a Group that the policy's "labels" currently select,
+// otherwise removing them would turn off the approvals for that Group.
+func CheckNestedApproval(info *RequestInfo, obj Object) *XRError {
+	method := ""
+	if info.OriginalRequest != nil {
+		method = info.OriginalRequest.Method
+	}
+
+	checkGroup := func(gm *GroupModel, gID string, gObj any) *XRError {
+		path := "/" + gm.Plural + "/" + gID
+		gMap, _ := gObj.(map[string]any)
+		for _, rType := range SortedKeys(gm.Resources) {
+			if IsNil(gMap[rType]) || !gm.Approval.CoversResource(rType) {
+				continue
+			}
+			return NewXRError("approval_required", path+"/"+rType)
+		}
+
+		// A PATCH w/o "labels" leaves them alone, and the body of a
+		// "POST /GROUPS/gID" is its Resources, not the Group
+		newLabels, ok := gMap["labels"]
+		if len(gm.Approval.Labels) == 0 || (method == "PATCH" && !ok) ||
+			(method == "POST" && len(info.Parts) == 2) {
+			return nil
+		}
+
+		group, xErr := info.Registry.FindGroup(gm.Plural, gID, false,
+			FOR_READ)
+		if xErr != nil || group == nil {
+			return xErr
+		}
+		oldLabels, _ := group.Get("labels").(map[string]any)
+		if !gm.Approval.MatchesLabels(oldLabels) ||
+			reflect.DeepEqual(diffValue(oldLabels), diffValue(newLabels)) {
+			return nil
+		}
+		return NewXRError("approval_required", path+"/labels").
+			SetDetail("The Group's labels select it for approval, so " +
+				"they can't be changed.")
+	}
+
+	checkGroups := func(gm *GroupModel, gObjs any) *XRError {
+		gMap, _ := gObjs.(map[string]any)
+		for _, gID := range SortedKeys(gMap) {
+			if xErr := checkGroup(gm, gID, gMap[gID]); xErr != nil {
+				return xErr
+			}
+		}
+		return nil
+	}
+
+	switch len(info.Parts) {
+	case 0:
+		for _, gType := range SortedKeys(info.Registry.Model.Groups) {
+			gm := info.Registry.Model.Groups[gType]
+			if gm.Approval == nil || IsNil(obj[gType]) {
+				continue
+			}
+			if xErr := checkGroups(gm, obj[gType]); xErr != nil {
+				return xErr
+			}
+		}
+	case 1:
+		if info.GroupModel.Approval != nil {
+			return checkGroups(info.GroupModel, (map[string]any)(obj))
+		}
+	case 2:
+		if info.GroupModel.Approval != nil {
+			return checkGroup(info.GroupModel, info.GroupUID,
+				(map[string]any)(obj))
+		}
+	}
+	return nil
+}
+
+func (g *Group) crPath(id string) string {
+	return "/" + g.Path + "/" + CHANGEREQUESTS + "/" + id
+}
+
+// NextChangeRequestID returns the next free change request ID. The caller
+// must have the Group locked.
+func (g *Group) NextChangeRequestID() string {
+	results := Query(g.tx, `
+        SELECT MAX(CAST(UID AS SIGNED)) FROM ChangeRequests
+        WHERE GroupSID=?`, g.DbSID)
+	defer results.Close()
+
+	next := 1
+	if row := results.NextRow(); row != nil && !IsNil(*row[0]) {
+		next = NotNilInt(row[0]) + 1
+	}
+	return strconv.Itoa(next)
+}
+
+func (g *Group) SaveChangeRequest(cr *ChangeRequest) *XRError {
+	self := cr.Self
+	cr.Self = ""
+	buf, err := json.Marshal(cr)
+	cr.Self = self
+	if err != nil {
+		return NewXRError("server_error", g.crPath(cr.ID)).
+			SetDetailf("Error saving change request: %s.", err.Error())
+	}
+
+	Do(g.tx, `
+        INSERT INTO ChangeRequests(SID,UID,RegistrySID,GroupSID,Object)
+        VALUES(?,?,?,?,?)
+        ON DUPLICATE KEY UPDATE Object=VALUES(Object)`,
+		NewUUID(), cr.ID, g.Registry.DbSID, g.DbSID, string(buf))
+	return nil
+}
+
+// GetChangeRequests returns the Group's change requests, or just the one
+// with an ID of "id" if it's not empty
+func (g *Group) GetChangeRequests(id string) ([]*ChangeRequest, *XRError) {
+	query := `SELECT Object FROM ChangeRequests WHERE GroupSID=?`
+	args := []any{g.DbSID}
+	if id != "" {
+		query += ` AND UID=?`
+		args = append(args, id)
+	}
+
+	results := Query(g.tx, query, args...)
+	defer results.Close()
+
+	list := []*ChangeRequest{}
+	for row := results.NextRow(); row != nil; row = results.NextRow() {
+		cr := &ChangeRequest{}
+		if err := json.Unmarshal([]byte(NotNilString(row[0])), cr); err != nil {
+			return nil, NewXRError("server_error", "/"+g.Path).
+				SetDetailf("Error loading change request: %s.", err.Error())
+		}
+		list = append(list, cr)
+	}
+
+	slices.SortFunc(list, func(a, b *ChangeRequest) int {
+		aID, _ := strconv.Atoi(a.ID)
+		bID, _ := strconv.Atoi(b.ID)
+		return aID - bID
+	})
+	return list, nil
+}
+
+func (g *Group) FindChangeRequest(id string) (*ChangeRequest, *XRError) {
+	list, xErr := g.GetChangeRequests(id)
+	if xErr != nil || len(list) == 0 {
+		return nil, xErr
+	}
+	return list[0], nil
+}
+
+// Apply does the write that the change request describes and returns the
+// Version that was created/updated
+func (cr *ChangeRequest) Apply(g *Group) (*Version, *XRError) {
+	rType, rID, vID := cr.TargetParts()
+	obj := Object(maps.Clone(cr.Proposed))
+
+	r, xErr := g.FindResource(rType, rID, false, FOR_WRITE)
+	if xErr != nil {
+		return nil, xErr
+	}
+
+	if r == nil {
+		r, xErr = g.AddResourceWithObject(rType, rID, vID, obj, true)
+		if xErr != nil {
+			return nil, xErr
+		}
+		if vID == "" {
+			return r.GetDefault()
+		}
+		return r.FindVersion(vID, false)
+	}
+
+	addType := ADD_UPSERT
+	if vID != "" && cr.Patch {
+		v, xErr := r.FindVersion(vID, false)
+		if xErr != nil {
+			return nil, xErr
+		}
+		if v != nil {
+			addType = ADD_PATCH
+		}
+	}
+
+	v, _, xErr := r.UpsertVersionWithObject(&VersionUpsert{
+		Id:               vID,
+		Obj:              obj,
+		AddType:          addType,
+		More:             false,
+		DefaultVersionID: cr.DefaultVersionID,
+	})
+	return v, xErr
+}
+
+// HTTPCreateChangeRequest saves the PUT/PATCH/POST in "info" as a change
+// request instead of doing it
+func HTTPCreateChangeRequest(info *RequestInfo, group *Group, obj Object, metaInBody bool) *XRError {
+	method := info.OriginalRequest.Method
+	numParts := len(info.Parts)
+
+	// Only writes of a single Version (or a Resource's default Version)
+	if !(numParts == 4 || (numParts == 6 && method != "POST")) {
+		return NewXRError("approval_required", "/"+info.OriginalPath)
+	}
+	if _, ok := obj["meta"]; ok {
+		return NewXRError("approval_required", "/"+info.OriginalPath)
+	}
+	if _, ok := obj["versions"]; ok {
+		return NewXRError("approval_required", "/"+info.OriginalPath)
+	}
+
+	// Creating the Group isn't something that needs approval
+	var xErr *XRError
+	if group == nil {
+		group, _, xErr = info.Registry.UpsertGroup(info.GroupType,
+			info.GroupUID)
+		if xErr != nil {
+			return xErr
+		}
+	}
+	group, xErr = info.Registry.FindGroup(info.GroupType, info.GroupUID,
+		false, FOR_WRITE)
+	if xErr != nil {
+		return xErr
+	}
+
+	resource, xErr := group.FindResource(info.ResourceType, info.ResourceUID,
+		false, FOR_READ)
+	if xErr != nil {
+		return xErr
+	}
+
+	// Figure out which Version this is for
+	vID := info.VersionUID
+	if numParts == 4 {
+		if method == "POST" {
+			vID, _ = obj["versionid"].(string)
+		} else if resource != nil {
+			vID = resource.MustFindMeta(false).GetAsString("defaultversionid")
+		}
+	}
+
+	target := "/" + group.Path + "/" + info.ResourceType + "/" +
+		info.ResourceUID
+	current := map[string]any{}
+	if vID != "" {
+		target += "/versions/" + vID
+		if resource != nil {
+			v, xErr := resource.FindVersion(vID, false)
+			if xErr != nil {
+				return xErr
+			}
+			if v != nil {
+				current = v.Object
+				if info.ResourceModel.GetHasDocument() {
+					current = maps.Clone(current)
+					current[info.ResourceModel.Singular] =
+						v.Get(info.ResourceModel.Singular)
+				}
+			}
+		}
+	}
+
+	patch := method == "PATCH" || (method == "PUT" && !metaInBody)
+
+	cr := &ChangeRequest{
+		ID:               group.NextChangeRequestID(),
+		Status:           CR_PENDING,
+		Method:           method,
+		Target:           target,
+		Requester:        info.tx.User,
+		CreatedAt:        info.tx.CreateTime,
+		ModifiedAt:       info.tx.CreateTime,
+		Diff:             DiffAttributes(current, obj, patch),
+		Proposed:         map[string]any(obj),
+		Patch:            patch,
+		DefaultVersionID: info.GetFlag("setdefaultversionid"),
+	}
+
+	// Documents need to survive the trip through JSON
+	singular := info.ResourceModel.Singular
+	if buf, ok := cr.Proposed[singular].([]byte); ok {
+		cr.Proposed = maps.Clone(cr.Proposed)
+		delete(cr.Proposed, singular)
+		cr.Proposed[singular+"base64"] = base64.StdEncoding.EncodeToString(buf)
+	}
+
+	if xErr := group.SaveChangeRequest(cr); xErr != nil {
+		return xErr
+	}
+
+	cr.Self = info.BaseURL + group.crPath(cr.ID)
+	info.SetHeader("Location", cr.Self)
+	info.StatusCode = http.StatusAccepted
+	return writeChangeRequests(info, cr)
+}
+
+// HTTPChangeRequests handles everything under /GROUPS/gID/changerequests
+func HTTPChangeRequests(info *RequestInfo) *XRError {
+	method := info.OriginalRequest.Method
+	parts := info.Parts[3:] // [ crID [ approve|reject ] ]
+
+	if len(parts) > 2 ||
+		(len(parts) == 2 && parts[1] != "approve" && parts[1] != "reject") {
+		return NewXRError("api_not_found", "/"+info.OriginalPath)
+	}
+
+	if !((method == "GET" && len(parts) < 2) ||
+		(method == "POST" && len(parts) == 2) ||
+		(method == "DELETE" && len(parts) == 1)) {
+		return NewXRError("action_not_supported", "/"+info.OriginalPath,
+			"action="+method)
+	}
+
+	accessMode := FOR_WRITE
+	if method == "GET" {
+		accessMode = FOR_READ
+	}
+	group, xErr := info.Registry.FindGroup(info.GroupType, info.GroupUID,
+		false, accessMode)
+	if xErr != nil {
+		return xErr
+	}
+	if group == nil {
+		return NewXRError("not_found", info.GetParts(2))
+	}
+
+	if len(parts) == 0 {
+		list, xErr := group.GetChangeRequests("")
+		if xErr != nil {
+			return xErr
+		}
+		for _, cr := range list {
+			cr.Self = info.BaseURL + group.crPath(cr.ID)
+		}
+		return writeChangeRequests(info, list...)
+	}
+
+	cr, xErr := group.FindChangeRequest(parts[0])
+	if xErr != nil {
+		return xErr
+	}
+	if cr == nil {
+		return NewXRError("not_found", info.GetParts(4))
+	}
+	cr.Self = info.BaseURL + group.crPath(cr.ID)
+
+	if method == "GET" {
+		return writeChangeRequests(info, cr)
+	}
+
+	action := "delete"
+	if method == "POST" {
+		action = parts[1] // approve|reject
+	}
+	crXID := group.crPath(cr.ID)
+	user := info.tx.User
+	ap := info.GroupModel.Approval
+
+	if user == "" {
+		detail := "the \"Authorization\" header is missing or its " +
+			"token is unknown"
+		if len(UserTokens) == 0 {
+			detail = "no user tokens are configured"
+			if TrustUserHeader {
+				detail = "the \"xRegistry~User\" header is missing"
+			}
+		}
+		return NewXRError("changerequest_forbidden", crXID, "user="+user,
+			"action="+action,
+			"error_detail="+detail)
+	}
+
+	if method == "DELETE" {
+		// Applied ones are kept as the record of who approved what. Pending
+		// ones can be withdrawn by whoever asked for them, anything else
+		// is up to the approvers.
+		if cr.Status == CR_APPLIED {
+			return NewXRError("changerequest_closed", crXID,
+				"status="+cr.Status)
+		}
+		if !ap.IsApprover(user) &&
+			!(cr.Status == CR_PENDING && user == cr.Requester) {
+			return NewXRError("changerequest_forbidden", crXID,
+				"user="+user,
+				"action="+action,
+				"error_detail=only the requester (while it's pending) or "+
+					"an approver can delete it")
+		}
+
+		Do(info.tx, `DELETE FROM ChangeRequests WHERE GroupSID=? AND UID=?`,
+			group.DbSID, cr.ID)
+		info.StatusCode = http.StatusNoContent
+		return nil
+	}
+
+	if cr.Status != CR_PENDING {
+		return NewXRError("changerequest_closed", crXID, "status="+cr.Status)
+	}
+
+	if !ap.IsApprover(user) {
+		return NewXRError("changerequest_forbidden", crXID, "user="+user,
+			"action="+action,
+			"error_detail=not one of the configured approvers")
+	}
+	if user == cr.Requester {
+		return NewXRError("changerequest_forbidden", crXID, "user="+user,
+			"action="+action,
+			"error_detail=it was requested by the same user")
+	}
+
+	cr.ModifiedAt = info.tx.CreateTime
+
+	if action == "reject" {
+		body := struct {
+			Reason string `json:"reason"`
+		}{}
+		if len(info.Body) > 0 {
+			if err := Unmarshal(info.Body, &body); err != nil {
+				return NewXRError("parsing_data", "/"+info.OriginalPath,
+					"error_detail="+err.Error())
+			}
+		}
+		cr.Status = CR_REJECTED
+		cr.RejectedBy = user
+		cr.Reason = body.Reason
+	} else {
+		if slices.Contains(cr.Approvals, user) {
+			return NewXRError("changerequest_forbidden", crXID,
+				"user="+user,
+				"action="+action,
+				"error_detail=it was already approved by this user")
+		}
+		cr.Approvals = append(cr.Approvals, user)
+
+		if len(cr.Approvals) >= ap.GetApprovals() {
+			v, xErr := cr.Apply(group)
+			if xErr != nil {
+				return xErr
+			}
+			if xErr = info.tx.Validate(info); xErr != nil {
+				return xErr
+			}
+			cr.Status = CR_APPLIED
+			if v != nil {
+				cr.AppliedXID = v.XID
+			}
+		}
+	}
+
+	if xErr := group.SaveChangeRequest(cr); xErr != nil {
+		return xErr
+	}
+	return writeChangeRequests(info, cr)
+}
+
+// writeChangeRequests sends back either a single change request, or the
+// map of them (by ID) when we're at the collection level
+func writeChangeRequests(info *RequestInfo, list ...*ChangeRequest) *XRError {
+	var data any
+	if len(info.Parts) == 3 {
+		crs := map[string]*ChangeRequest{}
+		for _, cr := range list {
+			crs[cr.ID] = cr
+		}
+		data = crs
+	} else {
+		data = list[0]
+	}
+
+	buf, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return NewXRError("server_error", "/"+info.OriginalPath).
+			SetDetailf("Error serializing change request: %s.", err.Error())
+	}
+
+	info.SetHeader("Content-Type", "application/json")
+	info.Write(buf)
+	info.Write([]byte("\n"))
+	return nil
+}
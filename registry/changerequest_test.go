@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestDiffAttributes(t *testing.T) {
+	old := map[string]any{
+		"#resource":   "x",
+		"epoch":       1,
+		"description": "old",
+		"labels":      map[string]any{"a": "b"},
+		"ext1":        "gone",
+		"ext2":        nil,
+		"file":        []byte("doc1"),
+	}
+
+	tests := []struct {
+		name  string
+		new   map[string]any
+		patch bool
+		exp   string
+	}{
+		{"no change", map[string]any{"description": "old"}, true, `[]`},
+		{"patch", map[string]any{"description": "new", "ext3": 3}, true,
+			`[{"name":"description","old":"old","new":"new"},` +
+				`{"name":"ext3","new":3}]`},
+		{"put", map[string]any{"description": "old",
+			"labels": map[string]string{"a": "b"}}, false,
+			`[{"name":"ext1","old":"gone"}]`},
+		{"remove", map[string]any{"description": nil}, true,
+			`[{"name":"description","old":"old"}]`},
+		{"doc", map[string]any{"file": []byte("doc2"),
+			"ext1": []byte{0xff}}, true,
+			`[{"name":"ext1","old":"gone","new":"(1 bytes)"},` +
+				`{"name":"file","old":"doc1","new":"doc2"}]`},
+	}
+
+	for _, test := range tests {
+		buf, _ := json.Marshal(DiffAttributes(old, test.new, test.patch))
+		if res := string(buf); res != test.exp {
+			t.Fatalf("%s:\nexp: %s\ngot: %s", test.name, test.exp, res)
+		}
+	}
+}
+
+func TestChangeRequestTargetParts(t *testing.T) {
+	tests := []struct {
+		target string
+		exp    [3]string
+	}{
+		{"/dirs/d1/files/f1/versions/v1", [3]string{"files", "f1", "v1"}},
+		{"/dirs/d1/files/f1", [3]string{"files", "f1", ""}},
+	}
+
+	for _, test := range tests {
+		cr := &ChangeRequest{Target: test.target}
+		rType, rID, vID := cr.TargetParts()
+		if res := [3]string{rType, rID, vID}; res != test.exp {
+			t.Fatalf("%s: expected %v, got %v", test.target, test.exp, res)
+		}
+	}
+}
+
+func TestApprovalPolicyAppliesTo(t *testing.T) {
+	ap := &ApprovalPolicy{
+		Resources: []string{"files"},
+		Labels:    []string{"protected", "stage=prod"},
+	}
+
+	tests := []struct {
+		rType  string
+		labels map[string]any
+		exp    bool
+	}{
+		{"files", map[string]any{"protected": ""}, true},
+		{"files", map[string]any{"stage": "prod"}, true},
+		{"files", map[string]any{"stage": "dev"}, false},
+		{"files", nil, false},
+		{"others", map[string]any{"protected": ""}, false},
+	}
+
+	for _, test := range tests {
+		if res := ap.AppliesTo(test.rType, test.labels); res != test.exp {
+			t.Fatalf("%s/%v: expected %v, got %v", test.rType, test.labels,
+				test.exp, res)
+		}
+	}
+
+	if (*ApprovalPolicy)(nil).AppliesTo("files", nil) {
+		t.Fatalf("nil policy shouldn't apply")
+	}
+	if !(&ApprovalPolicy{}).AppliesTo("any", nil) {
+		t.Fatalf("empty policy should apply to everything")
+	}
+	if n := (&ApprovalPolicy{}).GetApprovals(); n != 1 {
+		t.Fatalf("expected 1 approval by default, got %d", n)
+	}
+}
+
+func TestLoadUserTokens(t *testing.T) {
+	defer func() { UserTokens = map[string]string{} }()
+
+	file := t.TempDir() + "/tokens"
+	os.WriteFile(file, []byte("# comment\nalice t1\n\nbob t2 # 2nd\n"), 0600)
+	if err := LoadUserTokens(file); err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for _, test := range []struct {
+		auth string
+		exp  string
+	}{
+		{"Bearer t1", "alice"},
+		{"bearer  t2", "bob"},
+		{"Bearer t3", ""},
+		{"Basic t1", ""},
+		{"", ""},
+	} {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("xRegistry~User", "carol")
+		if test.auth != "" {
+			r.Header.Set("Authorization", test.auth)
+		}
+		if res := RequestUser(r); res != test.exp {
+			t.Fatalf("%q: expected %q, got %q", test.auth, test.exp, res)
+		}
+	}
+
+	os.WriteFile(file, []byte("alice t1\nbob t1\n"), 0600)
+	if err := LoadUserTokens(file); err == nil ||
+		err.Error() != file+":2: duplicate token" {
+		t.Fatalf("Expected a duplicate error, got: %v", err)
+	}
+}
@@ -24,11 +24,22 @@ const (
 	FILTER_LESS_EQUAL
 	FILTER_GREATER
 	FILTER_GREATER_EQUAL
+
+	// Extensions - see SupportedFilterOperators
+	FILTER_REGEX    // ~=
+	FILTER_PREFIX   // ^=
+	FILTER_IN       // =in(a,b)
+	FILTER_NOT_IN   // !=in(a,b)
+	FILTER_CONTAINS // =contains(a)
+	FILTER_HASKEY   // =haskey(a)
 )
 
 // COLLATE clause for case-insensitive string comparisons per spec
 const FILTER_CI_COLLATE = "COLLATE utf8mb4_0900_ai_ci"
 
+// COLLATE clause for case-sensitive (==, !==, ...) string comparisons
+const FILTER_CS_COLLATE = "COLLATE utf8mb4_bin"
+
 const HTML_EXP = "&#9662;" // Expanded json symbol for HTML output
 const HTML_MIN = "&#9656;" // Minimized json symbol for HTML output
 
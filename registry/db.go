@@ -985,6 +985,32 @@ func doCount(tx *Tx, cmd string, args ...interface{}) int {
 	return int(count)
 }
 
+// CheckRegex makes sure the DB will accept "pattern" in a REGEXP_LIKE(),
+// which is what really runs a "~=" filter. MySQL uses ICU regular
+// expressions, not Go's RE2, so we can't just use regexp.Compile().
+// A failed statement doesn't abort the rest of the Tx.
+func CheckRegex(tx *Tx, pattern string, caseSensitive bool) error {
+	mode := "i"
+	if caseSensitive {
+		mode = "c"
+	}
+
+	ps, xErr := tx.Prepare(`SELECT REGEXP_LIKE('', ?, ?)`)
+	if xErr != nil {
+		return errors.New(xErr.GetTitle())
+	}
+	defer ps.Close()
+
+	var res any
+	err := ps.QueryRow(pattern, mode).Scan(&res)
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return errors.New(strings.TrimSuffix(mysqlErr.Message, "."))
+	}
+	return err
+}
+
 func Do(tx *Tx, cmd string, args ...interface{}) {
 	doCount(tx, cmd, args...)
 }
@@ -1078,6 +1104,11 @@ func OpenDB(name string) *XRError {
 	DB.SetMaxOpenConns(5)
 	DB.SetMaxIdleConns(5)
 
+	if err = UpgradeDB(DB, name); err != nil {
+		return NewXRError("server_error", "/",
+			fmt.Sprintf("Error upgrading DB(%s): %s", name, err))
+	}
+
 	if DB_InitFunc != nil {
 		DB_InitFunc()
 	}
@@ -1085,6 +1116,207 @@ func OpenDB(name string) *XRError {
 	return nil
 }
 
+// UpgradeDB brings a DB created by an older version of the server up to
+// date. Each step checks to see if it's needed, and can be re-run if it was
+// interrupted, so this is safe to call each time the DB is opened.
+func UpgradeDB(db *sql.DB, name string) error {
+	if err := upgradeResourceContents(db, name); err != nil {
+		return err
+	}
+	if err := upgradePendingBlobs(db, name); err != nil {
+		return err
+	}
+	if err := upgradeTables(db, name); err != nil {
+		return err
+	}
+	return upgradeTriggers(db, name)
+}
+
+// upgradeTables adds the tables that don't need anything more than just
+// being created. [0] is the table, [1] is one of its columns.
+func upgradeTables(db *sql.DB, name string) error {
+	tables := [][2]string{
+		{"DocProps", "ContentSID"},     // see docindex.go
+		{"ArchivedVersions", "SID"},    // see retention.go
+		{"ChangeRequests", "GroupSID"}, // see changerequest.go
+	}
+
+	for _, table := range tables {
+		exists, err := columnExists(db, name, table[0], table[1])
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		log.Printf("Upgrading DB(%s): adding %s", name, table[0])
+		for _, cmd := range initDBStatements(table[0]) {
+			log.VPrintf(4, "CMD: %s", cmd)
+			if _, err := db.Exec(cmd); err != nil {
+				return fmt.Errorf("error on: %s\n%s", cmd, err)
+			}
+		}
+		if table[0] == "DocProps" {
+			log.Printf("Existing documents can't be searched until "+
+				"\"xrserver registry reindex ID\" is run for DB(%s)", name)
+		}
+	}
+	return nil
+}
+
+// upgradeTriggers recreates the triggers that now also need to clean up
+// the tables added by upgradeTables. [0] is the trigger, the rest are the
+// tables it needs to mention.
+func upgradeTriggers(db *sql.DB, name string) error {
+	triggers := [][]string{
+		{"RegistryTrigger", "ArchivedVersions", "ChangeRequests"},
+		{"GroupTrigger", "ChangeRequests"},
+		{"VersionsTrigger", "DocProps"},
+	}
+
+	for _, trigger := range triggers {
+		upToDate := true
+		for _, table := range trigger[1:] {
+			count := 0
+			err := db.QueryRow(`
+                SELECT COUNT(*) FROM INFORMATION_SCHEMA.TRIGGERS
+                WHERE TRIGGER_SCHEMA=? AND TRIGGER_NAME=? AND
+                      ACTION_STATEMENT LIKE ?`,
+				name, trigger[0], "%"+table+"%").Scan(&count)
+			if err != nil {
+				return err
+			}
+			upToDate = upToDate && count > 0
+		}
+		if upToDate {
+			continue
+		}
+
+		log.Printf("Upgrading DB(%s): updating %s", name, trigger[0])
+		cmds := append([]string{`DROP TRIGGER IF EXISTS ` + trigger[0]},
+			initDBStatements(trigger[0])...)
+		for _, cmd := range cmds {
+			log.VPrintf(4, "CMD: %s", cmd)
+			if _, err := db.Exec(cmd); err != nil {
+				return fmt.Errorf("error on: %s\n%s", cmd, err)
+			}
+		}
+	}
+	return nil
+}
+
+// upgradePendingBlobs adds the PendingBlobs table (see SaveDocument)
+func upgradePendingBlobs(db *sql.DB, name string) error {
+	exists, err := columnExists(db, name, "PendingBlobs", "Digest")
+	if err != nil || exists {
+		return err
+	}
+
+	log.Printf("Upgrading DB(%s): adding PendingBlobs", name)
+	for _, cmd := range initDBStatements("PendingBlobs") {
+		log.VPrintf(4, "CMD: %s", cmd)
+		if _, err := db.Exec(cmd); err != nil {
+			return fmt.Errorf("error on: %s\n%s", cmd, err)
+		}
+	}
+	return nil
+}
+
+// upgradeResourceContents moves documents from the old
+// ResourceContents.Content column into Blobs (see blobs.go)
+func upgradeResourceContents(db *sql.DB, name string) error {
+	hasContent, err := columnExists(db, name, "ResourceContents", "Content")
+	if err != nil || !hasContent {
+		return err
+	}
+	hasDigest, err := columnExists(db, name, "ResourceContents", "Digest")
+	if err != nil {
+		return err
+	}
+	hasBlobs, err := columnExists(db, name, "Blobs", "Digest")
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Upgrading DB(%s): moving documents into Blobs", name)
+
+	cmds := []string{}
+	if !hasBlobs {
+		cmds = append(cmds,
+			initDBStatements("Blobs", "DeadBlobs", "BlobsDelete")...)
+	}
+	if !hasDigest {
+		cmds = append(cmds,
+			`ALTER TABLE ResourceContents ADD COLUMN Digest VARCHAR(80)`)
+	}
+
+	// The triggers are created after the data is copied so that they
+	// don't also adjust the RefCounts we're calculating here
+	cmds = append(cmds,
+		`UPDATE ResourceContents
+         SET Digest=CONCAT('`+DIGEST_PREFIX+`',
+                           SHA2(COALESCE(Content, ''), 256))
+         WHERE Digest IS NULL OR Digest=''`,
+		`INSERT IGNORE INTO Blobs(Digest, Content, Size, Store)
+         SELECT Digest, ANY_VALUE(COALESCE(Content, '')),
+                ANY_VALUE(LENGTH(COALESCE(Content, ''))), 'db'
+         FROM ResourceContents GROUP BY Digest`,
+		`UPDATE Blobs SET RefCount=(SELECT COUNT(*) FROM ResourceContents
+           WHERE ResourceContents.Digest=Blobs.Digest)`,
+		`DROP TRIGGER IF EXISTS ResourceContentsInsert`,
+		`DROP TRIGGER IF EXISTS ResourceContentsUpdate`,
+		`DROP TRIGGER IF EXISTS ResourceContentsDelete`)
+	cmds = append(cmds, initDBStatements("ResourceContentsInsert",
+		"ResourceContentsUpdate", "ResourceContentsDelete")...)
+	cmds = append(cmds,
+		`ALTER TABLE ResourceContents
+           DROP COLUMN Content,
+           MODIFY Digest VARCHAR(80) NOT NULL,
+           ADD INDEX (Digest)`)
+
+	for _, cmd := range cmds {
+		log.VPrintf(4, "CMD: %s", cmd)
+		if _, err := db.Exec(cmd); err != nil {
+			return fmt.Errorf("error on: %s\n%s", cmd, err)
+		}
+	}
+	return nil
+}
+
+func columnExists(db *sql.DB, dbName, table, column string) (bool, error) {
+	count := 0
+	err := db.QueryRow(`
+        SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS
+        WHERE TABLE_SCHEMA=? AND TABLE_NAME=? AND COLUMN_NAME=?`,
+		dbName, table, column).Scan(&count)
+	return count > 0, err
+}
+
+// initDBStatements returns the statements from init.sql that create the
+// named tables and triggers, ready to be executed
+func initDBStatements(names ...string) []string {
+	res := []string{}
+	for _, cmd := range strings.Split(initDB, ";") {
+		// Skip the comments before the statement to find its name
+		stmt := ""
+		for _, line := range strings.Split(cmd, "\n") {
+			if line = strings.TrimSpace(line); line != "" && line[0] != '#' {
+				stmt = line
+				break
+			}
+		}
+
+		for _, name := range names {
+			if strings.HasPrefix(stmt, "CREATE TABLE "+name+" ") ||
+				strings.HasPrefix(stmt, "CREATE TRIGGER "+name+" ") {
+				res = append(res, ReplaceVariables(strings.TrimSpace(cmd)))
+			}
+		}
+	}
+	return res
+}
+
 func ListDBs() ([]string, *XRError) {
 	log.VPrintf(3, ">Enter: ListDBs")
 	defer log.VPrintf(3, "<Exit: ListDBs")
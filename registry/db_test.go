@@ -0,0 +1,27 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInitDBStatements(t *testing.T) {
+	cmds := initDBStatements("Blobs", "ResourceContentsDelete")
+	if len(cmds) != 2 {
+		t.Fatalf("Expected 2 statements, got %d: %q", len(cmds), cmds)
+	}
+	if !strings.Contains(cmds[0], "CREATE TABLE Blobs (") {
+		t.Fatalf("Wrong 1st statement: %s", cmds[0])
+	}
+	if !strings.Contains(cmds[1],
+		"CREATE TRIGGER ResourceContentsDelete AFTER DELETE") ||
+		strings.Contains(cmds[1], "$$") ||
+		!strings.HasSuffix(cmds[1], "END") {
+		t.Fatalf("Wrong 2nd statement: %s", cmds[1])
+	}
+
+	// Names must match exactly, not just be a prefix
+	if cmds := initDBStatements("Blob"); len(cmds) != 0 {
+		t.Fatalf("Expected no statements, got: %q", cmds)
+	}
+}
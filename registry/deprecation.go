@@ -0,0 +1,329 @@
+// Package registry - enforcement of the "deprecated" attribute.
+//
+// "deprecated" (on Groups and on a Resource's meta) is just metadata as far
+// as the spec is concerned. On top of that we:
+//   - add Deprecation (RFC 9745), Sunset (RFC 8594) and Link headers to
+//     responses for deprecated entities (see AddDeprecationHeaders)
+//   - support "?filter=deprecationstate=active|deprecated|removed", a
+//     computed value based on "deprecated.effective/removal" and the
+//     current time (see deprecationStateTable)
+//   - optionally run a background scheduler that marks Resources as
+//     readonly, or deletes them, once their "removal" time has passed (see
+//     StartDeprecationScheduler)
+//
+// A Resource's (and its Versions') deprecation info lives on its meta
+// sub-object, a Group's on the Group itself. There's no inheritance, a
+// deprecated Group doesn't make its Resources deprecated.
+package registry
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/duglin/dlog"
+	. "github.com/xregistry/server/common"
+)
+
+const DEPRECATION_STATE = "deprecationstate"
+
+const (
+	DEP_STATE_ACTIVE     = "active"
+	DEP_STATE_DEPRECATED = "deprecated"
+	DEP_STATE_REMOVED    = "removed"
+)
+
+// What the scheduler does with entities whose "removal" time has passed
+const (
+	DEP_ACTION_NONE     = "none"
+	DEP_ACTION_READONLY = "readonly"
+	DEP_ACTION_DELETE   = "delete"
+)
+
+var DeprecationActions = []string{DEP_ACTION_NONE, DEP_ACTION_READONLY,
+	DEP_ACTION_DELETE}
+
+// DeprecationPath returns the Path of the entity that holds the
+// "deprecated" attribute for the entity at "path"
+func DeprecationPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch len(parts) {
+	case 4: // Resource
+		return path + "/meta"
+	case 6: // Version
+		return strings.Join(parts[:4], "/") + "/meta"
+	}
+	return path // Registry (nothing), Group or meta
+}
+
+func depTime(dep map[string]any, name string) (time.Time, bool) {
+	str, _ := dep[name].(string)
+	if str == "" {
+		return time.Time{}, false
+	}
+	t, err := ConvertStrToTime(str)
+	return t, err == nil
+}
+
+// DeprecationHeaders returns the HTTP headers to include in responses for
+// an entity whose "deprecated" attribute is "dep". Without an "effective"
+// time the entity is already deprecated, so we use the pre-RFC 9745
+// "Deprecation: true" form since there's no date to give.
+func DeprecationHeaders(dep map[string]any) [][2]string {
+	if dep == nil {
+		return nil
+	}
+
+	headers := [][2]string{}
+
+	if t, ok := depTime(dep, "effective"); ok {
+		headers = append(headers,
+			[2]string{"Deprecation", fmt.Sprintf("@%d", t.Unix())})
+	} else {
+		headers = append(headers, [2]string{"Deprecation", "true"})
+	}
+
+	if t, ok := depTime(dep, "removal"); ok {
+		headers = append(headers,
+			[2]string{"Sunset", t.UTC().Format(http.TimeFormat)})
+	}
+
+	if alt, _ := dep["alternative"].(string); alt != "" {
+		headers = append(headers,
+			[2]string{"Link", fmt.Sprintf("<%s>; rel=\"alternate\"", alt)})
+	}
+
+	if doc, _ := dep["documentation"].(string); doc != "" {
+		headers = append(headers,
+			[2]string{"Link", fmt.Sprintf("<%s>; rel=\"deprecation\"", doc)})
+	}
+
+	return headers
+}
+
+// AddDeprecationHeaders adds the Deprecation/Sunset/Link headers to the
+// response if the entity being retrieved is deprecated
+func AddDeprecationHeaders(info *RequestInfo) *XRError {
+	if info.What != "Entity" || len(info.Parts) < 2 {
+		return nil
+	}
+
+	path := DeprecationPath(strings.Join(info.Parts, "/"))
+	entity, xErr := RawEntityFromPath(info.tx, info.Registry.DbSID, path,
+		false, FOR_READ)
+	if xErr != nil || entity == nil {
+		return xErr
+	}
+
+	dep, _ := entity.Object["deprecated"].(map[string]any)
+	for _, header := range DeprecationHeaders(dep) {
+		info.AddHeader(header[0], header[1])
+	}
+	return nil
+}
+
+// deprecationStateTable returns SQL that looks like the Props table but
+// with just one "deprecationstate" row per entity, so ?filter can treat it
+// like any other attribute. "now" is inlined rather than passed as an
+// arg since the callers add their args in an order that assumes the
+// Props table doesn't need any.
+func deprecationStateTable(now time.Time) string {
+	depPath := `CASE e.Type
+          WHEN ` + StrTypes(ENTITY_RESOURCE) + ` THEN CONCAT(e.Path,'/meta')
+          WHEN ` + StrTypes(ENTITY_VERSION) +
+		` THEN CONCAT(substring_index(e.Path,'/',4),'/meta')
+          ELSE e.Path END`
+
+	depTimeSQL := func(name string) string {
+		return `(SELECT ` + sqlUTCTimestamp("dp.PropValue") + `
+             FROM Props AS dp
+             WHERE dp.RegSID=e.RegSID AND dp.Path=` + depPath + ` AND
+               dp.PropName='deprecated` + string(DB_IN) + name +
+			string(DB_IN) + `')`
+	}
+
+	nowStr := "'" + now.UTC().Format("2006-01-02 15:04:05.000000") + "'"
+
+	return `(
+            SELECT e.RegSID,e.eSID,e.Type,e.Path,e.Abstract,
+              '` + DEPRECATION_STATE + string(DB_IN) + `' AS PropName,
+              CASE
+                WHEN NOT EXISTS (SELECT 1 FROM Props AS dp
+                  WHERE dp.RegSID=e.RegSID AND dp.Path=` + depPath + ` AND
+                    dp.PropName LIKE 'deprecated` + string(DB_IN) + `%')
+                  THEN '` + DEP_STATE_ACTIVE + `'
+                WHEN ` + depTimeSQL("removal") + ` <= ` + nowStr + `
+                  THEN '` + DEP_STATE_REMOVED + `'
+                WHEN ` + depTimeSQL("effective") + ` > ` + nowStr + `
+                  THEN '` + DEP_STATE_ACTIVE + `'
+                ELSE '` + DEP_STATE_DEPRECATED + `'
+              END AS PropValue,
+              '` + STRING + `' AS PropType
+            FROM Entities AS e
+          ) AS Props`
+}
+
+// StartDeprecationScheduler checks every "interval" for Groups and
+// Resources whose "deprecated.removal" time has passed and applies
+// "action" to them (see EnforceDeprecations).
+func StartDeprecationScheduler(interval time.Duration, action string) {
+	if action == DEP_ACTION_NONE || interval <= 0 {
+		return
+	}
+
+	log.VPrintf(1, "Deprecation scheduler: %s every %s", action, interval)
+
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			names, xErr := GetRegistryNames()
+			if xErr != nil {
+				log.Printf("Deprecation scheduler: %s", xErr)
+				continue
+			}
+
+			for _, name := range names {
+				enforceDeprecationsAndLog(name, action)
+			}
+		}
+	}()
+}
+
+// enforceDeprecationsAndLog runs one registry's pass of the scheduler.
+// A panic (e.g. a DB error) is logged rather than allowed to kill the
+// scheduler's goroutine, and with it the whole server.
+func enforceDeprecationsAndLog(name string, action string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("Deprecation scheduler(%s): panic: %v", name, rec)
+		}
+	}()
+
+	xids, xErr := EnforceDeprecations(name, action, time.Now())
+	if xErr != nil {
+		log.Printf("Deprecation scheduler(%s): %s", name, xErr)
+	}
+	for _, xid := range xids {
+		log.VPrintf(1, "Deprecation scheduler(%s): %s %s", name, action, xid)
+	}
+}
+
+// EnforceDeprecations applies "action" to all of the entities in registry
+// "name" whose "removal" time is at or before "now":
+//   - readonly: sets the Resource's "meta.readonly" to true. Groups don't
+//     have a "readonly" attribute so they're left alone.
+//   - delete: deletes the Group or Resource. readonly Resources (and Groups
+//     containing them) are skipped.
+//
+// Returns the XIDs of the entities that were changed.
+func EnforceDeprecations(name string, action string, now time.Time) ([]string, *XRError) {
+	reg, xErr := FindRegistry(nil, name, FOR_WRITE)
+	if xErr != nil || reg == nil {
+		return nil, xErr
+	}
+
+	// No-op once we've committed, but cleans up if we panic
+	defer reg.Rollback()
+
+	xids, xErr := reg.EnforceDeprecations(action, now)
+	if xErr != nil {
+		return nil, xErr
+	}
+	return xids, reg.Commit()
+}
+
+func (reg *Registry) EnforceDeprecations(action string, now time.Time) ([]string, *XRError) {
+	if action != DEP_ACTION_READONLY && action != DEP_ACTION_DELETE {
+		return nil, nil
+	}
+
+	results := Query(reg.tx, `
+        SELECT p.Type,p.Path,p.PropValue FROM Props AS p
+        WHERE p.RegSID=? AND p.PropName=? AND p.Type IN (?,?)
+        ORDER BY p.Path`,
+		reg.DbSID, "deprecated"+string(DB_IN)+"removal"+string(DB_IN),
+		ENTITY_GROUP, ENTITY_META)
+
+	type removed struct {
+		eType int
+		path  string
+	}
+	list := []removed{}
+	for row := results.NextRow(); row != nil; row = results.NextRow() {
+		t, err := ConvertStrToTime(NotNilString(row[2]))
+		if err != nil || t.After(now) {
+			continue
+		}
+		list = append(list, removed{NotNilInt(row[0]), NotNilString(row[1])})
+	}
+	results.Close()
+
+	xids := []string{}
+	deleted := []string{}
+
+	for _, item := range list {
+		// Skip things under Groups we've already deleted
+		gone := false
+		for _, d := range deleted {
+			if strings.HasPrefix(item.path, d+"/") {
+				gone = true
+				break
+			}
+		}
+		if gone {
+			continue
+		}
+
+		if item.eType == ENTITY_GROUP {
+			if action != DEP_ACTION_DELETE {
+				continue
+			}
+			parts := strings.Split(item.path, "/")
+			g, xErr := reg.FindGroup(parts[0], parts[1], false, FOR_WRITE)
+			if xErr != nil {
+				return nil, xErr
+			}
+			if g == nil {
+				continue
+			}
+			if xErr = g.Delete(); xErr != nil {
+				if xErr.IsType("readonly") {
+					continue
+				}
+				return nil, xErr
+			}
+			deleted = append(deleted, item.path)
+			xids = append(xids, "/"+item.path)
+			continue
+		}
+
+		// Resource's meta
+		resPath := strings.TrimSuffix(item.path, "/meta")
+		r, xErr := reg.FindResourceByXID("/"+resPath, "/"+resPath, FOR_WRITE)
+		if xErr != nil {
+			return nil, xErr
+		}
+		if r == nil {
+			continue
+		}
+
+		meta := r.MustFindMeta(false)
+		if meta.Get("readonly") == true {
+			continue
+		}
+
+		if action == DEP_ACTION_READONLY {
+			xErr = r.SetSaveMeta("readonly", true)
+		} else {
+			xErr = r.Delete()
+		}
+		if xErr != nil {
+			return nil, xErr
+		}
+		xids = append(xids, "/"+resPath)
+	}
+
+	return xids, nil
+}
@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDeprecationPath(t *testing.T) {
+	tests := []struct {
+		path string
+		exp  string
+	}{
+		{"", ""},
+		{"dirs/d1", "dirs/d1"},
+		{"dirs/d1/files/f1", "dirs/d1/files/f1/meta"},
+		{"dirs/d1/files/f1/meta", "dirs/d1/files/f1/meta"},
+		{"dirs/d1/files/f1/versions/v1", "dirs/d1/files/f1/meta"},
+	}
+
+	for _, test := range tests {
+		if got := DeprecationPath(test.path); got != test.exp {
+			t.Fatalf("%q: expected %q, got %q", test.path, test.exp, got)
+		}
+	}
+}
+
+func TestDeprecationHeaders(t *testing.T) {
+	tests := []struct {
+		dep map[string]any
+		exp string
+	}{
+		{nil, "[]"},
+		{map[string]any{}, "[[Deprecation true]]"},
+		{map[string]any{
+			"effective":     "2020-01-01T00:00:00Z",
+			"removal":       "2021-01-01T12:00:00+02:00",
+			"alternative":   "http://example.com/new",
+			"documentation": "http://example.com/why",
+		}, "[[Deprecation @1577836800] " +
+			"[Sunset Fri, 01 Jan 2021 10:00:00 GMT] " +
+			"[Link <http://example.com/new>; rel=\"alternate\"] " +
+			"[Link <http://example.com/why>; rel=\"deprecation\"]]"},
+		{map[string]any{"effective": "bad"}, "[[Deprecation true]]"},
+	}
+
+	for _, test := range tests {
+		got := fmt.Sprintf("%v", DeprecationHeaders(test.dep))
+		if got != test.exp {
+			t.Fatalf("%v: expected %s, got %s", test.dep, test.exp, got)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+// Package registry - document index, which lets ?filter= look inside a
+// Resource's document.
+//
+// Each time a Version's document is written we parse it (JSON, or YAML
+// when the contenttype says so - Avro and JSON Structure schemas are just
+// JSON) and flatten it into the DocProps table: one row per node, keyed
+// by the node's JSON Pointer (RFC 6901). Filters like
+//
+//	?filter=schemas.schema#/properties/id/type=string
+//
+// are then just another Props-like lookup (see GenerateQuery), so we never
+// have to re-parse the stored blobs at query time.
+//
+// Rows are keyed by ContentSID (the ResourceContents.VersionSID value,
+// which is what "#contentid" holds), not by the entity, so xref'd
+// Resources and a Resource's default-version copy of "#contentid" all
+// resolve to the same set of rows without us having to copy anything.
+//
+// Scalars are stored with the same PropType names as Props (string,
+// boolean, integer, decimal) so the filter SQL can treat both tables the
+// same way. Objects and arrays get a row too (PropType object/array, empty
+// value) so "present" checks work on non-leaf pointers. JSON null isn't
+// stored at all, which makes "ptr=null" behave like a missing attribute.
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/duglin/dlog"
+	. "github.com/xregistry/server/common"
+	"gopkg.in/yaml.v3"
+)
+
+// Max # of nodes we'll index for any one document. Anything past this
+// is silently not searchable - it's an index, not the source of truth.
+const MAX_DOC_INDEX_NODES = 10000
+
+// Max length of a pointer, it's the size of DocProps.Pointer. Nodes with
+// longer pointers (and so everything under them) aren't indexed.
+const MAX_DOC_INDEX_POINTER = 512
+
+type docIndexRow struct {
+	Pointer string
+	Value   string
+	Type    string
+}
+
+// IndexDocument (re)builds the DocProps rows for the document stored
+// under contentSID. A nil buf just removes any existing rows.
+func IndexDocument(tx *Tx, contentSID string, contentType string, buf []byte) {
+	Do(tx, `DELETE FROM DocProps WHERE ContentSID=?`, contentSID)
+
+	if len(buf) == 0 {
+		return
+	}
+
+	rows := []docIndexRow{}
+	skipped := 0
+	for _, row := range FlattenDocument(contentType, buf) {
+		if utf8.RuneCountInString(row.Pointer) > MAX_DOC_INDEX_POINTER {
+			skipped++
+			continue
+		}
+		rows = append(rows, row)
+	}
+	if skipped > 0 {
+		log.Printf("Not indexing %d node(s) of document %q, their pointers "+
+			"are longer than %d", skipped, contentSID, MAX_DOC_INDEX_POINTER)
+	}
+
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > dbPropBatchChunkSize {
+			n = dbPropBatchChunkSize
+		}
+		chunk := rows[:n]
+		rows = rows[n:]
+
+		placeholders := make([]string, len(chunk))
+		args := make([]any, 0, len(chunk)*4)
+		for i, row := range chunk {
+			placeholders[i] = "(?,?,?,?)"
+			args = append(args, contentSID, row.Pointer, row.Value, row.Type)
+		}
+
+		Do(tx, `
+            REPLACE INTO DocProps(ContentSID, Pointer, PropValue, PropType)
+            VALUES `+strings.Join(placeholders, ","), args...)
+	}
+}
+
+// FlattenDocument parses buf and returns one row per node. Documents that
+// can't be parsed (or aren't JSON/YAML at all) just produce no rows.
+func FlattenDocument(contentType string, buf []byte) []docIndexRow {
+	var doc any
+
+	if json.Valid(buf) {
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			return nil
+		}
+	} else if IsYAMLContentType(contentType) {
+		if err := yaml.Unmarshal(buf, &doc); err != nil {
+			return nil
+		}
+	} else {
+		return nil
+	}
+
+	rows := []docIndexRow{}
+	flattenNode(&rows, "", doc)
+	return rows
+}
+
+func IsYAMLContentType(ct string) bool {
+	ct, _, _ = strings.Cut(ct, ";")
+	ct = strings.ToLower(strings.TrimSpace(ct))
+	return strings.HasSuffix(ct, "/yaml") || strings.HasSuffix(ct, "+yaml") ||
+		strings.HasSuffix(ct, "/x-yaml")
+}
+
+// EscapePointerToken escapes one JSON Pointer reference token per RFC 6901
+func EscapePointerToken(tok string) string {
+	tok = strings.ReplaceAll(tok, "~", "~0")
+	return strings.ReplaceAll(tok, "/", "~1")
+}
+
+func flattenNode(rows *[]docIndexRow, ptr string, node any) {
+	if len(*rows) >= MAX_DOC_INDEX_NODES {
+		return
+	}
+
+	switch val := node.(type) {
+	case nil:
+		// Not stored, so "ptr=null" acts like "absent"
+	case map[string]any:
+		*rows = append(*rows, docIndexRow{ptr, "", "object"})
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			flattenNode(rows, ptr+"/"+EscapePointerToken(k), val[k])
+		}
+	case map[any]any:
+		// yaml.v3 uses this when keys aren't all strings
+		m := map[string]any{}
+		for k, v := range val {
+			m[fmt.Sprintf("%v", k)] = v
+		}
+		flattenNode(rows, ptr, m)
+	case []any:
+		*rows = append(*rows, docIndexRow{ptr, "", "array"})
+		for i, v := range val {
+			flattenNode(rows, fmt.Sprintf("%s/%d", ptr, i), v)
+		}
+	case string:
+		*rows = append(*rows, docIndexRow{ptr, val, STRING})
+	case bool:
+		*rows = append(*rows, docIndexRow{ptr, fmt.Sprintf("%v", val), BOOLEAN})
+	case json.Number:
+		typ := INTEGER
+		if _, err := val.Int64(); err != nil {
+			typ = DECIMAL
+		}
+		*rows = append(*rows, docIndexRow{ptr, val.String(), typ})
+	case int, int64, uint64:
+		*rows = append(*rows, docIndexRow{ptr, fmt.Sprintf("%d", val), INTEGER})
+	case float64:
+		typ := DECIMAL
+		if val == float64(int64(val)) {
+			typ = INTEGER
+		}
+		*rows = append(*rows, docIndexRow{ptr,
+			strings.TrimSuffix(fmt.Sprintf("%v", val), ".0"), typ})
+	default:
+		// Timestamps, binary, etc. from YAML - just index the string form
+		*rows = append(*rows, docIndexRow{ptr, fmt.Sprintf("%v", val), STRING})
+	}
+}
+
+// IsDocFilterTarget returns true if absPP+attrPP (as split by SplitProp)
+// points to the document attribute of a Resource, or of a Version, whose
+// model has hasdocument=true. e.g. "schemagroups,schemas" + "schema".
+func IsDocFilterTarget(reg *Registry, absPP *PropPath, attrPP *PropPath) bool {
+	if attrPP.Len() != 1 {
+		return false
+	}
+	if absPP.Len() == 3 {
+		if absPP.Parts[2].Text != "versions" {
+			return false
+		}
+	} else if absPP.Len() != 2 {
+		return false
+	}
+
+	rm := reg.Model.FindResourceModel(absPP.Parts[0].Text,
+		absPP.Parts[1].Text)
+	return rm != nil && rm.GetHasDocument() && attrPP.Top() == rm.Singular
+}
+
+// DocPointerFilter converts a (possibly wildcarded) JSON pointer into a
+// REGEXP for the DocProps.Pointer column. A "*" token matches any one
+// key or array index. Returns false if there are no wildcards, in which
+// case the pointer should just be compared as-is.
+func DocPointerFilter(ptr string) (string, bool) {
+	if ptr == "" {
+		return "", false
+	}
+
+	hasWild := false
+	parts := strings.Split(ptr[1:], "/")
+	for i, part := range parts {
+		if part == "*" {
+			parts[i] = "[^/]*"
+			hasWild = true
+		} else {
+			parts[i] = regexp.QuoteMeta(part)
+		}
+	}
+	if !hasWild {
+		return ptr, false
+	}
+	return "^/" + strings.Join(parts, "/") + "$", true
+}
+
+// ReindexDocuments rebuilds the DocProps rows of all of the documents in
+// the Registry "name". Documents stored before DocProps existed aren't
+// searchable until this is done. Returns the number of documents indexed.
+func ReindexDocuments(name string) (int, *XRError) {
+	reg, xErr := FindRegistry(nil, name, FOR_WRITE)
+	if xErr != nil || reg == nil {
+		return 0, xErr
+	}
+
+	// No-op once we've committed, but cleans up if we panic
+	defer reg.Rollback()
+
+	results := Query(reg.tx, `
+        SELECT rc.VersionSID, p.PropValue
+        FROM ResourceContents AS rc
+        JOIN Versions AS v ON (v.SID=rc.VersionSID)
+        LEFT JOIN Props AS p ON (p.RegSID=v.RegistrySID AND p.eSID=v.SID AND
+                                 p.PropName=?)
+        WHERE v.RegistrySID=?
+        ORDER BY rc.VersionSID`,
+		"contenttype"+string(DB_IN), reg.DbSID)
+
+	docs := [][2]string{} // SID, contenttype
+	for row := results.NextRow(); row != nil; row = results.NextRow() {
+		docs = append(docs, [2]string{NotNilString(row[0]),
+			NotNilString(row[1])})
+	}
+	results.Close()
+
+	for _, doc := range docs {
+		IndexDocument(reg.tx, doc[0], doc[1], LoadDocument(reg.tx, doc[0]))
+	}
+
+	return len(docs), reg.Commit()
+}
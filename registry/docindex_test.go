@@ -0,0 +1,113 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestFlattenDocument(t *testing.T) {
+	tests := []struct {
+		name string
+		ct   string
+		doc  string
+		exp  []docIndexRow
+	}{
+		{
+			name: "json",
+			ct:   "application/json",
+			doc: `{"namespace":"com.acme","n":5,"d":1.5,"b":true,"z":null,
+			       "a/b~c":"esc","arr":["x",{"y":1}]}`,
+			exp: []docIndexRow{
+				{"", "", "object"},
+				{"/a~1b~0c", "esc", "string"},
+				{"/arr", "", "array"},
+				{"/arr/0", "x", "string"},
+				{"/arr/1", "", "object"},
+				{"/arr/1/y", "1", "integer"},
+				{"/b", "true", "boolean"},
+				{"/d", "1.5", "decimal"},
+				{"/n", "5", "integer"},
+				{"/namespace", "com.acme", "string"},
+			},
+		},
+		{
+			name: "json - contenttype doesn't matter",
+			ct:   "",
+			doc:  `{"a":"b"}`,
+			exp: []docIndexRow{
+				{"", "", "object"},
+				{"/a", "b", "string"},
+			},
+		},
+		{
+			name: "yaml",
+			ct:   "application/yaml; charset=utf-8",
+			doc:  "namespace: com.acme\nversion: 3\nratio: 2.5\nlist:\n- a\n- 7\n",
+			exp: []docIndexRow{
+				{"", "", "object"},
+				{"/list", "", "array"},
+				{"/list/0", "a", "string"},
+				{"/list/1", "7", "integer"},
+				{"/namespace", "com.acme", "string"},
+				{"/ratio", "2.5", "decimal"},
+				{"/version", "3", "integer"},
+			},
+		},
+		{
+			name: "yaml - but not a yaml contenttype",
+			ct:   "text/plain",
+			doc:  "namespace: com.acme\n",
+			exp:  nil,
+		},
+		{
+			name: "bad yaml",
+			ct:   "application/x-yaml",
+			doc:  "a: [b\n",
+			exp:  nil,
+		},
+		{
+			name: "scalar json",
+			ct:   "application/json",
+			doc:  `"hello"`,
+			exp: []docIndexRow{
+				{"", "hello", "string"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		rows := FlattenDocument(test.ct, []byte(test.doc))
+		if len(rows) != len(test.exp) {
+			t.Fatalf("%s: expected %d rows, got %d: %v", test.name,
+				len(test.exp), len(rows), rows)
+		}
+		for i, row := range rows {
+			if row != test.exp[i] {
+				t.Fatalf("%s: row %d: expected %v, got %v", test.name, i,
+					test.exp[i], row)
+			}
+		}
+	}
+}
+
+func TestDocPointerFilter(t *testing.T) {
+	tests := []struct {
+		ptr  string
+		exp  string
+		wild bool
+	}{
+		{"", "", false},
+		{"/a/b", "/a/b", false},
+		{"/a/*/b", "^/a/[^/]*/b$", true},
+		{"/*", "^/[^/]*$", true},
+		{"/a.b/*", `^/a\.b/[^/]*$`, true},
+		{"/a*/b", "/a*/b", false},
+	}
+
+	for _, test := range tests {
+		str, wild := DocPointerFilter(test.ptr)
+		if str != test.exp || wild != test.wild {
+			t.Fatalf("%q: expected %q/%v, got %q/%v", test.ptr, test.exp,
+				test.wild, str, wild)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"bytes"
 	"fmt"
 	"maps"
 	"reflect"
@@ -34,6 +35,10 @@ type EntityExtensions struct {
 	// below) so they can be written as a single multi-row REPLACE INTO
 	// instead of one round trip per property.
 	dbPropBatch []dbPropRow
+
+	// proxyDoc is the document fetched from RESOURCEproxyurl, only set
+	// while it's being validated (see validateProxyDocument())
+	proxyDoc []byte
 }
 
 func (e *Entity) GetRequestInfo() *RequestInfo {
@@ -253,24 +258,15 @@ func (e *Entity) GetPP(pp *PropPath) any {
 	if (e.Type == ENTITY_RESOURCE || e.Type == ENTITY_VERSION) && pp.Len() == 1 {
 		rm := e.GetResourceModel()
 		if rm.GetHasDocument() && pp.Top() == rm.Singular {
-			contentID := e.Get("#contentid")
-
-			results := Query(e.tx, `
-            SELECT Content FROM ResourceContents WHERE VersionSID=? `,
-				contentID)
-			defer results.Close()
-
-			row := results.NextRow()
-			if row == nil {
+			if e.proxyDoc != nil {
+				return e.proxyDoc
+			}
+			buf := LoadDocument(e.tx, e.Get("#contentid"))
+			if buf == nil {
 				// No data so just return
 				return nil
 			}
-
-			if results.NextRow() != nil {
-				panic("too many results")
-			}
-
-			return (*(row[0])).([]byte)
+			return buf
 		}
 	}
 
@@ -914,13 +910,26 @@ func (e *Entity) prepDBProperty(pp *PropPath, val any) (row dbPropRow,
 		if rm.GetHasDocument() && pp.Top() == rm.Singular {
 			if IsNil(val) {
 				// Remove the content
-				Do(e.tx, `DELETE FROM ResourceContents WHERE VersionSID=?`,
-					e.DbSID)
+				DeleteDocument(e.tx, e.DbSID)
+				IndexDocument(e.tx, e.DbSID, "", nil)
 			} else {
-				// Update the content
-				DoOneTwo(e.tx, `
-                REPLACE INTO ResourceContents(VersionSID, Content)
-            	VALUES(?,?)`, e.DbSID, val)
+				// Update the content. If it's the same document as before
+				// then this is a metadata-only change
+				buf := DocumentBytes(val)
+				_, changed, err := SaveDocument(e.tx, e.DbSID,
+					bytes.NewReader(buf), int64(len(buf)))
+				if err != nil {
+					return dbPropRow{}, true, NewXRError("server_error",
+						e.XID).SetDetailf("Error saving document: %s.", err)
+				}
+
+				// And its searchable (?filter=RESOURCE#/ptr) form, which
+				// also depends on the contenttype
+				ct, _ := e.NewObject["contenttype"].(string)
+				oldCT, _ := e.Object["contenttype"].(string)
+				if changed || ct != oldCT {
+					IndexDocument(e.tx, e.DbSID, ct, buf)
+				}
 
 				PanicIf(IsNil(e.NewObject["#contentid"]), "Missing cid")
 
@@ -1967,6 +1976,16 @@ var PropsFuncs = []*Attribute{
 			},
 		},
 	},
+	{
+		Name: "state",
+		internals: &AttrInternals{
+			checkFn: func(e *Entity) *XRError {
+				oldState, _ := e.Object["state"].(string)
+				newState, _ := e.NewObject["state"].(string)
+				return CheckVersionStateChange(e.XID, oldState, newState)
+			},
+		},
+	},
 	{
 		Name:      "contenttype",
 		internals: &AttrInternals{},
@@ -2001,6 +2020,22 @@ var PropsFuncs = []*Attribute{
 		Name:      "compatibilityvalidatedreason",
 		internals: &AttrInternals{},
 	},
+	{
+		Name: "digest",
+		internals: &AttrInternals{
+			updateFn: func(e *Entity) *XRError {
+				return e.UpdateIntegrity()
+			},
+		},
+	},
+	{
+		Name:      "signature",
+		internals: &AttrInternals{},
+	},
+	{
+		Name:      "signaturekeyid",
+		internals: &AttrInternals{},
+	},
 	{
 		Name:      "$extensions",
 		internals: &AttrInternals{},
@@ -3770,6 +3805,12 @@ func (e *Entity) CalcAttrDefault(attr *Attribute, path *PropPath) any {
 		return attr.Default
 	}
 
+	// A Version keeps its current "state" unless told otherwise, otherwise
+	// updating a draft would silently publish it
+	if attr.Name == "state" && path.Len() == 0 && !IsNil(e.Object["state"]) {
+		return e.Object["state"]
+	}
+
 	v := e.Self.(*Version)
 	g := v.Resource.Group
 
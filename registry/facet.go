@@ -0,0 +1,273 @@
+// Package registry - ?facet= support, which returns aggregate counts of
+// attribute values instead of the entities themselves. For example:
+//
+//	GET /dirs?facet=files.versions.format,files.labels.team,id
+//
+// returns, for each facet, how many entities of that type are in the
+// result set, how many of them don't have the attribute at all, the
+// min/max value and the # of entities per unique value:
+//
+//	{
+//	  "files.labels.team": {
+//	    "count": 3,
+//	    "missing": 1,
+//	    "min": "blue",
+//	    "max": "red",
+//	    "values": {
+//	      "blue": 1,
+//	      "red": 1
+//	    }
+//	  },
+//	  ...
+//	}
+//
+// Facet names are relative to the URL's path, just like ?filter and
+// ?inline, and the set of entities considered is exactly the set a
+// normal GET of that URL (with the same ?filter) would return, including
+// nested collections. The counting itself is done by the DB.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	log "github.com/duglin/dlog"
+	. "github.com/xregistry/server/common"
+)
+
+type Facet struct {
+	Name     string // As specified by the client
+	Abstract string // Type of entity to count, e.g. "dirs,files"
+	PropName string // PP.DB() of the attribute
+	PathPart int    // >0 if the value is the Nth part of the entity's Path
+}
+
+type FacetResult struct {
+	Count   int            `json:"count"`
+	Missing int            `json:"missing"`
+	Min     any            `json:"min,omitempty"`
+	Max     any            `json:"max,omitempty"`
+	Values  map[string]int `json:"values"`
+}
+
+// ParseFacets processes ?facet=attr[,attr...]. "id" is a shorthand for the
+// entity's SINGULARid attribute and the Group's ID can be used at lower
+// levels (e.g. "files.dirid") even though it isn't stored on those
+// entities.
+func (info *RequestInfo) ParseFacets() *XRError {
+	for _, value := range info.GetFlagValues("facet") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			pp, err := PropPathFromUI(name)
+			if err == nil && pp.HasWild {
+				err = fmt.Errorf("wildcards aren't allowed")
+			}
+			if err != nil {
+				return NewXRError("bad_facet",
+					info.OriginalRequest.URL.RequestURI(),
+					"value="+name,
+					"error_detail="+err.Error())
+			}
+
+			if info.Abstract != "" {
+				absPP, _ := PropPathFromPath(info.Abstract)
+				pp = absPP.Append(pp)
+			}
+
+			absPP, attrPP := SplitProp(info.Registry, pp)
+			if attrPP.Len() == 0 {
+				return NewXRError("bad_facet",
+					info.OriginalRequest.URL.RequestURI(),
+					"value="+name,
+					"error_detail=missing an attribute name")
+			}
+
+			if attrPP.Top() == "id" {
+				idPP := NewPPP(singularForAbstract(info.Registry, absPP) + "id")
+				if attrPP.Len() > 1 {
+					idPP = idPP.Append(attrPP.Next())
+				}
+				attrPP = idPP
+			}
+
+			facet := &Facet{
+				Name:     name,
+				Abstract: absPP.Abstract(),
+				PropName: attrPP.DB(),
+			}
+
+			// Below the Group level the Group's ID isn't an attribute,
+			// so pull it out of the Path instead
+			if absPP.Len() > 1 && attrPP.Len() == 1 {
+				gm := info.Registry.Model.FindGroupModel(absPP.Parts[0].Text)
+				if attrPP.Top() == gm.Singular+"id" {
+					facet.PathPart = 2
+				}
+			}
+
+			info.Facets = append(info.Facets, facet)
+		}
+	}
+	return nil
+}
+
+// facetQuery returns the SQL to count, per unique value of the facet's
+// attribute, the entities that the equivalent GET would have returned.
+// Entities w/o the attribute are counted under a NULL value.
+func facetQuery(reg *Registry, what string, paths []string, filters [][]*FilterExpr, facet *Facet) (string, []any) {
+	valCol := "CASE WHEN r.PropName=? THEN r.PropValue END"
+	typCol := "CASE WHEN r.PropName=? THEN r.PropType END"
+	args := []any{facet.PropName, facet.PropName}
+
+	if facet.PathPart > 0 {
+		valCol = fmt.Sprintf("substring_index(substring_index(r.Path,'/',%d),'/',-1)",
+			facet.PathPart)
+		typCol = "'" + STRING + "'"
+		args = []any{}
+	}
+
+	sel, selArgs := generateSelect(reg, what, paths, filters, false, nil,
+		"", nil)
+	args = append(args, selArgs...)
+	args = append(args, facet.Abstract)
+
+	query := `
+SELECT f.val,f.typ,COUNT(*) FROM (
+  SELECT r.eSID,MAX(` + valCol + `) AS val,MAX(` + typCol + `) AS typ
+  FROM (` + sel + `) AS r
+  WHERE r.Abstract=?
+  GROUP BY r.eSID
+) AS f
+GROUP BY f.val,f.typ`
+
+	return query, args
+}
+
+func HTTPGETFacets(info *RequestInfo) *XRError {
+	if info.What == "Entity" || (info.What == "Coll" && len(info.Parts) > 2) {
+		path := strings.Join(info.Parts, "/")
+		if info.What == "Coll" {
+			path = strings.Join(info.Parts[:len(info.Parts)-1], "/")
+		}
+		entity, xErr := RawEntityFromPath(info.tx, info.Registry.DbSID,
+			path, false, FOR_READ)
+		if xErr != nil {
+			return xErr
+		}
+		if IsNil(entity) {
+			return NewXRError("not_found", "/"+path)
+		}
+	}
+
+	paths := []string{strings.Join(info.Parts, "/")}
+	res := map[string]*FacetResult{}
+
+	for _, facet := range info.Facets {
+		fr := &FacetResult{Values: map[string]int{}}
+		res[facet.Name] = fr
+
+		if IsExcludeAll(info.Filters) {
+			continue
+		}
+
+		query, args := facetQuery(info.Registry, info.What, paths,
+			info.Filters, facet)
+		if log.GetVerbose() > 3 || log.HasKeyword("genq") {
+			log.Printf("Facet Query:\n%s\n\n", SubQuery(query, args))
+		}
+
+		results := Query(info.tx, query, args...)
+		defer results.Close()
+
+		minType, minVal, maxType, maxVal := "", "", "", ""
+		for row := results.NextRow(); row != nil; row = results.NextRow() {
+			count := NotNilInt(row[2])
+			fr.Count += count
+			if IsNil(*row[0]) {
+				fr.Missing += count
+				continue
+			}
+
+			val, typ := NotNilString(row[0]), NotNilString(row[1])
+			fr.Values[val] += count
+
+			if fr.Min == nil ||
+				CompareFacetValues(typ, val, minType, minVal) < 0 {
+				minType, minVal = typ, val
+				fr.Min = FacetValue(typ, val)
+			}
+			if fr.Max == nil ||
+				CompareFacetValues(typ, val, maxType, maxVal) > 0 {
+				maxType, maxVal = typ, val
+				fr.Max = FacetValue(typ, val)
+			}
+		}
+	}
+
+	buf, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return NewXRError("server_error", info.GetParts(0)).
+			SetDetailf("Error serializing facets: %s.", err.Error())
+	}
+
+	info.SetHeader("Content-Type", "application/json")
+	info.Write(buf)
+	info.Write([]byte("\n"))
+	return nil
+}
+
+// FacetValue converts a DB PropValue into the JSON type its PropType says
+// it is so numbers and booleans aren't shown as strings
+func FacetValue(propType string, val string) any {
+	switch propType {
+	case INTEGER, UINTEGER, DECIMAL:
+		return json.Number(val)
+	case BOOLEAN:
+		return val == "true"
+	}
+	return val
+}
+
+// CompareFacetValues returns <0, 0 or >0 like strings.Compare. Numbers
+// and timestamps are compared by value, everything else as strings.
+// Numbers sort before timestamps, which sort before everything else, which
+// matches ?sort.
+func CompareFacetValues(aType, a, bType, b string) int {
+	class := func(propType string) int {
+		switch propType {
+		case INTEGER, UINTEGER, DECIMAL:
+			return 0
+		case TIMESTAMP:
+			return 1
+		}
+		return 2
+	}
+
+	ca, cb := class(aType), class(bType)
+	if ca != cb {
+		return ca - cb
+	}
+
+	switch ca {
+	case 0:
+		fa, _, errA := big.ParseFloat(a, 10, 200, big.ToNearestEven)
+		fb, _, errB := big.ParseFloat(b, 10, 200, big.ToNearestEven)
+		if errA == nil && errB == nil {
+			return fa.Cmp(fb)
+		}
+	case 1:
+		ta, errA := time.Parse(time.RFC3339Nano, a)
+		tb, errB := time.Parse(time.RFC3339Nano, b)
+		if errA == nil && errB == nil {
+			return ta.Compare(tb)
+		}
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,63 @@
+package registry
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompareFacetValues(t *testing.T) {
+	tests := []struct {
+		aType, a string
+		bType, b string
+		exp      int
+	}{
+		{"integer", "5", "integer", "5", 0},
+		{"integer", "5", "integer", "10", -1},
+		{"decimal", "10.5", "integer", "9", 1},
+		{"uinteger", "2", "decimal", "2.0", 0},
+		{"integer", "100", "string", "1", -1},
+		{"timestamp", "2024-01-01T00:00:00Z", "integer", "1", 1},
+		{"timestamp", "2024-01-01T00:00:00.5Z", "timestamp",
+			"2024-01-01T00:00:00.25Z", 1},
+		{"timestamp", "2024-01-01T00:00:00Z", "string", "2020", -1},
+		{"string", "b", "string", "a", 1},
+		{"string", "B", "string", "a", -1},
+		{"boolean", "false", "boolean", "true", -1},
+	}
+
+	for _, test := range tests {
+		res := CompareFacetValues(test.aType, test.a, test.bType, test.b)
+		if res < 0 {
+			res = -1
+		} else if res > 0 {
+			res = 1
+		}
+		if res != test.exp {
+			t.Fatalf("%s(%s) vs %s(%s): expected %d, got %d", test.aType,
+				test.a, test.bType, test.b, test.exp, res)
+		}
+	}
+}
+
+func TestFacetValue(t *testing.T) {
+	tests := []struct {
+		typ string
+		val string
+		exp string
+	}{
+		{"integer", "5", `5`},
+		{"decimal", "1.50", `1.50`},
+		{"boolean", "true", `true`},
+		{"boolean", "false", `false`},
+		{"string", "5", `"5"`},
+		{"timestamp", "2024-01-01T00:00:00Z", `"2024-01-01T00:00:00Z"`},
+	}
+
+	for _, test := range tests {
+		buf, _ := json.Marshal(FacetValue(test.typ, test.val))
+		if string(buf) != test.exp {
+			t.Fatalf("%s(%s): expected %s, got %s", test.typ, test.val,
+				test.exp, string(buf))
+		}
+	}
+}
@@ -68,6 +68,9 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// to be written and not default to 200
 		if info != nil {
 			info.HTTPWriter.Done()
+			if cw, ok := info.OriginalResponse.(*compressWriter); ok {
+				cw.Close()
+			}
 		}
 	}()
 
@@ -272,6 +275,18 @@ func (s *Server) serveOneAttempt(w http.ResponseWriter, r *http.Request,
 		return false
 	}
 
+	// Only charge the client once, not for each retry
+	if attempt == 1 {
+		if xErr = CheckRateLimit(info); xErr != nil {
+			HTTPWriteError(info, xErr)
+			return false
+		}
+	}
+
+	if encoding := NegotiateEncoding(info); encoding != "" {
+		info.OriginalResponse = newCompressWriter(w, encoding)
+	}
+
 	if r.URL.Query().Has("ui") { // Wrap in html page
 		info.HTTPWriter = NewPageWriter(info)
 	}
@@ -375,8 +390,16 @@ func (dw *DefaultWriter) Write(b []byte) (int, error) {
 			dw.AddHeader("Allow", methodsStr)
 		}
 
-		// Link header for xRegistry root - add only if not already present
-		if len(dw.GetHeaderValues("Link")) == 0 {
+		// Link header for xRegistry root - add only if not already present.
+		// Other Links (e.g. for deprecated entities) don't count.
+		hasRootLink := false
+		for _, link := range dw.GetHeaderValues("Link") {
+			if strings.Contains(link, "rel=xregistry-root") {
+				hasRootLink = true
+				break
+			}
+		}
+		if !hasRootLink {
 			dw.AddHeader("Link",
 				fmt.Sprintf("<%s>;rel=xregistry-root", dw.Info.BaseURL))
 		}
@@ -773,7 +796,8 @@ FROM Props WHERE RegSID=? AND `
 			return nil
 		}
 
-		if attr.internals != nil && attr.internals.neverSerialize {
+		if attr.internals != nil &&
+			(attr.internals.neverSerialize || attr.internals.noHeader) {
 			return nil
 		}
 
@@ -841,33 +865,35 @@ FROM Props WHERE RegSID=? AND `
 	log.VPrintf(3, singular+"proxyurl: %s", url)
 	if url != "" {
 		// Just act as a proxy and copy the remote resource as our response
-		resp, err := http.Get(url)
-		if err != nil {
-			return NewXRError("parsing_response", "/"+info.OriginalPath,
-				"error_detail="+err.Error())
+		doc, xErr := FetchProxyDocument(entity, url)
+		if xErr != nil {
+			return xErr
 		}
-		if resp.StatusCode/100 != 2 {
-			info.StatusCode = resp.StatusCode
-			// return fmt.Error f("Remote error")
+		if doc.StatusCode/100 != 2 {
+			info.StatusCode = doc.StatusCode
 			// Let the body of the response be our body, below
 		}
 
-		// Copy all HTTP headers
-		for header, value := range resp.Header {
+		// Copy the remote's headers, minus the ones we shouldn't
+		for header, value := range doc.Header {
 			info.AddHeader(header, strings.Join(value, ","))
 		}
 
-		// Now copy the body
-		_, err = io.Copy(info, resp.Body)
-		if err != nil {
-			return NewXRError("parsing_response", "/"+info.OriginalPath,
-				"error_detail="+err.Error())
+		if doc.StatusCode/100 != 2 {
+			info.Write(doc.Body)
+			return nil
 		}
-		return nil
+		return ServeDocument(info, bytes.NewReader(doc.Body),
+			int64(len(doc.Body)))
 	}
 
-	buf := version.Get(singular)
-	if IsNil(buf) {
+	// Stream it from its BlobStore rather than loading it all first
+	doc, size, err := OpenDocument(info.tx, version.Get("#contentid"))
+	if err != nil {
+		return NewXRError("server_error", "/"+info.OriginalPath).
+			SetDetailf("Error loading document: %s.", err)
+	}
+	if doc == nil {
 		// No data so just return
 		/*
 			if info.StatusCode == 0 {
@@ -876,10 +902,16 @@ FROM Props WHERE RegSID=? AND `
 		*/
 		return nil
 	}
+	defer doc.Close()
 
-	info.Write(buf.([]byte))
+	if digest := version.GetDocumentDigest(); digest != "" {
+		if repr, legacy, err := DigestHeaders(digest); err == nil {
+			info.SetHeader("Repr-Digest", repr)
+			info.SetHeader("Digest", legacy)
+		}
+	}
 
-	return nil
+	return ServeDocument(info, doc, size)
 }
 
 func HTTPOptions(info *RequestInfo) *XRError {
@@ -940,6 +972,22 @@ func HTTPGet(info *RequestInfo) *XRError {
 		return HTTPGETXRegistryDiscovery(info)
 	}
 
+	if info.RootPath == "ratelimits" {
+		return HTTPGETRateLimits(info)
+	}
+
+	if info.ChangeRequests {
+		return HTTPChangeRequests(info)
+	}
+
+	if len(info.Facets) > 0 {
+		return HTTPGETFacets(info)
+	}
+
+	if xErr := AddDeprecationHeaders(info); xErr != nil {
+		return xErr
+	}
+
 	// 'metaInBody' tells us whether xReg metadata should be in the http
 	// response body or not (meaning, the hasDoc doc)
 	metaInBody := (info.ResourceModel == nil) ||
@@ -1024,7 +1072,7 @@ func SerializeQuery(info *RequestInfo, resPaths map[string][]string,
 		// "!" is special - it means skip the query and just produce: {}
 		if len(paths) != 1 || paths[0] != "!" {
 			query, args, err := GenerateQuery(info.Registry, what, paths,
-				filters, info.DoDocView(), info.SortKey)
+				filters, info.DoDocView(), info.SortKeys, info.Fields)
 			if err != nil {
 				return err
 			}
@@ -1181,6 +1229,11 @@ func HTTPPutPost(info *RequestInfo) *XRError {
 			SetDetail("Use \"/modelsource\" instead of \"/model\".")
 	}
 
+	if info.RootPath == "ratelimits" {
+		return NewXRError("action_not_supported", "/"+info.OriginalPath,
+			"action="+method)
+	}
+
 	// The model has its own special func
 	if info.RootPath == "modelsource" {
 		if !info.IsAvailable("modelsource") || !info.IsAvailableMutable("modelsource") {
@@ -1194,6 +1247,10 @@ func HTTPPutPost(info *RequestInfo) *XRError {
 			SetDetail("Registry data is read-only.")
 	}
 
+	if info.ChangeRequests {
+		return HTTPChangeRequests(info)
+	}
+
 	// Check for some obvious high-level bad states up-front
 	// //////////////////////////////////////////////////////
 	if info.What == "Coll" && method == "PUT" {
@@ -1206,6 +1263,10 @@ func HTTPPutPost(info *RequestInfo) *XRError {
 			"action="+method).SetDetail("POST not allowed on a 'meta'.")
 	}
 
+	if numParts == 6 && method == "POST" && info.HasFlag("publish") {
+		return HTTPPublishVersion(info)
+	}
+
 	if numParts == 6 && method == "POST" {
 		return NewXRError("action_not_supported", "/"+info.OriginalPath,
 			"action="+method).
@@ -1230,6 +1291,10 @@ func HTTPPutPost(info *RequestInfo) *XRError {
 		return xErr
 	}
 
+	if xErr := CheckNestedApproval(info, IncomingObj); xErr != nil {
+		return xErr
+	}
+
 	// Walk the PATH and process things
 	///////////////////////////////////
 
@@ -1397,6 +1462,11 @@ func HTTPPutPost(info *RequestInfo) *XRError {
 		return xErr
 	}
 
+	// Writes of Versions that need approval become change requests
+	if NeedsApproval(info, group) {
+		return HTTPCreateChangeRequest(info, group, IncomingObj, metaInBody)
+	}
+
 	// If not found, create it
 	if group == nil {
 		group, _, xErr = info.Registry.UpsertGroup(info.GroupType, groupUID)
@@ -1941,6 +2011,20 @@ func HTTPPUTModelSource(info *RequestInfo) *XRError {
 	// I wonder if a PUT to a version would be let thru and missed??
 	info.Registry.Lock()
 
+	// ?dryrun just shows what the new model would do to the existing data,
+	// nothing is kept
+	if info.OriginalRequest.URL.Query().Has("dryrun") {
+		plan, xErr := info.Registry.Model.PlanNewModelFromJSON(body)
+		info.Registry.Rollback()
+		if xErr != nil {
+			return xErr
+		}
+
+		info.SetHeader("Content-Type", "application/json")
+		info.Write([]byte(ToJSON(plan) + "\n"))
+		return nil
+	}
+
 	xErr := info.Registry.Model.ApplyNewModelFromJSON(body, true)
 	if xErr != nil {
 		return xErr
@@ -1952,14 +2036,34 @@ func HTTPPUTModelSource(info *RequestInfo) *XRError {
 func HTTPDelete(info *RequestInfo) *XRError {
 	// DELETE /...
 	if len(info.Parts) == 0 {
+		// DELETE /reg-ID of a scratch Registry deletes all of it
+		reg := info.Registry
+		if IsScratchRegistry(reg.UID) && reg.DbSID != DefaultRegDbSID &&
+			strings.HasSuffix(info.BaseURL, "/reg-"+reg.UID) {
+			if xErr := reg.Delete(); xErr != nil {
+				return xErr
+			}
+			info.StatusCode = http.StatusNoContent
+			return nil
+		}
+
 		// DELETE /
 		return NewXRError("action_not_supported", "/", "action=DELETE")
 	}
 
+	if info.RootPath == "ratelimits" {
+		return NewXRError("action_not_supported", "/"+info.OriginalPath,
+			"action=DELETE")
+	}
+
 	if !info.IsAvailableMutable("entities") {
 		return NewXRError("not_available", "/"+info.OriginalPath)
 	}
 
+	if info.ChangeRequests {
+		return HTTPChangeRequests(info)
+	}
+
 	var xErr *XRError
 	var err error
 	epochStr := info.GetFlag("epoch")
@@ -47,7 +47,14 @@ type RequestInfo struct {
 	Inlines       []*Inline
 	Filters       [][]*FilterExpr // [OR][AND] filter=e,e(and) &(or) filter=e
 	ShowDetails   bool            //	is $details present
-	SortKey       string          // [-]AttrName  - => descending
+	SortKeys      []*SortKey      // ?sort=[-]attr[=order],...
+
+	// ?fields= attributes to show, indexed by the entity's abstract path
+	Fields map[string][]*PropPath
+
+	Facets []*Facet // ?facet=attr,... (see facet.go)
+
+	ChangeRequests bool // /GROUPS/gID/changerequests... (changerequest.go)
 
 	StatusCode int
 	SentStatus bool
@@ -63,7 +70,7 @@ type RequestInfo struct {
 var explicitInlines = []string{"capabilities", "model", "modelsource"}
 var nonModelInlines = append([]string{"*"}, explicitInlines...)
 var rootPaths = []string{"capabilities", "capabilitiesoffered", "export",
-	"model", "modelsource", "proxy", ".xregistry"}
+	"model", "modelsource", "proxy", "ratelimits", ".xregistry"}
 
 type Inline struct {
 	Path    string    // value from ?inline query param
@@ -235,6 +242,84 @@ func (ri *RequestInfo) GetHeaderValues(name string) []string {
 	return ri.HTTPWriter.GetHeaderValues(name)
 }
 
+// One key from ?sort=. Keys are applied in the order specified, to the
+// top-level collection and to any inlined nested collections under it.
+type SortKey struct {
+	PP         *PropPath // labels.team as PP
+	Desc       bool
+	NullsFirst bool // defaults to "nulls are smaller than any value"
+}
+
+// ParseSortKeys parses the ?sort= value. Each comma separated key is:
+//
+//	[-]ATTR[=ORDER[:NULLS]] or [-]ATTR[=NULLS]
+//
+// where ATTR may be a nested path (labels.team, myobj.list[0]), ORDER is
+// "asc" (default) or "desc", and NULLS is "nullsfirst" or "nullslast".
+// A leading "-" is the same as "=desc".
+func ParseSortKeys(str string) ([]*SortKey, error) {
+	keys := []*SortKey{}
+
+	for _, keyStr := range strings.Split(str, ",") {
+		name, mods, hasMods := strings.Cut(keyStr, "=")
+		key := &SortKey{}
+
+		if strings.HasPrefix(name, "-") {
+			key.Desc = true
+			name = name[1:]
+		}
+		if name == "" {
+			return nil, fmt.Errorf("missing \"sort\" attribute name")
+		}
+
+		nulls := ""
+		if hasMods {
+			order, nullsStr, _ := strings.Cut(mods, ":")
+			if nullsStr == "" &&
+				(order == "nullsfirst" || order == "nullslast") {
+				order, nullsStr = "", order
+			}
+			switch order {
+			case "":
+			case "asc", "desc":
+				if key.Desc {
+					return nil, fmt.Errorf("\"-\" and \"=%s\" can't both "+
+						"be used on %q", order, name)
+				}
+				key.Desc = (order == "desc")
+			default:
+				return nil, fmt.Errorf("invalid \"sort\" order %q", order)
+			}
+			if nullsStr != "" && nullsStr != "nullsfirst" &&
+				nullsStr != "nullslast" {
+				return nil, fmt.Errorf("invalid \"sort\" null ordering %q",
+					nullsStr)
+			}
+			nulls = nullsStr
+		}
+
+		// Default: nulls are smaller than everything else
+		key.NullsFirst = !key.Desc
+		if nulls != "" {
+			key.NullsFirst = (nulls == "nullsfirst")
+		}
+
+		pp, err := PropPathFromUI(name)
+		if err == nil && pp.HasWild {
+			err = fmt.Errorf("wildcards aren't allowed")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad attribute name(%s): %s", name,
+				err.Error())
+		}
+		key.PP = pp
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
 type FilterExpr struct {
 	// User provided
 	PP       *PropPath // endpoints.id as PP
@@ -242,21 +327,49 @@ type FilterExpr struct {
 	Value    string    // myEndpoint
 	Operator int       // FILTER_PRESENT, ...
 
+	Values        []string // in(a,b,c) values
+	CaseSensitive bool     // ==, !==, ~==, ...
+
+	// RESOURCE#/json/pointer - look inside the entity's document
+	IsDoc      bool
+	DocPointer string // "/json/pointer", "" means the whole doc
+
+	// The computed "deprecationstate" value (see deprecation.go)
+	IsDeprecationState bool
+
 	// helpers
 	Abstract string
 	PropName string // PP.DB()
 }
 
 func (fe *FilterExpr) OpValue() string {
+	// Case-sensitive ops just double up the "="
+	eq := "="
+	if fe.CaseSensitive {
+		eq = "=="
+	}
+
 	switch fe.Operator {
 	case FILTER_PRESENT:
 		return ""
 	case FILTER_ABSENT:
 		return "=null"
 	case FILTER_EQUAL:
-		return "=" + fe.Value
+		return eq + fe.Value
 	case FILTER_NOT_EQUAL:
-		return "!=" + fe.Value
+		return "!" + eq + fe.Value
+	case FILTER_REGEX:
+		return "~" + eq + fe.Value
+	case FILTER_PREFIX:
+		return "^" + eq + fe.Value
+	case FILTER_IN:
+		return eq + "in(" + strings.Join(fe.Values, ",") + ")"
+	case FILTER_NOT_IN:
+		return "!" + eq + "in(" + strings.Join(fe.Values, ",") + ")"
+	case FILTER_CONTAINS:
+		return eq + "contains(" + fe.Value + ")"
+	case FILTER_HASKEY:
+		return "=haskey(" + fe.Value + ")"
 	case FILTER_LESS:
 		return "<" + fe.Value
 	case FILTER_LESS_EQUAL:
@@ -289,6 +402,10 @@ func (fe *FilterExpr) StringRelativeToAbstract(abs string) string {
 		// Convert , into .
 		rest = strings.ReplaceAll(rest, string(DB_IN), ".")
 
+		if fe.IsDoc {
+			rest += "#" + fe.DocPointer
+		}
+
 		return rest + fe.OpValue()
 	}
 
@@ -368,6 +485,9 @@ func ParseRequest(tx *Tx, w http.ResponseWriter, r *http.Request) (*RequestInfo,
 	if len(info.Body) == 0 {
 		info.Body = nil
 	}
+	if xErr = DecodeRequestBody(info); xErr != nil {
+		return info, xErr
+	}
 
 	if log.GetVerbose() > 2 {
 		defer func() { log.Printf("Info:\n%s\n", ToJSON(info)) }()
@@ -378,7 +498,7 @@ func ParseRequest(tx *Tx, w http.ResponseWriter, r *http.Request) (*RequestInfo,
 		return info, xErr
 	}
 
-	if tmp := r.Header.Get("xRegistry~User"); tmp != "" {
+	if tmp := RequestUser(r); tmp != "" {
 		info.tx.User = tmp
 	}
 
@@ -416,6 +536,77 @@ func ParseRequest(tx *Tx, w http.ResponseWriter, r *http.Request) (*RequestInfo,
 	return info, nil
 }
 
+// All filter operators, longest first so that the first match wins.
+// Doubling the "=" means "case-sensitive".
+var filterOps = []struct {
+	str string
+	op  int
+	cs  bool
+}{
+	{"!==", FILTER_NOT_EQUAL, true},
+	{"~==", FILTER_REGEX, true},
+	{"^==", FILTER_PREFIX, true},
+	{"!=", FILTER_NOT_EQUAL, false},
+	{"<>", FILTER_NOT_EQUAL, false},
+	{"<=", FILTER_LESS_EQUAL, false},
+	{">=", FILTER_GREATER_EQUAL, false},
+	{"~=", FILTER_REGEX, false},
+	{"^=", FILTER_PREFIX, false},
+	{"==", FILTER_EQUAL, true},
+	{"<", FILTER_LESS, false},
+	{">", FILTER_GREATER, false},
+	{"=", FILTER_EQUAL, false},
+}
+
+// CutFilterOp splits a filter expression at its first operator.
+// No operator means FILTER_PRESENT.
+func CutFilterOp(expr string) (path string, op int, cs bool, value string) {
+	for i := 0; i < len(expr); i++ {
+		if !strings.ContainsRune("!<>=~^", rune(expr[i])) {
+			continue
+		}
+		for _, fop := range filterOps {
+			if strings.HasPrefix(expr[i:], fop.str) {
+				return expr[:i], fop.op, fop.cs, expr[i+len(fop.str):]
+			}
+		}
+	}
+	return expr, FILTER_PRESENT, false, ""
+}
+
+// IsFilterFuncValue returns true if a filter's value looks like one of the
+// func-like operators, e.g. "in(a,b)"
+func IsFilterFuncValue(value string) bool {
+	if !strings.HasSuffix(value, ")") {
+		return false
+	}
+	fn, _, ok := strings.Cut(value, "(")
+	return ok && ArrayContains([]string{"in", "contains", "haskey"}, fn)
+}
+
+// SplitFilterExprs splits a ?filter= value on its commas (the ANDs), except
+// for commas within parens since those are part of an in(a,b,c) list.
+func SplitFilterExprs(filterQ string) []string {
+	exprs := []string{}
+	depth, start := 0, 0
+	for i, ch := range filterQ {
+		switch ch {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				exprs = append(exprs, filterQ[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(exprs, filterQ[start:])
+}
+
 func (info *RequestInfo) ParseFilters() *XRError {
 	seenExcludeAll := false
 
@@ -423,7 +614,7 @@ func (info *RequestInfo) ParseFilters() *XRError {
 		// ?filter=path.to.attribute[=value],* & filter=...
 
 		filterQ = strings.TrimSpace(filterQ)
-		exprs := strings.Split(filterQ, ",")
+		exprs := SplitFilterExprs(filterQ)
 		AndFilters := ([]*FilterExpr)(nil)
 		for _, expr := range exprs {
 			expr = strings.TrimSpace(expr)
@@ -448,54 +639,84 @@ func (info *RequestInfo) ParseFilters() *XRError {
 						"any other filter expressions")
 			}
 
-			filterOp := FILTER_PRESENT
+			path, filterOp, caseSensitive, value := CutFilterOp(expr)
+			values := []string(nil)
+
+			// "xxx!=null" and "xxx<>null" are the same as "xxx" (present)
+			// and "xxx=null" means absent
+			if value == "null" {
+				if filterOp == FILTER_NOT_EQUAL {
+					filterOp = FILTER_PRESENT
+					caseSensitive = false
+				} else if filterOp == FILTER_EQUAL {
+					filterOp = FILTER_ABSENT
+					caseSensitive = false
+				}
+			}
 
-			path, value, found := strings.Cut(expr, "!=")
-			if found {
-				// Note that "xxx!=null" is the same as "xxx"
-				if value != "null" {
-					filterOp = FILTER_NOT_EQUAL
+			// Check for the func-like values: in(), contains(), haskey().
+			// A leading "\" means it's just a value, e.g. "name=\in(x)"
+			// looks for "in(x)"
+			isFunc := (filterOp == FILTER_EQUAL ||
+				filterOp == FILTER_NOT_EQUAL) && strings.HasSuffix(value, ")")
+			if isFunc && value[0] == '\\' && IsFilterFuncValue(value[1:]) {
+				value = value[1:]
+				isFunc = false
+			}
+			if isFunc {
+
+				fn, args, _ := strings.Cut(value[:len(value)-1], "(")
+				newOp := 0
+				switch fn {
+				case "in":
+					newOp = FILTER_IN
+					if filterOp == FILTER_NOT_EQUAL {
+						newOp = FILTER_NOT_IN
+					}
+					for _, v := range strings.Split(args, ",") {
+						values = append(values, strings.TrimSpace(v))
+					}
+				case "contains":
+					newOp = FILTER_CONTAINS
+				case "haskey":
+					newOp = FILTER_HASKEY
+					caseSensitive = false
 				}
-			} else {
-				path, value, found = strings.Cut(expr, "<>")
-				if found {
-					// "<>null" is the same as present (no operator), per spec
-					if value != "null" {
-						filterOp = FILTER_NOT_EQUAL
+
+				if newOp != 0 {
+					if filterOp == FILTER_NOT_EQUAL && newOp != FILTER_NOT_IN {
+						return NewXRError("bad_filter",
+							info.OriginalRequest.URL.RequestURI(),
+							"value="+expr,
+							"error_detail=\"!=\" is not allowed with "+
+								fn+"()")
 					}
-				} else {
-					path, value, found = strings.Cut(expr, "<=")
-					if found {
-						filterOp = FILTER_LESS_EQUAL
-					} else {
-						path, value, found = strings.Cut(expr, ">=")
-						if found {
-							filterOp = FILTER_GREATER_EQUAL
-						} else {
-							path, value, found = strings.Cut(expr, "<")
-							if found {
-								filterOp = FILTER_LESS
-							} else {
-								path, value, found = strings.Cut(expr, ">")
-								if found {
-									filterOp = FILTER_GREATER
-								} else {
-									path, value, found = strings.Cut(expr, "=")
-									if found {
-										if value == "null" {
-											filterOp = FILTER_ABSENT
-										} else {
-											filterOp = FILTER_EQUAL
-										}
-									}
-									// No operator means FILTER_PRESENT
-								}
-							}
-						}
+					if args == "" {
+						return NewXRError("bad_filter",
+							info.OriginalRequest.URL.RequestURI(),
+							"value="+expr,
+							"error_detail="+fn+"() must have a value")
 					}
+					filterOp = newOp
+					value = args
 				}
 			}
 
+			if xErr := info.CheckFilterOp(expr, filterOp, caseSensitive,
+				value); xErr != nil {
+				return xErr
+			}
+
+			// RESOURCE#/json/pointer means look inside the document
+			path, docPtr, isDoc := strings.Cut(path, "#")
+			if isDoc && docPtr != "" && docPtr[0] != '/' {
+				return NewXRError("bad_filter",
+					info.OriginalRequest.URL.RequestURI(),
+					"value="+expr,
+					"error_detail=a document pointer must be empty or "+
+						"start with \"/\"")
+			}
+
 			pp, err := PropPathFromUI(path)
 			if err != nil {
 				return NewXRError("bad_filter",
@@ -548,16 +769,35 @@ func (info *RequestInfo) ParseFilters() *XRError {
 
 			absPP, newPP := SplitProp(info.Registry, pp)
 
+			if isDoc && !IsDocFilterTarget(info.Registry, absPP, newPP) {
+				return NewXRError("bad_filter",
+					info.OriginalRequest.URL.RequestURI(),
+					"value="+expr,
+					"error_detail=\"#\" is only allowed after the "+
+						"document attribute of a Resource or Version")
+			}
+
 			filter := &FilterExpr{
-				PP:       newPP,
-				Path:     path,
-				Value:    value,
-				Operator: filterOp,
+				PP:            newPP,
+				Path:          path,
+				Value:         value,
+				Operator:      filterOp,
+				Values:        values,
+				CaseSensitive: caseSensitive,
+				IsDoc:         isDoc,
+				DocPointer:    docPtr,
+
+				IsDeprecationState: absPP.Len() > 0 && newPP.Len() == 1 &&
+					newPP.Top() == DEPRECATION_STATE,
 
 				Abstract: absPP.Abstract(),
 				PropName: newPP.DB(),
 			}
 
+			if isDoc {
+				filter.PropName = docPtr
+			}
+
 			if AndFilters == nil {
 				AndFilters = []*FilterExpr{}
 			}
@@ -574,6 +814,52 @@ func (info *RequestInfo) ParseFilters() *XRError {
 	return nil
 }
 
+// CheckFilterOp makes sure that any non-spec filter operator being used is
+// enabled via the "filteroperators" capability, and that its value is ok.
+func (info *RequestInfo) CheckFilterOp(expr string, op int, cs bool,
+	value string) *XRError {
+
+	capName := ""
+	switch op {
+	case FILTER_REGEX:
+		capName = "regex"
+	case FILTER_PREFIX:
+		capName = "prefix"
+	case FILTER_IN, FILTER_NOT_IN:
+		capName = "in"
+	case FILTER_CONTAINS:
+		capName = "contains"
+	case FILTER_HASKEY:
+		capName = "haskey"
+	}
+
+	caps := info.Registry.Capabilities
+	for _, name := range []string{capName, "casesensitive"} {
+		if name == "" || (name == "casesensitive" && !cs) {
+			continue
+		}
+		if !caps.FilterOperatorEnabled(name) {
+			return NewXRError("bad_filter",
+				info.OriginalRequest.URL.RequestURI(),
+				"value="+expr,
+				"error_detail=the \""+name+"\" filter operator is "+
+					"not enabled")
+		}
+	}
+
+	// No Tx means we're not going to run the query (e.g. the UI proxy)
+	if op == FILTER_REGEX && info.tx != nil {
+		if err := CheckRegex(info.tx, value, cs); err != nil {
+			return NewXRError("bad_filter",
+				info.OriginalRequest.URL.RequestURI(),
+				"value="+expr,
+				"error_detail=invalid regular expression: "+err.Error())
+		}
+	}
+
+	return nil
+}
+
 // pp == PP for full DB path of attribute we're looking for
 // (group.resource.attrPath) e.g abstractPP + propNamePP
 // Look at it and remove any Group or Resource type names
@@ -622,6 +908,14 @@ func (info *RequestInfo) ParseRegistryURL() *XRError {
 				info.OriginalRequest.URL.RequestURI()).
 				SetDetail(xErr.GetTitle())
 		}
+		if reg == nil && rest == "" && IsScratchRegistry(name) &&
+			info.OriginalRequest.Method == "PUT" {
+			// The rest of the PUT is handled like a PUT of its root
+			if reg, xErr = NewRegistry(info.tx, name); xErr != nil {
+				return xErr
+			}
+			info.StatusCode = http.StatusCreated
+		}
 		if reg == nil {
 			name = "/reg-" + name
 			return NewXRError("not_found", name).
@@ -757,34 +1051,14 @@ func (info *RequestInfo) ParseRequestURL() *XRError {
 		}
 
 		sortStr := info.GetFlag("sort")
-		name, ascDesc, _ := strings.Cut(sortStr, "=")
-		if name == "" {
-			return NewXRError("bad_sort",
-				info.OriginalRequest.URL.RequestURI(),
-				"value="+sortStr,
-				"error_detail=missing \"sort\" attribute name")
-		}
-		if ascDesc != "" && ascDesc != "asc" && ascDesc != "desc" {
-			return NewXRError("bad_sort",
-				info.OriginalRequest.URL.RequestURI(),
-				"value="+sortStr,
-				"error_detail="+
-					fmt.Sprintf("invalid \"sort\" order %q", ascDesc))
-		}
-		// info.SortKey = name
-		pp, err := PropPathFromUI(name)
+		keys, err := ParseSortKeys(sortStr)
 		if err != nil {
 			return NewXRError("bad_sort",
 				info.OriginalRequest.URL.RequestURI(),
 				"value="+sortStr,
-				"error_detail="+
-					fmt.Sprintf("bad attribute name(%s): %s",
-						name, err.Error()))
-		}
-		info.SortKey = pp.DB()
-		if ascDesc == "desc" {
-			info.SortKey = "-" + info.SortKey
+				"error_detail="+err.Error())
 		}
+		info.SortKeys = keys
 	}
 
 	if sv := info.GetFlag("specversion"); sv != "" {
@@ -806,9 +1080,101 @@ func (info *RequestInfo) ParseRequestURL() *XRError {
 		}
 	}
 
+	if xErr := info.ParseFields(); xErr != nil {
+		return xErr
+	}
+
+	if xErr := info.ParseFacets(); xErr != nil {
+		return xErr
+	}
+
 	return info.ParseFilters()
 }
 
+// ParseFields processes ?fields=attr[,attr...]. Like ?inline and ?filter,
+// each value is relative to the entity being retrieved and can include
+// the collection names to reach deeper levels, e.g. on /dirs:
+//
+//	?fields=name,files.fileid,files.versions.labels.owner
+//
+// The results are stored by the abstract entity type they apply to.
+// Types that aren't mentioned are serialized in full. "id" is a shorthand
+// for the entity's SINGULARid attribute.
+func (info *RequestInfo) ParseFields() *XRError {
+	for _, value := range info.GetFlagValues("fields") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+
+			pp, err := PropPathFromUI(field)
+			if err == nil && pp.HasWild {
+				err = fmt.Errorf("wildcards aren't allowed")
+			}
+			if err != nil {
+				return NewXRError("bad_fields",
+					info.OriginalRequest.URL.RequestURI(),
+					"value="+field,
+					"error_detail="+err.Error())
+			}
+
+			if info.Abstract != "" {
+				absPP, _ := PropPathFromPath(info.Abstract)
+				pp = absPP.Append(pp)
+			}
+
+			absPP, attrPP := SplitProp(info.Registry, pp)
+			if attrPP.Len() == 0 {
+				return NewXRError("bad_fields",
+					info.OriginalRequest.URL.RequestURI(),
+					"value="+field,
+					"error_detail=missing an attribute name")
+			}
+
+			if attrPP.Top() == "id" {
+				idPP := NewPPP(singularForAbstract(info.Registry, absPP) + "id")
+				if attrPP.Len() > 1 {
+					idPP = idPP.Append(attrPP.Next())
+				}
+				attrPP = idPP
+			}
+
+			if info.Fields == nil {
+				info.Fields = map[string][]*PropPath{}
+			}
+			abs := absPP.Abstract()
+			info.Fields[abs] = append(info.Fields[abs], attrPP)
+		}
+	}
+	return nil
+}
+
+// singularForAbstract returns the singular name of the entity type that
+// absPP (as returned by SplitProp) points to
+func singularForAbstract(reg *Registry, absPP *PropPath) string {
+	if absPP.Len() == 0 {
+		return "registry"
+	}
+	gm := reg.Model.FindGroupModel(absPP.Parts[0].Text)
+	if absPP.Len() == 1 {
+		return gm.Singular
+	}
+	if absPP.Len() == 3 && absPP.Parts[2].Text == "versions" {
+		return "version"
+	}
+	return gm.Resources[absPP.Parts[1].Text].Singular
+}
+
+// FieldsFor returns the ?fields= attributes to show for entities of
+// the "abstract" type, or nil if all of them should be shown
+func (info *RequestInfo) FieldsFor(abstract string) []*PropPath {
+	if info.Fields == nil {
+		return nil
+	}
+	return info.Fields[abstract]
+}
+
 func (info *RequestInfo) ParseRequestPath() *XRError {
 	// Now process the URL path
 	log.VPrintf(4, "ParseRequestPath: %q", info.OriginalPath)
@@ -883,6 +1249,16 @@ func (info *RequestInfo) ParseRequestPath() *XRError {
 		return nil
 	}
 
+	// /GROUPs/gID/changerequests[/crID[/approve|reject]]
+	if info.Parts[2] == CHANGEREQUESTS && gModel.Approval != nil {
+		info.ChangeRequests = true
+		info.What = "Entity"
+		if len(info.Parts) == 3 {
+			info.What = "Coll"
+		}
+		return nil
+	}
+
 	// /GROUPs/gID/RESOURCEs
 	if strings.HasSuffix(info.Parts[2], "$details") {
 		return NewXRError("bad_details", info.GetParts(3))
@@ -1112,6 +1488,22 @@ func (info *RequestInfo) GetAllowedMethods() []string {
 		if info.IsAvailable(".xregistry") {
 			methods = append(methods, "GET")
 		}
+	} else if rootPath == "ratelimits" {
+		if info.IsAvailable("ratelimits") {
+			methods = append(methods, "GET")
+		}
+	} else if info.ChangeRequests {
+		// /GROUPS/gID/changerequests[/crID[/approve|reject]]
+		if numParts < 5 {
+			methods = append(methods, "GET")
+		}
+		if info.IsAvailableMutable("entities") {
+			if numParts == 4 {
+				methods = append(methods, "DELETE")
+			} else if numParts == 5 {
+				methods = append(methods, "POST")
+			}
+		}
 	} else if info.IsAvailable("entities") {
 		// Standard entity endpoints
 		isMutable := info.IsAvailableMutable("entities")
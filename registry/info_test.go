@@ -2,6 +2,7 @@ package registry
 
 import (
 	// "maps"
+	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
@@ -96,3 +97,107 @@ func TestInfoIgnore(t *testing.T) {
 		XEqual(t, "URL: "+test.URL, info.Ignores, test.exp)
 	}
 }
+
+func TestInfoCutFilterOp(t *testing.T) {
+	for _, test := range []struct {
+		expr  string
+		path  string
+		op    int
+		cs    bool
+		value string
+	}{
+		{"name", "name", FILTER_PRESENT, false, ""},
+		{"name=bob", "name", FILTER_EQUAL, false, "bob"},
+		{"name==bob", "name", FILTER_EQUAL, true, "bob"},
+		{"name!=bob", "name", FILTER_NOT_EQUAL, false, "bob"},
+		{"name!==bob", "name", FILTER_NOT_EQUAL, true, "bob"},
+		{"name<>bob", "name", FILTER_NOT_EQUAL, false, "bob"},
+		{"count<3", "count", FILTER_LESS, false, "3"},
+		{"count<=3", "count", FILTER_LESS_EQUAL, false, "3"},
+		{"count>3", "count", FILTER_GREATER, false, "3"},
+		{"count>=3", "count", FILTER_GREATER_EQUAL, false, "3"},
+		{"name~=^b.*$", "name", FILTER_REGEX, false, "^b.*$"},
+		{"name~==^b", "name", FILTER_REGEX, true, "^b"},
+		{"name^=bo", "name", FILTER_PREFIX, false, "bo"},
+		{"name^==Bo", "name", FILTER_PREFIX, true, "Bo"},
+		{"name=a<b", "name", FILTER_EQUAL, false, "a<b"},
+		{"name<a=b", "name", FILTER_LESS, false, "a=b"},
+		{"name=in(a,b)", "name", FILTER_EQUAL, false, "in(a,b)"},
+		{"file#/a~0b=c", "file#/a~0b", FILTER_EQUAL, false, "c"},
+	} {
+		path, op, cs, value := CutFilterOp(test.expr)
+		if path != test.path || op != test.op || cs != test.cs ||
+			value != test.value {
+			t.Fatalf("%q: expected %q/%d/%v/%q, got %q/%d/%v/%q", test.expr,
+				test.path, test.op, test.cs, test.value, path, op, cs, value)
+		}
+	}
+}
+
+func TestInfoSplitFilterExprs(t *testing.T) {
+	for _, test := range []struct {
+		filter string
+		exp    []string
+	}{
+		{"", []string{""}},
+		{"a", []string{"a"}},
+		{"a,b=c", []string{"a", "b=c"}},
+		{"a=in(1,2),b", []string{"a=in(1,2)", "b"}},
+		{"a=in(1,2", []string{"a=in(1,2"}},
+		{"a=x),b", []string{"a=x)", "b"}},
+	} {
+		got := SplitFilterExprs(test.filter)
+		if strings.Join(got, "|") != strings.Join(test.exp, "|") ||
+			len(got) != len(test.exp) {
+			t.Fatalf("%q: expected %q, got %q", test.filter, test.exp, got)
+		}
+	}
+}
+
+func TestInfoParseSortKeys(t *testing.T) {
+	for _, test := range []struct {
+		sort string
+		exp  string // path/desc/nullsfirst;...
+		err  string
+	}{
+		{"name", "name,/f/t", ""},
+		{"name=asc", "name,/f/t", ""},
+		{"name=desc", "name,/t/f", ""},
+		{"-name", "name,/t/f", ""},
+		{"name=nullslast", "name,/f/f", ""},
+		{"-name=nullsfirst", "name,/t/t", ""},
+		{"name=desc:nullsfirst", "name,/t/t", ""},
+		{"labels.team,-modifiedat,name",
+			"labels,team,/f/t;modifiedat,/t/f;name,/f/t", ""},
+		{"strs[1]=desc", "strs,#1,/t/f", ""},
+
+		{"", "", `missing "sort" attribute name`},
+		{"name,", "", `missing "sort" attribute name`},
+		{"-", "", `missing "sort" attribute name`},
+		{"name=up", "", `invalid "sort" order "up"`},
+		{"-name=asc", "", `"-" and "=asc" can't both be used on "name"`},
+		{"name=asc:nulls", "", `invalid "sort" null ordering "nulls"`},
+		{"name=nullsfirst:desc", "", `invalid "sort" order "nullsfirst"`},
+		{"a.*", "", `bad attribute name(a.*): wildcards aren't allowed`},
+	} {
+		keys, err := ParseSortKeys(test.sort)
+		if test.err != "" || err != nil {
+			if err == nil || err.Error() != test.err {
+				t.Fatalf("%q: expected err %q, got %v", test.sort, test.err,
+					err)
+			}
+			continue
+		}
+
+		got := []string{}
+		for _, key := range keys {
+			got = append(got, fmt.Sprintf("%s/%s/%s", key.PP.DB(),
+				map[bool]string{true: "t", false: "f"}[key.Desc],
+				map[bool]string{true: "t", false: "f"}[key.NullsFirst]))
+		}
+		if strings.Join(got, ";") != test.exp {
+			t.Fatalf("%q: expected %q, got %q", test.sort, test.exp,
+				strings.Join(got, ";"))
+		}
+	}
+}
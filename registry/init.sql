@@ -64,6 +64,8 @@ BEGIN
     DELETE FROM Models   WHERE RegistrySID=OLD.SID $$
     DELETE FROM Props WHERE RegSID=OLD.SID $$
     DELETE FROM Entities  WHERE RegSID=OLD.SID $$
+    DELETE FROM ArchivedVersions WHERE RegistrySID=OLD.SID $$
+    DELETE FROM ChangeRequests WHERE RegistrySID=OLD.SID $$
 END ;
 
 CREATE TABLE Models (
@@ -135,6 +137,7 @@ CREATE TRIGGER GroupTrigger BEFORE DELETE ON "Groups"
 FOR EACH ROW
 BEGIN
     DELETE FROM Resources WHERE GroupSID=OLD.SID $$
+    DELETE FROM ChangeRequests WHERE GroupSID=OLD.SID $$
     DELETE FROM Props WHERE eSID=OLD.SID $$
     DELETE FROM Entities  WHERE eSID=OLD.SID $$
 END ;
@@ -274,15 +277,121 @@ CREATE TRIGGER VersionsTrigger BEFORE DELETE ON Versions
 FOR EACH ROW
 BEGIN
     DELETE FROM ResourceContents WHERE VersionSID=OLD.SID $$
+    DELETE FROM DocProps WHERE ContentSID=OLD.SID $$
     DELETE FROM Props WHERE eSID=OLD.SID $$
     DELETE FROM Entities  WHERE eSID=OLD.SID $$
 END ;
 
+# Documents are stored once, by content hash, no matter how many Versions
+# use them (see blobs.go). RefCount is maintained by the ResourceContents
+# triggers below and a Blob is deleted once nothing references it.
+CREATE TABLE Blobs (
+    Digest          VARCHAR(80) NOT NULL,   # sha256:<hex> of the document
+    Content         LONGBLOB,               # Only for Store="db"
+    Size            BIGINT NOT NULL,
+    RefCount        INT NOT NULL DEFAULT 0,
+    Store           VARCHAR(16) NOT NULL DEFAULT 'db',  # See blobstore.go
+
+    PRIMARY KEY (Digest),
+    INDEX (Store)
+);
+
+# Documents of external (non-"db") stores whose Blob is gone but that still
+# need to be deleted from the store, see SweepDeadBlobs()
+CREATE TABLE DeadBlobs (
+    Digest          VARCHAR(80) NOT NULL,
+    Store           VARCHAR(16) NOT NULL,
+
+    PRIMARY KEY (Digest, Store)
+);
+
+# Documents being written into an external store by a Tx that hasn't
+# finished yet. They're recorded (and committed) before the object is
+# written, so if the Tx rolls back, or we crash, SweepDeadBlobs() can still
+# find and delete it. See SaveDocument()
+CREATE TABLE PendingBlobs (
+    Digest          VARCHAR(80) NOT NULL,
+    Store           VARCHAR(16) NOT NULL,
+
+    PRIMARY KEY (Digest, Store)
+);
+
+CREATE TRIGGER BlobsDelete AFTER DELETE ON Blobs
+FOR EACH ROW
+BEGIN
+    INSERT IGNORE INTO DeadBlobs(Digest, Store)
+        SELECT OLD.Digest, OLD.Store FROM DUAL WHERE OLD.Store<>'db' $$
+END ;
+
 CREATE TABLE ResourceContents (
     VersionSID      VARCHAR(255),
-    Content         MEDIUMBLOB,
+    Digest          VARCHAR(80) NOT NULL,   # Blobs.Digest
+
+    PRIMARY KEY (VersionSID),
+    INDEX (Digest)
+);
+
+# Don't use REPLACE on ResourceContents, the DELETE half could drop the
+# Blob that the INSERT half is about to reference
+CREATE TRIGGER ResourceContentsInsert AFTER INSERT ON ResourceContents
+FOR EACH ROW
+BEGIN
+    UPDATE Blobs SET RefCount=RefCount+1 WHERE Digest=NEW.Digest $$
+END ;
+
+CREATE TRIGGER ResourceContentsUpdate AFTER UPDATE ON ResourceContents
+FOR EACH ROW
+BEGIN
+    UPDATE Blobs SET RefCount=RefCount+1 WHERE Digest=NEW.Digest $$
+    UPDATE Blobs SET RefCount=RefCount-1 WHERE Digest=OLD.Digest $$
+    DELETE FROM Blobs WHERE Digest=OLD.Digest AND RefCount<=0 $$
+END ;
+
+CREATE TRIGGER ResourceContentsDelete AFTER DELETE ON ResourceContents
+FOR EACH ROW
+BEGIN
+    UPDATE Blobs SET RefCount=RefCount-1 WHERE Digest=OLD.Digest $$
+    DELETE FROM Blobs WHERE Digest=OLD.Digest AND RefCount<=0 $$
+END ;
+
+# Versions removed by a Resource model's "retention" policy when its
+# "archive" flag is set (see retention.go). Not tied to the Versions table
+# so they survive the deletion of the Version (and its Resource/Group).
+CREATE TABLE ArchivedVersions (
+    SID             VARCHAR(64) NOT NULL,
+    RegistrySID     VARCHAR(64) NOT NULL,
+    Path            VARCHAR(255) NOT NULL COLLATE utf8mb4_bin,
+    ArchivedAt      VARCHAR(255) NOT NULL,
+    Object          MEDIUMTEXT,             # Version's attributes as JSON
+    Content         MEDIUMBLOB,             # Version's document, if any
+
+    PRIMARY KEY (SID),
+    INDEX (RegistrySID, Path)
+);
+
+# Pending (and closed) writes to Groups whose model has an "approval"
+# policy (see changerequest.go). "Object" is the whole ChangeRequest as JSON.
+CREATE TABLE ChangeRequests (
+    SID             VARCHAR(64) NOT NULL,   # System ID
+    UID             VARCHAR(64) NOT NULL,   # "1", "2", ... per Group
+    RegistrySID     VARCHAR(64) NOT NULL,
+    GroupSID        VARCHAR(64) NOT NULL,
+    Object          MEDIUMTEXT NOT NULL,
+
+    PRIMARY KEY (SID),
+    UNIQUE INDEX (GroupSID, UID)
+);
+
+# Flattened copy of each document in ResourceContents, one row per node,
+# keyed by its JSON Pointer. Maintained by IndexDocument() (docindex.go)
+# so ?filter=RESOURCE#/json/pointer=value never needs to parse the blobs.
+CREATE TABLE DocProps (
+    ContentSID      VARCHAR(64) NOT NULL,   # ResourceContents.VersionSID
+    Pointer         VARCHAR(512) NOT NULL COLLATE utf8mb4_bin,
+    PropValue       MEDIUMTEXT NULL,
+    PropType        CHAR(64) NOT NULL,      # string, boolean, object, ...
 
-    PRIMARY KEY (VersionSID)
+    PRIMARY KEY (ContentSID, Pointer)
 );
 
 CREATE TABLE Props (
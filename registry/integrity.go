@@ -0,0 +1,171 @@
+// Package registry - Version document digests and signatures.
+//
+// Every stored document has a sha256 digest (see blobs.go) that is
+// returned in the "Repr-Digest" and "Digest" headers of its GETs, and as
+// the read-only "digest" ("sha256:<hex>") attribute of its Version. When a
+// Resource model has an "integrity" policy its Versions also get:
+//
+//	signature      - base64 Ed25519 signature of the "digest" string
+//	signaturekeyid - which key made the signature
+//
+// A signature can come from the client, in which case it's verified against
+// the policy's "trustedkeys" before the document is stored, or from the
+// server itself when "sign" is true and the server was started with a
+// signing key (see SetSigningKey). Changing the document drops any
+// signature that doesn't match it.
+package registry
+
+import (
+	"crypto/ed25519"
+	"fmt"
+
+	. "github.com/xregistry/server/common"
+)
+
+// The server's own key, used when a policy has "sign": true
+var SigningKey ed25519.PrivateKey
+var SigningKeyID string
+
+// SetSigningKey sets the key the server uses to sign documents. An empty
+// "id" means use the key's default ID (see PublicKeyID).
+func SetSigningKey(key ed25519.PrivateKey, id string) {
+	SigningKey = key
+	SigningKeyID = id
+	if key != nil && id == "" {
+		SigningKeyID = PublicKeyID(key.Public().(ed25519.PublicKey))
+	}
+}
+
+// DocumentBytes returns the form of a document that's stored, and
+// therefore what its digest is based on
+func DocumentBytes(val any) []byte {
+	buf, ok := val.([]byte)
+	if !ok {
+		buf = []byte(fmt.Sprintf("%v", val))
+	}
+	return buf
+}
+
+// FindTrustedKey returns the public key for "keyID", from either the
+// policy's "trustedkeys" or the server's own signing key
+func FindTrustedKey(policy *IntegrityPolicy, keyID string) (ed25519.PublicKey, error) {
+	if str, ok := policy.TrustedKeys[keyID]; ok {
+		return ParsePublicKey(str)
+	}
+	if SigningKey != nil && keyID == SigningKeyID {
+		return SigningKey.Public().(ed25519.PublicKey), nil
+	}
+	return nil, fmt.Errorf("key %q isn't trusted", keyID)
+}
+
+// UpdateIntegrity (re)calculates the Version's "digest" and, if there's
+// an "integrity" policy, decides what its "signature" should be. Called as
+// the "digest" attribute's updateFn.
+func (e *Entity) UpdateIntegrity() *XRError {
+	if e.Type != ENTITY_VERSION {
+		return nil
+	}
+	rm := e.GetResourceModel()
+	if !rm.GetHasDocument() {
+		return nil
+	}
+	policy := rm.Integrity
+
+	oldDigest, _ := e.Object["digest"].(string)
+	oldSig, _ := e.Object["signature"].(string)
+	oldKeyID, _ := e.Object["signaturekeyid"].(string)
+
+	digest := oldDigest
+	if data, ok := e.NewObject[rm.Singular]; ok {
+		digest = ""
+		if !IsNil(data) {
+			digest = DocumentDigest(DocumentBytes(data))
+		}
+	} else if IsNil(e.NewObject["#contentid"]) {
+		// No document, or it's been replaced by a RESOURCEurl
+		digest = ""
+	} else if digest == "" {
+		// Document was stored before we kept digests as an attribute
+		if buf, ok := e.GetPP(NewPPP(rm.Singular)).([]byte); ok {
+			digest = DocumentDigest(buf)
+		}
+	}
+	docChanged := digest != oldDigest
+
+	if policy == nil {
+		e.NewObject["digest"] = nil
+		if digest != "" {
+			e.NewObject["digest"] = digest
+		}
+		return nil
+	}
+
+	sig, _ := e.NewObject["signature"].(string)
+	keyID, _ := e.NewObject["signaturekeyid"].(string)
+	unchanged := !docChanged && sig == oldSig && keyID == oldKeyID
+
+	if sig == "" && !docChanged {
+		// Like the document itself, not including it means keep it
+		sig, keyID = oldSig, oldKeyID
+		unchanged = true
+	} else if docChanged && sig == oldSig && keyID == oldKeyID {
+		// Left over from the old document (e.g. a PATCH), so drop it
+		sig, keyID = "", ""
+	}
+
+	if digest == "" {
+		sig, keyID = "", ""
+	} else if sig != "" && !unchanged {
+		if keyID == "" {
+			return NewXRError("signature_invalid", e.XID,
+				`error_detail="signaturekeyid" must be specified`)
+		}
+		pub, err := FindTrustedKey(policy, keyID)
+		if err == nil {
+			err = VerifyDigestSignature(pub, digest, sig)
+		}
+		if err != nil {
+			return NewXRError("signature_invalid", e.XID,
+				"error_detail="+err.Error())
+		}
+	} else if sig == "" {
+		keyID = ""
+		if policy.Sign && SigningKey != nil {
+			sig, keyID = SignDigest(SigningKey, digest), SigningKeyID
+		} else if policy.RequireSignature && docChanged {
+			return NewXRError("signature_required", e.XID)
+		}
+	}
+
+	for name, val := range map[string]string{
+		"digest":         digest,
+		"signature":      sig,
+		"signaturekeyid": keyID,
+	} {
+		if val == "" {
+			e.NewObject[name] = nil
+		} else {
+			e.NewObject[name] = val
+		}
+	}
+
+	return nil
+}
+
+// GetDocumentDigest returns the digest of the entity's stored document,
+// or "" if it doesn't have one
+func (e *Entity) GetDocumentDigest() string {
+	contentID := e.Get("#contentid")
+	if IsNil(contentID) {
+		return ""
+	}
+
+	results := Query(e.tx, `
+        SELECT Digest FROM ResourceContents WHERE VersionSID=?`, contentID)
+	defer results.Close()
+
+	if row := results.NextRow(); row != nil {
+		return NotNilString(row[0])
+	}
+	return ""
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"path"
 	"strings"
@@ -283,6 +282,16 @@ func (jw *JsonWriter) WriteEntity() *XRError {
 			return nil
 		}
 
+		// ?fields= - skip, or trim, the attributes that weren't asked for.
+		// Links to nested entities, and inlined data, are always shown
+		if fields := info.FieldsFor(e.Abstract); fields != nil &&
+			!ArrayContains(alwaysShownFields, key) {
+			var ok bool
+			if val, ok = ProjectField(key, val, fields); !ok {
+				return nil
+			}
+		}
+
 		// "RESOURCE" has a special serialization func
 		if e.Type == ENTITY_RESOURCE || e.Type == ENTITY_VERSION {
 			rm := e.GetResourceModel()
@@ -292,7 +301,10 @@ func (jw *JsonWriter) WriteEntity() *XRError {
 		}
 
 		if addSpace {
-			jw.Printf("%s\n", extra)
+			// With ?fields= we might not have printed anything yet
+			if extra != "" {
+				jw.Printf("%s\n", extra)
+			}
 			extra = ""
 			addSpace = false
 		}
@@ -488,16 +500,14 @@ func SerializeResourceContents(jw *JsonWriter, e *Entity, info *RequestInfo, ext
 	}
 
 	if url := jw.Entity.GetAsString(singular + "proxyurl"); url != "" {
-		resp, err := http.Get(url)
-		if err != nil {
-			data = []byte("GET error:" + err.Error())
-		} else if resp.StatusCode/100 != 2 {
-			data = []byte("GET error:" + resp.Status)
+		doc, xErr := FetchProxyDocument(jw.Entity, url)
+		if xErr != nil {
+			data = []byte("GET error:" + xErr.GetTitle())
+		} else if doc.StatusCode/100 != 2 {
+			data = []byte(fmt.Sprintf("GET error:%d %s", doc.StatusCode,
+				http.StatusText(doc.StatusCode)))
 		} else {
-			data, err = io.ReadAll(resp.Body)
-			if err != nil {
-				data = []byte("GET error:" + err.Error())
-			}
+			data = doc.Body
 		}
 	}
 
@@ -643,6 +653,76 @@ func (jw *JsonWriter) WriteEmptyCollection(hasXref bool, extra string, eType int
 	return extra
 }
 
+// Attributes that ?fields= never removes
+var alwaysShownFields = []string{"metaurl", "capabilities", "model",
+	"modelsource"}
+
+// ProjectField returns the part of attribute "key"'s value that was
+// asked for via ?fields=, where "fields" are the field paths for the
+// entity's level. A field of just "key" means the entire value. Returns
+// false if none of it was asked for.
+func ProjectField(key string, val any, fields []*PropPath) (any, bool) {
+	subs := []*PropPath{}
+	for _, field := range fields {
+		if field.Top() != key || field.Parts[0].Index != NO_INDEX {
+			continue
+		}
+		if field.Len() == 1 {
+			return val, true
+		}
+		subs = append(subs, field.Next())
+	}
+	if len(subs) == 0 {
+		return nil, false
+	}
+	return projectValue(val, subs)
+}
+
+func projectValue(val any, pps []*PropPath) (any, bool) {
+	for _, pp := range pps {
+		if pp.Len() == 0 {
+			return val, true
+		}
+	}
+
+	switch daVal := val.(type) {
+	case map[string]any:
+		res := map[string]any{}
+		for _, pp := range pps {
+			key := pp.Top()
+			if _, ok := res[key]; ok {
+				continue
+			}
+			if subVal, ok := daVal[key]; ok {
+				if newVal, ok := ProjectField(key, subVal, pps); ok {
+					res[key] = newVal
+				}
+			}
+		}
+		return res, len(res) > 0
+
+	case []any:
+		res := []any{}
+		for i, subVal := range daVal {
+			subs := []*PropPath{}
+			for _, pp := range pps {
+				if pp.Parts[0].Index == i {
+					subs = append(subs, pp.Next())
+				}
+			}
+			if len(subs) == 0 {
+				continue
+			}
+			if newVal, ok := projectValue(subVal, subs); ok {
+				res = append(res, newVal)
+			}
+		}
+		return res, len(res) > 0
+	}
+
+	return nil, false
+}
+
 func Path2Abstract(path string) string {
 	parts := strings.Split(path, "/")
 	addSlash := strings.HasSuffix(path, "/")
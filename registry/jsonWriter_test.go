@@ -0,0 +1,46 @@
+package registry
+
+import (
+	"testing"
+
+	. "github.com/xregistry/server/common"
+)
+
+func TestProjectField(t *testing.T) {
+	labels := map[string]any{"owner": "me", "env": "prod"}
+	arr := []any{"a", map[string]any{"x": 1, "y": 2}, "c"}
+
+	for _, test := range []struct {
+		key    string
+		val    any
+		fields []string
+		exp    string // ToJSONOneLine of result, "" means not shown
+	}{
+		{"labels", labels, []string{"labels"}, `{"env":"prod","owner":"me"}`},
+		{"labels", labels, []string{"labels.owner"}, `{"owner":"me"}`},
+		{"labels", labels, []string{"labels.owner", "labels"},
+			`{"env":"prod","owner":"me"}`},
+		{"labels", labels, []string{"labels.none"}, ``},
+		{"labels", labels, []string{"name"}, ``},
+		{"name", "bob", []string{"name", "labels.owner"}, `"bob"`},
+		{"name", "bob", []string{"name.first"}, ``},
+		{"arr", arr, []string{"arr[2]", "arr[0]"}, `["a","c"]`},
+		{"arr", arr, []string{"arr[1].y"}, `[{"y":2}]`},
+		{"arr", arr, []string{"arr[5]"}, ``},
+	} {
+		fields := []*PropPath{}
+		for _, f := range test.fields {
+			fields = append(fields, MustPropPathFromUI(f))
+		}
+
+		val, ok := ProjectField(test.key, test.val, fields)
+		got := ""
+		if ok {
+			got = ToJSONOneLine(val)
+		}
+		if got != test.exp {
+			t.Fatalf("%s %v: expected %q, got %q", test.key, test.fields,
+				test.exp, got)
+		}
+	}
+}
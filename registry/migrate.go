@@ -0,0 +1,466 @@
+// Package registry - data migrations.
+//
+// A model update (e.g. PUT /modelsource) can carry a "$migrate" array, at
+// the top level of the model, that changes the data already in the
+// Registry so that it matches the new model. For example, when an extension
+// attribute is renamed. Each entry is one operation on one attribute of
+// every entity of one type:
+//
+//	{ "op": "rename", "target": "/dirs", "attribute": "owner", "to": "maintainer" }
+//	{ "op": "move", "target": "/dirs/files/versions", "attribute": "size", "to": "stats" }
+//	{ "op": "default", "target": "/dirs", "attribute": "region", "value": "us" }
+//	{ "op": "map", "target": "/dirs", "attribute": "tier", "values": { "1": "gold" } }
+//	{ "op": "delete", "target": "/", "attribute": "legacy" }
+//
+// "target" is one of "/", "/GROUPS", "/GROUPS/RESOURCES",
+// "/GROUPS/RESOURCES/versions" or "/GROUPS/RESOURCES/meta", and "attribute"
+// (and "to") can reference nested attributes, e.g. "stats.size".
+//
+// The operations are run, in order, after the new model is saved but
+// before the data is verified against it, all in the same Tx. Each entity
+// that's changed gets its epoch bumped like any other update. "$migrate"
+// isn't part of the model, so it's not kept in the model's source.
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	. "github.com/xregistry/server/common"
+)
+
+const (
+	MIGRATE_RENAME  = "rename"  // rename "attribute" to "to"
+	MIGRATE_MOVE    = "move"    // move "attribute" into the "to" object
+	MIGRATE_DEFAULT = "default" // set "attribute" to "value" if missing
+	MIGRATE_MAP     = "map"     // change "attribute" per the "values" map
+	MIGRATE_DELETE  = "delete"  // remove "attribute"
+)
+
+var MigrateOps = []string{MIGRATE_RENAME, MIGRATE_MOVE, MIGRATE_DEFAULT,
+	MIGRATE_MAP, MIGRATE_DELETE}
+
+type MigrateOp struct {
+	Op        string         `json:"op"`
+	Target    string         `json:"target"`
+	Attribute string         `json:"attribute"`
+	To        string         `json:"to,omitempty"`
+	Value     any            `json:"value,omitempty"`
+	Values    map[string]any `json:"values,omitempty"`
+
+	abstract string    // Target in Entity.Abstract form, e.g. "dirs,files"
+	attrPP   *PropPath // Attribute
+	toPP     *PropPath // Where "attribute" ends up for rename/move
+}
+
+type Migration []*MigrateOp
+
+// MigratedEntity is the summary of what a Migration did to one entity
+type MigratedEntity struct {
+	XID        string   `json:"xid"`
+	Epoch      int      `json:"epoch"`      // the entity's new epoch
+	Attributes []string `json:"attributes"` // attributes that were changed
+}
+
+// RemoveMigration pulls the "$migrate" array out of the model source in
+// "buf" and returns the source without it. If there's no "$migrate" then
+// "buf" is returned as is, otherwise the source is reformatted, which means
+// any comments in it are lost.
+func RemoveMigration(buf []byte) ([]byte, Migration, *XRError) {
+	// Quick check so we don't reformat the source when there's no need
+	if !bytes.Contains(buf, []byte(`"$migrate"`)) {
+		return buf, nil, nil
+	}
+
+	obj, err := ParseJSONToObject(RemoveComments(buf))
+	if err != nil {
+		return nil, nil, NewXRError("model_error", "/model",
+			"error_detail="+
+				fmt.Sprintf("error parsing JSON: %s", err))
+	}
+
+	ordered, ok := obj.(*OrderedMap)
+	if !ok {
+		return buf, nil, nil
+	}
+
+	val, ok := ordered.Values["$migrate"]
+	if !ok {
+		return buf, nil, nil
+	}
+
+	delete(ordered.Values, "$migrate")
+	for i, v := range ordered.Keys {
+		if v == "$migrate" {
+			ordered.Keys = append(ordered.Keys[:i], ordered.Keys[i+1:]...)
+			break
+		}
+	}
+
+	migration := Migration{}
+	tmp, _ := json.Marshal(val)
+	if err := Unmarshal(tmp, &migration); err != nil {
+		return nil, nil, NewXRError("model_error", "/model",
+			"error_detail="+
+				fmt.Sprintf("error parsing \"$migrate\": %s", err))
+	}
+
+	buf, err = json.MarshalIndent(ordered, "", "  ")
+	if err != nil {
+		return nil, nil, NewXRError("model_error", "/model",
+			"error_detail="+
+				fmt.Sprintf("error generating JSON: %s", err))
+	}
+
+	return buf, migration, nil
+}
+
+// Verify checks each operation against the (new) model "m" and gets it
+// ready to be applied
+func (mig Migration) Verify(m *Model) *XRError {
+	for i, op := range mig {
+		fail := func(format string, args ...any) *XRError {
+			return NewXRError("model_error", "/model",
+				"error_detail="+
+					fmt.Sprintf("\"$migrate\"[%d]: ", i)+
+					fmt.Sprintf(format, args...))
+		}
+
+		if op == nil {
+			return fail("must be an object")
+		}
+
+		if !ArrayContains(MigrateOps, op.Op) {
+			return fail("\"op\" must be one of: %s",
+				strings.Join(MigrateOps, ", "))
+		}
+
+		xidType, err := ParseXidType(op.Target)
+		if err != nil {
+			return fail("\"target\" isn't valid: %s", err)
+		}
+		parts := []string{}
+		if xidType.Group != "" {
+			parts = append(parts, xidType.Group)
+			if m.FindGroupModel(xidType.Group) == nil {
+				return fail("\"target\" references an unknown Group "+
+					"type %q", xidType.Group)
+			}
+		}
+		if xidType.Resource != "" {
+			parts = append(parts, xidType.Resource)
+			if m.FindResourceModel(xidType.Group, xidType.Resource) == nil {
+				return fail("\"target\" references an unknown Resource "+
+					"type %q", "/"+xidType.Group+"/"+xidType.Resource)
+			}
+		}
+		if xidType.Version != "" {
+			parts = append(parts, xidType.Version)
+		}
+		op.abstract = strings.Join(parts, string(DB_IN))
+
+		if op.attrPP, err = migratePath(op.Attribute); err != nil {
+			return fail("\"attribute\" isn't valid: %s", err)
+		}
+
+		switch op.Op {
+		case MIGRATE_RENAME, MIGRATE_MOVE:
+			if op.To == "" {
+				return fail("\"to\" must be specified for %q", op.Op)
+			}
+			if op.toPP, err = migratePath(op.To); err != nil {
+				return fail("\"to\" isn't valid: %s", err)
+			}
+			if op.Op == MIGRATE_MOVE {
+				op.toPP = op.toPP.P(op.attrPP.Last().Text)
+			}
+			if op.toPP.HasPrefix(op.attrPP) || op.attrPP.HasPrefix(op.toPP) {
+				return fail("\"to\" and \"attribute\" can't overlap")
+			}
+		case MIGRATE_DEFAULT:
+			if IsNil(op.Value) {
+				return fail("\"value\" must be specified for %q", op.Op)
+			}
+		case MIGRATE_MAP:
+			if len(op.Values) == 0 {
+				return fail("\"values\" must be specified for %q", op.Op)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migratePath parses an attribute reference from a "$migrate" operation.
+// The data the server manages (e.g. "epoch", "xid") can't be migrated.
+func migratePath(str string) (*PropPath, error) {
+	if strings.TrimSpace(str) == "" {
+		return nil, fmt.Errorf("can't be empty")
+	}
+
+	pp, err := PropPathFromUI(str)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range pp.Parts {
+		if part.IsIndex() || part.IsWild {
+			return nil, fmt.Errorf("%q can't reference an array or use "+
+				"wildcards", str)
+		}
+	}
+
+	top := pp.Top()
+	sp := SpecProps[top]
+	if top[0] == '#' || (sp != nil && (sp.ReadOnly || sp.Immutable)) ||
+		ArrayContains([]string{"createdat", "modifiedat", "xref"}, top) {
+		return nil, fmt.Errorf("%q can't be migrated", top)
+	}
+
+	return pp, nil
+}
+
+// Migrate applies "mig" to all of the entities in the Registry and returns
+// the ones that were changed. Changed entities are saved, except for the
+// Registry itself which is left for the caller to validate and save since
+// it might have other pending changes.
+func (reg *Registry) Migrate(mig Migration) ([]*MigratedEntity, *XRError) {
+	migrated := []*MigratedEntity{}
+	if len(mig) == 0 {
+		return migrated, nil
+	}
+
+	add := func(e *Entity, save bool) *XRError {
+		me, xErr := mig.migrateEntity(e, save)
+		if me != nil {
+			migrated = append(migrated, me)
+		}
+		return xErr
+	}
+
+	if xErr := add(&reg.Entity, false); xErr != nil {
+		return nil, xErr
+	}
+
+	groups, xErr := RawEntitiesFromQuery(reg.tx, reg.DbSID, FOR_WRITE,
+		fmt.Sprintf(`e.Type=%d`, ENTITY_GROUP))
+	if xErr != nil {
+		return nil, xErr
+	}
+
+	for _, e := range groups {
+		group := &Group{Entity: *e, Registry: reg}
+		group.Self = group
+		if xErr := add(&group.Entity, true); xErr != nil {
+			return nil, xErr
+		}
+
+		resources, xErr := RawEntitiesFromQuery(reg.tx, reg.DbSID, FOR_WRITE,
+			fmt.Sprintf(`e.ParentSID=? AND e.Type=%d`, ENTITY_RESOURCE),
+			group.DbSID)
+		if xErr != nil {
+			return nil, xErr
+		}
+
+		for _, e := range resources {
+			resource := &Resource{Entity: *e, Group: group}
+			resource.Self = resource
+			if xErr := add(&resource.Entity, true); xErr != nil {
+				return nil, xErr
+			}
+
+			// The Meta and Versions. Skip xref'd ones since they aren't
+			// really theirs
+			children, xErr := RawEntitiesFromQuery(reg.tx, reg.DbSID,
+				FOR_WRITE, `e.ParentSID=?`, resource.DbSID)
+			if xErr != nil {
+				return nil, xErr
+			}
+
+			count := len(migrated)
+			for _, e := range children {
+				if e.Type == ENTITY_META {
+					if !IsNil(e.Object["xref"]) {
+						continue
+					}
+					meta := &Meta{Entity: *e, Resource: resource}
+					meta.Self = meta
+					xErr = add(&meta.Entity, true)
+				} else if e.Type == ENTITY_VERSION {
+					if resource.IsXref() {
+						continue
+					}
+					version := &Version{Entity: *e, Resource: resource}
+					version.Self = version
+					xErr = add(&version.Entity, true)
+				}
+				if xErr != nil {
+					return nil, xErr
+				}
+			}
+
+			// The Resource shows its default Version's attributes, so make
+			// sure it sees the new ones
+			if len(migrated) > count {
+				resource.SaveDefaultVersionCascade()
+			}
+		}
+	}
+
+	for _, me := range migrated {
+		log.VPrintf(2, "Migrated %s (epoch: %d): %s", me.XID, me.Epoch,
+			strings.Join(me.Attributes, ", "))
+	}
+
+	return migrated, nil
+}
+
+// migrateEntity runs all of the operations for e's type against it. If
+// anything changed then its epoch and modifiedat are updated and, if
+// "save" is true, it's saved.
+func (mig Migration) migrateEntity(e *Entity, save bool) (*MigratedEntity, *XRError) {
+	var obj map[string]any
+	attrs := []string{}
+
+	for _, op := range mig {
+		if op.abstract != e.Abstract {
+			continue
+		}
+
+		if obj == nil {
+			src := e.NewObject
+			if src == nil {
+				src = e.Object
+			}
+			obj = map[string]any{}
+			if src != nil {
+				obj = deepCopy(src).(map[string]any)
+			}
+		}
+
+		changed, xErr := op.apply(e, obj)
+		if xErr != nil {
+			return nil, xErr
+		}
+		if !changed {
+			continue
+		}
+		for _, pp := range []*PropPath{op.attrPP, op.toPP} {
+			if pp != nil && !ArrayContains(attrs, pp.UI()) {
+				attrs = append(attrs, pp.UI())
+			}
+		}
+	}
+
+	if len(attrs) == 0 {
+		return nil, nil
+	}
+
+	e.Lock()
+	e.EnsureNewObject()
+	e.SetNewObject(obj)
+
+	xid := e.XID
+	if xid == "" {
+		xid = "/"
+	}
+	oldEpoch := e.Object["epoch"]
+	me := &MigratedEntity{
+		XID:        xid,
+		Epoch:      NotNilInt(&oldEpoch) + 1,
+		Attributes: attrs,
+	}
+
+	if !save {
+		// The epoch will be bumped when it's validated
+		if e.EpochSet {
+			newEpoch := e.NewObject["epoch"]
+			me.Epoch = NotNilInt(&newEpoch)
+		}
+		return me, nil
+	}
+
+	// Same as the epoch and modifiedat updateFns, but we're not going to
+	// validate it, that's VerifyData()'s job
+	if !e.EpochSet {
+		e.NewObject["epoch"] = me.Epoch
+		e.EpochSet = true
+	} else if IsNil(e.NewObject["epoch"]) {
+		e.NewObject["epoch"] = e.Object["epoch"]
+	}
+	newEpoch := e.NewObject["epoch"]
+	me.Epoch = NotNilInt(&newEpoch)
+
+	if !e.ModSet {
+		e.NewObject["modifiedat"] = e.tx.CreateTime
+		e.ModSet = true
+	}
+
+	if xErr := e.Save(); xErr != nil {
+		return nil, xErr
+	}
+
+	return me, nil
+}
+
+// apply runs "op" against "obj", which is one entity's data, and returns
+// whether anything was changed
+func (op *MigrateOp) apply(e *Entity, obj map[string]any) (bool, *XRError) {
+	val, found, err := ObjectGetProp(obj, op.attrPP)
+	found = err == nil && found && !IsNil(val)
+
+	var newVal any
+	switch op.Op {
+	case MIGRATE_RENAME, MIGRATE_MOVE:
+		if !found {
+			return false, nil
+		}
+		old, ok, err := ObjectGetProp(obj, op.toPP)
+		if err == nil && ok && !IsNil(old) {
+			return false, NewXRError("invalid_attribute", e.XID,
+				"name="+op.toPP.UI(),
+				"error_detail="+
+					fmt.Sprintf("can't %s %q since it already has a value",
+						op.Op, op.attrPP.UI()))
+		}
+		if err := ObjectSetProp(obj, op.toPP, val); err != nil {
+			return false, NewXRError("invalid_attribute", e.XID,
+				"name="+op.toPP.UI(),
+				"error_detail="+err.Error())
+		}
+		newVal = nil
+
+	case MIGRATE_DEFAULT:
+		if found {
+			return false, nil
+		}
+		newVal = op.Value
+
+	case MIGRATE_MAP:
+		if !found {
+			return false, nil
+		}
+		key := fmt.Sprintf("%v", val)
+		mapped, ok := op.Values[key]
+		if !ok || fmt.Sprintf("%v", mapped) == key {
+			return false, nil
+		}
+		newVal = mapped
+
+	case MIGRATE_DELETE:
+		if !found {
+			return false, nil
+		}
+		newVal = nil
+	}
+
+	if err := ObjectSetProp(obj, op.attrPP, newVal); err != nil {
+		return false, NewXRError("invalid_attribute", e.XID,
+			"name="+op.attrPP.UI(),
+			"error_detail="+err.Error())
+	}
+	return true, nil
+}
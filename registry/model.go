@@ -47,6 +47,52 @@ func (m *Model) VerifyAndSave(verifyData bool) *XRError {
 	return nil
 }
 
+// modelEntityUsage returns the model entities (Abstract->SID) that are in
+// the DB right now, and which of them (by Abstract) "m" still uses.
+// Anything not in use will be deleted when "m" is saved. We can't just
+// delete the entire set and re-add them because the DB will erase all
+// instances of those types automatically when the types are deleted.
+func (m *Model) modelEntityUsage() (map[string]string, map[string]bool) {
+	existingModelEntities := map[string]string{} // Abstract->SID
+	results := Query(m.Registry.tx,
+		`SELECT SID,Abstract FROM ModelEntities WHERE RegistrySID=?`,
+		m.Registry.DbSID)
+	defer results.Close()
+
+	for {
+		row := results.NextRow()
+		if row == nil {
+			break
+		}
+		sid := NotNilString(row[0])
+		abs := NotNilString(row[1])
+		existingModelEntities[abs] = sid
+	}
+
+	inUseAbs := map[string]bool{}
+	for _, gm := range m.Groups {
+		for _, rName := range gm.XImportResources {
+			parts := strings.Split(rName, "/")
+			rAbs := "/" + gm.Plural + "/" + parts[2]
+			if _, ok := existingModelEntities[rAbs]; ok {
+				inUseAbs[rAbs] = true
+			}
+		}
+		gAbs := "/" + gm.Plural
+		if _, ok := existingModelEntities[gAbs]; ok {
+			inUseAbs[gAbs] = true
+		}
+		for _, rm := range gm.Resources {
+			rmAbs := gAbs + "/" + rm.Plural
+			if _, ok := existingModelEntities[rmAbs]; ok {
+				inUseAbs[rmAbs] = true
+			}
+		}
+	}
+
+	return existingModelEntities, inUseAbs
+}
+
 func (m *Model) Save() *XRError {
 	// log.Printf("In model.Save - changed: %v", m.GetChanged())
 	if m.GetChanged() == false {
@@ -115,49 +161,10 @@ func (m *Model) Save() *XRError {
 		m.Registry.DbSID, modelStr,
 		modelStr)
 
-	existingModelEntities := map[string]string{} // Abstract->SID
-	results := Query(m.Registry.tx,
-		`SELECT SID,Abstract FROM ModelEntities WHERE RegistrySID=?`,
-		m.Registry.DbSID)
-	defer results.Close()
-
-	for {
-		row := results.NextRow()
-		if row == nil {
-			break
-		}
-		sid := NotNilString(row[0])
-		abs := NotNilString(row[1])
-		existingModelEntities[abs] = sid
-	}
-
-	// Remove from existingModelEntities all MEs that are going to be kept
-	// around. Then we'll delete everything else before we re-add the keepers
-	// to ensure there isn't any conflicts.
-	// We can't just delete the entire set and re-add them because the DB
-	// will erase all instances of those types automatically when the types
-	// are deleted.
-
-	inUseAbs := map[string]bool{}
-	for _, gm := range m.Groups {
-		for _, rName := range gm.XImportResources {
-			parts := strings.Split(rName, "/")
-			rAbs := "/" + gm.Plural + "/" + parts[2]
-			if _, ok := existingModelEntities[rAbs]; ok {
-				inUseAbs[rAbs] = true
-			}
-		}
-		gAbs := "/" + gm.Plural
-		if _, ok := existingModelEntities[gAbs]; ok {
-			inUseAbs[gAbs] = true
-		}
-		for _, rm := range gm.Resources {
-			rmAbs := gAbs + "/" + rm.Plural
-			if _, ok := existingModelEntities[rmAbs]; ok {
-				inUseAbs[rmAbs] = true
-			}
-		}
-	}
+	// Find all MEs that are going to be kept around. Then we'll delete
+	// everything else before we re-add the keepers to ensure there isn't
+	// any conflicts.
+	existingModelEntities, inUseAbs := m.modelEntityUsage()
 
 	// Before deleting anything, make sure none of the model entities about
 	// to be removed still have live instances. Deleting a ModelEntity
@@ -489,30 +496,72 @@ func (reg *Registry) clearValidationSystemProps(modelSID string, names ...string
 }
 
 func (m *Model) ApplyNewModelFromJSON(buf []byte, verify bool) *XRError {
+	model, modelSource, migration, xErr := m.ParseNewModelJSON(buf)
+	if xErr != nil {
+		return xErr
+	}
+
+	if len(migration) == 0 {
+		return m.ApplyNewModel(model, modelSource, verify)
+	}
+
+	// Migrate the data after the new model is saved (so that things like
+	// removed types are gone) but before the data is checked against it
+	reg := m.Registry
+	if xErr := m.ApplyNewModel(model, modelSource, false); xErr != nil {
+		return xErr
+	}
+
+	if _, xErr := reg.Migrate(migration); xErr != nil {
+		return xErr
+	}
+
+	if verify {
+		return reg.VerifyData()
+	}
+	return nil
+}
+
+// ParseNewModelJSON parses the model source in "buf" (e.g. the body of a
+// PUT /modelsource) and returns the new Model, its source and any data
+// migration that came with it
+func (m *Model) ParseNewModelJSON(buf []byte) (*Model, string, Migration, *XRError) {
 	modelSource := string(buf)
 	modelSource = strings.TrimSpace(modelSource)
 
 	if modelSource == "" {
-		return NewXRError("missing_body", "/")
+		return nil, "", nil, NewXRError("missing_body", "/")
+	}
+
+	// "$migrate" isn't part of the model so don't save it in the source
+	buf, migration, xErr := RemoveMigration([]byte(modelSource))
+	if xErr != nil {
+		return nil, "", nil, xErr
 	}
+	modelSource = string(buf)
 
 	// Don't allow local files to be included (e.g. ../foo)
-	buf, xErr := ProcessIncludes("", []byte(modelSource), false)
+	buf, xErr = ProcessIncludes("", buf, false)
 	if xErr != nil {
-		return xErr
+		return nil, "", nil, xErr
 	}
 
 	buf, err := RemoveSchema(buf)
 	if err != nil {
-		return NewXRError("bad_request", "/", "error_detail="+err.Error())
+		return nil, "", nil, NewXRError("bad_request", "/",
+			"error_detail="+err.Error())
 	}
 
 	model, xErr := ParseModel(buf, m.Registry)
 	if xErr != nil {
-		return xErr
+		return nil, "", nil, xErr
+	}
+
+	if xErr := migration.Verify(model); xErr != nil {
+		return nil, "", nil, xErr
 	}
 
 	// model.Source = modelSource
 
-	return m.ApplyNewModel(model, modelSource, verify)
+	return model, modelSource, migration, nil
 }
@@ -0,0 +1,365 @@
+// Package registry - model change plans.
+//
+// PlanNewModel shows what applying a new model would do to the data that's
+// already in a Registry, without keeping any of it. It's what backs
+// "PUT /modelsource?dryrun". Unlike ApplyNewModel(), which stops at the
+// first entity that doesn't match the new model, the plan lists:
+//
+//   - each Group/Resource type that would be removed, with how many
+//     entities would go with it
+//   - each entity that the model's "$migrate" operations would change
+//   - each entity that would no longer be valid, and why
+//   - each attribute whose value the new model would change, for example
+//     a new default value, or one that's dropped or normalized
+//
+// The model is really applied (and the data really checked), so the caller
+// MUST roll back the Tx when it's done.
+package registry
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	. "github.com/xregistry/server/common"
+)
+
+const (
+	PLAN_DEFAULTED = "defaulted" // attribute not set, new model has default
+	PLAN_DROPPED   = "dropped"   // attribute would be removed
+	PLAN_COERCED   = "coerced"   // attribute's value would be changed
+)
+
+type ModelPlan struct {
+	Valid        bool               `json:"valid"`
+	DeletedTypes []*PlanDeletedType `json:"deletedtypes"`
+	Migrated     []*MigratedEntity  `json:"migrated"`
+	Invalid      []*PlanInvalid     `json:"invalid"`
+	Changes      []*PlanChange      `json:"changes"`
+}
+
+// PlanDeletedType is a Group or Resource type (e.g. "/dirs/files") that
+// isn't in the new model, and how much data would go with it
+type PlanDeletedType struct {
+	Type      string `json:"type"`
+	Groups    int    `json:"groups,omitempty"`
+	Resources int    `json:"resources,omitempty"`
+	Versions  int    `json:"versions,omitempty"`
+}
+
+func (dt *PlanDeletedType) Entities() int {
+	return dt.Groups + dt.Resources + dt.Versions
+}
+
+// PlanInvalid is an entity that doesn't match the new model
+type PlanInvalid struct {
+	XID    string `json:"xid"`
+	Error  string `json:"error"` // e.g. "unknown_attribute"
+	Title  string `json:"title"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// PlanChange is an attribute whose value would be different under the new
+// model. Old/New are missing when the attribute isn't there.
+type PlanChange struct {
+	XID       string `json:"xid"`
+	Attribute string `json:"attribute"`
+	Change    string `json:"change"` // PLAN_DEFAULTED, PLAN_DROPPED, ...
+	Old       any    `json:"old,omitempty"`
+	New       any    `json:"new,omitempty"`
+}
+
+func (m *Model) PlanNewModelFromJSON(buf []byte) (*ModelPlan, *XRError) {
+	model, modelSource, migration, xErr := m.ParseNewModelJSON(buf)
+	if xErr != nil {
+		return nil, xErr
+	}
+
+	return m.PlanNewModel(model, modelSource, migration)
+}
+
+func (m *Model) PlanNewModel(newM *Model, src string, mig Migration) (*ModelPlan, *XRError) {
+	reg := m.Registry
+	plan := &ModelPlan{
+		DeletedTypes: []*PlanDeletedType{},
+		Migrated:     []*MigratedEntity{},
+		Invalid:      []*PlanInvalid{},
+		Changes:      []*PlanChange{},
+	}
+
+	// Count what's under each type being removed. Model.Save() will
+	// (rightly) refuse to remove a type that's still in use, so delete the
+	// types ourselves so that we can keep going and check everything else.
+	newM.Registry = reg
+	existing, inUse := newM.modelEntityUsage()
+	for _, abs := range SortedKeys(existing) {
+		if inUse[abs] {
+			continue
+		}
+		sid := existing[abs]
+		dt := &PlanDeletedType{Type: abs}
+
+		if strings.Count(abs, "/") == 1 {
+			dt.Groups = planCount(reg, `
+				SELECT COUNT(*) FROM "Groups" WHERE ModelSID=?`, sid)
+			dt.Resources = planCount(reg, `
+				SELECT COUNT(*) FROM Resources r
+				JOIN "Groups" g ON (g.SID=r.GroupSID)
+				WHERE g.ModelSID=?`, sid)
+			dt.Versions = planCount(reg, `
+				SELECT COUNT(*) FROM Versions v
+				JOIN Resources r ON (r.SID=v.ResourceSID)
+				JOIN "Groups" g ON (g.SID=r.GroupSID)
+				WHERE g.ModelSID=?`, sid)
+		} else {
+			dt.Resources = planCount(reg, `
+				SELECT COUNT(*) FROM Resources WHERE ModelSID=?`, sid)
+			dt.Versions = planCount(reg, `
+				SELECT COUNT(*) FROM Versions v
+				JOIN Resources r ON (r.SID=v.ResourceSID)
+				WHERE r.ModelSID=?`, sid)
+		}
+		plan.DeletedTypes = append(plan.DeletedTypes, dt)
+
+		if dt.Entities() > 0 {
+			// ModelTrigger deletes all of the entities of this type
+			DoOne(reg.tx, `DELETE FROM ModelEntities WHERE SID=?`, sid)
+		}
+	}
+
+	if xErr := m.ApplyNewModel(newM, src, false); xErr != nil {
+		return nil, xErr
+	}
+	defer func() { reg.Model = m }()
+
+	// Like ApplyNewModelFromJSON(), migrate the data before checking it
+	migrated, xErr := reg.Migrate(mig)
+	if xErr != nil {
+		return nil, xErr
+	}
+	plan.Migrated = migrated
+
+	// Same walk as VerifyData() except we keep going after an error
+	plan.checkEntity(&reg.Entity, m, newM)
+
+	groups, xErr := RawEntitiesFromQuery(reg.tx, reg.DbSID, FOR_WRITE,
+		fmt.Sprintf(`e.Type=%d`, ENTITY_GROUP))
+	if xErr != nil {
+		return nil, xErr
+	}
+
+	for _, e := range groups {
+		group := &Group{Entity: *e, Registry: reg}
+		group.Self = group
+		groupOK := plan.checkEntity(&group.Entity, m, newM)
+
+		resources, xErr := RawEntitiesFromQuery(reg.tx, reg.DbSID, FOR_WRITE,
+			fmt.Sprintf(`e.ParentSID=? AND e.Type=%d`, ENTITY_RESOURCE),
+			group.DbSID)
+		if xErr != nil {
+			return nil, xErr
+		}
+
+		for _, e := range resources {
+			resource := &Resource{Entity: *e, Group: group}
+			resource.Self = resource
+			resourceOK := true
+
+			// Like VerifyData(), xref'd Resources are checked via their
+			// target
+			if !resource.IsXref() {
+				versions, xErr := RawEntitiesFromQuery(reg.tx, reg.DbSID,
+					FOR_WRITE, fmt.Sprintf(`e.ParentSID=? AND e.Type=%d`,
+						ENTITY_VERSION), resource.DbSID)
+				if xErr != nil {
+					return nil, xErr
+				}
+
+				for _, e := range versions {
+					version := &Version{Entity: *e, Resource: resource}
+					version.Self = version
+					if !plan.checkEntity(&version.Entity, m, newM) {
+						resourceOK = false
+					}
+				}
+			}
+
+			// Only check the Resource as a whole if its Versions are ok so
+			// that a more specific error isn't hidden by a generic one
+			if resourceOK {
+				if xErr := resource.ValidateResource(false, true); xErr != nil {
+					plan.addInvalid(resource.XID, xErr)
+				}
+			} else {
+				groupOK = false
+			}
+		}
+
+		if reg.tx.GroupsToValidate != nil {
+			delete(reg.tx.GroupsToValidate, group.XID)
+		}
+		if groupOK {
+			if xErr := group.Validate(); xErr != nil {
+				plan.addInvalid(group.XID, xErr)
+			}
+		}
+	}
+
+	// Anything left over, e.g. other Groups impacted by an xref
+	if xErr := reg.Validate(nil); xErr != nil {
+		plan.addInvalid(xErr.Subject, xErr)
+	}
+
+	sort.SliceStable(plan.Invalid, func(i, j int) bool {
+		return plan.Invalid[i].XID < plan.Invalid[j].XID
+	})
+	sort.SliceStable(plan.Changes, func(i, j int) bool {
+		return plan.Changes[i].XID < plan.Changes[j].XID
+	})
+
+	plan.Valid = len(plan.Invalid) == 0
+	for _, dt := range plan.DeletedTypes {
+		if dt.Entities() > 0 {
+			plan.Valid = false
+		}
+	}
+
+	return plan, nil
+}
+
+func planCount(reg *Registry, query string, args ...any) int {
+	results := Query(reg.tx, query, args...)
+	defer results.Close()
+	return NotNilInt(results.NextRow()[0])
+}
+
+func (plan *ModelPlan) addInvalid(xid string, xErr *XRError) {
+	_, errType, _ := strings.Cut(xErr.Type, "#")
+	if xid == "" {
+		xid = "/"
+	}
+	plan.Invalid = append(plan.Invalid, &PlanInvalid{
+		XID:    xid,
+		Error:  errType,
+		Title:  xErr.GetTitle(),
+		Detail: xErr.Detail,
+	})
+}
+
+// checkEntity validates "e" against the new model and adds any problems,
+// or attribute changes, to the plan. To see what the new model changes,
+// rather than what any model would change (e.g. defaults that are never
+// stored), the result is compared to validating it against the old model.
+// Returns false if "e" isn't valid.
+func (plan *ModelPlan) checkEntity(e *Entity, oldM *Model, newM *Model) bool {
+	before, xErr := planValidate(e, oldM)
+	if xErr != nil {
+		// Already not valid, so just compare against what's there now
+		before = e.Object
+	}
+
+	after, xErr := planValidate(e, newM)
+	if xErr != nil {
+		plan.addInvalid(e.XID, xErr)
+		return false
+	}
+
+	plan.addChanges(e.XID, NewPP(), before, after)
+	return true
+}
+
+// planValidate validates a copy of e's data against "model" and returns
+// the copy, with whatever changes validation made to it
+func planValidate(e *Entity, model *Model) (map[string]any, *XRError) {
+	saveModel, saveObject, saveNewObject := e.Registry.Model, e.Object,
+		e.NewObject
+	defer func() {
+		e.Registry.Model = saveModel
+		e.Object, e.NewObject = saveObject, saveNewObject
+		e.GroupModel, e.ResourceModel = nil, nil
+	}()
+
+	e.Registry.Model = model
+	e.GroupModel, e.ResourceModel = nil, nil
+
+	obj := saveNewObject
+	if obj == nil {
+		obj = saveObject
+	}
+	e.Object = map[string]any{}
+	if obj != nil {
+		e.Object = deepCopy(obj).(map[string]any)
+	}
+	e.NewObject = nil
+
+	xErr := e.Validate()
+	return e.Object, xErr
+}
+
+func (plan *ModelPlan) addChanges(xid string, pp *PropPath, before map[string]any, after map[string]any) {
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+
+	for _, key := range SortedKeys(keys) {
+		if pp.Len() == 0 && key[0] == '#' {
+			continue // system attributes
+		}
+
+		oldVal, oldOK := before[key]
+		newVal, newOK := after[key]
+		change := ""
+
+		switch {
+		case !oldOK || IsNil(oldVal):
+			if newOK && !IsNil(newVal) {
+				change = PLAN_DEFAULTED
+			}
+		case !newOK || IsNil(newVal):
+			change = PLAN_DROPPED
+		default:
+			oldMap, ok1 := oldVal.(map[string]any)
+			newMap, ok2 := newVal.(map[string]any)
+			if ok1 && ok2 {
+				plan.addChanges(xid, pp.P(key), oldMap, newMap)
+			} else if !reflect.DeepEqual(oldVal, newVal) {
+				change = PLAN_COERCED
+			}
+		}
+
+		if change != "" {
+			plan.Changes = append(plan.Changes, &PlanChange{
+				XID:       xid,
+				Attribute: pp.P(key).UI(),
+				Change:    change,
+				Old:       oldVal,
+				New:       newVal,
+			})
+		}
+	}
+}
+
+// deepCopy makes a deep copy of an entity's data so that it can be changed
+// (e.g. by validation) without touching the original
+func deepCopy(val any) any {
+	switch v := val.(type) {
+	case map[string]any:
+		res := make(map[string]any, len(v))
+		for k, item := range v {
+			res[k] = deepCopy(item)
+		}
+		return res
+	case []any:
+		res := make([]any, len(v))
+		for i, item := range v {
+			res[i] = deepCopy(item)
+		}
+		return res
+	}
+	return val
+}
@@ -0,0 +1,495 @@
+// Package registry - fetching the documents of "$RESOURCEproxyurl".
+//
+// When a Version has a RESOURCEproxyurl we GET its document from there each
+// time someone asks for it (HTTPGETContent, or an inlined RESOURCE). Since
+// that makes the server an HTTP client on behalf of anyone who can write a
+// Version, all of those fetches go through a ProxyFetcher which:
+//
+//   - only allows http/https URLs and checks the host against an
+//     allow/deny list (host names, "*.domain" wildcards or CIDRs)
+//   - refuses to connect to loopback/private/link-local addresses unless
+//     they're explicitly allowed. This is checked on the address actually
+//     dialed, so DNS tricks and redirects don't get around it
+//   - has a timeout and a max document size
+//   - caches documents for a TTL, then revalidates them with their ETag
+//     (or Last-Modified)
+//   - drops hop-by-hop headers (see xrproxy.go), and any that could be
+//     confused for our own (xRegistry-*, Set-Cookie, ...)
+//   - optionally checks the document against the Version's "format"
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/duglin/dlog"
+	. "github.com/xregistry/server/common"
+)
+
+const PROXY_DEF_TIMEOUT = 10 * time.Second
+const PROXY_DEF_MAXSIZE = 10 * 1024 * 1024
+const PROXY_DEF_CACHETTL = time.Minute
+const PROXY_DEF_CACHESIZE = 64 * 1024 * 1024
+
+// Remote headers that are never passed along to our client, in addition
+// to the hop-by-hop ones. Either we set them ourselves or they'd let the
+// remote pretend to be us.
+var proxyDropHeaders = []string{
+	"Set-Cookie",
+	"Content-Location",
+	"Content-Disposition",
+	"Location",
+	"Link",
+	"Repr-Digest",
+	"Digest",
+	"Access-Control-Allow-Origin",
+	"Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers",
+	"Access-Control-Allow-Credentials",
+	"Strict-Transport-Security",
+	"Accept-Ranges", // We do our own ranges, see ServeDocument()
+	"Content-Range",
+}
+
+type ProxyFetcher struct {
+	Allow        []string      // Host names, "*.domain" or CIDRs
+	Deny         []string      // Same as Allow, checked first
+	AllowPrivate bool          // Allow loopback/private/link-local addrs
+	Timeout      time.Duration // Whole request, including the body
+	MaxSize      int64         // Of a document, in bytes
+	CacheTTL     time.Duration // 0 = no caching
+	CacheSize    int64         // Max bytes of documents to keep cached
+	Validate     bool          // Check documents against their "format"
+
+	client    *http.Client
+	mutex     sync.Mutex
+	cache     map[string]*proxyCacheEntry
+	cacheUsed int64
+}
+
+// ProxyDoc is the result of a fetch. Non-2xx responses are returned too
+// (and passed along as-is), just not cached.
+type ProxyDoc struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Cached     bool // Served from the cache w/o talking to the remote
+}
+
+type proxyCacheEntry struct {
+	doc       *ProxyDoc
+	fetchedAt time.Time
+}
+
+// proxyCheck tracks, per request (including its redirects), whether the
+// current URL's host was explicitly allowed by name. If not, and there's
+// an allow list, the dialed address must be in one of its CIDRs.
+type proxyCheck struct {
+	allowedByName bool
+}
+
+type proxyCheckKey struct{}
+
+// ProxyDeniedError is returned by Fetch when the URL, or the address it
+// resolves to, isn't allowed
+type ProxyDeniedError struct {
+	Reason string
+}
+
+func (e *ProxyDeniedError) Error() string { return e.Reason }
+
+func proxyDenied(format string, args ...any) error {
+	return &ProxyDeniedError{fmt.Sprintf(format, args...)}
+}
+
+var proxyFetcher = NewProxyFetcher()
+var proxyFetcherMutex sync.RWMutex
+
+func NewProxyFetcher() *ProxyFetcher {
+	return &ProxyFetcher{
+		Timeout:   PROXY_DEF_TIMEOUT,
+		MaxSize:   PROXY_DEF_MAXSIZE,
+		CacheTTL:  PROXY_DEF_CACHETTL,
+		CacheSize: PROXY_DEF_CACHESIZE,
+	}
+}
+
+// SetProxyFetcher replaces the ProxyFetcher used for RESOURCEproxyurls.
+// The new one starts with an empty cache.
+func SetProxyFetcher(pf *ProxyFetcher) error {
+	for _, list := range [][]string{pf.Allow, pf.Deny} {
+		for _, entry := range list {
+			if entry == "" {
+				return fmt.Errorf("empty proxy allow/deny entry")
+			}
+			if strings.Contains(entry, "/") {
+				if _, _, err := net.ParseCIDR(entry); err != nil {
+					return fmt.Errorf("invalid proxy allow/deny CIDR %q: %s",
+						entry, err)
+				}
+			}
+		}
+	}
+
+	proxyFetcherMutex.Lock()
+	defer proxyFetcherMutex.Unlock()
+	proxyFetcher = pf
+	return nil
+}
+
+func GetProxyFetcher() *ProxyFetcher {
+	proxyFetcherMutex.RLock()
+	defer proxyFetcherMutex.RUnlock()
+	return proxyFetcher
+}
+
+func (pf *ProxyFetcher) getClient() *http.Client {
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	if pf.client != nil {
+		return pf.client
+	}
+
+	dialer := &net.Dialer{
+		Timeout: pf.Timeout,
+	}
+
+	transport := &http.Transport{
+		// No env var proxies, we need to see the real address we dial
+		Proxy: nil,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := *dialer
+			check, _ := ctx.Value(proxyCheckKey{}).(*proxyCheck)
+			d.Control = func(network, address string, c syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				return pf.checkIP(net.ParseIP(host), check)
+			}
+			return d.DialContext(ctx, network, addr)
+		},
+		TLSHandshakeTimeout:   pf.Timeout,
+		ResponseHeaderTimeout: pf.Timeout,
+	}
+
+	pf.client = &http.Client{
+		Transport: transport,
+		Timeout:   pf.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			check, _ := req.Context().Value(proxyCheckKey{}).(*proxyCheck)
+			return pf.checkURL(req.URL, check)
+		},
+	}
+	return pf.client
+}
+
+// matchHost checks "host" against the host name entries of "list" (CIDRs
+// are skipped). "*.example.com" matches any sub-domain of example.com.
+func matchHost(list []string, host string) bool {
+	for _, entry := range list {
+		entry = strings.ToLower(entry)
+		if strings.Contains(entry, "/") {
+			continue
+		}
+		if entry == "*" || entry == host {
+			return true
+		}
+		if strings.HasPrefix(entry, "*.") && strings.HasSuffix(host, entry[1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIP checks "ip" against the CIDR entries of "list"
+func matchIP(list []string, ip net.IP) bool {
+	for _, entry := range list {
+		if !strings.Contains(entry, "/") {
+			continue
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCIDRs(list []string) bool {
+	for _, entry := range list {
+		if strings.Contains(entry, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// checkURL is done before each request, and redirect
+func (pf *ProxyFetcher) checkURL(u *url.URL, check *proxyCheck) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return proxyDenied("only http and https URLs are allowed")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("missing host")
+	}
+
+	if matchHost(pf.Deny, host) {
+		return proxyDenied("host %q is denied", host)
+	}
+
+	byName := matchHost(pf.Allow, host)
+	if len(pf.Allow) > 0 && !byName && !hasCIDRs(pf.Allow) {
+		return proxyDenied("host %q isn't allowed", host)
+	}
+	if check != nil {
+		check.allowedByName = byName
+	}
+	return nil
+}
+
+// checkIP is done on the address actually being connected to
+func (pf *ProxyFetcher) checkIP(ip net.IP, check *proxyCheck) error {
+	if ip == nil {
+		return fmt.Errorf("invalid address")
+	}
+	if matchIP(pf.Deny, ip) {
+		return proxyDenied("address %s is denied", ip)
+	}
+
+	inAllow := matchIP(pf.Allow, ip)
+	if len(pf.Allow) > 0 && !inAllow && (check == nil || !check.allowedByName) {
+		return proxyDenied("address %s isn't allowed", ip)
+	}
+
+	if !pf.AllowPrivate && !inAllow && (ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() ||
+		ip.IsUnspecified()) {
+		return proxyDenied("address %s is internal", ip)
+	}
+	return nil
+}
+
+// FilterProxyHeaders returns the headers of a remote response that are ok
+// to pass along to our client
+func FilterProxyHeaders(src http.Header) http.Header {
+	res := http.Header{}
+	for name, vals := range src {
+		if isXRProxyHopHeader(name) ||
+			strings.HasPrefix(strings.ToLower(name), "xregistry-") ||
+			ArrayContainsAnyCase(proxyDropHeaders, name) {
+			continue
+		}
+		res[name] = append([]string{}, vals...)
+	}
+	return res
+}
+
+// Fetch GETs "u", from the cache if possible
+func (pf *ProxyFetcher) Fetch(u string) (*ProxyDoc, error) {
+	entry := pf.getCached(u)
+	if entry != nil && time.Since(entry.fetchedAt) < pf.CacheTTL {
+		doc := *entry.doc
+		doc.Cached = true
+		return &doc, nil
+	}
+
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	check := &proxyCheck{}
+	if err = pf.checkURL(parsed, check); err != nil {
+		return nil, err
+	}
+
+	ctx := context.WithValue(context.Background(), proxyCheckKey{}, check)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stale, so just ask if it changed
+	if entry != nil {
+		if etag := entry.doc.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		} else if lm := entry.doc.Header.Get("Last-Modified"); lm != "" {
+			req.Header.Set("If-Modified-Since", lm)
+		}
+	}
+
+	resp, err := pf.getClient().Do(req)
+	if err != nil {
+		// Denials from the dialer or a redirect get wrapped, unwrap them
+		var denied *ProxyDeniedError
+		if errors.As(err, &denied) {
+			return nil, denied
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && entry != nil {
+		pf.putCached(u, entry.doc)
+		doc := *entry.doc
+		return &doc, nil
+	}
+
+	if resp.ContentLength > pf.MaxSize {
+		return nil, fmt.Errorf("document is larger than %d bytes",
+			pf.MaxSize)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, pf.MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > pf.MaxSize {
+		return nil, fmt.Errorf("document is larger than %d bytes",
+			pf.MaxSize)
+	}
+
+	doc := &ProxyDoc{
+		StatusCode: resp.StatusCode,
+		Header:     FilterProxyHeaders(resp.Header),
+		Body:       body,
+	}
+
+	if resp.StatusCode/100 == 2 && !strings.Contains(
+		strings.ToLower(resp.Header.Get("Cache-Control")), "no-store") {
+		pf.putCached(u, doc)
+	}
+
+	return doc, nil
+}
+
+func (pf *ProxyFetcher) getCached(u string) *proxyCacheEntry {
+	if pf.CacheTTL <= 0 {
+		return nil
+	}
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+	return pf.cache[u]
+}
+
+func (pf *ProxyFetcher) putCached(u string, doc *ProxyDoc) {
+	if pf.CacheTTL <= 0 || int64(len(doc.Body)) > pf.CacheSize {
+		return
+	}
+
+	pf.mutex.Lock()
+	defer pf.mutex.Unlock()
+
+	if pf.cache == nil {
+		pf.cache = map[string]*proxyCacheEntry{}
+	}
+	if old := pf.cache[u]; old != nil {
+		pf.cacheUsed -= int64(len(old.doc.Body))
+	}
+	pf.cache[u] = &proxyCacheEntry{doc: doc, fetchedAt: time.Now()}
+	pf.cacheUsed += int64(len(doc.Body))
+
+	// Too big? Drop the oldest ones until we fit
+	for pf.cacheUsed > pf.CacheSize {
+		oldestURL := ""
+		var oldest *proxyCacheEntry
+		for key, e := range pf.cache {
+			if oldest == nil || e.fetchedAt.Before(oldest.fetchedAt) {
+				oldestURL, oldest = key, e
+			}
+		}
+		pf.cacheUsed -= int64(len(oldest.doc.Body))
+		delete(pf.cache, oldestURL)
+	}
+}
+
+// FetchProxyDocument gets the document of "v" from "u" (its
+// RESOURCEproxyurl) and, if enabled, checks it against the Version's
+// "format". "v" can be a Resource or a Version entity.
+func FetchProxyDocument(v *Entity, u string) (*ProxyDoc, *XRError) {
+	pf := GetProxyFetcher()
+
+	doc, err := pf.Fetch(u)
+	if err != nil {
+		log.VPrintf(2, "Proxy fetch of %q for %q: %s", u, v.XID, err)
+		if _, ok := err.(*ProxyDeniedError); ok {
+			return nil, NewXRError("proxy_denied", v.XID, "url="+u,
+				"error_detail="+err.Error())
+		}
+		return nil, NewXRError("proxy_error", v.XID, "url="+u,
+			"error_detail="+err.Error())
+	}
+
+	if pf.Validate && doc.StatusCode/100 == 2 {
+		if xErr := validateProxyDocument(v, doc.Body); xErr != nil {
+			return nil, NewXRError("proxy_error", v.XID, "url="+u,
+				"error_detail="+xErr.GetTitle())
+		}
+	}
+
+	return doc, nil
+}
+
+// validateProxyDocument runs the FormatChecker of the Version's "format"
+// over "buf" as if it were the Version's document
+func validateProxyDocument(e *Entity, buf []byte) *XRError {
+	format := e.GetAsString("format")
+	if format == "" {
+		return nil
+	}
+	checker, _ := GetFormatChecker(format)
+	if checker == nil {
+		return nil
+	}
+
+	v, xErr := findVersionOf(e)
+	if xErr != nil || v == nil {
+		return xErr
+	}
+
+	v.proxyDoc = buf
+	defer func() { v.proxyDoc = nil }()
+
+	checked, _, xErr := checker.IsValid(v)
+	if checked {
+		return xErr
+	}
+	return nil
+}
+
+// findVersionOf returns the Version of a Version entity, or the default
+// Version of a Resource entity
+func findVersionOf(e *Entity) (*Version, *XRError) {
+	xid, err := ParseXid(e.XID)
+	if err != nil {
+		return nil, NewXRError("server_error", e.XID).SetDetail(err.Error())
+	}
+
+	group, xErr := e.Registry.FindGroup(xid.Group, xid.GroupID, false,
+		FOR_READ)
+	if xErr != nil || group == nil {
+		return nil, xErr
+	}
+	resource, xErr := group.FindResource(xid.Resource, xid.ResourceID, false,
+		FOR_READ)
+	if xErr != nil || resource == nil {
+		return nil, xErr
+	}
+	if xid.VersionID != "" {
+		return resource.FindVersion(xid.VersionID, false)
+	}
+	return resource.GetDefault()
+}
@@ -0,0 +1,184 @@
+package registry
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newProxyTestServer(hits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(hits, 1)
+			switch r.URL.Path {
+			case "/doc":
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("X-Custom", "yes")
+				w.Header().Set("xRegistry-epoch", "99")
+				w.Header().Set("Set-Cookie", "a=b")
+				w.Header().Set("Connection", "close")
+				w.Write([]byte("hello"))
+			case "/big":
+				w.Write([]byte(strings.Repeat("x", 100)))
+			case "/slow":
+				time.Sleep(500 * time.Millisecond)
+				w.Write([]byte("slow"))
+			case "/redirect":
+				http.Redirect(w, r, "http://169.254.169.254/latest", 302)
+			case "/missing":
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte("nope"))
+			}
+		}))
+}
+
+func isDenied(err error) bool {
+	_, ok := err.(*ProxyDeniedError)
+	return ok
+}
+
+func TestProxyFetchChecks(t *testing.T) {
+	hits := int32(0)
+	srv := newProxyTestServer(&hits)
+	defer srv.Close()
+
+	// Internal addresses aren't allowed by default
+	pf := NewProxyFetcher()
+	if _, err := pf.Fetch(srv.URL + "/doc"); !isDenied(err) {
+		t.Fatalf("Fetch of loopback should be denied: %v", err)
+	}
+	for _, u := range []string{"file:///etc/passwd", "ftp://example.com/x"} {
+		if _, err := pf.Fetch(u); !isDenied(err) {
+			t.Fatalf("Fetch of %q should be denied: %v", u, err)
+		}
+	}
+	if atomic.LoadInt32(&hits) != 0 {
+		t.Fatalf("Denied fetches shouldn't reach the server: %d", hits)
+	}
+
+	// Unless they're in the allow list
+	pf = NewProxyFetcher()
+	pf.Allow = []string{"127.0.0.0/8"}
+	doc, err := pf.Fetch(srv.URL + "/doc")
+	if err != nil || string(doc.Body) != "hello" || doc.StatusCode != 200 {
+		t.Fatalf("Fetch: %v %v", doc, err)
+	}
+
+	// Only the safe headers are kept
+	if doc.Header.Get("X-Custom") != "yes" ||
+		doc.Header.Get("Content-Type") != "text/plain" ||
+		doc.Header.Get("xRegistry-epoch") != "" ||
+		doc.Header.Get("Set-Cookie") != "" ||
+		doc.Header.Get("Connection") != "" {
+		t.Fatalf("Bad headers: %v", doc.Header)
+	}
+
+	// Redirects are checked too
+	if _, err = pf.Fetch(srv.URL + "/redirect"); !isDenied(err) {
+		t.Fatalf("Redirect to an internal address should be denied: %v", err)
+	}
+
+	// Allowed by name, but still internal
+	pf = NewProxyFetcher()
+	pf.Allow = []string{"127.0.0.1"}
+	if _, err = pf.Fetch(srv.URL + "/doc"); !isDenied(err) {
+		t.Fatalf("Fetch should be denied: %v", err)
+	}
+
+	pf = NewProxyFetcher()
+	pf.Allow = []string{"*.example.com"}
+	pf.AllowPrivate = true
+	if _, err = pf.Fetch(srv.URL + "/doc"); !isDenied(err) {
+		t.Fatalf("Host not in allow list should be denied: %v", err)
+	}
+
+	pf = NewProxyFetcher()
+	pf.AllowPrivate = true
+	pf.Deny = []string{"127.0.0.1/32"}
+	if _, err = pf.Fetch(srv.URL + "/doc"); !isDenied(err) {
+		t.Fatalf("Denied address should be denied: %v", err)
+	}
+
+	pf = NewProxyFetcher()
+	pf.AllowPrivate = true
+	pf.MaxSize = 10
+	pf.Timeout = 100 * time.Millisecond
+	if _, err = pf.Fetch(srv.URL + "/big"); err == nil || isDenied(err) {
+		t.Fatalf("Fetch of a big doc should fail: %v", err)
+	}
+	if _, err = pf.Fetch(srv.URL + "/slow"); err == nil || isDenied(err) {
+		t.Fatalf("Fetch of a slow doc should time out: %v", err)
+	}
+
+	// Errors from the remote are passed along
+	doc, err = pf.Fetch(srv.URL + "/missing")
+	if err != nil || doc.StatusCode != 404 || string(doc.Body) != "nope" {
+		t.Fatalf("Fetch of missing doc: %v %v", doc, err)
+	}
+
+	if SetProxyFetcher(&ProxyFetcher{Allow: []string{"10.0.0.0/33"}}) == nil {
+		t.Fatalf("Bad CIDR should have failed")
+	}
+}
+
+func TestProxyFetchCache(t *testing.T) {
+	hits := int32(0)
+	srv := newProxyTestServer(&hits)
+	defer srv.Close()
+
+	pf := NewProxyFetcher()
+	pf.AllowPrivate = true
+	u := srv.URL + "/doc"
+
+	for i := 0; i < 3; i++ {
+		doc, err := pf.Fetch(u)
+		if err != nil || string(doc.Body) != "hello" || doc.Cached != (i > 0) {
+			t.Fatalf("Fetch(%d): %v %v", i, doc, err)
+		}
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("Should have only hit the server once: %d", hits)
+	}
+
+	// Once stale it's revalidated with its ETag (the server says 304)
+	pf.cache[u].fetchedAt = time.Now().Add(-2 * pf.CacheTTL)
+	doc, err := pf.Fetch(u)
+	if err != nil || string(doc.Body) != "hello" || doc.Cached {
+		t.Fatalf("Revalidate: %v %v", doc, err)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("Should have hit the server again: %d", hits)
+	}
+
+	// Errors aren't cached
+	pf.Fetch(srv.URL + "/missing")
+	pf.Fetch(srv.URL + "/missing")
+	if atomic.LoadInt32(&hits) != 4 {
+		t.Fatalf("404s shouldn't be cached: %d", hits)
+	}
+
+	// Nothing is cached when the TTL is 0, and the cache has a max size
+	pf = NewProxyFetcher()
+	pf.AllowPrivate = true
+	pf.CacheTTL = 0
+	pf.Fetch(u)
+	if len(pf.cache) != 0 {
+		t.Fatalf("Shouldn't have cached anything")
+	}
+
+	pf.CacheTTL = time.Minute
+	pf.CacheSize = 7
+	pf.Fetch(u)
+	pf.Fetch(u + "?x=1")
+	if len(pf.cache) != 1 || pf.cache[u+"?x=1"] == nil || pf.cacheUsed != 5 {
+		t.Fatalf("Cache should have just the newest doc: %v", pf.cache)
+	}
+}
@@ -0,0 +1,427 @@
+// Package registry - per-client rate limiting.
+//
+// Each client gets a token bucket per class of request (see
+// ClassifyRequest) in each Registry. A client is its IP address, or the
+// value of the "keyheader" header (an API key) when it sends one that's in
+// the "keys" list. Anyone can make up a key, so unknown ones are treated
+// as if there wasn't one. The limits are the server's defaults
+// (RateLimiter.Defaults), overridden per Registry by its "#ratelimits"
+// system property. It's all in memory, so each server process has its own
+// buckets and counters.
+package registry
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/duglin/dlog"
+	. "github.com/xregistry/server/common"
+)
+
+const (
+	RATE_EXPENSIVE = "expensive" // export, deep inlines, document searches
+	RATE_READ      = "read"
+	RATE_WRITE     = "write"
+)
+
+var RateClasses = []string{RATE_EXPENSIVE, RATE_READ, RATE_WRITE}
+
+// Once we're tracking this many buckets the least recently used one is
+// dropped for each new one
+const RATE_MAX_BUCKETS = 100000
+
+type RateLimit struct {
+	Rate  float64 `json:"rate"`            // Per second, 0 means no limit
+	Burst int     `json:"burst,omitempty"` // Defaults to ceil(Rate)
+}
+
+type RateLimitConfig struct {
+	KeyHeader string                `json:"keyheader,omitempty"`
+	Keys      []string              `json:"keys,omitempty"`   // known keys
+	Limits    map[string]*RateLimit `json:"limits,omitempty"` // by class
+}
+
+type RateCounter struct {
+	Allowed int64 `json:"allowed"`
+	Limited int64 `json:"limited"`
+}
+
+type rateKey struct {
+	reg, class, client string
+}
+
+type rateBucket struct {
+	key    rateKey
+	tokens float64
+	last   time.Time
+}
+
+type RateLimiter struct {
+	Defaults  *RateLimitConfig
+	Forwarded int // # of trusted proxies that add to X-Forwarded-For
+
+	mutex    sync.Mutex
+	buckets  map[rateKey]*list.Element          // of *rateBucket, in lru
+	lru      *list.List                         // most recently used 1st
+	counters map[string]map[string]*RateCounter // RegUID -> class ->
+	now      func() time.Time
+}
+
+var rateLimiter = NewRateLimiter()
+
+func NewRateLimiter() *RateLimiter {
+	return &RateLimiter{
+		Defaults: &RateLimitConfig{},
+		buckets:  map[rateKey]*list.Element{},
+		lru:      list.New(),
+		counters: map[string]map[string]*RateCounter{},
+		now:      time.Now,
+	}
+}
+
+func SetRateLimiter(rl *RateLimiter) {
+	rateLimiter = rl
+}
+
+func GetRateLimiter() *RateLimiter {
+	return rateLimiter
+}
+
+// ParseRateLimit parses "RATE[:BURST]", RATE is requests per second
+func ParseRateLimit(spec string) (*RateLimit, error) {
+	rateStr, burstStr, hasBurst := strings.Cut(strings.TrimSpace(spec), ":")
+
+	rate, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || rate < 0 || math.IsInf(rate, 0) || math.IsNaN(rate) {
+		return nil, fmt.Errorf("bad rate %q, must be RATE[:BURST]", spec)
+	}
+	limit := &RateLimit{Rate: rate}
+
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burstStr)
+		if err != nil || limit.Burst < 1 {
+			return nil, fmt.Errorf("bad burst %q, must be RATE[:BURST]",
+				spec)
+		}
+	}
+	return limit, nil
+}
+
+func (rl *RateLimit) String() string {
+	if rl == nil || rl.Rate <= 0 {
+		return "none"
+	}
+	return fmt.Sprintf("%g:%d", rl.Rate, rl.GetBurst())
+}
+
+func (rl *RateLimit) GetBurst() int {
+	if rl.Burst > 0 {
+		return rl.Burst
+	}
+	return max(1, int(math.Ceil(rl.Rate)))
+}
+
+// Merge returns "c" with anything that's set in "over" replacing it
+func (c *RateLimitConfig) Merge(over *RateLimitConfig) *RateLimitConfig {
+	res := &RateLimitConfig{Limits: map[string]*RateLimit{}}
+	for _, cfg := range []*RateLimitConfig{c, over} {
+		if cfg == nil {
+			continue
+		}
+		if cfg.KeyHeader != "" {
+			res.KeyHeader = cfg.KeyHeader
+		}
+		if len(cfg.Keys) > 0 {
+			res.Keys = cfg.Keys
+		}
+		for class, limit := range cfg.Limits {
+			res.Limits[class] = limit
+		}
+	}
+	return res
+}
+
+func (c *RateLimitConfig) Validate() error {
+	for class, limit := range c.Limits {
+		if !ArrayContains(RateClasses, class) {
+			return fmt.Errorf("unknown rate limit class %q, must be one "+
+				"of: %s", class, strings.Join(RateClasses, ", "))
+		}
+		if limit == nil || limit.Rate < 0 || limit.Burst < 0 {
+			return fmt.Errorf("bad rate limit for %q", class)
+		}
+	}
+	return nil
+}
+
+// LoadRateKeys reads a file of API keys, one per line. "#" starts a
+// comment.
+func LoadRateKeys(file string) ([]string, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 1 {
+			return nil, fmt.Errorf("%s:%d: expected just a key", file, line)
+		}
+		if ArrayContains(keys, fields[0]) {
+			return nil, fmt.Errorf("%s:%d: duplicate key", file, line)
+		}
+		keys = append(keys, fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// GetRateLimits returns the Registry's own rate limits, or nil
+func (reg *Registry) GetRateLimits() (*RateLimitConfig, error) {
+	str := reg.GetAsString("#ratelimits")
+	if str == "" {
+		return nil, nil
+	}
+	cfg := &RateLimitConfig{}
+	if err := json.Unmarshal([]byte(str), cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// SetRateLimits saves the Registry's own rate limits, nil removes them
+func (reg *Registry) SetRateLimits(cfg *RateLimitConfig) *XRError {
+	if cfg == nil {
+		return reg.SetSave("#ratelimits", nil)
+	}
+	if err := cfg.Validate(); err != nil {
+		return NewXRError("bad_request", reg.XID,
+			"error_detail="+err.Error())
+	}
+	return reg.SetSave("#ratelimits", ToJSON(cfg))
+}
+
+// Config returns the rate limits that apply to "reg"
+func (rl *RateLimiter) Config(reg *Registry) *RateLimitConfig {
+	var own *RateLimitConfig
+	if reg != nil {
+		var err error
+		if own, err = reg.GetRateLimits(); err != nil {
+			log.Printf("Bad #ratelimits in registry %q: %s", reg.UID, err)
+		}
+	}
+	return rl.Defaults.Merge(own)
+}
+
+// ClientKey returns who "r" is from: its API key when cfg.KeyHeader is set
+// and it's one of cfg.Keys, otherwise its IP address (see Forwarded)
+func (rl *RateLimiter) ClientKey(r *http.Request, cfg *RateLimitConfig) string {
+	if cfg.KeyHeader != "" {
+		if key := r.Header.Get(cfg.KeyHeader); key != "" &&
+			ArrayContains(cfg.Keys, key) {
+			return "key:" + key
+		}
+	}
+
+	// Each proxy appends the address it got the request from, so only
+	// the last "Forwarded" of them are trustworthy. Anything before that
+	// is whatever the client wanted to send.
+	if rl.Forwarded > 0 {
+		addrs := []string{}
+		for _, val := range r.Header.Values("X-Forwarded-For") {
+			for _, addr := range strings.Split(val, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					addrs = append(addrs, addr)
+				}
+			}
+		}
+		if len(addrs) > 0 {
+			return "ip:" + addrs[max(len(addrs)-rl.Forwarded, 0)]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// ClassifyRequest says which budget "info" comes out of
+func ClassifyRequest(info *RequestInfo) string {
+	method := info.OriginalRequest.Method
+	if method != "GET" && method != "HEAD" && method != "OPTIONS" {
+		return RATE_WRITE
+	}
+
+	if info.RootPath == "export" || len(info.Facets) > 0 {
+		return RATE_EXPENSIVE
+	}
+	for _, inline := range info.Inlines {
+		if inline.Path == "*" || strings.HasSuffix(inline.Path, ".*") {
+			return RATE_EXPENSIVE
+		}
+	}
+	for _, andFilters := range info.Filters {
+		for _, filter := range andFilters {
+			if filter.IsDoc || filter.Operator == FILTER_REGEX {
+				return RATE_EXPENSIVE
+			}
+		}
+	}
+	return RATE_READ
+}
+
+// Allow takes a token from the client's bucket. If there isn't one it
+// returns false and how long until there will be.
+func (rl *RateLimiter) Allow(regUID, class, client string,
+	limit *RateLimit) (bool, time.Duration) {
+
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	counters := rl.counters[regUID]
+	if counters == nil {
+		counters = map[string]*RateCounter{}
+		rl.counters[regUID] = counters
+	}
+	counter := counters[class]
+	if counter == nil {
+		counter = &RateCounter{}
+		counters[class] = counter
+	}
+
+	if limit == nil || limit.Rate <= 0 {
+		counter.Allowed++
+		return true, 0
+	}
+
+	now := rl.now()
+	burst := float64(limit.GetBurst())
+	key := rateKey{regUID, class, client}
+	var bucket *rateBucket
+	if elem := rl.buckets[key]; elem == nil {
+		if len(rl.buckets) >= RATE_MAX_BUCKETS {
+			rl.dropOldest()
+		}
+		bucket = &rateBucket{key: key, tokens: burst, last: now}
+		rl.buckets[key] = rl.lru.PushFront(bucket)
+	} else {
+		rl.lru.MoveToFront(elem)
+		bucket = elem.Value.(*rateBucket)
+		elapsed := now.Sub(bucket.last).Seconds()
+		bucket.tokens = min(burst, bucket.tokens+elapsed*limit.Rate)
+		bucket.last = now
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		counter.Allowed++
+		return true, 0
+	}
+
+	counter.Limited++
+	wait := (1 - bucket.tokens) / limit.Rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// dropOldest removes the least recently used bucket. Most likely it's
+// full by now, which is the same as not having one at all.
+func (rl *RateLimiter) dropOldest() {
+	if elem := rl.lru.Back(); elem != nil {
+		rl.lru.Remove(elem)
+		delete(rl.buckets, elem.Value.(*rateBucket).key)
+	}
+}
+
+// Counters returns a copy of the counters of "regUID", by class, and how
+// many clients it has buckets for
+func (rl *RateLimiter) Counters(regUID string) (map[string]RateCounter, int) {
+	rl.mutex.Lock()
+	defer rl.mutex.Unlock()
+
+	res := map[string]RateCounter{}
+	for _, class := range RateClasses {
+		res[class] = RateCounter{}
+		if counter := rl.counters[regUID][class]; counter != nil {
+			res[class] = *counter
+		}
+	}
+
+	clients := map[string]bool{}
+	for key := range rl.buckets {
+		if key.reg == regUID {
+			clients[key.client] = true
+		}
+	}
+	return res, len(clients)
+}
+
+// CheckRateLimit returns a "too_many_requests" error if the client of
+// "info" is over its budget
+func CheckRateLimit(info *RequestInfo) *XRError {
+	rl := GetRateLimiter()
+	cfg := rl.Config(info.Registry)
+	class := ClassifyRequest(info)
+	client := rl.ClientKey(info.OriginalRequest, cfg)
+
+	ok, wait := rl.Allow(info.Registry.UID, class, client, cfg.Limits[class])
+	if ok {
+		return nil
+	}
+
+	retry := int(math.Ceil(wait.Seconds()))
+	info.SetHeader("Retry-After", strconv.Itoa(retry))
+	return NewXRError("too_many_requests", "/"+info.OriginalPath,
+		"class="+class,
+		"retry="+strconv.Itoa(retry))
+}
+
+func HTTPGETRateLimits(info *RequestInfo) *XRError {
+	if !info.IsAvailable("ratelimits") {
+		return NewXRError("not_available", "/ratelimits")
+	}
+	if len(info.Parts) > 1 {
+		return NewXRError("api_not_found", info.GetParts(0))
+	}
+
+	rl := GetRateLimiter()
+	cfg := rl.Config(info.Registry)
+	counters, clients := rl.Counters(info.Registry.UID)
+	res := struct {
+		*RateLimitConfig
+		Keys     int                    `json:"keys,omitempty"` // not them!
+		Counters map[string]RateCounter `json:"counters"`
+		Clients  int                    `json:"clients"`
+	}{cfg, len(cfg.Keys), counters, clients}
+
+	buf, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		return NewXRError("server_error", "/ratelimits").
+			SetDetailf("Error serializing ratelimits: %s.", err.Error())
+	}
+
+	info.SetHeader("Content-Type", "application/json")
+	info.Write(buf)
+	info.Write([]byte("\n"))
+	return nil
+}
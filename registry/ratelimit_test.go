@@ -0,0 +1,289 @@
+package registry
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	for _, test := range []struct {
+		spec string
+		exp  string // "" means error
+	}{
+		{"10", "10:10"},
+		{"10:50", "10:50"},
+		{"0.5", "0.5:1"},
+		{" 2.5:3 ", "2.5:3"},
+		{"0", "none"},
+		{"-1", ""},
+		{"x", ""},
+		{"5:0", ""},
+		{"5:x", ""},
+		{"Inf", ""},
+		{"", ""},
+	} {
+		limit, err := ParseRateLimit(test.spec)
+		if test.exp == "" {
+			if err == nil {
+				t.Fatalf("%q: should have failed, got %s", test.spec, limit)
+			}
+			continue
+		}
+		if err != nil || limit.String() != test.exp {
+			t.Fatalf("%q: got %s/%v, expected %s", test.spec, limit, err,
+				test.exp)
+		}
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	defs := &RateLimitConfig{
+		KeyHeader: "X-Api-Key",
+		Limits: map[string]*RateLimit{
+			RATE_READ:  {Rate: 10},
+			RATE_WRITE: {Rate: 1},
+		},
+	}
+	res := defs.Merge(&RateLimitConfig{
+		Limits: map[string]*RateLimit{RATE_WRITE: {Rate: 5, Burst: 2}},
+	})
+	if res.KeyHeader != "X-Api-Key" || res.Limits[RATE_READ].Rate != 10 ||
+		res.Limits[RATE_WRITE].String() != "5:2" ||
+		res.Limits[RATE_EXPENSIVE] != nil {
+		t.Fatalf("Bad merge: %+v", res)
+	}
+	if defs.Limits[RATE_WRITE].Rate != 1 {
+		t.Fatalf("Merge changed the defaults")
+	}
+
+	if res = defs.Merge(nil); len(res.Limits) != 2 {
+		t.Fatalf("Bad nil merge: %+v", res)
+	}
+
+	defs.Keys = []string{"a", "b"}
+	if res = defs.Merge(&RateLimitConfig{}); len(res.Keys) != 2 {
+		t.Fatalf("Keys should be inherited: %+v", res)
+	}
+	if res = defs.Merge(&RateLimitConfig{Keys: []string{"c"}}); len(res.Keys) != 1 ||
+		res.Keys[0] != "c" {
+		t.Fatalf("Keys should be replaced: %+v", res)
+	}
+
+	if err := defs.Validate(); err != nil {
+		t.Fatalf("Should be valid: %s", err)
+	}
+	bad := &RateLimitConfig{Limits: map[string]*RateLimit{"foo": {Rate: 1}}}
+	if err := bad.Validate(); err == nil {
+		t.Fatalf("Bad class should have failed")
+	}
+	bad = &RateLimitConfig{Limits: map[string]*RateLimit{RATE_READ: nil}}
+	if err := bad.Validate(); err == nil {
+		t.Fatalf("Missing limit should have failed")
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	now := time.Unix(1000, 0)
+	rl := NewRateLimiter()
+	rl.now = func() time.Time { return now }
+
+	limit := &RateLimit{Rate: 2, Burst: 3}
+	allow := func(client string) (bool, time.Duration) {
+		return rl.Allow("reg", RATE_READ, client, limit)
+	}
+
+	// Full bucket to start with
+	for i := 0; i < 3; i++ {
+		if ok, _ := allow("a"); !ok {
+			t.Fatalf("Request %d should have been allowed", i)
+		}
+	}
+	ok, wait := allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("Should have been limited: %v %s", ok, wait)
+	}
+
+	// Other clients have their own bucket
+	if ok, _ := allow("b"); !ok {
+		t.Fatalf("Other client should have been allowed")
+	}
+
+	// Half a second later there's one token
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := allow("a"); !ok {
+		t.Fatalf("Should have been refilled")
+	}
+	if ok, _ := allow("a"); ok {
+		t.Fatalf("Should only have had one token")
+	}
+
+	// Never more than the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		allow("a")
+	}
+	if ok, _ := allow("a"); ok {
+		t.Fatalf("Should have been capped at the burst")
+	}
+
+	// No limit
+	if ok, _ := rl.Allow("reg", RATE_WRITE, "a", nil); !ok {
+		t.Fatalf("No limit should always be allowed")
+	}
+	if ok, _ := rl.Allow("reg", RATE_WRITE, "a", &RateLimit{}); !ok {
+		t.Fatalf("Zero rate should always be allowed")
+	}
+
+	counters, clients := rl.Counters("reg")
+	if counters[RATE_READ] != (RateCounter{Allowed: 8, Limited: 3}) ||
+		counters[RATE_WRITE] != (RateCounter{Allowed: 2}) ||
+		counters[RATE_EXPENSIVE] != (RateCounter{}) || clients != 2 {
+		t.Fatalf("Bad counters: %v %d", counters, clients)
+	}
+
+	counters, clients = rl.Counters("other")
+	if len(counters) != len(RateClasses) || clients != 0 ||
+		counters[RATE_READ] != (RateCounter{}) {
+		t.Fatalf("Bad empty counters: %v %d", counters, clients)
+	}
+
+	// The least recently used bucket is the one that's dropped
+	allow("a")
+	rl.dropOldest()
+	if len(rl.buckets) != 1 || rl.buckets[rateKey{"reg", RATE_READ, "a"}] == nil {
+		t.Fatalf("Should only have 'a' left: %v", rl.buckets)
+	}
+}
+
+func TestRateLimiterMaxBuckets(t *testing.T) {
+	rl := NewRateLimiter()
+	limit := &RateLimit{Rate: 1}
+	for i := 0; i < RATE_MAX_BUCKETS+10; i++ {
+		rl.Allow("reg", RATE_READ, strconv.Itoa(i), limit)
+		if i == RATE_MAX_BUCKETS-1 {
+			// Keep using "0" so it's not the one that's dropped
+			rl.Allow("reg", RATE_READ, "0", limit)
+		}
+	}
+	if len(rl.buckets) != RATE_MAX_BUCKETS || rl.lru.Len() != RATE_MAX_BUCKETS {
+		t.Fatalf("Should be capped: %d %d", len(rl.buckets), rl.lru.Len())
+	}
+	if rl.buckets[rateKey{"reg", RATE_READ, "0"}] == nil ||
+		rl.buckets[rateKey{"reg", RATE_READ, "1"}] != nil {
+		t.Fatalf("Dropped the wrong buckets")
+	}
+}
+
+func TestRateLimiterClientKey(t *testing.T) {
+	rl := NewRateLimiter()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.2")
+
+	cfg := &RateLimitConfig{}
+	keyCfg := &RateLimitConfig{KeyHeader: "X-Api-Key", Keys: []string{"secret"}}
+
+	if key := rl.ClientKey(req, cfg); key != "ip:10.0.0.1" {
+		t.Fatalf("Got %q", key)
+	}
+	if key := rl.ClientKey(req, keyCfg); key != "ip:10.0.0.1" {
+		t.Fatalf("Missing key, got %q", key)
+	}
+	req.Header.Set("X-Api-Key", "secret")
+	if key := rl.ClientKey(req, keyCfg); key != "key:secret" {
+		t.Fatalf("Got %q", key)
+	}
+
+	// Unknown keys don't get their own bucket
+	req.Header.Set("X-Api-Key", "made-up")
+	if key := rl.ClientKey(req, keyCfg); key != "ip:10.0.0.1" {
+		t.Fatalf("Unknown key, got %q", key)
+	}
+	keyCfg.Keys = nil
+	if key := rl.ClientKey(req, keyCfg); key != "ip:10.0.0.1" {
+		t.Fatalf("No keys, got %q", key)
+	}
+
+	// Only the addresses added by our own proxies count
+	rl.Forwarded = 1
+	if key := rl.ClientKey(req, cfg); key != "ip:10.0.0.2" {
+		t.Fatalf("Forwarded, got %q", key)
+	}
+	rl.Forwarded = 2
+	if key := rl.ClientKey(req, cfg); key != "ip:1.2.3.4" {
+		t.Fatalf("Forwarded 2, got %q", key)
+	}
+	rl.Forwarded = 5
+	if key := rl.ClientKey(req, cfg); key != "ip:1.2.3.4" {
+		t.Fatalf("Forwarded 5, got %q", key)
+	}
+
+	// Made up addresses in front of ours don't matter, nor does splitting
+	// it across headers
+	rl.Forwarded = 1
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 1.2.3.4")
+	req.Header.Add("X-Forwarded-For", "10.0.0.2")
+	if key := rl.ClientKey(req, cfg); key != "ip:10.0.0.2" {
+		t.Fatalf("Spoofed, got %q", key)
+	}
+	rl.Forwarded = 2
+	if key := rl.ClientKey(req, cfg); key != "ip:1.2.3.4" {
+		t.Fatalf("Spoofed 2, got %q", key)
+	}
+}
+
+func TestLoadRateKeys(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "keys")
+	os.WriteFile(file, []byte("# API keys\nk1\n\n  k2  # ci\n"), 0600)
+	keys, err := LoadRateKeys(file)
+	if err != nil || len(keys) != 2 || keys[0] != "k1" || keys[1] != "k2" {
+		t.Fatalf("Got %q %v", keys, err)
+	}
+
+	for _, bad := range []string{"k1 k2\n", "k1\nk1\n"} {
+		os.WriteFile(file, []byte(bad), 0600)
+		if _, err := LoadRateKeys(file); err == nil {
+			t.Fatalf("%q should have failed", bad)
+		}
+	}
+	if _, err := LoadRateKeys(file + "-missing"); err == nil {
+		t.Fatalf("Missing file should have failed")
+	}
+}
+
+func TestClassifyRequest(t *testing.T) {
+	for _, test := range []struct {
+		method, path, exp string
+	}{
+		{"GET", "/", RATE_READ},
+		{"GET", "/dirs?inline=files", RATE_READ},
+		{"GET", "/?inline=*", RATE_EXPENSIVE},
+		{"GET", "/?inline=dirs.*", RATE_EXPENSIVE},
+		{"GET", "/export", RATE_EXPENSIVE},
+		{"GET", "/dirs?filter=name=foo", RATE_READ},
+		{"GET", "/dirs?filter=name~=foo", RATE_EXPENSIVE},
+		{"HEAD", "/", RATE_READ},
+		{"PUT", "/", RATE_WRITE},
+		{"POST", "/dirs", RATE_WRITE},
+		{"DELETE", "/dirs/d1", RATE_WRITE},
+	} {
+		info := newTransferInfo()
+		info.OriginalRequest = httptest.NewRequest(test.method, test.path, nil)
+		info.OriginalPath = info.OriginalRequest.URL.Path[1:]
+		info.RootPath = info.OriginalPath
+		for _, inline := range info.OriginalRequest.URL.Query()["inline"] {
+			info.Inlines = append(info.Inlines, &Inline{Path: inline})
+		}
+		if test.path == "/dirs?filter=name~=foo" {
+			info.Filters = [][]*FilterExpr{{{Operator: FILTER_REGEX}}}
+		}
+		if got := ClassifyRequest(info); got != test.exp {
+			t.Fatalf("%s %s: got %q, expected %q", test.method, test.path,
+				got, test.exp)
+		}
+	}
+}
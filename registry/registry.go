@@ -6,7 +6,9 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"regexp"
 	"strings"
+	"time"
 
 	log "github.com/duglin/dlog"
 	. "github.com/xregistry/server/common"
@@ -14,6 +16,18 @@ import (
 
 var DefaultRegDbSID string
 
+// ScratchRegistries lets clients create (PUT /reg-ID) and delete
+// (DELETE /reg-ID) Registries whose ID starts with SCRATCH_REGISTRY_PREFIX.
+// Only meant for test servers. See IsScratchRegistry.
+var ScratchRegistries = false
+
+// IsScratchRegistry says whether the Registry "id" can be created and
+// deleted over HTTP
+func IsScratchRegistry(id string) bool {
+	return ScratchRegistries && len(id) > len(SCRATCH_REGISTRY_PREFIX) &&
+		strings.HasPrefix(id, SCRATCH_REGISTRY_PREFIX)
+}
+
 func (r *Registry) GetTx() *Tx {
 	return r.tx
 }
@@ -1005,13 +1019,12 @@ func (reg *Registry) UpsertJustGroups(rootObj Object, addType AddType) (map[stri
 	return groups, nil
 }
 
-// sortKey = attribute name, -NAME means descending, no "-" means ascending
-func GenerateQuery(reg *Registry, what string, paths []string, filters [][]*FilterExpr, docView bool, sortKey string) (string, []interface{}, *XRError) {
-	query := ""
-	args := []any{}
-
+// sortKeys are applied, in order, to the collection being returned and
+// then to each inlined collection nested under it (see sortClauses).
+// fields is the ?fields= list (see fieldsClause).
+func GenerateQuery(reg *Registry, what string, paths []string, filters [][]*FilterExpr, docView bool, sortKeys []*SortKey, fields map[string][]*PropPath) (string, []interface{}, *XRError) {
 	// ?filter=excludeall returns nothing
-	if len(filters) == 1 && filters[0][0].Path == "excludeall"+string(DB_IN) {
+	if IsExcludeAll(filters) {
 		if what != "Coll" {
 			return "", nil, NewXRError("bad_filter",
 				reg.tx.RequestInfo.OriginalRequest.URL.RequestURI(),
@@ -1022,66 +1035,40 @@ func GenerateQuery(reg *Registry, what string, paths []string, filters [][]*Filt
 		return "", nil, nil
 	}
 
-	if sortKey != "" && what != "Coll" {
+	if len(sortKeys) != 0 && what != "Coll" {
 		return "", nil, NewXRError("bad_sort", "",
-			"value="+sortKey,
+			"value="+sortKeys[0].PP.UI(),
 			"error_detail=can't sort on a non-collection results")
 	}
 
-	ascDesc := "ASC"
-	sortJoin := ""
-	sortOrder := ""
-
-	if sortKey != "" {
-		if sortKey[0] == '-' {
-			ascDesc = "DESC"
-			sortKey = sortKey[1:]
-		}
+	sortJoin, sortOrder, sortArgs := sortClauses(paths, sortKeys)
 
-		count := strings.Count(paths[0], "/")
-		if count == 0 {
-			count = 2
-		} else if count == 2 {
-			count = 4
-		} else {
-			count = 6
-		}
-		slashCount := fmt.Sprintf("%d", count)
+	query, args := generateSelect(reg, what, paths, filters, docView, fields,
+		sortJoin, sortArgs)
 
-		/*
-					sortOrder = `
-			    sj.PropValue IS NOT NULL,
-			    CASE WHEN sj.PropType IN ('integer','decimal','uinteger')
-			      THEN CAST(sj.PropValue AS DECIMAL) END ` + ascDesc + `,
-			    CASE WHEN sj.PropType NOT IN ('integer','decimal','uinteger')
-			      THEN sj.PropValue END ` + ascDesc + `,
-			`
-		*/
-
-		sortOrder = `
-    sj.PropValue IS NOT NULL ` + ascDesc + `,
-    CASE
-      WHEN sj.PropType IN ('integer','decimal','uinteger') THEN
-        IFNULL(CAST(sj.PropValue AS DECIMAL), 0)
-      WHEN sj.PropType NOT IN ('integer','decimal','uinteger') THEN
-        sj.PropValue
-      ELSE NULL
-    END COLLATE utf8mb4_general_ci ` + ascDesc + `,
-`
+	query += `  ORDER BY ` + sortOrder +
+		`    ft.LowerPath ASC;`
 
-		sortJoin = `
-  LEFT JOIN Props AS sj ON (
-    sj.RegSID = ft.RegSID AND
-    sj.Path = substring_index(ft.Path, '/', ` + slashCount + `) AND
-    sj.PropName = '` + sortKey + `')
-`
+	if log.GetVerbose() > 3 || log.HasKeyword("genq") {
+		log.Printf("Query:\n%s\n\n", SubQuery(query, args))
 	}
+	return query, args, nil
+}
+
+func IsExcludeAll(filters [][]*FilterExpr) bool {
+	return len(filters) == 1 &&
+		filters[0][0].Path == "excludeall"+string(DB_IN)
+}
 
-	args = []interface{}{reg.DbSID}
-	query = `
+// generateSelect returns the un-ordered SELECT of all Props rows that make
+// up the entities under "paths" that match "filters". join (and its args)
+// is added after the Props table so callers can pull in extra columns.
+func generateSelect(reg *Registry, what string, paths []string, filters [][]*FilterExpr, docView bool, fields map[string][]*PropPath, join string, joinArgs []any) (string, []any) {
+	args := append(joinArgs, reg.DbSID)
+	query := `
 SELECT
   ft.RegSID,ft.Type,ft.Plural,ft.Singular,ft.ParentSID,ft.eSID,ft.UID,ft.Abstract,ft.Path,ft.PropName,ft.PropValue,ft.PropType,ft.IsSystemProp
-  FROM Props AS ft` + sortJoin + `
+  FROM Props AS ft` + join + `
   WHERE ft.RegSID=?
 `
 
@@ -1093,6 +1080,11 @@ SELECT
 `
 	}
 
+	if fieldsQuery, fieldsArgs := fieldsClause(reg, fields); fieldsQuery != "" {
+		query += fieldsQuery
+		args = append(args, fieldsArgs...)
+	}
+
 	// Remove entities that are higher than the GET PATH specified
 	if what != "Registry" && len(paths) > 0 {
 		query += "  AND ("
@@ -1107,6 +1099,8 @@ SELECT
 
 	}
 
+	query += hideDraftsClause(reg, paths, filters, docView)
+
 	if len(filters) != 0 {
 		query += `
 AND
@@ -1136,10 +1130,41 @@ ft.eSID IN ( -- eSID from query
 			firstAnd := true
 			andCount := 0
 			for _, filter := range OrFilters { // AndFilters
+				propsTable := "Props"
 				propNameSearch := "PropName=?"
 				filterPropName := filter.PropName
 
-				if filter.PP.HasWild {
+				if filter.IsDoc {
+					// Look inside the entity's document instead of at its
+					// attributes. Present the DocProps rows (found via the
+					// entity's #contentid) as if they were Props rows so
+					// the operator logic below doesn't need to care.
+					propsTable = `(
+            SELECT cp.RegSID,cp.eSID,cp.Type,cp.Path,cp.Abstract,
+                   dp.Pointer AS PropName,dp.PropValue,dp.PropType
+            FROM Props AS cp
+            JOIN DocProps AS dp ON (dp.ContentSID=cp.PropValue)
+            WHERE cp.PropName='#contentid` + string(DB_IN) + `'`
+					if filter.Operator == FILTER_CONTAINS {
+						propsTable += `
+              AND EXISTS (SELECT 1 FROM DocProps AS ap
+                WHERE ap.ContentSID=dp.ContentSID AND ap.PropType='array'
+                  AND ap.Pointer=REGEXP_REPLACE(dp.Pointer,'/[^/]*$',''))`
+					}
+					propsTable += `
+          ) AS Props`
+
+					if ptr, has := DocPointerFilter(filter.DocPointer); has {
+						propNameSearch = "PropName REGEXP ?"
+						filterPropName = ptr
+					}
+				} else if filter.IsDeprecationState {
+					// Computed from "deprecated" + the current time
+					propsTable = deprecationStateTable(time.Now())
+				} else if filter.Operator == FILTER_CONTAINS ||
+					filter.Operator == FILTER_HASKEY {
+					// Do nothing, see below
+				} else if filter.PP.HasWild {
 					// mysql format: column_name REGEXP 'pattern'
 					propNameSearch = "PropName REGEXP ?"
 					has := false
@@ -1167,6 +1192,12 @@ ft.eSID IN ( -- eSID from query
 					}
 				}
 
+				if filter.Operator == FILTER_CONTAINS ||
+					filter.Operator == FILTER_HASKEY {
+					propNameSearch = "PropName REGEXP ?"
+					filterPropName = filterSubPropName(filter)
+				}
+
 				andCount++
 				if !firstAnd {
 					query += `
@@ -1174,7 +1205,8 @@ ft.eSID IN ( -- eSID from query
 				}
 				firstAnd = false
 
-				if filter.Operator == FILTER_PRESENT { // ?filter=xxx
+				if filter.Operator == FILTER_PRESENT || // ?filter=xxx
+					filter.Operator == FILTER_HASKEY {
 					check := "(Abstract=? AND " + propNameSearch + " AND "
 
 					args = append(args, reg.DbSID, filter.Abstract,
@@ -1185,7 +1217,7 @@ ft.eSID IN ( -- eSID from query
 					// 2+ rows at matching more than one filter expression
 					check += " GROUP BY eSID" // " LIMIT 1"
 					query += `
-          (SELECT eSID,Type,Path FROM Props  -- FILTER_PRESENT
+          (SELECT eSID,Type,Path FROM ` + propsTable + `  -- FILTER_PRESENT
            WHERE RegSID=? AND ` + check + ")" // Need () for groupBy/limit
 
 				} else if filter.Operator == FILTER_ABSENT { // ?filter=xxx=null
@@ -1197,60 +1229,43 @@ ft.eSID IN ( -- eSID from query
           -- Entities that don't have the specified prop
           SELECT e.eSID,e.Type,e.Path FROM Entities AS e
           WHERE e.RegSID=? AND e.Abstract=? AND
-            NOT EXISTS (SELECT 1 FROM Props WHERE
+            NOT EXISTS (SELECT 1 FROM ` + propsTable + ` WHERE
               RegSID=e.RegSID AND eSID=e.eSID AND ( ` +
 						propNameSearch + `))`
 
-				} else if filter.Operator == FILTER_EQUAL { // ?filter=xxx=zzz
+				} else if filter.Operator == FILTER_EQUAL || // ?filter=xxx=zzz
+					filter.Operator == FILTER_REGEX ||
+					filter.Operator == FILTER_PREFIX ||
+					filter.Operator == FILTER_IN ||
+					filter.Operator == FILTER_CONTAINS {
+
 					check := "(Abstract=? AND " + propNameSearch + " AND "
 
 					args = append(args, reg.DbSID, filter.Abstract,
 						filterPropName)
-					value, wildcard := LikeWildcardIt(filter.Value)
-					args = append(args, value)
-					if !wildcard {
-						// Strings:case-insensitive per spec; others:exact match
-						args = append(args, value)
-						check += "((PropType='string' AND PropValue " +
-							FILTER_CI_COLLATE + "=?)" +
-							" OR (PropType<>'string' AND PropValue=?))"
-					} else {
-						args = append(args, value)
-						check += "((PropType<>'string' AND PropValue=?) " +
-							" OR (PropType='string' AND PropValue " +
-							FILTER_CI_COLLATE + " LIKE ?))"
-					}
-					check += ")"
+					valueCheck, valueArgs := filterValueCheck(filter)
+					args = append(args, valueArgs...)
+					check += valueCheck + ")"
 					query += `
-          SELECT eSID,Type,Path FROM Props
+          SELECT eSID,Type,Path FROM ` + propsTable + `
             WHERE RegSID=? AND ` + check
 
-				} else if filter.Operator == FILTER_NOT_EQUAL { // ?filter=x!=z
+				} else if filter.Operator == FILTER_NOT_EQUAL || // ?filter=x!=z
+					filter.Operator == FILTER_NOT_IN {
+
 					args = append(args, reg.DbSID, filter.Abstract,
 						filterPropName)
 					query += `
           -- Entities that don't have the specified prop
           SELECT e.eSID,e.Type,e.Path FROM Entities AS e
           WHERE e.RegSID=? AND e.Abstract=? AND
-            NOT EXISTS (SELECT 1 FROM Props WHERE
+            NOT EXISTS (SELECT 1 FROM ` + propsTable + ` WHERE
               RegSID=e.RegSID AND eSID=e.eSID AND (` +
 						propNameSearch + ` AND `
 
-					value, wildcard := LikeWildcardIt(filter.Value)
-					args = append(args, value)
-					if !wildcard {
-						// Strings:case-insensitive per spec;others:exact match
-						args = append(args, value)
-						query += "((PropType='string' AND PropValue " +
-							FILTER_CI_COLLATE + "=?)" +
-							" OR (PropType<>'string' AND PropValue=?))"
-					} else {
-						args = append(args, value)
-						query += "((PropType<>'string' AND PropValue=?) " +
-							" OR (PropType='string' AND PropValue " +
-							FILTER_CI_COLLATE + " LIKE ?))"
-					}
-					query += "))"
+					valueCheck, valueArgs := filterValueCheck(filter)
+					args = append(args, valueArgs...)
+					query += valueCheck + "))"
 
 				} else if filter.Operator == FILTER_LESS ||
 					filter.Operator == FILTER_LESS_EQUAL ||
@@ -1280,7 +1295,7 @@ ft.eSID IN ( -- eSID from query
 						" THEN CAST(PropValue AS DECIMAL) " + sqlOp + " CAST(? AS DECIMAL)" +
 						" ELSE PropValue " + FILTER_CI_COLLATE + " " + sqlOp + " ? END))"
 					query += `
-          SELECT eSID,Type,Path FROM Props
+          SELECT eSID,Type,Path FROM ` + propsTable + `
             WHERE RegSID=? AND ` + check
 
 				} else {
@@ -1348,13 +1363,261 @@ ft.eSID IN ( -- eSID from query
 `
 	}
 
-	query += `  ORDER BY ` + sortOrder +
-		`    ft.LowerPath ASC;`
+	return query, args
+}
 
-	if log.GetVerbose() > 3 || log.HasKeyword("genq") {
-		log.Printf("Query:\n%s\n\n", SubQuery(query, args))
+// Spec defined attributes that ?fields= is allowed to exclude from the
+// query. All other spec attributes are always pulled since they're
+// (potentially) needed to calculate other ones. Extensions can always be
+// excluded.
+var prunableFields = []string{"description", "documentation", "icon",
+	"labels", "name"}
+
+// fieldsClause returns the extra WHERE clauses (and args) needed to
+// not bother pulling the attributes that ?fields= will not show. This
+// is just an optimization, JsonWriter still does the real work.
+func fieldsClause(reg *Registry, fields map[string][]*PropPath) (string, []any) {
+	query := ""
+	args := []any{}
+
+	for _, abs := range SortedKeys(fields) {
+		keep := []string{}
+		for _, field := range fields[abs] {
+			keep = append(keep, field.Top())
+		}
+		for name := range SpecProps {
+			if name[0] != '$' && !ArrayContains(prunableFields, name) {
+				keep = append(keep, name)
+			}
+		}
+
+		singular := "registry"
+		if abs != "" {
+			parts := strings.Split(abs, string(DB_IN))
+			gm := reg.Model.FindGroupModel(parts[0])
+			if gm == nil {
+				continue
+			}
+			singular = gm.Singular
+			if len(parts) > 1 {
+				rm := gm.Resources[parts[1]]
+				if rm == nil {
+					continue
+				}
+				singular = rm.Singular
+				keep = append(keep, singular, singular+"url",
+					singular+"proxyurl", singular+"base64")
+			}
+		}
+		keep = append(keep, singular+"id")
+
+		query += `  AND NOT (ft.Abstract=? AND ft.PropName NOT LIKE '#%' AND
+    substring_index(ft.PropName, ',', 1) NOT IN (` +
+			strings.Repeat("?,", len(keep)-1) + `?))
+`
+		args = append(args, abs)
+		for _, name := range keep {
+			args = append(args, name)
+		}
 	}
-	return query, args, nil
+
+	return query, args
+}
+
+// sortClauses returns the JOINs (and their args) and the ORDER BY terms
+// needed for ?sort. Entity Paths have 2 parts per level (dirs/d1 = 2,
+// dirs/d1/files/f1 = 4, .../versions/v1 = 6), so for each level, starting
+// with the collection being returned, we join each key's Prop from that
+// level's ancestor (substring_index(Path,'/',level)). Each level's keys
+// are followed by that ancestor's Path so that its children stay grouped
+// under it, and then sorted themselves by the next level's keys.
+//
+// Each key sorts by: null-or-not, then type (numbers, timestamps, then
+// everything else), then the value itself as that type - so "10" > "9"
+// and timestamps with fractional seconds or UTC offsets compare correctly.
+func sortClauses(paths []string, sortKeys []*SortKey) (string, string, []any) {
+	if len(sortKeys) == 0 {
+		return "", "", nil
+	}
+
+	count := strings.Count(paths[0], "/")
+	if count == 0 {
+		count = 2
+	} else if count == 2 {
+		count = 4
+	} else {
+		count = 6
+	}
+
+	join := ""
+	order := ""
+	args := []any{}
+
+	for level := count; level <= 6; level += 2 {
+		levelStr := fmt.Sprintf("%d", level)
+
+		if level != count {
+			// Rows above this level (e.g. a Resource and its 'meta') come
+			// before the ones in this level's collection
+			order += `
+    (LENGTH(ft.Path)-LENGTH(REPLACE(ft.Path,'/',''))) >= ` +
+				fmt.Sprintf("%d", level-1) + ` ASC,`
+		}
+
+		for i, key := range sortKeys {
+			sj := fmt.Sprintf("sj%d_%d", level, i)
+			ascDesc := "ASC"
+			if key.Desc {
+				ascDesc = "DESC"
+			}
+			nulls := "ASC"
+			if key.NullsFirst {
+				nulls = "DESC"
+			}
+
+			join += `
+  LEFT JOIN Props AS ` + sj + ` ON (
+    ` + sj + `.RegSID = ft.RegSID AND
+    (LENGTH(ft.Path)-LENGTH(REPLACE(ft.Path,'/',''))) >= ` +
+				fmt.Sprintf("%d", level-1) + ` AND
+    ` + sj + `.Path = substring_index(ft.Path, '/', ` + levelStr + `) AND
+    ` + sj + `.PropName = ?)`
+			args = append(args, key.PP.DB())
+
+			order += `
+    ` + sj + `.PropValue IS NULL ` + nulls + `,
+    CASE
+      WHEN ` + sj + `.PropType IN ('integer','decimal','uinteger') THEN 0
+      WHEN ` + sj + `.PropType='timestamp' THEN 1
+      ELSE 2
+    END ` + ascDesc + `,
+    CASE WHEN ` + sj + `.PropType IN ('integer','decimal','uinteger')
+      THEN CAST(` + sj + `.PropValue AS DECIMAL(65,30)) END ` + ascDesc + `,
+    CASE WHEN ` + sj + `.PropType='timestamp'
+      THEN ` + sqlUTCTimestamp(sj+".PropValue") + `
+    END ` + ascDesc + `,
+    CASE
+      WHEN ` + sj + `.PropType NOT IN ('integer','decimal','uinteger','timestamp')
+      THEN ` + sj + `.PropValue
+    END COLLATE utf8mb4_general_ci ` + ascDesc + `,`
+		}
+
+		if level != 6 {
+			order += `
+    substring_index(ft.LowerPath, '/', ` + levelStr + `) ASC,`
+		}
+	}
+
+	return join + "\n", order + "\n", args
+}
+
+// sqlUTCTimestamp returns the SQL that converts an RFC3339 timestamp
+// stored in "col" into a UTC DATETIME. Timestamps can end with "Z" or with
+// a +hh:mm/-hh:mm offset, and CAST() doesn't understand either of them.
+// CONVERT_TZ() accepts numeric offsets without needing the DB's time zone
+// tables to be loaded.
+func sqlUTCTimestamp(col string) string {
+	return `CASE
+        WHEN ` + col + ` REGEXP '[+-][0-9]{2}:[0-9]{2}$' THEN
+          CONVERT_TZ(CAST(LEFT(` + col + `, LENGTH(` + col + `)-6) AS DATETIME(6)),
+            RIGHT(` + col + `, 6), '+00:00')
+        ELSE CAST(TRIM(TRAILING 'Z' FROM UPPER(` + col + `)) AS DATETIME(6))
+      END`
+}
+
+// filterValueCheck returns the SQL (and its args) used to check a Props
+// row's PropValue against the filter's value(s). Used for all of the
+// "does it match" style operators (=, !=, ~=, ^=, in(), contains()).
+// Per spec, string compares are case-insensitive unless the case-sensitive
+// form of the operator was used. Non-strings are always an exact match.
+func filterValueCheck(filter *FilterExpr) (string, []any) {
+	collate := FILTER_CI_COLLATE
+	if filter.CaseSensitive {
+		collate = FILTER_CS_COLLATE
+	}
+
+	switch filter.Operator {
+	case FILTER_REGEX:
+		mode := "i"
+		if filter.CaseSensitive {
+			mode = "c"
+		}
+		return "REGEXP_LIKE(PropValue, ?, '" + mode + "')",
+			[]any{filter.Value}
+
+	case FILTER_PREFIX:
+		return "PropValue " + collate + " LIKE ?",
+			[]any{LikeEscape(filter.Value) + "%"}
+
+	case FILTER_IN, FILTER_NOT_IN:
+		marks := strings.TrimSuffix(strings.Repeat("?,", len(filter.Values)),
+			",")
+		args := []any{}
+		for range 2 {
+			for _, v := range filter.Values {
+				args = append(args, v)
+			}
+		}
+		return "((PropType='string' AND PropValue " + collate +
+			" IN (" + marks + ")) OR (PropType<>'string' AND PropValue IN (" +
+			marks + ")))", args
+	}
+
+	// FILTER_EQUAL, FILTER_NOT_EQUAL, FILTER_CONTAINS
+	value, wildcard := LikeWildcardIt(filter.Value)
+	if !wildcard {
+		return "((PropType='string' AND PropValue " + collate + "=?)" +
+			" OR (PropType<>'string' AND PropValue=?))", []any{value, value}
+	}
+	return "((PropType<>'string' AND PropValue=?) " +
+			" OR (PropType='string' AND PropValue " + collate + " LIKE ?))",
+		[]any{value, value}
+}
+
+// filterSubPropName returns the REGEXP for the PropNames that
+// contains(value) (any element of the array) and haskey(key) (the key
+// itself, or anything under it) need to look at.
+func filterSubPropName(filter *FilterExpr) string {
+	if filter.IsDoc {
+		if filter.Operator == FILTER_HASKEY {
+			ptr := filter.DocPointer + "/" + EscapePointerToken(filter.Value)
+			if re, has := DocPointerFilter(ptr); has {
+				return re
+			}
+			return "^" + regexp.QuoteMeta(ptr) + "$"
+		}
+
+		// FILTER_CONTAINS - just the immediate elements of the array. The
+		// caller also checks that the parent really is an array so we don't
+		// pick up an object with numeric-looking keys.
+		base, has := DocPointerFilter(filter.DocPointer)
+		if has {
+			base = strings.TrimSuffix(base, "$")
+		} else {
+			base = "^" + regexp.QuoteMeta(filter.DocPointer)
+		}
+		return base + "/[0-9]+$"
+	}
+
+	base := "^" + regexp.QuoteMeta(filter.PropName)
+	if filter.PP.HasWild {
+		base, _ = filter.PP.DBFilter()
+		base = strings.TrimSuffix(base, "$")
+	}
+
+	if filter.Operator == FILTER_HASKEY {
+		return base + regexp.QuoteMeta(filter.Value+string(DB_IN))
+	}
+
+	// FILTER_CONTAINS - just the immediate elements of the array
+	return base + string(DB_INDEX) + "[0-9]+" + string(DB_IN) + "$"
+}
+
+// LikeEscape escapes the chars that are special in a LIKE pattern
+func LikeEscape(str string) string {
+	str = strings.ReplaceAll(str, `\`, `\\`)
+	str = strings.ReplaceAll(str, "%", `\%`)
+	return strings.ReplaceAll(str, "_", `\_`)
 }
 
 // Convert each non-escaped * into SQL % for LIKE queries
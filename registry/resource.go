@@ -441,7 +441,7 @@ func (r *Resource) EnsureLatest() *XRError {
 	}
 
 	if meta.Get("defaultversionsticky") != true || currentDefault == "" {
-		newDefault, xErr := r.GetNewestVersionID()
+		newDefault, xErr := r.GetNewestPublishedVersionID()
 		Must(xErr)
 		PanicIf(newDefault == "", "No versions")
 
@@ -508,7 +508,7 @@ func (r *Resource) SetDefault(newDefault *Version) *XRError {
 			return xErr
 		}
 
-		newDefaultID, xErr = r.GetNewestVersionID()
+		newDefaultID, xErr = r.GetNewestPublishedVersionID()
 		if xErr != nil {
 			return xErr
 		}
@@ -1429,6 +1429,11 @@ func (r *Resource) ValidateResource(onlyMetaChanged bool, force bool) *XRError {
 			return xErr
 		}
 
+		// Then let the model's "retention" policy, if any, remove more
+		if xErr := r.EnsureRetention(); xErr != nil {
+			return xErr
+		}
+
 		// Flag it if we have more than one root & the resource doesn't allow it
 		if xErr := r.EnsureSingleVersionRoot(); xErr != nil {
 			return xErr
@@ -2111,6 +2116,18 @@ func (r *Resource) EnsureCompat(force bool) *XRError {
 		return xErr
 	}
 
+	// Drafts are skipped entirely, and aren't used as anyone's baseline.
+	// Versions whose ancestor is a draft are checked against the nearest
+	// non-draft ancestor instead.
+	drafts, xErr := r.GetDraftVersionIDs()
+	if xErr != nil {
+		return xErr
+	}
+	rawAncestors := map[string]string{} // v.UID -> v.ancestorID
+	for _, va := range orderedVAs {
+		rawAncestors[va.VID] = va.AncestorID
+	}
+
 	childrenMap := map[string][]string{} // v.UID -> []child.UID
 	changedVersions := []string{}        // v.UID
 
@@ -2175,22 +2192,31 @@ func (r *Resource) EnsureCompat(force bool) *XRError {
 
 	// Loop over all of the Resource's Versions
 	for _, va := range orderedVAs {
+		if drafts[va.VID] {
+			continue
+		}
+
 		ver, xErr := r.FindVersion(va.VID, false)
 		if xErr != nil {
 			return xErr
 		}
 
+		ancestorID := va.AncestorID
+		if drafts[ancestorID] {
+			ancestorID = NonDraftAncestor(va.VID, rawAncestors, drafts)
+		}
+
 		// For each Version, save it's list of ancestors for easy lookup later.
 		// Note that we may need this even if the Version didn't change
-		oldList := childrenMap[va.AncestorID]
-		if va.AncestorID != va.VID {
+		oldList := childrenMap[ancestorID]
+		if ancestorID != va.VID {
 			// Don't add roots to themselves
-			childrenMap[va.AncestorID] = append(oldList, va.VID)
+			childrenMap[ancestorID] = append(oldList, va.VID)
 		}
 
 		// Save for easy look-up later
-		ancestorMap[va.VID] = va.AncestorID
-		PanicIf(va.AncestorID == "", "Not good")
+		ancestorMap[va.VID] = ancestorID
+		PanicIf(ancestorID == "", "Not good")
 
 		// Build our list of changed Versions.
 		// So, either doAll=true, or version's epoch was changed, otherwise
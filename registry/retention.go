@@ -0,0 +1,382 @@
+// Package registry - Resource model "retention" policies.
+//
+// "maxversions" just trims the oldest Versions once there are too many.
+// A "retention" policy (see RetentionPolicy in shared_model) is more
+// selective:
+//
+//	"retention": {
+//	  "keeplatest": 5,           # newest 5 Versions
+//	  "keeppermajor": 2,         # newest 2 Versions of each semver major
+//	  "keepnewerthan": "90d",    # anything created in the last 90 days
+//	  "protectlabels": [ "release", "stage=prod" ],
+//	  "archive": true            # copy to ArchivedVersions before deleting
+//	}
+//
+// Policies are applied on each write of a Resource (EnsureRetention) and,
+// since "keepnewerthan" depends on the current time, optionally by a
+// background sweeper (StartRetentionSweeper). SweepRetention can also be
+// run in dry-run mode to see what would be removed.
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/duglin/dlog"
+	. "github.com/xregistry/server/common"
+)
+
+const (
+	RETENTION_DELETE  = "delete"
+	RETENTION_ARCHIVE = "archive"
+)
+
+// RetentionAction is one Version that the policy removed (or would
+// remove, when it's a dry-run)
+type RetentionAction struct {
+	XID    string `json:"xid"`
+	Action string `json:"action"` // delete, archive
+}
+
+// RetentionCandidate is what SelectRetention needs to know about a Version
+type RetentionCandidate struct {
+	VID       string
+	CreatedAt time.Time
+	Labels    map[string]any
+	Protected bool // e.g. the default Version
+}
+
+// SemverMajor returns the major version # of "vid" (e.g. "2" for "v2.1.0")
+// or "" if it doesn't look like a semver value. All non-semver IDs end up
+// in the same "" bucket for "keeppermajor".
+func SemverMajor(vid string) string {
+	vid = strings.TrimPrefix(strings.TrimPrefix(vid, "v"), "V")
+	major, _, _ := strings.Cut(vid, ".")
+	if major == "" {
+		return ""
+	}
+	if _, err := strconv.ParseUint(major, 10, 64); err != nil {
+		return ""
+	}
+	// "01" and "1" are the same major, and "0" and "00" are both "0"
+	if major = strings.TrimLeft(major, "0"); major == "" {
+		return "0"
+	}
+	return major
+}
+
+// HasProtectedLabel returns true if any of the "protect" entries (either
+// "name" or "name=value") matches "labels"
+func HasProtectedLabel(labels map[string]any, protect []string) bool {
+	for _, p := range protect {
+		name, value, hasValue := strings.Cut(p, "=")
+		val, ok := labels[name]
+		if !ok {
+			continue
+		}
+		if !hasValue || fmt.Sprintf("%v", val) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SelectRetention returns the IDs of the Versions that "policy" says can
+// be removed. "versions" must be ordered oldest to newest, the same as
+// GetOrderedVersionIDs(). The result is in the same order.
+func SelectRetention(policy *RetentionPolicy, versions []*RetentionCandidate, now time.Time) []string {
+	if !policy.HasKeepRules() {
+		return nil
+	}
+
+	keep := map[string]bool{}
+
+	if policy.KeepLatest > 0 {
+		for i := len(versions) - 1; i >= 0 &&
+			i >= len(versions)-policy.KeepLatest; i-- {
+			keep[versions[i].VID] = true
+		}
+	}
+
+	if policy.KeepPerMajor > 0 {
+		perMajor := map[string]int{}
+		for i := len(versions) - 1; i >= 0; i-- {
+			major := SemverMajor(versions[i].VID)
+			if perMajor[major] < policy.KeepPerMajor {
+				perMajor[major]++
+				keep[versions[i].VID] = true
+			}
+		}
+	}
+
+	if policy.KeepNewerThan != "" {
+		// Already verified as part of the model
+		age, _ := ParseRetentionAge(policy.KeepNewerThan)
+		cutoff := now.Add(-age)
+		for _, v := range versions {
+			if v.CreatedAt.After(cutoff) {
+				keep[v.VID] = true
+			}
+		}
+	}
+
+	list := []string(nil)
+	for _, v := range versions {
+		if keep[v.VID] || v.Protected ||
+			HasProtectedLabel(v.Labels, policy.ProtectLabels) {
+			continue
+		}
+		list = append(list, v.VID)
+	}
+	return list
+}
+
+// IsXrefTarget returns true if any other Resource uses "xref" to point to
+// this one. Their Versions are really ours, so none of ours can go away.
+func (r *Resource) IsXrefTarget() bool {
+	if !r.tx.Registry.UsesXref {
+		return false
+	}
+
+	results := Query(r.tx, `
+        SELECT 1 FROM Metas WHERE RegistrySID=? AND xRefPath=? LIMIT 1`,
+		r.Registry.DbSID, r.Path)
+	defer results.Close()
+
+	return results.NextRow() != nil
+}
+
+// EnsureRetention applies the Resource model's "retention" policy, if any
+func (r *Resource) EnsureRetention() *XRError {
+	_, xErr := r.ApplyRetention(time.Now(), false)
+	return xErr
+}
+
+// ApplyRetention removes (archives or deletes) the Versions that the
+// Resource model's "retention" policy doesn't keep, as of "now". When
+// "dryRun" is true nothing is changed, we just return what would happen.
+func (r *Resource) ApplyRetention(now time.Time, dryRun bool) ([]RetentionAction, *XRError) {
+	if r.IsXref() {
+		return nil, nil
+	}
+
+	policy := r.GetResourceModel().Retention
+	if !policy.HasKeepRules() {
+		return nil, nil
+	}
+
+	if r.IsXrefTarget() {
+		return nil, nil
+	}
+
+	verIDs, xErr := r.GetOrderedVersionIDs()
+	if xErr != nil {
+		return nil, xErr
+	}
+
+	tmp := r.Get("defaultversionid")
+	defaultID := NotNilString(&tmp)
+
+	candidates := []*RetentionCandidate{}
+	for _, verID := range verIDs {
+		c := &RetentionCandidate{
+			VID:       verID.VID,
+			Protected: verID.VID == defaultID,
+		}
+		c.CreatedAt, _ = ConvertStrToTime(verID.CreatedAt)
+
+		if len(policy.ProtectLabels) > 0 {
+			v, xErr := r.FindVersion(verID.VID, false)
+			if xErr != nil {
+				return nil, xErr
+			}
+			if v != nil {
+				c.Labels, _ = v.Get("labels").(map[string]any)
+			}
+		}
+		candidates = append(candidates, c)
+	}
+
+	action := RETENTION_DELETE
+	if policy.Archive {
+		action = RETENTION_ARCHIVE
+	}
+
+	actions := []RetentionAction{}
+	for _, vID := range SelectRetention(policy, candidates, now) {
+		v, xErr := r.FindVersion(vID, false)
+		if xErr != nil {
+			return nil, xErr
+		}
+		if v == nil {
+			continue
+		}
+
+		if !dryRun {
+			if policy.Archive {
+				if xErr = v.Archive(now); xErr != nil {
+					return nil, xErr
+				}
+			}
+			if xErr = v.DeleteSetNextVersion(""); xErr != nil {
+				return nil, xErr
+			}
+		}
+		actions = append(actions, RetentionAction{XID: v.XID, Action: action})
+	}
+
+	return actions, nil
+}
+
+// Archive saves a copy of the Version (its attributes and its document,
+// if it has one) in the ArchivedVersions table. The Version itself isn't
+// touched.
+func (v *Version) Archive(now time.Time) *XRError {
+	obj := map[string]any{}
+	for k, val := range v.Object {
+		if !strings.HasPrefix(k, "#") {
+			obj[k] = val
+		}
+	}
+
+	buf, err := json.Marshal(obj)
+	if err != nil {
+		return NewXRError("server_error", v.XID).
+			SetDetailf("Error archiving: %s.", err.Error())
+	}
+
+	content := []byte(nil)
+	if rm := v.GetResourceModel(); rm.GetHasDocument() {
+		content, _ = v.Get(rm.Singular).([]byte)
+	}
+
+	Do(v.tx, `
+        INSERT INTO ArchivedVersions(SID,RegistrySID,Path,ArchivedAt,
+          Object,Content)
+        VALUES(?,?,?,?,?,?)`,
+		NewUUID(), v.Registry.DbSID, v.Path,
+		now.UTC().Format(time.RFC3339Nano), string(buf), content)
+
+	return nil
+}
+
+// StartRetentionSweeper re-applies the retention policies of all
+// Registries every "interval". Writes already apply them, but things
+// like "keepnewerthan" change over time even when nothing is written.
+func StartRetentionSweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	log.VPrintf(1, "Retention sweeper: every %s", interval)
+
+	go func() {
+		for {
+			time.Sleep(interval)
+
+			names, xErr := GetRegistryNames()
+			if xErr != nil {
+				log.Printf("Retention sweeper: %s", xErr)
+				continue
+			}
+
+			for _, name := range names {
+				sweepRetentionAndLog(name)
+			}
+		}
+	}()
+}
+
+// sweepRetentionAndLog runs one registry's pass of the sweeper. A panic
+// is logged so that one bad registry doesn't stop the sweeper for all
+// of the others.
+func sweepRetentionAndLog(name string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			log.Printf("Retention sweeper(%s): panic: %v", name, rec)
+		}
+	}()
+
+	actions, xErr := SweepRetention(name, time.Now(), false)
+	if xErr != nil {
+		log.Printf("Retention sweeper(%s): %s", name, xErr)
+	}
+	for _, a := range actions {
+		log.VPrintf(1, "Retention sweeper(%s): %s %s", name, a.Action, a.XID)
+	}
+}
+
+// SweepRetention applies the retention policies to all Resources in
+// registry "name". When "dryRun" is true nothing is saved.
+func SweepRetention(name string, now time.Time, dryRun bool) ([]RetentionAction, *XRError) {
+	reg, xErr := FindRegistry(nil, name, FOR_WRITE)
+	if xErr != nil || reg == nil {
+		return nil, xErr
+	}
+
+	// No-op once we've committed, but cleans up if we panic
+	defer reg.Rollback()
+
+	actions, xErr := reg.SweepRetention(now, dryRun)
+	if xErr != nil || dryRun {
+		return actions, xErr
+	}
+	return actions, reg.Commit()
+}
+
+func (reg *Registry) SweepRetention(now time.Time, dryRun bool) ([]RetentionAction, *XRError) {
+	abstracts := []string{}
+	for _, gm := range reg.Model.Groups {
+		for _, rm := range gm.Resources {
+			if rm.Retention.HasKeepRules() {
+				abstracts = append(abstracts, gm.Plural+string(DB_IN)+
+					rm.Plural)
+			}
+		}
+	}
+	if len(abstracts) == 0 {
+		return nil, nil
+	}
+	sort.Strings(abstracts)
+
+	paths := []string{}
+	for _, abs := range abstracts {
+		results := Query(reg.tx, `
+            SELECT Path FROM Entities
+            WHERE RegSID=? AND Type=? AND Abstract=?
+            ORDER BY Path`,
+			reg.DbSID, ENTITY_RESOURCE, abs)
+		for row := results.NextRow(); row != nil; row = results.NextRow() {
+			paths = append(paths, NotNilString(row[0]))
+		}
+		results.Close()
+	}
+
+	actions := []RetentionAction{}
+	for _, path := range paths {
+		r, xErr := reg.FindResourceByXID("/"+path, "/"+path, FOR_WRITE)
+		if xErr != nil {
+			return nil, xErr
+		}
+		if r == nil {
+			continue
+		}
+
+		// Leave readonly Resources alone
+		if meta := r.MustFindMeta(false); meta.Get("readonly") == true {
+			continue
+		}
+
+		list, xErr := r.ApplyRetention(now, dryRun)
+		if xErr != nil {
+			return nil, xErr
+		}
+		actions = append(actions, list...)
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSemverMajor(t *testing.T) {
+	tests := []struct {
+		vid string
+		exp string
+	}{
+		{"1.2.3", "1"},
+		{"v2.0", "2"},
+		{"V10", "10"},
+		{"01.5", "1"},
+		{"0.9.1", "0"},
+		{"v00.1", "0"},
+		{"abc", ""},
+		{"", ""},
+		{"1a.2", ""},
+	}
+
+	for _, test := range tests {
+		if res := SemverMajor(test.vid); res != test.exp {
+			t.Fatalf("%q: expected %q, got %q", test.vid, test.exp, res)
+		}
+	}
+}
+
+func TestParseRetentionAge(t *testing.T) {
+	tests := []struct {
+		str string
+		exp time.Duration
+		err bool
+	}{
+		{"90d", 90 * 24 * time.Hour, false},
+		{"1d12h", 36 * time.Hour, false},
+		{"30m", 30 * time.Minute, false},
+		{"0d", 0, false},
+		{"xd", 0, true},
+		{"-1h", 0, true},
+		{"1w", 0, true},
+		{"", 0, true},
+	}
+
+	for _, test := range tests {
+		d, err := ParseRetentionAge(test.str)
+		if (err != nil) != test.err || d != test.exp {
+			t.Fatalf("%q: expected %s/%v, got %s/%v", test.str, test.exp,
+				test.err, d, err)
+		}
+	}
+}
+
+func TestSelectRetention(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	// Oldest to newest
+	versions := []*RetentionCandidate{
+		{VID: "1.0.0", CreatedAt: now.Add(-50 * day)},
+		{VID: "1.1.0", CreatedAt: now.Add(-40 * day),
+			Labels: map[string]any{"stage": "prod"}},
+		{VID: "1.2.0", CreatedAt: now.Add(-30 * day), Protected: true},
+		{VID: "2.0.0", CreatedAt: now.Add(-20 * day),
+			Labels: map[string]any{"release": "x"}},
+		{VID: "2.1.0", CreatedAt: now.Add(-10 * day)},
+		{VID: "2.2.0", CreatedAt: now.Add(-1 * day)},
+	}
+
+	tests := []struct {
+		name   string
+		policy *RetentionPolicy
+		exp    string
+	}{
+		{"nil", nil, ""},
+		{"no keep rules", &RetentionPolicy{Archive: true}, ""},
+		{"latest", &RetentionPolicy{KeepLatest: 2},
+			"1.0.0,1.1.0,2.0.0"},
+		{"latest - more than we have", &RetentionPolicy{KeepLatest: 10},
+			""},
+		{"per major", &RetentionPolicy{KeepPerMajor: 1},
+			"1.0.0,1.1.0,2.0.0,2.1.0"},
+		{"newer than", &RetentionPolicy{KeepNewerThan: "15d"},
+			"1.0.0,1.1.0,2.0.0"},
+		{"union", &RetentionPolicy{KeepLatest: 1, KeepNewerThan: "45d"},
+			"1.0.0"},
+		{"labels", &RetentionPolicy{KeepLatest: 1,
+			ProtectLabels: []string{"release", "stage=prod"}},
+			"1.0.0,2.1.0"},
+		{"label value mismatch", &RetentionPolicy{KeepLatest: 1,
+			ProtectLabels: []string{"stage=dev"}},
+			"1.0.0,1.1.0,2.0.0,2.1.0"},
+	}
+
+	for _, test := range tests {
+		res := strings.Join(SelectRetention(test.policy, versions, now), ",")
+		if res != test.exp {
+			t.Fatalf("%s: expected %q, got %q", test.name, test.exp, res)
+		}
+	}
+}
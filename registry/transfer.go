@@ -0,0 +1,399 @@
+// Package registry - content-codings and byte ranges.
+//
+// Request bodies can be compressed (Content-Encoding), responses are
+// compressed when the client asks for it (Accept-Encoding) and documents
+// can be fetched in parts (Range). All of it is per Registry, see the
+// "encodings" and "ranges" capabilities.
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	log "github.com/duglin/dlog"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/xregistry/server/common"
+)
+
+// Responses smaller than this aren't worth compressing
+const COMPRESS_MIN_SIZE = 1024
+
+// Max size of a decompressed request body, so a small gzip bomb can't eat
+// all of our memory
+const DECODED_BODY_MAXSIZE = 256 * 1024 * 1024
+
+// Max zstd window, RFC 9659 says HTTP clients don't need to support more
+const ZSTD_WINDOW_MAXSIZE = 8 * 1024 * 1024
+
+// Content-Types that are already compressed
+var compressedTypes = []string{
+	"application/gzip", "application/x-gzip", "application/zip",
+	"application/zstd", "application/x-7z-compressed",
+	"application/x-rar-compressed", "audio/", "image/", "video/"}
+
+// DecodeRequestBody undoes any "Content-Encoding" of info.Body
+func DecodeRequestBody(info *RequestInfo) *XRError {
+	encoding := strings.ToLower(strings.TrimSpace(
+		info.OriginalRequest.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || info.Body == nil {
+		return nil
+	}
+
+	caps := info.Registry.Capabilities
+	if encoding == "x-gzip" {
+		encoding = "gzip"
+	}
+	if !ArrayContains(SupportedEncodings, encoding) ||
+		!caps.EncodingEnabled(encoding) {
+		info.SetHeader("Accept-Encoding", strings.Join(caps.Encodings, ", "))
+		return NewXRError("unsupported_encoding", "/"+info.OriginalPath,
+			"encoding="+encoding,
+			"list="+strings.Join(caps.Encodings, ","))
+	}
+
+	zr, err := newDecoder(encoding, bytes.NewReader(info.Body))
+	if err == nil {
+		defer zr.Close()
+		info.Body, err = io.ReadAll(io.LimitReader(zr,
+			DECODED_BODY_MAXSIZE+1))
+	}
+	if err != nil {
+		return NewXRError("parsing_data", "/"+info.OriginalPath,
+			"error_detail=error decompressing the body: "+err.Error())
+	}
+	if len(info.Body) > DECODED_BODY_MAXSIZE {
+		return NewXRError("parsing_data", "/"+info.OriginalPath,
+			fmt.Sprintf("error_detail=the decompressed body is larger "+
+				"than %d bytes", DECODED_BODY_MAXSIZE))
+	}
+	if len(info.Body) == 0 {
+		info.Body = nil
+	}
+	return nil
+}
+
+// newDecoder returns a reader of the decompressed form of "r"
+func newDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(ZSTD_WINDOW_MAXSIZE))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// encoder is what compresses a response, gzip.Writer or zstd.Encoder
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newEncoder(encoding string, w io.Writer) (encoder, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1),
+			zstd.WithWindowSize(ZSTD_WINDOW_MAXSIZE))
+	}
+	return nil, fmt.Errorf("unknown encoding %q", encoding)
+}
+
+// NegotiateEncoding returns the content-coding to use for the response,
+// based on the client's "Accept-Encoding" and the Registry's capabilities,
+// or "" for none
+func NegotiateEncoding(info *RequestInfo) string {
+	accept := info.OriginalRequest.Header.Get("Accept-Encoding")
+	if accept == "" || info.Registry == nil {
+		return ""
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok &&
+			strings.TrimSpace(k) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+				continue
+			}
+		}
+
+		names := []string{name}
+		if name == "*" {
+			names = info.Registry.Capabilities.Encodings
+		} else if name == "x-gzip" {
+			names = []string{"gzip"}
+		}
+		for _, n := range names {
+			if q > bestQ && info.Registry.Capabilities.EncodingEnabled(n) {
+				best, bestQ = n, q
+			}
+		}
+	}
+	return best
+}
+
+// compressWriter sits between us and the real http.ResponseWriter. It
+// holds onto the first COMPRESS_MIN_SIZE bytes of the response before
+// deciding whether to compress it, so small responses, and ones that
+// can't (or shouldn't) be compressed, go out as is.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	zw       encoder // nil if not compressing
+}
+
+var _ http.Flusher = &compressWriter{}
+
+func newCompressWriter(w http.ResponseWriter, encoding string) *compressWriter {
+	return &compressWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+	}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if !cw.decided && cw.status == 0 {
+		cw.status = code
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.decided {
+		if cw.zw != nil {
+			return cw.zw.Write(b)
+		}
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= COMPRESS_MIN_SIZE {
+		if err := cw.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+func (cw *compressWriter) canCompress() bool {
+	status := cw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if status < 200 || status == http.StatusNoContent ||
+		status == http.StatusPartialContent ||
+		status == http.StatusNotModified {
+		return false
+	}
+
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+
+	// Repr-Digest/Digest are of the bytes as stored, compressing them would
+	// make the digest wrong for the representation the client gets
+	if h.Get("Repr-Digest") != "" || h.Get("Digest") != "" {
+		return false
+	}
+
+	ct := strings.ToLower(h.Get("Content-Type"))
+	for _, prefix := range compressedTypes {
+		if strings.HasPrefix(ct, prefix) && !strings.Contains(ct, "+xml") {
+			return false
+		}
+	}
+	return true
+}
+
+// decide sends the headers, and whatever was buffered, either compressed
+// or not
+func (cw *compressWriter) decide(compress bool) error {
+	cw.decided = true
+
+	if compress && cw.canCompress() {
+		zw, err := newEncoder(cw.encoding, cw.ResponseWriter)
+		if err != nil {
+			return err
+		}
+		cw.zw = zw
+
+		h := cw.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.encoding)
+		h.Add("Vary", "Accept-Encoding")
+
+		// The compressed bytes aren't the ones a strong ETag vouches for,
+		// but they're still the same representation, so make it weak
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.Write(buf)
+	return err
+}
+
+// Flush is used when streaming, so compress from here on
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+	if cw.zw != nil {
+		cw.zw.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close must be called once the response is done
+func (cw *compressWriter) Close() {
+	if !cw.decided {
+		cw.decide(false)
+	}
+	if cw.zw != nil {
+		if err := cw.zw.Close(); err != nil {
+			log.VPrintf(2, "Error finishing compressed response: %s", err)
+		}
+		cw.zw = nil
+	}
+}
+
+// parseRange parses a "Range" header for a document of "size" bytes.
+// Only one "bytes" range is supported, anything else returns ok=false so
+// the whole document is sent instead, as allowed by RFC 9110.
+func parseRange(header string, size int64) (start int64, length int64,
+	ok bool, satisfiable bool) {
+
+	spec, found := strings.CutPrefix(strings.TrimSpace(header), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, false
+	}
+
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, false
+	}
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+
+	if first == "" {
+		// "-N" is the last N bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, false
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, true, false
+		}
+		n = min(n, size)
+		return size - n, n, true, true
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, false
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil ||
+			end < start {
+			return 0, 0, false, false
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return 0, 0, true, false
+	}
+	return start, end - start + 1, true, true
+}
+
+// ServeDocument writes "doc", which is "size" bytes, as the response, or
+// just the part of it that's asked for by a "Range" header
+func ServeDocument(info *RequestInfo, doc io.Reader, size int64) *XRError {
+	ranges := info.Registry.Capabilities.RangesEnabled()
+	if ranges {
+		info.SetHeader("Accept-Ranges", "bytes")
+	}
+
+	req := info.OriginalRequest
+	header := req.Header.Get("Range")
+
+	// Only on a plain GET, not the response to a PUT/POST. And if there's
+	// an "If-Range" it has to match our ETag, else it's the whole thing
+	useRange := ranges && header != "" && req.Method == "GET" &&
+		info.StatusCode == 0
+	if ifRange := req.Header.Get("If-Range"); useRange && ifRange != "" {
+		etag := info.GetHeader("ETag")
+		useRange = etag != "" && !strings.HasPrefix(etag, "W/") &&
+			ifRange == etag
+	}
+
+	if useRange {
+		start, length, ok, satisfiable := parseRange(header, size)
+		if ok && !satisfiable {
+			// The error is JSON, not the document's type
+			info.OriginalResponse.Header().Del("Content-Type")
+			info.SetHeader("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return NewXRError("range_not_satisfiable", "/"+info.OriginalPath,
+				"range="+header,
+				fmt.Sprintf("size=%d", size))
+		}
+		if ok {
+			if start > 0 {
+				var err error
+				if seeker, isSeeker := doc.(io.Seeker); isSeeker {
+					_, err = seeker.Seek(start, io.SeekStart)
+				} else {
+					_, err = io.CopyN(io.Discard, doc, start)
+				}
+				if err != nil {
+					return NewXRError("server_error", "/"+info.OriginalPath).
+						SetDetailf("Error reading document: %s.", err)
+				}
+			}
+
+			info.StatusCode = http.StatusPartialContent
+			info.SetHeader("Content-Range", fmt.Sprintf("bytes %d-%d/%d",
+				start, start+length-1, size))
+			info.SetHeader("Content-Length", strconv.FormatInt(length, 10))
+			doc = io.LimitReader(doc, length)
+			size = length
+		}
+	}
+
+	if info.GetHeader("Content-Length") == "" {
+		info.SetHeader("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	if _, err := io.Copy(info, doc); err != nil {
+		log.Printf("Error sending document %q: %s", info.OriginalPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package registry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/xregistry/server/common"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		header          string
+		start, length   int64
+		ok, satisfiable bool
+	}{
+		{"bytes=0-4", 0, 5, true, true},
+		{"bytes=2-", 2, 8, true, true},
+		{"bytes=-3", 7, 3, true, true},
+		{"bytes=-30", 0, 10, true, true},
+		{"bytes=8-20", 8, 2, true, true},
+		{" bytes= 1 - 1 ", 1, 1, true, true},
+		{"bytes=10-", 0, 0, true, false},
+		{"bytes=-0", 0, 0, true, false},
+		{"bytes=0-1,3-4", 0, 0, false, false},
+		{"bytes=4-2", 0, 0, false, false},
+		{"bytes=x-", 0, 0, false, false},
+		{"bytes=5", 0, 0, false, false},
+		{"items=0-1", 0, 0, false, false},
+		{"", 0, 0, false, false},
+	} {
+		start, length, ok, satisfiable := parseRange(test.header, 10)
+		if start != test.start || length != test.length || ok != test.ok ||
+			satisfiable != test.satisfiable {
+			t.Fatalf("%q: got %d,%d,%v,%v", test.header, start, length, ok,
+				satisfiable)
+		}
+	}
+}
+
+func newTransferInfo(header ...string) *RequestInfo {
+	req := httptest.NewRequest("GET", "/", nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	info := NewRequestInfo(httptest.NewRecorder(), req)
+	info.Registry = &Registry{Capabilities: DefaultCapabilities.Clone()}
+	return info
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, test := range []struct {
+		accept, exp string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"GZIP", "gzip"},
+		{"x-gzip", "gzip"},
+		{"*", "gzip"},
+		{"br, gzip;q=0.1", "gzip"},
+		{"gzip;q=0", ""},
+		{"gzip;q=bad", ""},
+		{"identity", ""},
+		{"br", ""},
+		{"br, zstd", "zstd"},
+		{"gzip;q=0.5, zstd", "zstd"},
+		{"zstd;q=0.5, gzip", "gzip"},
+	} {
+		got := NegotiateEncoding(newTransferInfo("Accept-Encoding", test.accept))
+		if got != test.exp {
+			t.Fatalf("%q: got %q, expected %q", test.accept, got, test.exp)
+		}
+	}
+
+	info := newTransferInfo("Accept-Encoding", "gzip")
+	info.Registry.Capabilities.Encodings = []string{}
+	if got := NegotiateEncoding(info); got != "" {
+		t.Fatalf("Encodings are off, got %q", got)
+	}
+}
+
+func TestDecodeRequestBody(t *testing.T) {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write([]byte("hello"))
+	zw.Close()
+
+	info := newTransferInfo("Content-Encoding", "gzip")
+	info.Body = buf.Bytes()
+	if xErr := DecodeRequestBody(info); xErr != nil ||
+		string(info.Body) != "hello" {
+		t.Fatalf("Decode: %q %v", string(info.Body), xErr)
+	}
+
+	info = newTransferInfo("Content-Encoding", "gzip")
+	info.Body = []byte("hello")
+	if xErr := DecodeRequestBody(info); xErr == nil ||
+		xErr.Code != http.StatusBadRequest {
+		t.Fatalf("Bad gzip should have failed: %v", xErr)
+	}
+
+	info = newTransferInfo("Content-Encoding", "br")
+	info.Body = []byte("hello")
+	xErr := DecodeRequestBody(info)
+	if xErr == nil || xErr.Code != http.StatusUnsupportedMediaType ||
+		info.GetHeader("Accept-Encoding") != "gzip, zstd" {
+		t.Fatalf("br should have failed: %v", xErr)
+	}
+
+	info = newTransferInfo()
+	info.Body = []byte("hello")
+	if xErr := DecodeRequestBody(info); xErr != nil ||
+		string(info.Body) != "hello" {
+		t.Fatalf("Plain body: %q %v", string(info.Body), xErr)
+	}
+
+	zenc, _ := zstd.NewWriter(nil)
+	info = newTransferInfo("Content-Encoding", "zstd")
+	info.Body = zenc.EncodeAll([]byte("hello"), nil)
+	if xErr := DecodeRequestBody(info); xErr != nil ||
+		string(info.Body) != "hello" {
+		t.Fatalf("Decode zstd: %q %v", string(info.Body), xErr)
+	}
+
+	info = newTransferInfo("Content-Encoding", "zstd")
+	info.Body = []byte("hello")
+	if xErr := DecodeRequestBody(info); xErr == nil ||
+		xErr.Code != http.StatusBadRequest {
+		t.Fatalf("Bad zstd should have failed: %v", xErr)
+	}
+}
+
+func TestCompressWriter(t *testing.T) {
+	big := strings.Repeat("x", COMPRESS_MIN_SIZE)
+
+	encoding := "gzip"
+	etag := ""
+	send := func(status int, contentType string, body ...string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		cw := newCompressWriter(rec, encoding)
+		if contentType != "" {
+			cw.Header().Set("Content-Type", contentType)
+		}
+		if etag != "" {
+			cw.Header().Set("ETag", etag)
+		}
+		cw.Header().Set("Content-Length", "123")
+		cw.WriteHeader(status)
+		for _, b := range body {
+			cw.Write([]byte(b))
+		}
+		cw.Close()
+		return rec
+	}
+
+	gunzip := func(rec *httptest.ResponseRecorder) string {
+		zr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Not gzip'd: %s", err)
+		}
+		buf, _ := io.ReadAll(zr)
+		return string(buf)
+	}
+
+	// Small ones aren't compressed
+	rec := send(201, "", "hello")
+	if rec.Code != 201 || rec.Body.String() != "hello" ||
+		rec.Header().Get("Content-Encoding") != "" ||
+		rec.Header().Get("Content-Length") != "123" {
+		t.Fatalf("Small: %d %v %q", rec.Code, rec.Header(), rec.Body)
+	}
+
+	rec = send(200, "application/json", big[:10], big[10:], "end")
+	if rec.Code != 200 || rec.Header().Get("Content-Encoding") != "gzip" ||
+		rec.Header().Get("Vary") != "Accept-Encoding" ||
+		rec.Header().Get("Content-Length") != "" ||
+		gunzip(rec) != big+"end" {
+		t.Fatalf("Big: %d %v", rec.Code, rec.Header())
+	}
+
+	// Nor are ones that already are, or partial ones
+	for _, test := range []struct {
+		status int
+		ct     string
+	}{
+		{200, "image/png"},
+		{200, "application/zip"},
+		{206, "text/plain"},
+	} {
+		rec = send(test.status, test.ct, big)
+		if rec.Code != test.status || rec.Body.String() != big ||
+			rec.Header().Get("Content-Encoding") != "" {
+			t.Fatalf("%d %s: %v", test.status, test.ct, rec.Header())
+		}
+	}
+	rec = send(200, "image/svg+xml", big)
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("svg should be compressed: %v", rec.Header())
+	}
+
+	// Strong ETags become weak once compressed, since the bytes changed
+	etag = `"abc"`
+	if rec = send(200, "", big); rec.Header().Get("ETag") != `W/"abc"` {
+		t.Fatalf("ETag: %v", rec.Header())
+	}
+	if rec = send(200, "", "hello"); rec.Header().Get("ETag") != `"abc"` {
+		t.Fatalf("Uncompressed ETag: %v", rec.Header())
+	}
+	etag = `W/"abc"`
+	if rec = send(200, "", big); rec.Header().Get("ETag") != `W/"abc"` {
+		t.Fatalf("Weak ETag: %v", rec.Header())
+	}
+	etag = ""
+
+	// Nor are ones with a digest of the uncompressed bytes
+	for _, header := range []string{"Repr-Digest", "Digest"} {
+		rec = httptest.NewRecorder()
+		cw := newCompressWriter(rec, "gzip")
+		cw.Header().Set(header, "sha-256=:abc=:")
+		cw.Write([]byte(big))
+		cw.Close()
+		if rec.Body.String() != big ||
+			rec.Header().Get("Content-Encoding") != "" ||
+			rec.Header().Get(header) != "sha-256=:abc=:" {
+			t.Fatalf("%s: %v", header, rec.Header())
+		}
+	}
+
+	// zstd too
+	encoding = "zstd"
+	rec = send(200, "application/json", big)
+	if rec.Header().Get("Content-Encoding") != "zstd" {
+		t.Fatalf("zstd: %v", rec.Header())
+	}
+	zr, _ := zstd.NewReader(nil)
+	if buf, err := zr.DecodeAll(rec.Body.Bytes(), nil); err != nil ||
+		string(buf) != big {
+		t.Fatalf("Not zstd'd: %v", err)
+	}
+	encoding = "gzip"
+
+	// Nothing written at all is still just the status
+	rec = send(404, "")
+	if rec.Code != 404 || rec.Body.Len() != 0 ||
+		rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Empty: %d %v", rec.Code, rec.Header())
+	}
+
+	// Once flushed it's compressed, no matter the size
+	rec = httptest.NewRecorder()
+	cw := newCompressWriter(rec, "gzip")
+	cw.Write([]byte("hi"))
+	cw.Flush()
+	cw.Write([]byte(" there"))
+	cw.Close()
+	if !rec.Flushed || gunzip(rec) != "hi there" {
+		t.Fatalf("Flush: %v %v", rec.Flushed, rec.Header())
+	}
+}
@@ -294,9 +294,14 @@ func GenerateUI(info *RequestInfo, data []byte) []byte {
 	if info.FlagEnabled("sort") && (info.What == "Coll") {
 		checked := ""
 		val := info.GetFlag("sort")
-		val, desc, _ := strings.Cut(val, "=")
-		if desc == "desc" {
-			checked = " checked"
+		// Only split out the "desc" checkbox for simple single key sorts,
+		// anything fancier is just left as-is in the textbox
+		if !strings.ContainsAny(val, ",:") {
+			name, desc, _ := strings.Cut(val, "=")
+			if desc == "desc" {
+				val = name
+				checked = " checked"
+			}
 		}
 		sortKey = "<div class=sortsection>\n" +
 			"  <b>Sort:</b>\n" +
@@ -324,9 +329,10 @@ func GenerateUI(info *RequestInfo, data []byte) []byte {
 				next := MustPropPathFromDB(FE.Path).UI()
 				next, _ = strings.CutPrefix(next, prefix)
 				subF += next
-				if FE.Operator == FILTER_EQUAL {
-					subF += "=" + FE.Value
+				if FE.IsDoc {
+					subF += "#" + FE.DocPointer
 				}
+				subF += FE.OpValue()
 			}
 			filters += subF
 		}
@@ -3011,8 +3017,9 @@ function apply() {
   var elem = document.getElementById("sortkey")
   if (elem != null && elem.value != "") {
     loc += "` + AMP + `sort=" + elem.value
-    elem = document.getElementById("sortdesc")
-    if (elem != null && elem.checked) {
+    var desc = document.getElementById("sortdesc")
+    if (desc != null && desc.checked && elem.value.indexOf("=") < 0 &&
+        elem.value.indexOf(",") < 0) {
       loc += "=desc"
     }
   }
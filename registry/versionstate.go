@@ -0,0 +1,211 @@
+// Package registry - Version "state" (draft, published, withdrawn).
+//
+// When a Resource model has "versionstates": true each of its Versions
+// has a "state" attribute:
+//
+//	draft -> published -> withdrawn
+//
+// Drafts can be edited freely but they aren't considered when picking the
+// default Version, they aren't used as the baseline for compatibility
+// checks, and they're hidden from unfiltered listings. They become real
+// Versions via "POST .../versions/vID?publish" (or by setting "state"),
+// at which point they go through the full set of validation checks.
+// Once a Version leaves the "draft" state it can't go back.
+package registry
+
+import (
+	"strings"
+
+	. "github.com/xregistry/server/common"
+)
+
+const (
+	VERSION_STATE_DRAFT     = "draft"
+	VERSION_STATE_PUBLISHED = "published"
+	VERSION_STATE_WITHDRAWN = "withdrawn"
+)
+
+// CheckVersionStateChange verifies that a Version (xid) can move from
+// "oldState" to "newState". An empty "oldState" means it's a new Version.
+func CheckVersionStateChange(xid string, oldState string, newState string) *XRError {
+	if oldState == "" || oldState == newState {
+		return nil
+	}
+	if newState == VERSION_STATE_DRAFT {
+		return NewXRError("version_state", xid,
+			"from="+oldState,
+			"to="+newState)
+	}
+	return nil
+}
+
+// NonDraftAncestor walks up the "ancestors" (vID -> ancestorID) chain of
+// "vID" until it finds a Version that isn't a draft. If there isn't one
+// then "vID" is treated as a root and is returned.
+func NonDraftAncestor(vID string, ancestors map[string]string, drafts map[string]bool) string {
+	seen := map[string]bool{vID: true}
+	for next := ancestors[vID]; next != "" && !seen[next]; next = ancestors[next] {
+		if !drafts[next] {
+			return next
+		}
+		seen[next] = true
+	}
+	return vID
+}
+
+// UsesVersionStates returns true if any Resource model has turned on
+// "versionstates"
+func (reg *Registry) UsesVersionStates() bool {
+	for _, gm := range reg.Model.Groups {
+		for _, rm := range gm.Resources {
+			if rm.GetVersionStates() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetState returns the Version's "state", or "published" if the Resource
+// model doesn't use states
+func (v *Version) GetState() string {
+	if !v.GetResourceModel().GetVersionStates() {
+		return VERSION_STATE_PUBLISHED
+	}
+	if state := v.GetAsString("state"); state != "" {
+		return state
+	}
+	return VERSION_STATE_PUBLISHED
+}
+
+// GetDraftVersionIDs returns the set of this Resource's Versions that are
+// still drafts
+func (r *Resource) GetDraftVersionIDs() (map[string]bool, *XRError) {
+	drafts := map[string]bool{}
+	if !r.GetResourceModel().GetVersionStates() {
+		return drafts, nil
+	}
+
+	vIDs, xErr := r.GetVersionIDs()
+	if xErr != nil {
+		return nil, xErr
+	}
+	for _, vID := range vIDs {
+		v, xErr := r.FindVersion(vID, false)
+		if xErr != nil {
+			return nil, xErr
+		}
+		if v != nil && v.GetState() == VERSION_STATE_DRAFT {
+			drafts[vID] = true
+		}
+	}
+	return drafts, nil
+}
+
+// GetNewestPublishedVersionID is the same as GetNewestVersionID except
+// that drafts and withdrawn Versions are skipped. If there are no
+// published Versions then we fall back to the newest one since a Resource
+// always needs a default Version.
+func (r *Resource) GetNewestPublishedVersionID() (string, *XRError) {
+	newest, xErr := r.GetNewestVersionID()
+	if xErr != nil || !r.GetResourceModel().GetVersionStates() {
+		return newest, xErr
+	}
+
+	verIDs, xErr := r.GetOrderedVersionIDs()
+	if xErr != nil {
+		return "", xErr
+	}
+
+	// Check "newest" first since the ordering rules live in the versionmode
+	list := []string{newest}
+	for i := len(verIDs) - 1; i >= 0; i-- {
+		if verIDs[i].VID != newest {
+			list = append(list, verIDs[i].VID)
+		}
+	}
+
+	for _, vID := range list {
+		v, xErr := r.FindVersion(vID, false)
+		if xErr != nil {
+			return "", xErr
+		}
+		if v != nil && v.GetState() == VERSION_STATE_PUBLISHED {
+			return vID, nil
+		}
+	}
+	return newest, nil
+}
+
+// hideDraftsClause returns the SQL needed to exclude draft Versions from
+// the results of a query. Drafts are only shown when asked for explicitly,
+// either via a filter or by asking for the Version itself.
+func hideDraftsClause(reg *Registry, paths []string, filters [][]*FilterExpr, docView bool) string {
+	if len(filters) != 0 || docView || !reg.UsesVersionStates() {
+		return ""
+	}
+
+	for _, p := range paths {
+		// GROUPS/gID/RESOURCES/rID/versions/vID
+		if strings.Count(p, "/") >= 5 {
+			return ""
+		}
+	}
+
+	return `  AND NOT EXISTS (SELECT 1 FROM Props AS st
+    WHERE st.RegSID=ft.RegSID AND st.eSID=ft.eSID AND st.Type=` +
+		StrTypes(ENTITY_VERSION) + ` AND st.PropName='state` +
+		string(DB_IN) + `' AND st.PropValue='` + VERSION_STATE_DRAFT + `')
+`
+}
+
+// HTTPPublishVersion handles "POST .../versions/vID?publish"
+func HTTPPublishVersion(info *RequestInfo) *XRError {
+	group, xErr := info.Registry.FindGroup(info.GroupType, info.GroupUID,
+		false, FOR_READ)
+	if xErr != nil {
+		return xErr
+	}
+	if group == nil {
+		return NewXRError("not_found", "/"+info.OriginalPath)
+	}
+
+	resource, xErr := group.FindResource(info.ResourceType, info.ResourceUID,
+		false, FOR_WRITE)
+	if xErr != nil {
+		return xErr
+	}
+	if resource == nil {
+		return NewXRError("not_found", "/"+info.OriginalPath)
+	}
+
+	if !resource.GetResourceModel().GetVersionStates() {
+		return NewXRError("bad_request", "/"+info.OriginalPath,
+			"error_detail=Resource model \""+resource.ResourceModel.Plural+
+				"\" doesn't have \"versionstates\" enabled")
+	}
+
+	version, xErr := resource.FindVersion(info.VersionUID, false)
+	if xErr != nil {
+		return xErr
+	}
+	if version == nil {
+		return NewXRError("not_found", "/"+info.OriginalPath)
+	}
+
+	if xErr = version.Publish(); xErr != nil {
+		return xErr
+	}
+
+	resPaths := map[string][]string{"": []string{version.Path}}
+	return SerializeQuery(info, resPaths, "Entity", info.Filters)
+}
+
+// Publish moves a Version to the "published" state. Publishing something
+// that's already published is a no-op.
+func (v *Version) Publish() *XRError {
+	if v.GetState() == VERSION_STATE_PUBLISHED {
+		return nil
+	}
+	return v.SetSave("state", VERSION_STATE_PUBLISHED)
+}
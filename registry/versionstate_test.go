@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestCheckVersionStateChange(t *testing.T) {
+	tests := []struct {
+		from string
+		to   string
+		err  bool
+	}{
+		{"", "draft", false},
+		{"", "published", false},
+		{"draft", "draft", false},
+		{"draft", "published", false},
+		{"draft", "withdrawn", false},
+		{"published", "withdrawn", false},
+		{"withdrawn", "published", false},
+		{"published", "draft", true},
+		{"withdrawn", "draft", true},
+	}
+
+	for _, test := range tests {
+		xErr := CheckVersionStateChange("/d/d1/f/f1/versions/v1", test.from,
+			test.to)
+		if (xErr != nil) != test.err {
+			t.Fatalf("%q->%q: expected err %v, got %v", test.from, test.to,
+				test.err, xErr)
+		}
+	}
+}
+
+func TestNonDraftAncestor(t *testing.T) {
+	// v1 <- v2(draft) <- v3(draft) <- v4,  v5(draft,root) <- v6,
+	// v7(draft) <-> v8(draft) <- v9
+	ancestors := map[string]string{
+		"v1": "v1", "v2": "v1", "v3": "v2", "v4": "v3",
+		"v5": "v5", "v6": "v5",
+		"v7": "v8", "v8": "v7", "v9": "v8",
+	}
+	drafts := map[string]bool{
+		"v2": true, "v3": true, "v5": true, "v7": true, "v8": true,
+	}
+
+	tests := []struct {
+		vid string
+		exp string
+	}{
+		{"v4", "v1"},
+		{"v3", "v1"},
+		{"v6", "v6"},
+		{"v9", "v9"},
+		{"v1", "v1"},
+	}
+
+	for _, test := range tests {
+		if res := NonDraftAncestor(test.vid, ancestors, drafts); res != test.exp {
+			t.Fatalf("%s: expected %q, got %q", test.vid, test.exp, res)
+		}
+	}
+}
@@ -43,6 +43,9 @@ func TestCapabilitySimple(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -95,14 +98,29 @@ func TestCapabilitySimple(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -125,6 +143,7 @@ func TestCapabilitySimple(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -167,6 +186,9 @@ func TestCapabilitySimple(t *testing.T) {
       },
       "modelsource": {
         "mutable": true
+      },
+      "ratelimits": {
+        "mutable": false
       }
     },
     "compatibilities": {
@@ -219,14 +241,29 @@ func TestCapabilitySimple(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [
+      "gzip",
+      "zstd"
+    ],
+    "filteroperators": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
     "flags": [
       "binary",
       "collections",
       "doc",
       "epoch",
+      "facet",
+      "fields",
       "filter",
       "ignore",
       "inline",
+      "publish",
       "setdefaultversionid",
       "sort",
       "specversion"
@@ -249,6 +286,7 @@ func TestCapabilitySimple(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": true,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -276,10 +314,13 @@ func TestCapabilitySimple(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "` + SPECVERSION + `"
@@ -308,10 +349,13 @@ func TestCapabilitySimple(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "` + SPECVERSION + `"
@@ -340,10 +384,13 @@ func TestCapabilitySimple(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "` + SPECVERSION + `"
@@ -426,6 +473,9 @@ func TestCapabilityPath(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -478,14 +528,29 @@ func TestCapabilityPath(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -508,6 +573,7 @@ func TestCapabilityPath(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -540,12 +606,15 @@ func TestCapabilityPath(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "inline"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -609,10 +678,13 @@ func TestCapabilityPath(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -642,10 +714,13 @@ func TestCapabilityPath(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -666,10 +741,13 @@ func TestCapabilityPath(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -752,6 +830,18 @@ func TestCapabilityPath(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary", "collections", "doc", "epoch", "filter", "inline", "ignore",
     "setdefaultversionid", "sort", "specversion"
@@ -767,6 +857,7 @@ func TestCapabilityPath(t *testing.T) {
   "ignores": [ "capabilities", "defaultversionid", "defaultversionsticky",
     "epoch", "id", "modelsource", "readonly" ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [ "`+SPECVERSION+`" ],
   "versionmodes": [ "createdat", "manual" ]
@@ -842,14 +933,29 @@ func TestCapabilityPath(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -872,6 +978,7 @@ func TestCapabilityPath(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -954,14 +1061,29 @@ func TestCapabilityPath(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -984,6 +1106,7 @@ func TestCapabilityPath(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -1007,10 +1130,13 @@ func TestCapabilityPath(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -1031,10 +1157,13 @@ func TestCapabilityPath(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -1060,10 +1189,13 @@ func TestCapabilityPath(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -1190,12 +1322,15 @@ func TestCapabilityAttr(t *testing.T) {
       }
     },
     "compatibilities": {},
+    "encodings": [],
+    "filteroperators": [],
     "flags": [
       "inline"
     ],
     "formats": [],
     "ignores": [],
     "pagination": false,
+    "ranges": false,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -1239,10 +1374,13 @@ func TestCapabilityAttr(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -1314,6 +1452,18 @@ func TestCapabilityAttr(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary", "collections", "doc", "epoch", "filter", "inline", "ignore",
     "setdefaultversionid", "sort", "specversion"
@@ -1331,6 +1481,7 @@ func TestCapabilityAttr(t *testing.T) {
     "id", "modelsource", "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [ "`+SPECVERSION+`" ],
   "versionmodes": [ "createdat", "manual" ]
@@ -1415,14 +1566,29 @@ func TestCapabilityAttr(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [
+      "gzip",
+      "zstd"
+    ],
+    "filteroperators": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
     "flags": [
       "binary",
       "collections",
       "doc",
       "epoch",
+      "facet",
+      "fields",
       "filter",
       "ignore",
       "inline",
+      "publish",
       "setdefaultversionid",
       "sort",
       "specversion"
@@ -1445,6 +1611,7 @@ func TestCapabilityAttr(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": true,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -1528,14 +1695,29 @@ func TestCapabilityAttr(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -1558,6 +1740,7 @@ func TestCapabilityAttr(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -1575,10 +1758,13 @@ func TestCapabilityAttr(t *testing.T) {
   "capabilities": {
     "available":{"capabilities":{"mutable":true},"entities":{"mutable":true}},
     "compatibilities": {},
+    "encodings": [],
+    "filteroperators": [],
     "flags": [],
     "formats": [],
     "ignores": [],
     "pagination": false,
+    "ranges": false,
     "shortself": false,
     "specversions": ["`+SPECVERSION+`"],
     "versionmodes": [ "manual" ]
@@ -1605,10 +1791,13 @@ func TestCapabilityAttr(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -1677,10 +1866,13 @@ func TestCapabilityFlagsOff(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -1849,6 +2041,17 @@ func TestCapabilityOffered(t *testing.T) {
             "type": "boolean"
           }
         }
+      },
+      "ratelimits": {
+        "type": "object",
+        "attributes": {
+          "mutable": {
+            "type": "boolean",
+            "enum": [
+              false
+            ]
+          }
+        }
       }
     }
   },
@@ -1941,6 +2144,30 @@ func TestCapabilityOffered(t *testing.T) {
       }
     }
   },
+  "encodings": {
+    "type": "array",
+    "enum": [
+      "gzip",
+      "zstd"
+    ],
+    "item": {
+      "type": "string"
+    }
+  },
+  "filteroperators": {
+    "type": "array",
+    "enum": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
+    "item": {
+      "type": "string"
+    }
+  },
   "flags": {
     "type": "array",
     "enum": [
@@ -1948,9 +2175,12 @@ func TestCapabilityOffered(t *testing.T) {
       "collections",
       "doc",
       "epoch",
+      "facet",
+      "fields",
       "filter",
       "ignore",
       "inline",
+      "publish",
       "setdefaultversionid",
       "sort",
       "specversion"
@@ -1994,6 +2224,9 @@ func TestCapabilityOffered(t *testing.T) {
       false
     ]
   },
+  "ranges": {
+    "type": "boolean"
+  },
   "shortself": {
     "type": "boolean"
   },
@@ -2039,10 +2272,13 @@ func TestCapabilityAvailable(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -2092,6 +2328,14 @@ func TestCapabilityAvailable(t *testing.T) {
   "subject": "/modelsource",
   "source": "b1fcff68b7f8:registry:httpStuff:683"
 }
+`)
+	XHTTP(t, reg, "GET", "/ratelimits", ``, 400,
+		`{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#not_available",
+  "title": "The requested data (/ratelimits) is not available.",
+  "subject": "/ratelimits",
+  "source": "xxx"
+}
 `)
 
 	// Now test mutability
@@ -2161,10 +2405,13 @@ func TestCapabilityAvailable(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -2198,10 +2445,13 @@ func TestCapabilityAvailable(t *testing.T) {
       }
     },
     "compatibilities": {},
+    "encodings": [],
+    "filteroperators": [],
     "flags": [],
     "formats": [],
     "ignores": [],
     "pagination": false,
+    "ranges": false,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -2301,10 +2551,13 @@ func TestCapabilityAvailable(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -2551,6 +2804,9 @@ func TestCapabilityPatch(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -2603,6 +2859,18 @@ func TestCapabilityPatch(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "inline"
   ],
@@ -2624,6 +2892,7 @@ func TestCapabilityPatch(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -2685,6 +2954,9 @@ func TestCapabilityPatch(t *testing.T) {
       },
       "modelsource": {
         "mutable": true
+      },
+      "ratelimits": {
+        "mutable": false
       }
     },
     "compatibilities": {
@@ -2737,6 +3009,18 @@ func TestCapabilityPatch(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [
+      "gzip",
+      "zstd"
+    ],
+    "filteroperators": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
     "flags": [
       "filter",
       "inline"
@@ -2759,6 +3043,7 @@ func TestCapabilityPatch(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": true,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -2833,6 +3118,9 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -2885,14 +3173,29 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -2915,6 +3218,7 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -2962,6 +3266,9 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
       },
       "modelsource": {
         "mutable": true
+      },
+      "ratelimits": {
+        "mutable": false
       }
     },
     "compatibilities": {
@@ -3014,6 +3321,18 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [
+      "gzip",
+      "zstd"
+    ],
+    "filteroperators": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
     "flags": [
       "inline"
     ],
@@ -3035,6 +3354,7 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": true,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -3086,6 +3406,9 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -3138,6 +3461,18 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "inline"
   ],
@@ -3159,6 +3494,7 @@ func TestCapabilityPatchRootSemantics(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3207,12 +3543,15 @@ func TestCapabilityPutRootSemantics(t *testing.T) {
       }
     },
     "compatibilities": {},
+    "encodings": [],
+    "filteroperators": [],
     "flags": [
       "inline"
     ],
     "formats": [],
     "ignores": [],
     "pagination": false,
+    "ranges": false,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -3235,12 +3574,15 @@ func TestCapabilityPutRootSemantics(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "inline"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3283,6 +3625,9 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -3335,6 +3680,18 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "inline"
   ],
@@ -3356,6 +3713,7 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3391,6 +3749,9 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -3443,6 +3804,18 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "inline"
   ],
@@ -3464,6 +3837,7 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3518,6 +3892,9 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -3570,6 +3947,18 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "inline"
   ],
@@ -3591,6 +3980,7 @@ func TestCapabilityPatchRootVsPatch(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3628,12 +4018,15 @@ func TestCapabilityNullHandling(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "inline"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3655,12 +4048,15 @@ func TestCapabilityNullHandling(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "inline"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3705,6 +4101,9 @@ func TestCapabilityNullHandling(t *testing.T) {
       },
       "modelsource": {
         "mutable": true
+      },
+      "ratelimits": {
+        "mutable": false
       }
     },
     "compatibilities": {
@@ -3757,14 +4156,29 @@ func TestCapabilityNullHandling(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [
+      "gzip",
+      "zstd"
+    ],
+    "filteroperators": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
     "flags": [
       "binary",
       "collections",
       "doc",
       "epoch",
+      "facet",
+      "fields",
       "filter",
       "ignore",
       "inline",
+      "publish",
       "setdefaultversionid",
       "sort",
       "specversion"
@@ -3787,6 +4201,7 @@ func TestCapabilityNullHandling(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": true,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -3822,6 +4237,9 @@ func TestCapabilityNullHandling(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -3874,14 +4292,29 @@ func TestCapabilityNullHandling(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -3904,6 +4337,7 @@ func TestCapabilityNullHandling(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3937,12 +4371,15 @@ func TestCapabilityNullHandling(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "filter"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -3964,12 +4401,15 @@ func TestCapabilityNullHandling(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "filter"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4018,6 +4458,9 @@ func TestCapabilityNullHandling(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -4070,14 +4513,29 @@ func TestCapabilityNullHandling(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -4100,6 +4558,7 @@ func TestCapabilityNullHandling(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4134,6 +4593,8 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "filter",
     "inline"
@@ -4141,6 +4602,7 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4162,6 +4624,8 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "filter",
     "inline"
@@ -4169,6 +4633,7 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4199,10 +4664,13 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4224,10 +4692,13 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4256,6 +4727,8 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "filter",
     "inline"
@@ -4263,6 +4736,7 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4284,6 +4758,8 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "filter",
     "inline"
@@ -4291,6 +4767,7 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4335,10 +4812,13 @@ func TestCapabilityPatchIndividualNull(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4375,14 +4855,19 @@ func TestCapabilityWildcard(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -4390,6 +4875,7 @@ func TestCapabilityWildcard(t *testing.T) {
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4411,14 +4897,19 @@ func TestCapabilityWildcard(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -4426,6 +4917,7 @@ func TestCapabilityWildcard(t *testing.T) {
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4469,6 +4961,8 @@ func TestCapabilityWildcard(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [
@@ -4481,6 +4975,7 @@ func TestCapabilityWildcard(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4524,6 +5019,8 @@ func TestCapabilityWildcard(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [
     "avro*",
@@ -4535,6 +5032,7 @@ func TestCapabilityWildcard(t *testing.T) {
   ],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4578,10 +5076,13 @@ func TestCapabilityWildcard(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4639,12 +5140,15 @@ func TestCapabilityWildcard(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [
     "avro*"
   ],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4697,14 +5201,19 @@ func TestCapabilityWildcard(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -4714,6 +5223,7 @@ func TestCapabilityWildcard(t *testing.T) {
   ],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4757,14 +5267,19 @@ func TestCapabilityWildcard(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [],
+    "filteroperators": [],
     "flags": [
       "binary",
       "collections",
       "doc",
       "epoch",
+      "facet",
+      "fields",
       "filter",
       "ignore",
       "inline",
+      "publish",
       "setdefaultversionid",
       "sort",
       "specversion"
@@ -4782,6 +5297,7 @@ func TestCapabilityWildcard(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": false,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -4811,14 +5327,19 @@ func TestCapabilityWildcard(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -4841,6 +5362,7 @@ func TestCapabilityWildcard(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
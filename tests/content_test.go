@@ -1,13 +1,19 @@
 package tests
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	"github.com/klauspost/compress/zstd"
 	. "github.com/xregistry/server/common"
 	"github.com/xregistry/server/registry"
 )
@@ -194,6 +200,677 @@ func TestContentResource(t *testing.T) {
 
 }
 
+func TestContentIntegrity(t *testing.T) {
+	reg := NewRegistry("TestContentIntegrity")
+	defer PassDeleteReg(t, reg)
+
+	clientKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, 32))
+	serverKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, 32))
+	clientPub := PublicKeyString(clientKey.Public().(ed25519.PublicKey))
+
+	registry.SetSigningKey(serverKey, "server")
+	defer registry.SetSigningKey(nil, "")
+
+	model := `{
+  "groups": { "dirs": { "singular": "dir", "resources": {
+    "files": {
+      "singular": "file",
+      "integrity": {
+        "sign": %v,
+        "requiresignature": true,
+        "trustedkeys": { "client": "%s" }
+      }
+    },
+    "others": { "singular": "other" }
+  } } }
+}`
+	XHTTP(t, reg, "PUT", "/modelsource",
+		fmt.Sprintf(model, false, clientPub), 200, `*`)
+
+	XHTTP(t, reg, "PUT", "/modelsource",
+		fmt.Sprintf(model, false, "bad"), 400,
+		`^(?s).*Resource \\"files\\" \\"integrity.trustedkeys.client\\" is invalid: public key.*`)
+
+	doc1 := "hello world"
+	digest1 := DocumentDigest([]byte(doc1))
+	repr1, legacy1, _ := DigestHeaders(digest1)
+	sig1 := SignDigest(clientKey, digest1)
+
+	// Unsigned documents aren't allowed
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v1", doc1, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#signature_required",
+  "title": "The document of Version \"/dirs/d1/files/f1/versions/v1\" must be signed by a trusted key.",
+  "subject": "/dirs/d1/files/f1/versions/v1",
+  "source": "xxx"
+}
+`)
+
+	// Signed by a key we don't know about
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:    "/dirs/d1/files/f1/versions/v1",
+		Method: "PUT",
+		ReqHeaders: []string{
+			"xRegistry-signature: " + sig1,
+			"xRegistry-signaturekeyid: nobody",
+		},
+		ReqBody: doc1,
+		Code:    400,
+		ResBody: `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#signature_invalid",
+  "title": "The \"signature\" of Version \"/dirs/d1/files/f1/versions/v1\" is invalid: key \"nobody\" isn't trusted.",
+  "subject": "/dirs/d1/files/f1/versions/v1",
+  "args": {
+    "error_detail": "key \"nobody\" isn't trusted"
+  },
+  "source": "xxx"
+}
+`})
+
+	// Signature of some other document
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:    "/dirs/d1/files/f1/versions/v1",
+		Method: "PUT",
+		ReqHeaders: []string{
+			"xRegistry-signature: " + sig1,
+			"xRegistry-signaturekeyid: client",
+		},
+		ReqBody: "something else",
+		Code:    400,
+		ResBody: `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#signature_invalid",
+  "title": "The \"signature\" of Version \"/dirs/d1/files/f1/versions/v1\" is invalid: signature doesn't match the document.",
+  "subject": "/dirs/d1/files/f1/versions/v1",
+  "args": {
+    "error_detail": "signature doesn't match the document"
+  },
+  "source": "xxx"
+}
+`})
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:    "/dirs/d1/files/f1/versions/v1",
+		Method: "PUT",
+		ReqHeaders: []string{
+			"xRegistry-signature: " + sig1,
+			"xRegistry-signaturekeyid: client",
+		},
+		ReqBody: doc1,
+		Code:    201,
+		ResHeaders: []string{
+			"*",
+			"Repr-Digest: " + repr1,
+			"Digest: " + legacy1,
+			"-xRegistry-digest:",
+			"xRegistry-signature: " + sig1,
+			"xRegistry-signaturekeyid: client",
+		},
+		ResBody: doc1,
+	})
+
+	// Metadata changes keep the signature
+	XHTTP(t, reg, "PATCH", "/dirs/d1/files/f1/versions/v1$details",
+		`{"description":"first"}`, 200, `*`)
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:    "/dirs/d1/files/f1",
+		Method: "GET",
+		Code:   200,
+		ResHeaders: []string{
+			"*",
+			"Repr-Digest: " + repr1,
+			"xRegistry-description: first",
+			"-xRegistry-digest:",
+			"xRegistry-signature: " + sig1,
+			"xRegistry-signaturekeyid: client",
+		},
+		ResBody: doc1,
+	})
+
+	// A new document needs a new signature
+	XHTTP(t, reg, "PATCH", "/dirs/d1/files/f1/versions/v1$details",
+		`{"file":"changed"}`, 400, `*`)
+
+	// Now let the server sign things itself
+	XHTTP(t, reg, "PUT", "/modelsource",
+		fmt.Sprintf(model, true, clientPub), 200, `*`)
+
+	doc2 := "hello again"
+	digest2 := DocumentDigest([]byte(doc2))
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:     "/dirs/d1/files/f1/versions/v2",
+		Method:  "PUT",
+		ReqBody: doc2,
+		Code:    201,
+		ResHeaders: []string{
+			"*",
+			"-xRegistry-digest:",
+			"xRegistry-signature: " + SignDigest(serverKey, digest2),
+			"xRegistry-signaturekeyid: server",
+		},
+		ResBody: doc2,
+	})
+
+	// "digest" is an attribute, just not an xRegistry- header
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v2$details", ``, 200,
+		`*"digest": "`+digest2+`"*`)
+
+	// Without a policy there's still a "digest", but no signature
+	digest3 := DocumentDigest([]byte("other"))
+	repr3, _, _ := DigestHeaders(digest3)
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:     "/dirs/d1/others/o1",
+		Method:  "PUT",
+		ReqBody: "other",
+		Code:    201,
+		ResHeaders: []string{
+			"*",
+			"Repr-Digest: " + repr3,
+			"-xRegistry-digest:",
+			"-xRegistry-signature:",
+		},
+		ResBody: "other",
+	})
+	XHTTP(t, reg, "GET", "/dirs/d1/others/o1$details", ``, 200,
+		`*"digest": "`+digest3+`"*`)
+}
+
+// getBlobRefCount returns the RefCount of the Blob with the given digest,
+// or -1 if there isn't one. Blobs is internal-only so, like getUsesXref(),
+// this reaches into the DB directly.
+func getBlobRefCount(t *testing.T, reg *registry.Registry, digest string) int {
+	t.Helper()
+	tx := reg.GetTx()
+	results := registry.Query(tx, `SELECT RefCount FROM Blobs WHERE Digest=?`,
+		digest)
+	defer results.Close()
+	row := results.NextRow()
+	if row == nil {
+		return -1
+	}
+	return NotNilIntDef(row[0], -1)
+}
+
+func TestContentDedup(t *testing.T) {
+	reg := NewRegistry("TestContentDedup")
+	defer PassDeleteReg(t, reg)
+
+	gm, err := reg.Model.AddGroupModel("dirs", "dir")
+	XNoErr(t, err)
+	_, err = gm.AddResourceModelSimple("files", "file")
+	XNoErr(t, err)
+	XNoErr(t, reg.SaveModel(true))
+
+	doc := "TestContentDedup shared document"
+	digest := DocumentDigest([]byte(doc))
+
+	XEqual(t, "", getBlobRefCount(t, reg, digest), -1)
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v1", doc, 201, doc)
+	XEqual(t, "", getBlobRefCount(t, reg, digest), 1)
+
+	// Same document in another Version and another Resource
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v2", doc, 201, doc)
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f2/versions/v1", doc, 201, doc)
+	XEqual(t, "", getBlobRefCount(t, reg, digest), 3)
+
+	// Rewriting the same document, or just metadata, is a no-op
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v1", doc, 200, doc)
+	XHTTP(t, reg, "PATCH", "/dirs/d1/files/f1/versions/v1$details",
+		`{"description": "hi"}`, 200, `*`)
+	XEqual(t, "", getBlobRefCount(t, reg, digest), 3)
+
+	// Changing a Version's document moves its reference
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v2", "other", 200, "other")
+	XEqual(t, "", getBlobRefCount(t, reg, digest), 2)
+
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f2/versions/v1", "", 200, doc)
+
+	XHTTP(t, reg, "DELETE", "/dirs/d1/files/f2", "", 204, "")
+	XEqual(t, "", getBlobRefCount(t, reg, digest), 1)
+
+	// Last reference is gone, so is the Blob
+	XHTTP(t, reg, "DELETE", "/dirs/d1/files/f1/versions/v1", "", 204, "")
+	XEqual(t, "", getBlobRefCount(t, reg, digest), -1)
+
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v2", "", 200, "other")
+}
+
+func TestContentBlobStore(t *testing.T) {
+	reg := NewRegistry("TestContentBlobStore")
+	defer PassDeleteReg(t, reg)
+
+	gm, err := reg.Model.AddGroupModel("dirs", "dir")
+	XNoErr(t, err)
+	_, err = gm.AddResourceModelSimple("files", "file")
+	XNoErr(t, err)
+	XNoErr(t, reg.SaveModel(true))
+
+	dbStore := registry.GetBlobStore()
+	fsStore, fsErr := registry.NewFSBlobStore(t.TempDir())
+	XNoErr(t, fsErr)
+	registry.SetBlobStore(fsStore)
+	defer registry.SetBlobStore(dbStore)
+	// Don't leave anything behind in the temp dir if we fail
+	defer registry.MigrateBlobs(dbStore, nil)
+
+	blobPath := func(digest string) string {
+		hex := digest[len(DIGEST_PREFIX):]
+		return filepath.Join(fsStore.Dir, hex[:2], hex[2:4], hex)
+	}
+
+	doc := "TestContentBlobStore document"
+	digest := DocumentDigest([]byte(doc))
+	path := blobPath(digest)
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v1", doc, 201, doc)
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v2", doc, 201, doc)
+	_, statErr := os.Stat(path)
+	XNoErr(t, statErr)
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/dirs/d1/files/f1/versions/v1",
+		Method:     "GET",
+		Code:       200,
+		ResHeaders: []string{"*", "Repr-Digest: " + getReprDigest(digest)},
+		ResBody:    doc,
+	})
+
+	// The file only goes away after the last Version does, and the sweeper
+	// has run
+	XHTTP(t, reg, "DELETE", "/dirs/d1/files/f1/versions/v1", "", 204, "")
+	_, xErr := registry.SweepDeadBlobs()
+	XNoErr(t, xErr)
+	_, statErr = os.Stat(path)
+	XNoErr(t, statErr)
+
+	XHTTP(t, reg, "DELETE", "/dirs/d1/files/f1", "", 204, "")
+	_, statErr = os.Stat(path)
+	XNoErr(t, statErr)
+	_, xErr = registry.SweepDeadBlobs()
+	XNoErr(t, xErr)
+	_, statErr = os.Stat(path)
+	XCheck(t, os.IsNotExist(statErr), "File should be gone: %v", statErr)
+
+	// An object written by a Tx that's rolled back is deleted by the sweeper
+	doc = "TestContentBlobStore rolled back document"
+	path = blobPath(DocumentDigest([]byte(doc)))
+
+	tx, xErr := registry.NewTx()
+	XNoErr(t, xErr)
+	_, _, saveErr := registry.SaveDocument(tx, "no-such-version",
+		strings.NewReader(doc), int64(len(doc)))
+	XNoErr(t, saveErr)
+	_, statErr = os.Stat(path)
+	XNoErr(t, statErr)
+	tx.Rollback()
+
+	count, xErr := registry.SweepDeadBlobs()
+	XNoErr(t, xErr)
+	XEqual(t, "", count, 1)
+	_, statErr = os.Stat(path)
+	XCheck(t, os.IsNotExist(statErr), "File should be gone: %v", statErr)
+
+	// Move a document into the DB
+	doc = "TestContentBlobStore other document"
+	path = blobPath(DocumentDigest([]byte(doc)))
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f2/versions/v1", doc, 201, doc)
+	_, statErr = os.Stat(path)
+	XNoErr(t, statErr)
+
+	count, xErr = registry.MigrateBlobs(dbStore, nil)
+	XNoErr(t, xErr)
+	XEqual(t, "", count, 1)
+	_, statErr = os.Stat(path)
+	XCheck(t, os.IsNotExist(statErr), "File should be gone: %v", statErr)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f2/versions/v1", "", 200, doc)
+}
+
+func getReprDigest(digest string) string {
+	repr, _, _ := DigestHeaders(digest)
+	return repr
+}
+
+func TestContentProxyFetch(t *testing.T) {
+	reg := NewRegistry("TestContentProxyFetch")
+	defer PassDeleteReg(t, reg)
+
+	gm, err := reg.Model.AddGroupModel("dirs", "dir")
+	XNoErr(t, err)
+	_, err = gm.AddResourceModelSimple("files", "file")
+	XNoErr(t, err)
+	XNoErr(t, reg.SaveModel(true))
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v1$details", `{
+  "format": "numbers",
+  "fileproxyurl": "http://localhost:8282/EMPTY-Proxy"
+}`, 201, `*`)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v1", ``, 200,
+		"hello-Proxy\n")
+
+	oldPF := registry.GetProxyFetcher()
+	defer registry.SetProxyFetcher(oldPF)
+
+	// By default internal addresses aren't allowed
+	registry.SetProxyFetcher(registry.NewProxyFetcher())
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v1", ``, 403,
+		`^(?s).*"title": "Fetching the document of \\"/dirs/d1/files/f1/versions/v1\\" from \\"http://localhost:8282/EMPTY-Proxy\\" isn't allowed: address .* is internal.".*`)
+	// When inlined it's the (base64'd) "GET error:..." message instead
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v1$details?inline=file",
+		``, 200, `^(?s).*"filebase64": "R0VUIGVycm9yOkZldGNoaW5n.*`)
+
+	pf := registry.NewProxyFetcher()
+	pf.Allow = []string{"127.0.0.0/8", "::1/128"}
+	registry.SetProxyFetcher(pf)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v1", ``, 200,
+		"hello-Proxy\n")
+
+	pf = registry.NewProxyFetcher()
+	pf.Allow = []string{"example.com"}
+	registry.SetProxyFetcher(pf)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v1", ``, 403,
+		`^(?s).*isn't allowed: host \\"localhost\\" isn't allowed.*`)
+
+	// "hello-Proxy" isn't a valid "numbers" document
+	pf = registry.NewProxyFetcher()
+	pf.AllowPrivate = true
+	pf.Validate = true
+	registry.SetProxyFetcher(pf)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v1", ``, 502,
+		`^(?s).*"title": "There was an error fetching the document of \\"/dirs/d1/files/f1/versions/v1\\" from \\"http://localhost:8282/EMPTY-Proxy\\": .*`)
+}
+
+func TestContentRange(t *testing.T) {
+	reg := NewRegistry("TestContentRange")
+	defer PassDeleteReg(t, reg)
+
+	gm, err := reg.Model.AddGroupModel("dirs", "dir")
+	XNoErr(t, err)
+	_, err = gm.AddResourceModelSimple("files", "file")
+	XNoErr(t, err)
+	XNoErr(t, reg.SaveModel(true))
+
+	url := "/dirs/d1/files/f1/versions/v1"
+	XHTTP(t, reg, "PUT", url, "0123456789", 201, "0123456789")
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "GET",
+		Code:       200,
+		ResHeaders: []string{"*", "Accept-Ranges: bytes", "Content-Length: 10"},
+		ResBody:    "0123456789",
+	})
+
+	for _, test := range []struct {
+		Range, ContentRange, Body string
+	}{
+		{"bytes=2-5", "bytes 2-5/10", "2345"},
+		{"bytes=7-", "bytes 7-9/10", "789"},
+		{"bytes=-3", "bytes 7-9/10", "789"},
+		{"bytes=-30", "bytes 0-9/10", "0123456789"},
+		{"bytes=5-100", "bytes 5-9/10", "56789"},
+	} {
+		XCheckHTTP(t, reg, &HTTPTest{
+			Name:       test.Range,
+			URL:        url,
+			Method:     "GET",
+			ReqHeaders: []string{"Range: " + test.Range},
+			Code:       206,
+			ResHeaders: []string{"*",
+				"Content-Range: " + test.ContentRange,
+				fmt.Sprintf("Content-Length: %d", len(test.Body)),
+			},
+			ResBody: test.Body,
+		})
+	}
+
+	// Multiple ranges, other units, bad syntax and a mismatched If-Range
+	// all just get the whole document
+	for _, hdrs := range [][]string{
+		{"Range: bytes=0-1,4-5"},
+		{"Range: items=0-1"},
+		{"Range: bytes=5-2"},
+		{"Range: bytes=0-1", "If-Range: \"abc\""},
+	} {
+		XCheckHTTP(t, reg, &HTTPTest{
+			Name:       hdrs[0],
+			URL:        url,
+			Method:     "GET",
+			ReqHeaders: hdrs,
+			Code:       200,
+			ResHeaders: []string{"*", "-Content-Range"},
+			ResBody:    "0123456789",
+		})
+	}
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "GET",
+		ReqHeaders: []string{"Range: bytes=10-"},
+		Code:       416,
+		ResHeaders: []string{"*", "Content-Range: bytes */10"},
+		ResBody: `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#range_not_satisfiable",
+  "title": "The range (bytes=10-) isn't satisfiable for the document of \"/dirs/d1/files/f1/versions/v1\", which is 10 bytes.",
+  "subject": "/dirs/d1/files/f1/versions/v1",
+  "args": {
+    "range": "bytes=10-",
+    "size": "10"
+  },
+  "source": "xxx"
+}
+`,
+	})
+
+	// Turned off
+	reg.Capabilities.Ranges = false
+	XNoErr(t, reg.SaveCapabilities())
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "GET",
+		ReqHeaders: []string{"Range: bytes=2-5"},
+		Code:       200,
+		ResHeaders: []string{"*", "-Accept-Ranges", "-Content-Range"},
+		ResBody:    "0123456789",
+	})
+}
+
+func gzipString(t *testing.T, str string) string {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	_, err := zw.Write([]byte(str))
+	XNoErr(t, err)
+	XNoErr(t, zw.Close())
+	return buf.String()
+}
+
+func gunzipString(t *testing.T, str string) string {
+	t.Helper()
+	zr, err := gzip.NewReader(strings.NewReader(str))
+	XNoErr(t, err)
+	buf, err := io.ReadAll(zr)
+	XNoErr(t, err)
+	return string(buf)
+}
+
+func zstdString(t *testing.T, str string) string {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	XNoErr(t, err)
+	return string(zw.EncodeAll([]byte(str), nil))
+}
+
+func unzstdString(t *testing.T, str string) string {
+	t.Helper()
+	zr, err := zstd.NewReader(nil)
+	XNoErr(t, err)
+	defer zr.Close()
+	buf, err := zr.DecodeAll([]byte(str), nil)
+	XNoErr(t, err)
+	return string(buf)
+}
+
+func TestContentCompression(t *testing.T) {
+	reg := NewRegistry("TestContentCompression")
+	defer PassDeleteReg(t, reg)
+
+	gm, err := reg.Model.AddGroupModel("dirs", "dir")
+	XNoErr(t, err)
+	_, err = gm.AddResourceModelSimple("files", "file")
+	XNoErr(t, err)
+	XNoErr(t, reg.SaveModel(true))
+
+	url := "/dirs/d1/files/f1/versions/v1"
+	doc := strings.Repeat("TestContentCompression ", 100)
+
+	// Compressed request body
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "PUT",
+		ReqHeaders: []string{"Content-Encoding: gzip"},
+		ReqBody:    gzipString(t, doc),
+		Code:       201,
+		ResHeaders: []string{"*"},
+		ResBody:    doc,
+	})
+	XHTTP(t, reg, "GET", url, "", 200, doc)
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "PUT",
+		ReqHeaders: []string{"Content-Encoding: zstd"},
+		ReqBody:    zstdString(t, doc),
+		Code:       200,
+		ResHeaders: []string{"*"},
+		ResBody:    doc,
+	})
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "PUT",
+		ReqHeaders: []string{"Content-Encoding: br"},
+		ReqBody:    "hi",
+		Code:       415,
+		ResHeaders: []string{"*", "Accept-Encoding: gzip, zstd"},
+		ResBody: `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#unsupported_encoding",
+  "title": "The \"Content-Encoding\" of the request (br) isn't supported. Allowable values include: gzip,zstd.",
+  "subject": "/dirs/d1/files/f1/versions/v1",
+  "args": {
+    "encoding": "br",
+    "list": "gzip,zstd"
+  },
+  "source": "xxx"
+}
+`,
+	})
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "PUT",
+		ReqHeaders: []string{"Content-Encoding: gzip"},
+		ReqBody:    "not gzip",
+		Code:       400,
+		ResHeaders: []string{"*"},
+		ResBody:    `^(?s).*error decompressing the body.*`,
+	})
+
+	// Compressed responses
+	res := XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/dirs/d1/files?inline",
+		Method:     "GET",
+		ReqHeaders: []string{"Accept-Encoding: br;q=0.9, gzip;q=0.5"},
+		Code:       200,
+		ResHeaders: []string{"*", "Content-Encoding: gzip",
+			"Vary: Accept-Encoding"},
+		ResBody: "*",
+	})
+	XCheck(t, strings.Contains(gunzipString(t, res.body), `"fileid": "f1"`),
+		"Bad JSON: %s", res.body)
+
+	res = XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/dirs/d1/files?inline",
+		Method:     "GET",
+		ReqHeaders: []string{"Accept-Encoding: *"},
+		Code:       200,
+		ResHeaders: []string{"*", "Content-Encoding: gzip"},
+		ResBody:    "*",
+	})
+	XCheck(t, strings.Contains(gunzipString(t, res.body), `"fileid": "f1"`),
+		"Bad JSON: %s", res.body)
+
+	res = XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/dirs/d1/files?inline",
+		Method:     "GET",
+		ReqHeaders: []string{"Accept-Encoding: gzip;q=0.5, zstd"},
+		Code:       200,
+		ResHeaders: []string{"*", "Content-Encoding: zstd"},
+		ResBody:    "*",
+	})
+	XCheck(t, strings.Contains(unzstdString(t, res.body), `"fileid": "f1"`),
+		"Bad JSON: %s", res.body)
+
+	// Documents have a Repr-Digest of the stored bytes, so they never are
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "GET",
+		ReqHeaders: []string{"Accept-Encoding: gzip, zstd"},
+		Code:       200,
+		ResHeaders: []string{"*", "-Content-Encoding",
+			"Repr-Digest: *"},
+		ResBody: doc,
+	})
+
+	// Not when it's small, a range, or it wasn't asked for
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f2/versions/v1", "small", 201,
+		"small")
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/dirs/d1/files/f2/versions/v1",
+		Method:     "GET",
+		ReqHeaders: []string{"Accept-Encoding: gzip"},
+		Code:       200,
+		ResHeaders: []string{"*", "-Content-Encoding"},
+		ResBody:    "small",
+	})
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "GET",
+		ReqHeaders: []string{"Accept-Encoding: gzip", "Range: bytes=0-"},
+		Code:       206,
+		ResHeaders: []string{"*", "-Content-Encoding"},
+		ResBody:    doc,
+	})
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "GET",
+		ReqHeaders: []string{"Accept-Encoding: gzip;q=0, identity"},
+		Code:       200,
+		ResHeaders: []string{"*", "-Content-Encoding"},
+		ResBody:    doc,
+	})
+
+	// Turned off
+	reg.Capabilities.Encodings = []string{}
+	XNoErr(t, reg.SaveCapabilities())
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "GET",
+		ReqHeaders: []string{"Accept-Encoding: gzip"},
+		Code:       200,
+		ResHeaders: []string{"*", "-Content-Encoding"},
+		ResBody:    doc,
+	})
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        url,
+		Method:     "PUT",
+		ReqHeaders: []string{"Content-Encoding: gzip"},
+		ReqBody:    gzipString(t, doc),
+		Code:       415,
+		ResHeaders: []string{"*"},
+		ResBody:    "*",
+	})
+}
+
 type Test struct {
 	Code    int
 	URL     string
@@ -59,6 +59,9 @@ func TestExportBasic(t *testing.T) {
       },
       "modelsource": {
         "mutable": true
+      },
+      "ratelimits": {
+        "mutable": false
       }
     },
     "compatibilities": {
@@ -111,14 +114,29 @@ func TestExportBasic(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [
+      "gzip",
+      "zstd"
+    ],
+    "filteroperators": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
     "flags": [
       "binary",
       "collections",
       "doc",
       "epoch",
+      "facet",
+      "fields",
       "filter",
       "ignore",
       "inline",
+      "publish",
       "setdefaultversionid",
       "sort",
       "specversion"
@@ -141,6 +159,7 @@ func TestExportBasic(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": true,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -391,6 +410,9 @@ func TestExportBasic(t *testing.T) {
       },
       "modelsource": {
         "mutable": true
+      },
+      "ratelimits": {
+        "mutable": false
       }
     },
     "compatibilities": {
@@ -443,14 +465,29 @@ func TestExportBasic(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [
+      "gzip",
+      "zstd"
+    ],
+    "filteroperators": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
     "flags": [
       "binary",
       "collections",
       "doc",
       "epoch",
+      "facet",
+      "fields",
       "filter",
       "ignore",
       "inline",
+      "publish",
       "setdefaultversionid",
       "sort",
       "specversion"
@@ -473,6 +510,7 @@ func TestExportBasic(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": true,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -908,6 +946,11 @@ func TestExportBasic(t *testing.T) {
                 "type": "string",
                 "readonly": true
               },
+              "digest": {
+                "name": "digest",
+                "type": "string",
+                "readonly": true
+              },
               "fileurl": {
                 "name": "fileurl",
                 "type": "url"
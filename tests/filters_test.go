@@ -1,9 +1,11 @@
 package tests
 
 import (
+	"strings"
 	"testing"
 
 	. "github.com/xregistry/server/common"
+	"github.com/xregistry/server/registry"
 )
 
 func TestFiltersBasic(t *testing.T) {
@@ -1357,6 +1359,298 @@ func TestFiltersOps(t *testing.T) {
 	}
 }
 
+func TestFiltersExtendedOps(t *testing.T) {
+	reg := NewRegistry("TestFiltersExtendedOps")
+	defer PassDeleteReg(t, reg)
+
+	model := `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "resources": {
+        "files": {
+          "singular": "file",
+          "hasdocument": false,
+          "attributes": {
+            "count": {
+              "type": "integer"
+            },
+            "tags": {
+              "type": "array",
+              "item": {
+                "type": "string"
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+	XHTTP(t, reg, "PUT", "/modelsource", model, 200, model+"\n")
+
+	XHTTP(t, reg, "PUT", "/", `{
+  "dirs": {
+    "d1": {
+      "files": {
+        "f1": {
+          "name": "Bob",
+          "count": 3,
+          "tags": [ "red", "blue" ],
+          "labels": { "env": "prod" }
+        },
+        "f2": {
+          "name": "bobby",
+          "count": 7,
+          "tags": [ "green" ],
+          "labels": { "env": "dev", "team": "a" }
+        },
+        "f3": {}
+      }
+    }
+  }
+}`, 200, `{
+  "specversion": "`+SPECVERSION+`",
+  "registryid": "TestFiltersExtendedOps",
+  "self": "http://localhost:8181/",
+  "xid": "/",
+  "epoch": 3,
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:02Z",
+
+  "dirsurl": "http://localhost:8181/dirs",
+  "dirscount": 1
+}
+`)
+
+	PRE := "?oneline&inline=dirs.files&filter="
+	tests := []struct {
+		Name string
+		URL  string
+		Exp  string
+	}{
+		// Case-sensitive vs case-insensitive
+		{
+			Name: "name=bob (case-insensitive)",
+			URL:  PRE + "dirs.files.name=bob",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+		{
+			Name: "name==Bob (case-sensitive)",
+			URL:  PRE + "dirs.files.name==Bob",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+		{
+			Name: "name==b* (case-sensitive wildcard)",
+			URL:  PRE + "dirs.files.name==b*",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{}}}}}`,
+		},
+		{
+			Name: "name!==Bob (case-sensitive)",
+			URL:  PRE + "dirs.files.name!==Bob",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{},"f3":{}}}}}`,
+		},
+
+		// Regex
+		{
+			Name: "name~=^bob$",
+			URL:  PRE + "dirs.files.name~=^bob$",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+		{
+			Name: "name~==^bob (case-sensitive)",
+			URL:  PRE + "dirs.files.name~==^bob",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{}}}}}`,
+		},
+		{
+			Name: "name~=^bo++b$ (ICU-only possessive quantifier)",
+			URL:  PRE + "dirs.files.name~=^bo%2B%2Bb$",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+		{
+			Name: "name~=b",
+			URL:  PRE + "dirs.files.name~=b",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{},"f2":{}}}}}`,
+		},
+
+		// Prefix
+		{
+			Name: "name^=bo",
+			URL:  PRE + "dirs.files.name^=bo",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{},"f2":{}}}}}`,
+		},
+		{
+			Name: "name^==Bo (case-sensitive)",
+			URL:  PRE + "dirs.files.name^==Bo",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+
+		// in()
+		{
+			Name: "count=in(3,5)",
+			URL:  PRE + "dirs.files.count=in(3,5)",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+		{
+			Name: "name=in(BOB,x)",
+			URL:  PRE + "dirs.files.name=in(BOB,x)",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+		{
+			Name: "name==in(BOB,bobby) (case-sensitive)",
+			URL:  PRE + "dirs.files.name==in(BOB,bobby)",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{}}}}}`,
+		},
+		{
+			Name: "name!=in(bob)",
+			URL:  PRE + "dirs.files.name!=in(bob)",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{},"f3":{}}}}}`,
+		},
+		{
+			Name: "count=in(3,7),name=bob - commas in in() aren't ANDs",
+			URL:  PRE + "dirs.files.count=in(3,7),dirs.files.name=bob",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+
+		// contains()
+		{
+			Name: "tags=contains(red)",
+			URL:  PRE + "dirs.files.tags=contains(red)",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{}}}}}`,
+		},
+		{
+			Name: "tags=contains(GREEN)",
+			URL:  PRE + "dirs.files.tags=contains(GREEN)",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{}}}}}`,
+		},
+		{
+			Name: "tags==contains(green) (case-sensitive)",
+			URL:  PRE + "dirs.files.tags==contains(green)",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{}}}}}`,
+		},
+		{
+			Name: "tags=contains(*e*)",
+			URL:  PRE + "dirs.files.tags=contains(*e*)",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{},"f2":{}}}}}`,
+		},
+
+		// haskey()
+		{
+			Name: "labels=haskey(team)",
+			URL:  PRE + "dirs.files.labels=haskey(team)",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{}}}}}`,
+		},
+		{
+			Name: "labels=haskey(env)",
+			URL:  PRE + "dirs.files.labels=haskey(env)",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{},"f2":{}}}}}`,
+		},
+
+		// Non-root
+		{
+			Name: "non-root tags=contains(blue)",
+			URL:  "/dirs/d1/files?oneline&filter=tags=contains(blue)",
+			Exp:  `{"f1":{}}`,
+		},
+		{
+			Name: "non-root name~=y$",
+			URL:  "/dirs/d1/files?oneline&filter=name~=y$",
+			Exp:  `{"f2":{}}`,
+		},
+
+		// Errors
+		{
+			Name: "name!=contains(x)",
+			URL:  "/dirs/d1/files?oneline&filter=tags!=contains(x)",
+			Exp: `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_filter",
+  "title": "An error was found in \"filter\" value (tags!=contains(x)): \"!=\" is not allowed with contains().",
+  "subject": "/dirs/d1/files",
+  "args": {
+    "error_detail": "\"!=\" is not allowed with contains()",
+    "value": "tags!=contains(x)"
+  },
+  "source": "xxx"
+}
+`,
+		},
+		{
+			Name: "name=in()",
+			URL:  "/dirs/d1/files?oneline&filter=name=in()",
+			Exp: `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_filter",
+  "title": "An error was found in \"filter\" value (name=in()): in() must have a value.",
+  "subject": "/dirs/d1/files",
+  "args": {
+    "error_detail": "in() must have a value",
+    "value": "name=in()"
+  },
+  "source": "xxx"
+}
+`,
+		},
+		{
+			Name: "name~=[a",
+			URL:  "/dirs/d1/files?oneline&filter=name~=[a",
+			Exp: `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_filter",
+  "title": "An error was found in \"filter\" value (name~=[a): invalid regular expression: The regular expression contains an unclosed bracket expression.",
+  "subject": "/dirs/d1/files",
+  "args": {
+    "error_detail": "invalid regular expression: The regular expression contains an unclosed bracket expression",
+    "value": "name~=[a"
+  },
+  "source": "xxx"
+}
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Logf("Test name: %s", test.Name)
+		XCheckGet(t, reg, test.URL, test.Exp)
+	}
+
+	// A leading \ means the value is a literal, not a function call
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f4", `{"name": "in(x)"}`, 201, "*")
+	XCheckGet(t, reg, "/dirs/d1/files?oneline&filter=name=%5Cin(x)",
+		`{"f4":{}}`)
+	XCheckGet(t, reg, "/dirs/d1/files?oneline&filter=name!=%5Cin(x)",
+		`{"f1":{},"f2":{},"f3":{}}`)
+
+	// Now turn off all but in()
+	XNoErr(t, reg.Refresh(registry.FOR_WRITE))
+	reg.Capabilities.FilterOperators = []string{"in"}
+	XNoErr(t, reg.SaveCapabilities())
+
+	XCheckGet(t, reg, "/dirs/d1/files?oneline&filter=count=in(7)",
+		`{"f2":{}}`)
+
+	XCheckGet(t, reg, "/dirs/d1/files?oneline&filter=name~=bob", `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_filter",
+  "title": "An error was found in \"filter\" value (name~=bob): the \"regex\" filter operator is not enabled.",
+  "subject": "/dirs/d1/files",
+  "args": {
+    "error_detail": "the \"regex\" filter operator is not enabled",
+    "value": "name~=bob"
+  },
+  "source": "xxx"
+}
+`)
+
+	XCheckGet(t, reg, "/dirs/d1/files?oneline&filter=count==in(7)", `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_filter",
+  "title": "An error was found in \"filter\" value (count==in(7)): the \"casesensitive\" filter operator is not enabled.",
+  "subject": "/dirs/d1/files",
+  "args": {
+    "error_detail": "the \"casesensitive\" filter operator is not enabled",
+    "value": "count==in(7)"
+  },
+  "source": "xxx"
+}
+`)
+}
+
 func TestFiltersObjs(t *testing.T) {
 	reg := NewRegistry("TestFiltersObjs")
 	defer PassDeleteReg(t, reg)
@@ -2221,6 +2515,202 @@ func TestFiltersWildcardsInName(t *testing.T) {
 	XHTTP(t, reg, "GET", "/?filter=foo=null", "", 200, `*`)
 }
 
+func TestFiltersDocument(t *testing.T) {
+	reg := NewRegistry("TestFiltersDocument")
+	defer PassDeleteReg(t, reg)
+
+	model := MODEL_DIRS
+	XHTTP(t, reg, "PUT", "/modelsource", model, 200, model+"\n")
+
+	XHTTP(t, reg, "PUT", "/", `{
+  "dirs": {
+    "d1": {
+      "files": {
+        "f1": {
+          "meta": {
+            "defaultversionid": "v2"
+          },
+          "versions": {
+            "v1": {
+              "file": {
+                "namespace": "com.acme",
+                "version": 1,
+                "fields": [ { "name": "id", "type": "string" } ]
+              }
+            },
+            "v2": {
+              "file": {
+                "namespace": "com.other",
+                "version": 2,
+                "fields": [ { "name": "id", "type": "long" } ]
+              }
+            }
+          }
+        },
+        "f2": {
+          "versions": {
+            "v1": {
+              "file": {
+                "namespace": "com.acme",
+                "version": 10,
+                "properties": { "id": { "type": "string" } }
+              }
+            }
+          }
+        },
+        "f3": {
+          "versions": {
+            "v1": {
+              "contenttype": "application/yaml",
+              "file": "namespace: com.yaml\nversion: 3\n"
+            }
+          }
+        },
+        "f4": {
+          "versions": {
+            "v1": {
+              "contenttype": "text/plain",
+              "file": "just some text"
+            }
+          }
+        }
+      }
+    }
+  }
+}`, 200, `{
+  "specversion": "`+SPECVERSION+`",
+  "registryid": "TestFiltersDocument",
+  "self": "http://localhost:8181/",
+  "xid": "/",
+  "epoch": 3,
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:02Z",
+
+  "dirsurl": "http://localhost:8181/dirs",
+  "dirscount": 1
+}
+`)
+
+	// Note that "#" needs to be escaped (%23) in the URL
+	PRE := "?inline&oneline&filter=dirs.files."
+	tests := []struct {
+		Name string
+		URL  string
+		Exp  string
+	}{
+		{
+			Name: "resource level - uses default version's doc",
+			URL:  PRE + "file%23/namespace=com.acme",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "version level",
+			URL:  PRE + "versions.file%23/namespace=com.acme",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"v1":{}}},"f2":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "case-insensitive string",
+			URL:  PRE + "versions.file%23/namespace=COM.ACME",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"v1":{}}},"f2":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "wildcard value, includes yaml doc",
+			URL:  PRE + "versions.file%23/namespace=com.*",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"v1":{},"v2":{}}},"f2":{"meta":{},"versions":{"v1":{}}},"f3":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "yaml doc",
+			URL:  PRE + "versions.file%23/namespace=com.yaml",
+			Exp:  `{"dirs":{"d1":{"files":{"f3":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "numeric compare",
+			URL:  PRE + "versions.file%23/version>2",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{"meta":{},"versions":{"v1":{}}},"f3":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "array index",
+			URL:  PRE + "versions.file%23/fields/0/type=long",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"v2":{}}}}}}}`,
+		},
+		{
+			Name: "wildcard pointer",
+			URL:  PRE + "versions.file%23/fields/*/type=string",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "present - non-leaf",
+			URL:  PRE + "versions.file%23/properties/id",
+			Exp:  `{"dirs":{"d1":{"files":{"f2":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "absent - non-json doc has nothing indexed",
+			URL:  PRE + "versions.file%23/namespace=null",
+			Exp:  `{"dirs":{"d1":{"files":{"f4":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "not equal",
+			URL:  PRE + "versions.file%23/namespace!=com.acme",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"v2":{}}},"f3":{"meta":{},"versions":{"v1":{}}},"f4":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "AND with a regular attribute",
+			URL:  PRE + "versions.file%23/namespace=com.acme,dirs.files.versions.fileid=f1",
+			Exp:  `{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"v1":{}}}}}}}`,
+		},
+		{
+			Name: "non-root",
+			URL:  "/dirs/d1/files?oneline&filter=file%23/namespace=com.acme",
+			Exp:  `{"f2":{}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Logf("Test name: %s", test.Name)
+		XCheckGet(t, reg, test.URL, test.Exp)
+	}
+
+	XHTTP(t, reg, "GET", "/dirs/d1/files?filter=fileid%23/x", ``, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_filter",
+  "title": "For \"/dirs/d1/files?filter=fileid%23/x\", an error was found in \"filter\" value (fileid#/x): \"#\" is only allowed after the document attribute of a Resource or Version.",
+  "subject": "/dirs/d1/files?filter=fileid%23/x",
+  "args": {
+    "error_detail": "\"#\" is only allowed after the document attribute of a Resource or Version",
+    "value": "fileid#/x"
+  },
+  "source": "xxx"
+}
+`)
+
+	XHTTP(t, reg, "GET", "/dirs/d1/files?filter=file%23x", ``, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_filter",
+  "title": "For \"/dirs/d1/files?filter=file%23x\", an error was found in \"filter\" value (file#x): a document pointer must be empty or start with \"/\".",
+  "subject": "/dirs/d1/files?filter=file%23x",
+  "args": {
+    "error_detail": "a document pointer must be empty or start with \"/\"",
+    "value": "file#x"
+  },
+  "source": "xxx"
+}
+`)
+
+	// Nodes with pointers longer than DocProps.Pointer aren't indexed, but
+	// the document is still saved and the rest of it is still searchable
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f5",
+		`{"short":"yes","`+strings.Repeat("a", 600)+`":"no"}`, 201, "*")
+	XHTTP(t, reg, "GET", "/dirs/d1/files?filter=file%23/short=yes", "", 200,
+		`*"fileid": "f5"*`)
+
+	// contains() only looks at array elements, not at object members
+	// whose keys happen to look like array indexes
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f6",
+		`{"tags":["red"],"obj":{"0":"red"}}`, 201, "*")
+	XCheckGet(t, reg, "/dirs/d1/files?oneline&filter=file%23/tags=contains(red)",
+		`{"f6":{}}`)
+	XCheckGet(t, reg, "/dirs/d1/files?oneline&filter=file%23/obj=contains(red)",
+		`{}`)
+}
+
 func TestFiltersExcludeAll(t *testing.T) {
 	reg := NewRegistry("TestFiltersExcludeAll")
 	defer PassDeleteReg(t, reg)
@@ -2303,3 +2793,43 @@ func TestFiltersExcludeAll(t *testing.T) {
 	XHTTP(t, reg, "GET", "/dirs?filter=excludeall", "", 200, `{}
 `)
 }
+
+func TestFiltersDocumentReindex(t *testing.T) {
+	reg := NewRegistry("TestFiltersDocumentReindex")
+	defer PassDeleteReg(t, reg)
+
+	_, _, err := reg.Model.CreateModels("dirs", "dir", "files", "file")
+	XNoErr(t, err)
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1", `{"a":"b"}`, 201, `*`)
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/dirs/d1/files/f2",
+		Method:     "PUT",
+		ReqHeaders: []string{"Content-Type: application/yaml"},
+		ReqBody:    "a: c\n",
+		Code:       201,
+		ResHeaders: []string{"*"},
+		ResBody:    `*`,
+	})
+
+	PRE := "?inline&oneline&filter=dirs.files.file%23/a="
+	XCheckGet(t, reg, PRE+"b",
+		`{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"1":{}}}}}}}`)
+
+	// Make them look like documents stored before they were indexed
+	_, dbErr := registry.DB.Exec(`
+        DELETE dp FROM DocProps AS dp
+        JOIN Versions AS v ON (v.SID=dp.ContentSID)
+        WHERE v.RegistrySID=?`, reg.DbSID)
+	XNoErr(t, dbErr)
+	XCheckGet(t, reg, PRE+"b", `{}`)
+
+	count, xErr := registry.ReindexDocuments(reg.UID)
+	XNoErr(t, xErr)
+	XEqual(t, "", count, 2)
+
+	XCheckGet(t, reg, PRE+"b",
+		`{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"1":{}}}}}}}`)
+	XCheckGet(t, reg, PRE+"c",
+		`{"dirs":{"d1":{"files":{"f2":{"meta":{},"versions":{"1":{}}}}}}}`)
+}
@@ -3410,6 +3410,11 @@ func TestFormatXrefModelRevalidationCompatCascade(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
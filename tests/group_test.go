@@ -251,3 +251,345 @@ func TestGroupDeprecated(t *testing.T) {
 }
 `)
 }
+
+func TestGroupChangeRequests(t *testing.T) {
+	reg := NewRegistry("TestGroupChangeRequests")
+	defer PassDeleteReg(t, reg)
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": { "dirs": {
+    "singular": "dir",
+    "approval": { "approvals": 2, "resources": [ "bogus" ] },
+    "resources": { "files": { "singular": "file", "hasdocument": false } }
+  } }
+}`, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#model_error",
+  "title": "There was an error in the model definition provided: Group \"dirs\" \"approval.resources\" references an unknown Resource \"bogus\".",
+  "subject": "/model",
+  "args": {
+    "error_detail": "Group \"dirs\" \"approval.resources\" references an unknown Resource \"bogus\""
+  },
+  "source": "xxx"
+}
+`)
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": { "dirs": {
+    "singular": "dir",
+    "approval": {
+      "approvals": 2,
+      "approvers": [ "alice", "bob", "carol", "dave" ],
+      "labels": [ "protected" ]
+    },
+    "resources": { "files": { "singular": "file", "hasdocument": false } }
+  } }
+}`, 200, `*`)
+
+	// as "user" - "" means no user
+	crHTTP := func(verb, url, user, body string, code int, res string, headers ...string) {
+		t.Helper()
+		reqHeaders := []string{}
+		if user != "" {
+			reqHeaders = append(reqHeaders, "xRegistry~User: "+user)
+		}
+		XCheckHTTP(t, reg, &HTTPTest{
+			URL:        url,
+			Method:     verb,
+			ReqHeaders: reqHeaders,
+			ReqBody:    body,
+			Code:       code,
+			ResHeaders: append([]string{"*"}, headers...),
+			ResBody:    res,
+		})
+	}
+
+	// Unprotected Groups work as usual
+	XHTTP(t, reg, "PUT", "/dirs/d1", `{"labels":{"protected":"yes"}}`, 201,
+		`*`)
+	XHTTP(t, reg, "PUT", "/dirs/d2/files/f1/versions/v1", `{}`, 201, `*`)
+
+	// By default the "xRegistry~User" header isn't trusted, so w/o any
+	// user tokens nobody can approve anything
+	crHTTP("PUT", "/dirs/d1/files/f0/versions/v1", "dave", `{}`, 202, `*`,
+		"location: http://localhost:8181/dirs/d1/changerequests/1")
+	crHTTP("POST", "/dirs/d1/changerequests/1/approve", "alice", ``, 403, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#changerequest_forbidden",
+  "title": "User \"\" can't approve the change request \"/dirs/d1/changerequests/1\": no user tokens are configured.",
+  "subject": "/dirs/d1/changerequests/1",
+  "args": {
+    "action": "approve",
+    "error_detail": "no user tokens are configured",
+    "user": ""
+  },
+  "source": "xxx"
+}
+`)
+	crHTTP("DELETE", "/dirs/d1/changerequests/1", "dave", ``, 403, `*`)
+
+	registry.TrustUserHeader = true
+	defer func() { registry.TrustUserHeader = false }()
+	crHTTP("DELETE", "/dirs/d1/changerequests/1", "alice", ``, 204, ``)
+
+	crHTTP("PUT", "/dirs/d1/files/f1/versions/v1", "dave",
+		`{"description":"first"}`, 202, `{
+  "changerequestid": "1",
+  "self": "http://localhost:8181/dirs/d1/changerequests/1",
+  "status": "pending",
+  "method": "PUT",
+  "target": "/dirs/d1/files/f1/versions/v1",
+  "requester": "dave",
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:01Z",
+  "diff": [
+    {
+      "name": "description",
+      "new": "first"
+    }
+  ],
+  "proposed": {
+    "description": "first"
+  }
+}
+`, "location: http://localhost:8181/dirs/d1/changerequests/1")
+
+	// Nothing was created yet
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1", ``, 404, `*`)
+
+	crHTTP("POST", "/dirs/d1/changerequests/1/approve", "", ``, 403, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#changerequest_forbidden",
+  "title": "User \"\" can't approve the change request \"/dirs/d1/changerequests/1\": the \"xRegistry~User\" header is missing.",
+  "subject": "/dirs/d1/changerequests/1",
+  "args": {
+    "action": "approve",
+    "error_detail": "the \"xRegistry~User\" header is missing",
+    "user": ""
+  },
+  "source": "xxx"
+}
+`)
+	crHTTP("POST", "/dirs/d1/changerequests/1/approve", "eve", ``, 403, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#changerequest_forbidden",
+  "title": "User \"eve\" can't approve the change request \"/dirs/d1/changerequests/1\": not one of the configured approvers.",
+  "subject": "/dirs/d1/changerequests/1",
+  "args": {
+    "action": "approve",
+    "error_detail": "not one of the configured approvers",
+    "user": "eve"
+  },
+  "source": "xxx"
+}
+`)
+	crHTTP("POST", "/dirs/d1/changerequests/1/approve", "dave", ``, 403,
+		`*`)
+
+	crHTTP("POST", "/dirs/d1/changerequests/1/approve", "alice", ``, 200, `{
+  "changerequestid": "1",
+  "self": "http://localhost:8181/dirs/d1/changerequests/1",
+  "status": "pending",
+  "method": "PUT",
+  "target": "/dirs/d1/files/f1/versions/v1",
+  "requester": "dave",
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:02Z",
+  "approvals": [
+    "alice"
+  ],
+  "diff": [
+    {
+      "name": "description",
+      "new": "first"
+    }
+  ],
+  "proposed": {
+    "description": "first"
+  }
+}
+`)
+	crHTTP("POST", "/dirs/d1/changerequests/1/approve", "alice", ``, 403,
+		`*`)
+
+	// 2nd approval applies it
+	crHTTP("POST", "/dirs/d1/changerequests/1/approve", "bob", ``, 200, `{
+  "changerequestid": "1",
+  "self": "http://localhost:8181/dirs/d1/changerequests/1",
+  "status": "applied",
+  "method": "PUT",
+  "target": "/dirs/d1/files/f1/versions/v1",
+  "requester": "dave",
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:02Z",
+  "approvals": [
+    "alice",
+    "bob"
+  ],
+  "appliedxid": "/dirs/d1/files/f1/versions/v1",
+  "diff": [
+    {
+      "name": "description",
+      "new": "first"
+    }
+  ],
+  "proposed": {
+    "description": "first"
+  }
+}
+`)
+	XCheckGet(t, reg, "dirs/d1/files/f1/versions?oneline", `{"v1":{}}`)
+
+	crHTTP("POST", "/dirs/d1/changerequests/1/reject", "carol", ``, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#changerequest_closed",
+  "title": "The change request \"/dirs/d1/changerequests/1\" is already \"applied\".",
+  "subject": "/dirs/d1/changerequests/1",
+  "args": {
+    "status": "applied"
+  },
+  "source": "xxx"
+}
+`)
+
+	crHTTP("PATCH", "/dirs/d1/files/f1/versions/v1", "dave",
+		`{"description":"second","x":1}`, 202, `*`,
+		"location: http://localhost:8181/dirs/d1/changerequests/2")
+	crHTTP("POST", "/dirs/d1/changerequests/2/reject", "carol",
+		`{"reason":"no"}`, 200, `*`)
+
+	XHTTP(t, reg, "GET", "/dirs/d1/changerequests/2", ``, 200, `{
+  "changerequestid": "2",
+  "self": "http://localhost:8181/dirs/d1/changerequests/2",
+  "status": "rejected",
+  "method": "PATCH",
+  "target": "/dirs/d1/files/f1/versions/v1",
+  "requester": "dave",
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:02Z",
+  "rejectedby": "carol",
+  "reason": "no",
+  "diff": [
+    {
+      "name": "description",
+      "old": "first",
+      "new": "second"
+    },
+    {
+      "name": "x",
+      "new": 1
+    }
+  ],
+  "proposed": {
+    "description": "second",
+    "x": 1
+  },
+  "patch": true
+}
+`)
+	XCheckGet(t, reg,
+		"dirs/d1/files/f1/versions/v1?fields=versionid,description", `{
+  "versionid": "v1",
+  "description": "first"
+}
+`)
+
+	// Only approvers can delete a closed one, and applied ones are kept
+	crHTTP("DELETE", "/dirs/d1/changerequests/2", "", ``, 403, `*`)
+	crHTTP("DELETE", "/dirs/d1/changerequests/2", "eve", ``, 403, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#changerequest_forbidden",
+  "title": "User \"eve\" can't delete the change request \"/dirs/d1/changerequests/2\": only the requester (while it's pending) or an approver can delete it.",
+  "subject": "/dirs/d1/changerequests/2",
+  "args": {
+    "action": "delete",
+    "error_detail": "only the requester (while it's pending) or an approver can delete it",
+    "user": "eve"
+  },
+  "source": "xxx"
+}
+`)
+	crHTTP("DELETE", "/dirs/d1/changerequests/2", "carol", ``, 204, ``)
+	XHTTP(t, reg, "GET", "/dirs/d1/changerequests/2", ``, 404, `*`)
+	crHTTP("DELETE", "/dirs/d1/changerequests/1", "alice", ``, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#changerequest_closed",
+  "title": "The change request \"/dirs/d1/changerequests/1\" is already \"applied\".",
+  "subject": "/dirs/d1/changerequests/1",
+  "args": {
+    "status": "applied"
+  },
+  "source": "xxx"
+}
+`)
+
+	// A non-approver can withdraw their own pending request, but nobody
+	// else's
+	crHTTP("PATCH", "/dirs/d1/files/f1/versions/v1", "erin",
+		`{"description":"third"}`, 202, `*`,
+		"location: http://localhost:8181/dirs/d1/changerequests/2")
+	crHTTP("DELETE", "/dirs/d1/changerequests/2", "eve", ``, 403, `*`)
+	crHTTP("DELETE", "/dirs/d1/changerequests/2", "erin", ``, 204, ``)
+	XHTTP(t, reg, "GET", "/dirs/d1/changerequests/2", ``, 404, `*`)
+
+	// With user tokens the "xRegistry~User" header can't be used to
+	// pretend to be someone else
+	registry.UserTokens = map[string]string{"t-alice": "alice"}
+	defer func() { registry.UserTokens = map[string]string{} }()
+	crHTTP("PATCH", "/dirs/d1/files/f1/versions/v1", "alice",
+		`{"description":"fourth"}`, 202, `*`,
+		"location: http://localhost:8181/dirs/d1/changerequests/2")
+	crHTTP("DELETE", "/dirs/d1/changerequests/2", "alice", ``, 403, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#changerequest_forbidden",
+  "title": "User \"\" can't delete the change request \"/dirs/d1/changerequests/2\": the \"Authorization\" header is missing or its token is unknown.",
+  "subject": "/dirs/d1/changerequests/2",
+  "args": {
+    "action": "delete",
+    "error_detail": "the \"Authorization\" header is missing or its token is unknown",
+    "user": ""
+  },
+  "source": "xxx"
+}
+`)
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/dirs/d1/changerequests/2",
+		Method:     "DELETE",
+		ReqHeaders: []string{"Authorization: Bearer t-alice"},
+		Code:       204,
+	})
+	registry.UserTokens = map[string]string{}
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/changerequests/1", `{}`, 405, `*`)
+
+	// Anything that isn't a single Version can't be done
+	XHTTP(t, reg, "POST", "/dirs/d1/files", `{"f2":{}}`, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#approval_required",
+  "title": "Changes to \"/dirs/d1/files\" require approval and must be made one Version at a time.",
+  "subject": "/dirs/d1/files",
+  "source": "xxx"
+}
+`)
+	XHTTP(t, reg, "PUT", "/dirs/d3", `{"files":{"f2":{}}}`, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#approval_required",
+  "title": "Changes to \"/dirs/d3/files\" require approval and must be made one Version at a time.",
+  "subject": "/dirs/d3/files",
+  "source": "xxx"
+}
+`)
+
+	// The labels of a Group that the policy selects can't be changed,
+	// otherwise removing them would turn off its approvals
+	XHTTP(t, reg, "PATCH", "/dirs/d1", `{"labels":{}}`, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#approval_required",
+  "title": "Changes to \"/dirs/d1/labels\" require approval and must be made one Version at a time.",
+  "detail": "The Group's labels select it for approval, so they can't be changed.",
+  "subject": "/dirs/d1/labels",
+  "source": "xxx"
+}
+`)
+	XHTTP(t, reg, "PUT", "/dirs/d1", `{}`, 400, `*approval_required*`)
+	XHTTP(t, reg, "PATCH", "/", `{"dirs":{"d1":{"labels":{"a":"b"}}}}`, 400,
+		`*approval_required*`)
+	XHTTP(t, reg, "POST", "/dirs", `{"d1":{}}`, 400, `*approval_required*`)
+
+	// Other changes are fine, as are label changes of other Groups
+	XHTTP(t, reg, "PATCH", "/dirs/d1", `{"description":"d"}`, 200, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d1", `{"labels":{"protected":"yes"}}`, 200,
+		`*`)
+	XHTTP(t, reg, "PATCH", "/dirs/d2", `{"labels":{"other":"x"}}`, 200, `*`)
+	XHTTP(t, reg, "POST", "/dirs/d1", `{}`, 200, `*`)
+	XHTTP(t, reg, "GET", "/dirs/d1", ``, 200, `*"protected": "yes"*`)
+}
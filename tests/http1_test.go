@@ -904,6 +904,11 @@ func TestHTTPModel(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -2254,6 +2259,11 @@ func TestHTTPModel(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -9635,6 +9635,9 @@ func TestHTTPSpecVersionPatchIgnore(t *testing.T) {
     },
     "modelsource": {
       "mutable": true
+    },
+    "ratelimits": {
+      "mutable": false
     }
   },
   "compatibilities": {
@@ -9687,14 +9690,29 @@ func TestHTTPSpecVersionPatchIgnore(t *testing.T) {
       "full_transitive"
     ]
   },
+  "encodings": [
+    "gzip",
+    "zstd"
+  ],
+  "filteroperators": [
+    "casesensitive",
+    "contains",
+    "haskey",
+    "in",
+    "prefix",
+    "regex"
+  ],
   "flags": [
     "binary",
     "collections",
     "doc",
     "epoch",
+    "facet",
+    "fields",
     "filter",
     "ignore",
     "inline",
+    "publish",
     "setdefaultversionid",
     "sort",
     "specversion"
@@ -9717,6 +9735,7 @@ func TestHTTPSpecVersionPatchIgnore(t *testing.T) {
     "readonly"
   ],
   "pagination": false,
+  "ranges": true,
   "shortself": false,
   "specversions": [
     "` + SPECVERSION + `"
@@ -9799,12 +9818,15 @@ func TestHTTPSpecVersionPatchIgnore(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "specversion"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "1.0.5-rc4"
@@ -9827,12 +9849,15 @@ func TestHTTPSpecVersionPatchIgnore(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "specversion"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "1.0.5-rc4"
@@ -630,6 +630,11 @@ func TestHTTPModelSource(t *testing.T) {
                 "type": "string",
                 "readonly": true
               },
+              "digest": {
+                "name": "digest",
+                "type": "string",
+                "readonly": true
+              },
               "fileurl": {
                 "name": "fileurl",
                 "type": "url"
@@ -2341,6 +2346,454 @@ func TestHTTPSortArray(t *testing.T) {
 
 }
 
+func TestHTTPSortMulti(t *testing.T) {
+	reg := NewRegistry("TestHTTPSortMulti")
+	defer PassDeleteReg(t, reg)
+
+	XHTTP(t, reg, "PUT", "/", `{
+  "modelsource": {
+    "groups": {
+      "dirs": {
+        "singular": "dir",
+        "attributes": {
+          "myint": { "type": "integer" },
+          "mytime": { "type": "timestamp" }
+        },
+        "resources": {
+          "files": {
+            "singular": "file",
+            "hasdocument": false
+          }
+        }
+      }
+    }
+  },
+  "dirs": {
+    "d1": {
+      "labels": { "team": "b" },
+      "myint": 10,
+      "mytime": "2024-01-01T00:00:00.5Z",
+      "files": {
+        "f1": {
+          "versions": {
+            "v1": { "name": "b" },
+            "v2": { "name": "c" },
+            "v3": {}
+          }
+        },
+        "f2": {
+          "versions": {
+            "v1": { "name": "x" },
+            "v2": { "name": "a" }
+          }
+        }
+      }
+    },
+    "d2": {
+      "labels": { "team": "a" },
+      "myint": 9,
+      "mytime": "2024-01-01T00:00:00Z"
+    },
+    "d3": {
+      "labels": { "team": "b" },
+      "myint": 9,
+      "mytime": "2023-12-31T23:00:00-02:00"
+    },
+    "d4": {
+      "myint": 2
+    }
+  }
+}`, 200, `*`)
+
+	// Nulls first by default (asc), then numbers compared as numbers
+	XCheckGet(t, reg, "dirs?oneline&sort=labels.team,-myint",
+		`{"d4":{},"d2":{},"d1":{},"d3":{}}`)
+	XCheckGet(t, reg, "dirs?oneline&sort=labels.team=nullslast,myint",
+		`{"d2":{},"d3":{},"d1":{},"d4":{}}`)
+	XCheckGet(t, reg, "dirs?oneline&sort=-labels.team=nullsfirst,myint",
+		`{"d4":{},"d3":{},"d1":{},"d2":{}}`)
+
+	// Timestamps with fractional seconds, or that were normalized, still
+	// sort in time order (not string order)
+	XCheckGet(t, reg, "dirs?oneline&sort=mytime",
+		`{"d4":{},"d2":{},"d1":{},"d3":{}}`)
+	XCheckGet(t, reg, "dirs?oneline&sort=mytime=desc",
+		`{"d3":{},"d1":{},"d2":{},"d4":{}}`)
+
+	// Inlined nested collections are sorted too, 'meta' stays first
+	XCheckGet(t, reg, "dirs/d1/files?oneline&inline&sort=name=desc",
+		`{"f2":{"meta":{},"versions":{"v1":{},"v2":{}}},"f1":{"meta":{},"versions":{"v2":{},"v1":{},"v3":{}}}}`)
+	XCheckGet(t, reg, "dirs?oneline&inline&sort=-myint,name",
+		`{"d1":{"files":{"f1":{"meta":{},"versions":{"v3":{},"v1":{},"v2":{}}},"f2":{"meta":{},"versions":{"v2":{},"v1":{}}}}},"d2":{"files":{}},"d3":{"files":{}},"d4":{"files":{}}}`)
+
+	XHTTP(t, reg, "GET", "/dirs?sort=name=up", ``, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_sort",
+  "title": "For \"/dirs?sort=name=up\", an error was found in \"sort\" value (name=up): invalid \"sort\" order \"up\".",
+  "subject": "/dirs?sort=name=up",
+  "args": {
+    "error_detail": "invalid \"sort\" order \"up\"",
+    "value": "name=up"
+  },
+  "source": "xxx"
+}
+`)
+
+	XHTTP(t, reg, "GET", "/dirs?sort=-name=desc", ``, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_sort",
+  "title": "For \"/dirs?sort=-name=desc\", an error was found in \"sort\" value (-name=desc): \"-\" and \"=desc\" can't both be used on \"name\".",
+  "subject": "/dirs?sort=-name=desc",
+  "args": {
+    "error_detail": "\"-\" and \"=desc\" can't both be used on \"name\"",
+    "value": "-name=desc"
+  },
+  "source": "xxx"
+}
+`)
+}
+
+func TestHTTPFields(t *testing.T) {
+	reg := NewRegistry("TestHTTPFields")
+	defer PassDeleteReg(t, reg)
+
+	XHTTP(t, reg, "PUT", "/", `{
+  "modelsource": {
+    "groups": {
+      "dirs": {
+        "singular": "dir",
+        "resources": {
+          "files": {
+            "singular": "file",
+            "hasdocument": false
+          }
+        }
+      }
+    }
+  },
+  "dirs": {
+    "d1": {
+      "description": "dir one",
+      "documentation": "http://example.com/d1",
+      "labels": { "owner": "team-a", "env": "prod" },
+      "files": {
+        "f1": {
+          "versions": {
+            "v1": {
+              "description": "v one",
+              "labels": { "owner": "x", "tier": "1" }
+            },
+            "v2": { "name": "v two" }
+          }
+        }
+      }
+    },
+    "d2": {}
+  }
+}`, 200, `*`)
+
+	XHTTP(t, reg, "GET", "/dirs?fields=id,labels.owner", ``, 200, `{
+  "d1": {
+    "dirid": "d1",
+    "labels": {
+      "owner": "team-a"
+    },
+
+    "filesurl": "http://localhost:8181/dirs/d1/files",
+    "filescount": 1
+  },
+  "d2": {
+    "dirid": "d2",
+
+    "filesurl": "http://localhost:8181/dirs/d2/files",
+    "filescount": 0
+  }
+}
+`)
+
+	// Per-level, "metaurl" is always shown, types w/o fields are unchanged
+	XHTTP(t, reg, "GET",
+		"/dirs/d1/files?inline=versions&fields=id,versions.id,versions.labels.owner",
+		``, 200, `{
+  "f1": {
+    "fileid": "f1",
+
+    "metaurl": "http://localhost:8181/dirs/d1/files/f1/meta",
+    "versionsurl": "http://localhost:8181/dirs/d1/files/f1/versions",
+    "versions": {
+      "v1": {
+        "versionid": "v1",
+        "labels": {
+          "owner": "x"
+        }
+      },
+      "v2": {
+        "versionid": "v2"
+      }
+    },
+    "versionscount": 2
+  }
+}
+`)
+
+	XHTTP(t, reg, "GET", "/?inline=dirs&fields=registryid,dirs.dirid",
+		``, 200, `{
+  "registryid": "TestHTTPFields",
+
+  "dirsurl": "http://localhost:8181/dirs",
+  "dirs": {
+    "d1": {
+      "dirid": "d1",
+
+      "filesurl": "http://localhost:8181/dirs/d1/files",
+      "filescount": 1
+    },
+    "d2": {
+      "dirid": "d2",
+
+      "filesurl": "http://localhost:8181/dirs/d2/files",
+      "filescount": 0
+    }
+  },
+  "dirscount": 2
+}
+`)
+
+	// Works with filters too
+	XHTTP(t, reg, "GET", "/dirs?fields=id,description&filter=dirid=d2",
+		``, 200, `{
+  "d2": {
+    "dirid": "d2",
+
+    "filesurl": "http://localhost:8181/dirs/d2/files?filter=excludeall",
+    "filescount": 0
+  }
+}
+`)
+
+	XHTTP(t, reg, "GET", "/dirs?fields=files", ``, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_fields",
+  "title": "For \"/dirs?fields=files\", an error was found in \"fields\" value (files): missing an attribute name.",
+  "subject": "/dirs?fields=files",
+  "args": {
+    "error_detail": "missing an attribute name",
+    "value": "files"
+  },
+  "source": "xxx"
+}
+`)
+}
+
+func TestHTTPFacets(t *testing.T) {
+	reg := NewRegistry("TestHTTPFacets")
+	defer PassDeleteReg(t, reg)
+
+	XHTTP(t, reg, "PUT", "/", `{
+  "modelsource": {
+    "groups": {
+      "dirs": {
+        "singular": "dir",
+        "attributes": {
+          "myint": { "type": "integer" },
+          "mytime": { "type": "timestamp" }
+        },
+        "resources": {
+          "files": {
+            "singular": "file",
+            "hasdocument": false
+          }
+        }
+      }
+    }
+  },
+  "dirs": {
+    "d1": {
+      "labels": { "team": "b" },
+      "myint": 10,
+      "mytime": "2024-01-01T00:00:00.5Z",
+      "files": {
+        "f1": {
+          "versions": {
+            "v1": { "name": "b" },
+            "v2": { "name": "c" },
+            "v3": {}
+          }
+        },
+        "f2": {
+          "versions": {
+            "v1": { "name": "x" },
+            "v2": { "name": "a" }
+          }
+        }
+      }
+    },
+    "d2": {
+      "labels": { "team": "a" },
+      "myint": 9,
+      "mytime": "2024-01-01T00:00:00Z"
+    },
+    "d3": {
+      "labels": { "team": "b" },
+      "myint": 9,
+      "mytime": "2023-12-31T23:00:00Z"
+    },
+    "d4": {
+      "myint": 2
+    }
+  }
+}`, 200, `*`)
+
+	// Numbers and timestamps are compared by value for min/max
+	XHTTP(t, reg, "GET", "/dirs?facet=labels.team,myint,mytime,id", ``, 200,
+		`{
+  "id": {
+    "count": 4,
+    "missing": 0,
+    "min": "d1",
+    "max": "d4",
+    "values": {
+      "d1": 1,
+      "d2": 1,
+      "d3": 1,
+      "d4": 1
+    }
+  },
+  "labels.team": {
+    "count": 4,
+    "missing": 1,
+    "min": "a",
+    "max": "b",
+    "values": {
+      "a": 1,
+      "b": 2
+    }
+  },
+  "myint": {
+    "count": 4,
+    "missing": 0,
+    "min": 2,
+    "max": 10,
+    "values": {
+      "10": 1,
+      "2": 1,
+      "9": 2
+    }
+  },
+  "mytime": {
+    "count": 4,
+    "missing": 1,
+    "min": "YYYY-MM-DDTHH:MM:01Z",
+    "max": "YYYY-MM-DDTHH:MM:02Z",
+    "values": {
+      "YYYY-MM-DDTHH:MM:01Z": 1,
+      "YYYY-MM-DDTHH:MM:02Z": 1,
+      "YYYY-MM-DDTHH:MM:03Z": 1
+    }
+  }
+}
+`)
+
+	// Nested collections, and the Group's ID at a lower level
+	XHTTP(t, reg, "GET", "/dirs?facet=files.versions.name,files.dirid", ``,
+		200, `{
+  "files.dirid": {
+    "count": 2,
+    "missing": 0,
+    "min": "d1",
+    "max": "d1",
+    "values": {
+      "d1": 2
+    }
+  },
+  "files.versions.name": {
+    "count": 5,
+    "missing": 1,
+    "min": "a",
+    "max": "x",
+    "values": {
+      "a": 1,
+      "b": 1,
+      "c": 1,
+      "x": 1
+    }
+  }
+}
+`)
+
+	// Only what's under the URL's path is counted
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1?facet=versions.name", ``, 200,
+		`{
+  "versions.name": {
+    "count": 3,
+    "missing": 1,
+    "min": "b",
+    "max": "c",
+    "values": {
+      "b": 1,
+      "c": 1
+    }
+  }
+}
+`)
+
+	// Filters apply
+	XHTTP(t, reg, "GET", "/dirs?facet=myint&filter=labels.team=b", ``, 200,
+		`{
+  "myint": {
+    "count": 2,
+    "missing": 0,
+    "min": 9,
+    "max": 10,
+    "values": {
+      "10": 1,
+      "9": 1
+    }
+  }
+}
+`)
+
+	XHTTP(t, reg, "GET", "/dirs?facet=files.fileid&filter=files.versions.name=x",
+		``, 200, `{
+  "files.fileid": {
+    "count": 1,
+    "missing": 0,
+    "min": "f2",
+    "max": "f2",
+    "values": {
+      "f2": 1
+    }
+  }
+}
+`)
+
+	XHTTP(t, reg, "GET", "/dirs?facet=myint&filter=excludeall", ``, 200, `{
+  "myint": {
+    "count": 0,
+    "missing": 0,
+    "values": {}
+  }
+}
+`)
+
+	XHTTP(t, reg, "GET", "/dirs?facet=files", ``, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_facet",
+  "title": "For \"/dirs?facet=files\", an error was found in \"facet\" value (files): missing an attribute name.",
+  "subject": "/dirs?facet=files",
+  "args": {
+    "error_detail": "missing an attribute name",
+    "value": "files"
+  },
+  "source": "xxx"
+}
+`)
+
+	XHTTP(t, reg, "GET", "/dirs/dx/files?facet=id", ``, 404, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#not_found",
+  "title": "The targeted entity (/dirs/dx) cannot be found.",
+  "subject": "/dirs/dx",
+  "source": "xxx"
+}
+`)
+}
+
 func TestHTTPJsonSchema(t *testing.T) {
 	reg := NewRegistry("TestHTTPJsonSchema")
 	defer PassDeleteReg(t, reg)
@@ -2426,10 +2879,13 @@ func TestHTTPJsonSchema(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -2472,10 +2928,13 @@ func TestHTTPJsonSchema(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -4817,10 +5276,13 @@ func TestHTTPXRegistryDiscovery(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -12,6 +12,7 @@ import (
 	log "github.com/duglin/dlog"
 
 	. "github.com/xregistry/server/common"
+	"github.com/xregistry/server/registry"
 )
 
 func TestMiscDBRows(t *testing.T) {
@@ -615,3 +616,87 @@ func (b *SyncBuffer) String() string {
 	defer b.mu.Unlock()
 	return b.buf.String()
 }
+
+func TestMiscUpgradeDB(t *testing.T) {
+	// Make the DB look like one created before DocProps, ArchivedVersions
+	// and ChangeRequests were added, with the old triggers
+	for _, cmd := range []string{
+		`DROP TABLE IF EXISTS DocProps`,
+		`DROP TABLE IF EXISTS ArchivedVersions`,
+		`DROP TABLE IF EXISTS ChangeRequests`,
+		`DROP TRIGGER IF EXISTS RegistryTrigger`,
+		`DROP TRIGGER IF EXISTS GroupTrigger`,
+		`DROP TRIGGER IF EXISTS VersionsTrigger`,
+		`CREATE TRIGGER VersionsTrigger BEFORE DELETE ON Versions
+         FOR EACH ROW
+         BEGIN
+             DELETE FROM ResourceContents WHERE VersionSID=OLD.SID;
+             DELETE FROM Props WHERE eSID=OLD.SID;
+             DELETE FROM Entities  WHERE eSID=OLD.SID;
+         END`,
+	} {
+		_, err := registry.DB.Exec(cmd)
+		XNoErr(t, err)
+	}
+
+	XNoErr(t, registry.UpgradeDB(registry.DB, registry.DB_Name))
+	// Nothing left to do the 2nd time
+	XNoErr(t, registry.UpgradeDB(registry.DB, registry.DB_Name))
+
+	count := func(query string, args ...any) int {
+		t.Helper()
+		res := 0
+		XNoErr(t, registry.DB.QueryRow(query, args...).Scan(&res))
+		return res
+	}
+
+	XEqual(t, "", count(`SELECT COUNT(*) FROM INFORMATION_SCHEMA.TRIGGERS
+        WHERE TRIGGER_SCHEMA=? AND ACTION_STATEMENT LIKE '%DocProps%' AND
+              TRIGGER_NAME='VersionsTrigger'`, registry.DB_Name), 1)
+	XEqual(t, "", count(`SELECT COUNT(*) FROM INFORMATION_SCHEMA.TRIGGERS
+        WHERE TRIGGER_SCHEMA=? AND ACTION_STATEMENT LIKE '%ChangeRequests%'
+              AND TRIGGER_NAME IN ('RegistryTrigger','GroupTrigger')`,
+		registry.DB_Name), 2)
+
+	reg := NewRegistry("TestMiscUpgradeDB")
+	defer PassDeleteReg(t, reg)
+
+	_, _, err := reg.Model.CreateModels("dirs", "dir", "files", "file")
+	XNoErr(t, err)
+
+	// Writing (and indexing) a document needs DocProps
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1", `{"a":"b"}`, 201, `*`)
+	XCheckGet(t, reg, "?inline&oneline&filter=dirs.files.file%23/a=b",
+		`{"dirs":{"d1":{"files":{"f1":{"meta":{},"versions":{"1":{}}}}}}}`)
+	XEqual(t, "", count(`SELECT COUNT(*) FROM DocProps`) > 0, true)
+
+	// And the new triggers clean it up
+	XHTTP(t, reg, "DELETE", "/dirs/d1", ``, 204, ``)
+	XEqual(t, "", count(`SELECT COUNT(*) FROM DocProps`), 0)
+}
+
+func TestMiscScratchRegistry(t *testing.T) {
+	reg := NewRegistry("TestMiscScratchRegistry")
+	defer PassDeleteReg(t, reg)
+
+	name := SCRATCH_REGISTRY_PREFIX + "test"
+	url := "http://localhost:8181/reg-" + name
+
+	// Not w/o --scratch-registries
+	XHTTP(t, reg, "PUT", url, "{}", 404, "*")
+
+	registry.ScratchRegistries = true
+	defer func() { registry.ScratchRegistries = false }()
+
+	// Only ones with the prefix
+	XHTTP(t, reg, "PUT", "http://localhost:8181/reg-notscratch", "{}", 404,
+		"*")
+	XHTTP(t, reg, "DELETE", "http://localhost:8181/reg-"+reg.UID, "", 405,
+		"*")
+
+	XHTTP(t, reg, "PUT", url, `{"name":"scratch"}`, 201, "*")
+	XHTTP(t, reg, "GET", url+"/modelsource", "", 200, "*")
+	XHTTP(t, reg, "PUT", url, `{"name":"again"}`, 200, "*")
+	XHTTP(t, reg, "DELETE", url, "", 204, "*")
+	XHTTP(t, reg, "GET", url, "", 404, "*")
+}
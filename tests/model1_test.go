@@ -1521,6 +1521,11 @@ func TestModelResourceCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -2000,6 +2005,11 @@ func TestModelResourceCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -2644,6 +2654,11 @@ func TestModelResourceCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -3408,6 +3423,11 @@ func TestModelResourceCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -4147,6 +4167,11 @@ func TestModelResourceCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -4764,6 +4789,11 @@ func TestModelResourceCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -8004,6 +8034,11 @@ func TestModelMultCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "rm1url": {
               "name": "rm1url",
               "type": "url"
@@ -8334,6 +8369,11 @@ func TestModelMultCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "rm2url": {
               "name": "rm2url",
               "type": "url"
@@ -8843,6 +8883,11 @@ func TestModelMultCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "rm1url": {
               "name": "rm1url",
               "type": "url"
@@ -9173,6 +9218,11 @@ func TestModelMultCreate(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "rm2url": {
               "name": "rm2url",
               "type": "url"
@@ -10001,6 +10051,11 @@ func TestModelMult2Create(t *testing.T) {
                 "type": "string",
                 "readonly": true
               },
+              "digest": {
+                "name": "digest",
+                "type": "string",
+                "readonly": true
+              },
               "fileurl": {
                 "name": "fileurl",
                 "type": "url"
@@ -10484,6 +10539,11 @@ func TestModelMult2Create(t *testing.T) {
                 "type": "string",
                 "readonly": true
               },
+              "digest": {
+                "name": "digest",
+                "type": "string",
+                "readonly": true
+              },
               "fileurl": {
                 "name": "fileurl",
                 "type": "url"
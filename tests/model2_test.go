@@ -453,6 +453,11 @@ func TestModelLabels(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "rmurl": {
               "name": "rmurl",
               "type": "url"
@@ -1087,6 +1092,11 @@ func TestModelLabels(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "rmurl": {
               "name": "rmurl",
               "type": "url"
@@ -1756,6 +1766,11 @@ func TestModelLabels(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -4214,6 +4229,11 @@ func TestModelCompatibleWith(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "dataurl": {
               "name": "dataurl",
               "type": "url"
@@ -4546,6 +4566,11 @@ func TestModelCompatibleWith(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -4877,6 +4902,11 @@ func TestModelCompatibleWith(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "foourl": {
               "name": "foourl",
               "type": "url"
@@ -5874,6 +5904,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -6536,6 +6571,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -7015,6 +7055,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "typeurl": {
               "name": "typeurl",
               "type": "url"
@@ -7764,6 +7809,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -8243,6 +8293,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "typeurl": {
               "name": "typeurl",
               "type": "url"
@@ -8898,6 +8953,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -9377,6 +9437,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "typeurl": {
               "name": "typeurl",
               "type": "url"
@@ -10032,6 +10097,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "fileurl": {
               "name": "fileurl",
               "type": "url"
@@ -10511,6 +10581,11 @@ func TestModelIncludes(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "typeurl": {
               "name": "typeurl",
               "type": "url"
@@ -11250,6 +11325,11 @@ func TestModelMissingFields(t *testing.T) {
               "type": "string",
               "readonly": true
             },
+            "digest": {
+              "name": "digest",
+              "type": "string",
+              "readonly": true
+            },
             "rext": {
               "name": "rext",
               "type": "integer"
@@ -4140,3 +4140,363 @@ func TestModelHasDocumentStaleExtensionBecomesReserved(t *testing.T) {
 		XHTTP(t, reg, "PUT", "/modelsource", newModelSrc, 200, newModelSrc)
 	}
 }
+
+// TestModelPlan verifies that PUT /modelsource?dryrun shows what a model
+// change would do to the existing data without actually changing anything
+func TestModelPlan(t *testing.T) {
+	reg := NewRegistry("TestModelPlan")
+	defer PassDeleteReg(t, reg)
+
+	model := `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "attributes": {
+        "size": {
+          "type": "string"
+        }
+      },
+      "resources": {
+        "files": {
+          "singular": "file"
+        }
+      }
+    },
+    "extra": {
+      "singular": "ex"
+    }
+  }
+}
+`
+	XHTTP(t, reg, "PUT", "/modelsource", model, 200, model)
+	XHTTP(t, reg, "PUT", "/dirs/d1", `{"size":"big"}`, 201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d2", `{}`, 201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d2/files/f1", "hello", 201, "*")
+	XHTTP(t, reg, "PUT", "/extra/e1", `{}`, 201, "*")
+
+	// Same model, nothing to do
+	XHTTP(t, reg, "PUT", "/modelsource?dryrun", model, 200, `{
+  "valid": true,
+  "deletedtypes": [],
+  "migrated": [],
+  "invalid": [],
+  "changes": []
+}
+`)
+
+	newModel := `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "attributes": {
+        "size": {
+          "type": "integer"
+        },
+        "owner": {
+          "type": "string",
+          "required": true,
+          "default": "me"
+        }
+      },
+      "resources": {
+        "files": {
+          "singular": "file"
+        }
+      }
+    }
+  }
+}
+`
+	XHTTP(t, reg, "PUT", "/modelsource?dryrun", newModel, 200, `{
+  "valid": false,
+  "deletedtypes": [
+    {
+      "type": "/extra",
+      "groups": 1
+    }
+  ],
+  "migrated": [],
+  "invalid": [
+    {
+      "xid": "/dirs/d1",
+      "error": "invalid_attribute",
+      "title": "The attribute \"size\" for \"/dirs/d1\" is not valid: must be an integer."
+    }
+  ],
+  "changes": [
+    {
+      "xid": "/dirs/d2",
+      "attribute": "owner",
+      "change": "defaulted",
+      "new": "me"
+    }
+  ]
+}
+`)
+
+	// Nothing changed
+	XHTTP(t, reg, "GET", "/modelsource", "", 200, model)
+	XHTTP(t, reg, "GET", "/extra/e1", "", 200, "*\"exid\": \"e1\"*")
+	XHTTP(t, reg, "GET", "/dirs/d2", "", 200, "*\"dirid\": \"d2\"*")
+
+	// Bad models are still errors
+	XHTTP(t, reg, "PUT", "/modelsource?dryrun", `{"groups":{"x":{}}}`, 400,
+		"*model_error*")
+
+	// Once the data is fixed the model can be applied
+	XHTTP(t, reg, "DELETE", "/extra/e1", "", 204, "")
+	XHTTP(t, reg, "PATCH", "/dirs/d1", `{"size":null}`, 200, "*")
+	XHTTP(t, reg, "PUT", "/modelsource?dryrun", newModel, 200, `{
+  "valid": true,
+  "deletedtypes": [
+    {
+      "type": "/extra"
+    }
+  ],
+  "migrated": [],
+  "invalid": [],
+  "changes": [
+    {
+      "xid": "/dirs/d1",
+      "attribute": "owner",
+      "change": "defaulted",
+      "new": "me"
+    },
+    {
+      "xid": "/dirs/d2",
+      "attribute": "owner",
+      "change": "defaulted",
+      "new": "me"
+    }
+  ]
+}
+`)
+	XHTTP(t, reg, "PUT", "/modelsource", newModel, 200, newModel)
+}
+
+func TestModelMigrate(t *testing.T) {
+	reg := NewRegistry("TestModelMigrate")
+	defer PassDeleteReg(t, reg)
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "attributes": {
+        "owner": { "type": "string" },
+        "tier": { "type": "integer" },
+        "legacy": { "type": "boolean" }
+      },
+      "resources": {
+        "files": {
+          "singular": "file",
+          "hasdocument": false,
+          "attributes": {
+            "size": { "type": "integer" }
+          }
+        }
+      }
+    }
+  }
+}`, 200, "*")
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1", `{"size":10}`, 201, "*")
+	XHTTP(t, reg, "PATCH", "/dirs/d1", `{"owner":"joe","tier":1,"legacy":true}`,
+		200, `*"epoch": 2,*`)
+	XHTTP(t, reg, "PUT", "/dirs/d2", `{"tier":2}`, 201, "*")
+
+	newModel := `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "attributes": {
+        "maintainer": {
+          "type": "string"
+        },
+        "tier": {
+          "type": "string",
+          "enum": [
+            "gold",
+            "silver"
+          ]
+        },
+        "region": {
+          "type": "string",
+          "required": true
+        }
+      },
+      "resources": {
+        "files": {
+          "singular": "file",
+          "hasdocument": false,
+          "attributes": {
+            "stats": {
+              "type": "object",
+              "attributes": {
+                "size": {
+                  "type": "integer"
+                }
+              }
+            }
+          }
+        }
+      }
+    }
+  }
+}
+`
+	migrate := func(ops string) string {
+		return strings.TrimSuffix(newModel, "\n}\n") + `,
+  "$migrate": ` + ops + "\n}\n"
+	}
+
+	// Without a migration the existing data doesn't match the new model
+	XHTTP(t, reg, "PUT", "/modelsource", newModel, 400, "*")
+
+	ops := `[
+    { "op": "rename", "target": "/dirs", "attribute": "owner",
+      "to": "maintainer" },
+    { "op": "map", "target": "/dirs", "attribute": "tier",
+      "values": { "1": "gold", "2": "silver" } },
+    { "op": "default", "target": "/dirs", "attribute": "region",
+      "value": "us" },
+    { "op": "delete", "target": "/dirs", "attribute": "legacy" },
+    { "op": "move", "target": "/dirs/files/versions", "attribute": "size",
+      "to": "stats" }
+  ]`
+
+	XHTTP(t, reg, "PUT", "/modelsource?dryrun", migrate(ops), 200, `{
+  "valid": true,
+  "deletedtypes": [],
+  "migrated": [
+    {
+      "xid": "/dirs/d1",
+      "epoch": 3,
+      "attributes": [
+        "owner",
+        "maintainer",
+        "tier",
+        "region",
+        "legacy"
+      ]
+    },
+    {
+      "xid": "/dirs/d1/files/f1/versions/1",
+      "epoch": 2,
+      "attributes": [
+        "size",
+        "stats.size"
+      ]
+    },
+    {
+      "xid": "/dirs/d2",
+      "epoch": 2,
+      "attributes": [
+        "tier",
+        "region"
+      ]
+    }
+  ],
+  "invalid": [],
+  "changes": []
+}
+`)
+
+	// Nothing changed
+	XHTTP(t, reg, "GET", "/dirs/d1", "", 200, `*"owner": "joe",*`)
+
+	// "$migrate" isn't saved as part of the model
+	XHTTP(t, reg, "PUT", "/modelsource", migrate(ops), 200, newModel)
+	XHTTP(t, reg, "GET", "/modelsource", "", 200, newModel)
+
+	XHTTP(t, reg, "GET", "/dirs/d1", "", 200, `*"epoch": 3,*`)
+	XHTTP(t, reg, "GET", "/dirs/d1", "", 200, `*"maintainer": "joe",*`)
+	XHTTP(t, reg, "GET", "/dirs/d1", "", 200, `*"region": "us",*`)
+	XHTTP(t, reg, "GET", "/dirs/d1", "", 200, `*"tier": "gold",*`)
+	XHTTP(t, reg, "GET", "/dirs/d2", "", 200, `*"epoch": 2,*`)
+	XHTTP(t, reg, "GET", "/dirs/d2", "", 200, `*"tier": "silver",*`)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/1", "", 200,
+		"^"+`"epoch": 2,`)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/1", "", 200,
+		"^"+`"stats": \{\s*"size": 10\s*\}`)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1", "", 200,
+		"^"+`"stats": \{\s*"size": 10\s*\}`)
+
+	// Running it again doesn't change anything
+	XHTTP(t, reg, "PUT", "/modelsource?dryrun", migrate(ops), 200, `{
+  "valid": true,
+  "deletedtypes": [],
+  "migrated": [],
+  "invalid": [],
+  "changes": []
+}
+`)
+
+	// Bad migrations
+	XHTTP(t, reg, "PUT", "/modelsource",
+		migrate(`[{"op":"copy","target":"/dirs","attribute":"tier"}]`), 400,
+		`*must be one of: rename, move, default, map, delete*`)
+	XHTTP(t, reg, "PUT", "/modelsource",
+		migrate(`[{"op":"delete","target":"/foo","attribute":"tier"}]`), 400,
+		`*references an unknown Group type*`)
+	XHTTP(t, reg, "PUT", "/modelsource",
+		migrate(`[{"op":"delete","target":"/dirs","attribute":"epoch"}]`), 400,
+		`*can't be migrated*`)
+	XHTTP(t, reg, "PUT", "/modelsource",
+		migrate(`[{"op":"rename","target":"/dirs","attribute":"tier"}]`), 400,
+		`*must be specified for*`)
+	XHTTP(t, reg, "PUT", "/modelsource",
+		migrate(`[{"op":"rename","target":"/dirs","attribute":"tier",
+		  "to":"region"}]`), 400,
+		`*since it already has a value*`)
+
+	// None of them changed anything
+	XHTTP(t, reg, "GET", "/modelsource", "", 200, newModel)
+	XHTTP(t, reg, "GET", "/dirs/d1", "", 200, `*"tier": "gold",*`)
+}
+
+func TestModelComments(t *testing.T) {
+	reg := NewRegistry("TestModelComments")
+	defer PassDeleteReg(t, reg)
+
+	// "#" comments are removed before the model is parsed, but not when
+	// the "#" is inside of a string - even one with escaped quotes in it
+	src := `# The model
+{
+  "groups": {  # Just one
+    "dirs": {
+      "singular": "dir",
+      "description": "a \"#b",   # Escaped quote then a "#"
+      "resources": {
+        "files": {
+          "singular": "file",
+          "description": "c\\#d \\\" # e"
+        }
+      }
+    }
+  }
+}`
+
+	XHTTP(t, reg, "PUT", "/modelsource", src, 200, src+"\n")
+	XHTTP(t, reg, "GET", "/modelsource", "", 200, src+"\n")
+
+	res := XHTTP(t, reg, "GET", "/model", "", 200, "*")
+	groups, _ := res.ToMap()["groups"].(map[string]any)
+	dirs, _ := groups["dirs"].(map[string]any)
+	XEqual(t, "", dirs["description"], `a "#b`)
+	resources, _ := dirs["resources"].(map[string]any)
+	files, _ := resources["files"].(map[string]any)
+	XEqual(t, "", files["description"], `c\#d \" # e`)
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1$details", "{}", 201, "*")
+
+	// A "#" in an unterminated string can't hide the rest of the line
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "description": "a \"#b
+    }
+  }
+}`, 400, `*error parsing JSON*`)
+}
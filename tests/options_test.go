@@ -311,10 +311,13 @@ func TestOptionsWithCapabilities(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -379,10 +382,13 @@ func TestOptionsWithCapabilities(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
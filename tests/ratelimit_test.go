@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"testing"
+
+	. "github.com/xregistry/server/common"
+	"github.com/xregistry/server/registry"
+)
+
+func TestRateLimitBasic(t *testing.T) {
+	reg := NewRegistry("TestRateLimitBasic")
+	defer PassDeleteReg(t, reg)
+
+	// One "expensive" request per client, then wait ~1000 seconds
+	XNoErr(t, reg.SetRateLimits(&registry.RateLimitConfig{
+		KeyHeader: "X-Api-Key",
+		Keys:      []string{"other"},
+		Limits: map[string]*registry.RateLimit{
+			registry.RATE_EXPENSIVE: {Rate: 0.001, Burst: 1},
+		},
+	}))
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/?inline=*",
+		Method:     "GET",
+		Code:       200,
+		ResHeaders: []string{"*", "-Retry-After"},
+		ResBody:    "*",
+	})
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/?inline=*",
+		Method:     "GET",
+		Code:       429,
+		ResHeaders: []string{"*", "Retry-After: 1000"},
+		ResBody: `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#too_many_requests",
+  "title": "Too many \"expensive\" requests, try again in 1000 second(s).",
+  "subject": "/",
+  "args": {
+    "class": "expensive",
+    "retry": "1000"
+  },
+  "source": "xxx"
+}
+`,
+	})
+
+	// Reads have their own budget, which is unlimited
+	XHTTP(t, reg, "GET", "/", "", 200, "*")
+
+	// A different API key is a different client
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/?inline=*",
+		Method:     "GET",
+		ReqHeaders: []string{"X-Api-Key: other"},
+		Code:       200,
+		ResBody:    "*",
+	})
+
+	// But only if it's one we know about, otherwise it's still by IP
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/?inline=*",
+		Method:     "GET",
+		ReqHeaders: []string{"X-Api-Key: made-up"},
+		Code:       429,
+		ResHeaders: []string{"*", "Retry-After: 1000"},
+		ResBody:    "*",
+	})
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:        "/ratelimits",
+		Method:     "GET",
+		Code:       200,
+		ResHeaders: []string{"Content-Type: application/json"},
+		ResBody: `{
+  "keyheader": "X-Api-Key",
+  "limits": {
+    "expensive": {
+      "rate": 0.001,
+      "burst": 1
+    }
+  },
+  "keys": 1,
+  "counters": {
+    "expensive": {
+      "allowed": 2,
+      "limited": 2
+    },
+    "read": {
+      "allowed": 2,
+      "limited": 0
+    },
+    "write": {
+      "allowed": 0,
+      "limited": 0
+    }
+  },
+  "clients": 2
+}
+`,
+	})
+
+	XHTTP(t, reg, "PUT", "/ratelimits", "{}", 405, "*")
+
+	// Back to the server's defaults (none)
+	XNoErr(t, reg.SetRateLimits(nil))
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:     "/?inline=*",
+		Method:  "GET",
+		Code:    200,
+		ResBody: "*",
+	})
+}
@@ -571,6 +571,9 @@ func TestRegistryRoot(t *testing.T) {
       },
       "modelsource": {
         "mutable": true
+      },
+      "ratelimits": {
+        "mutable": false
       }
     },
     "compatibilities": {
@@ -623,14 +626,29 @@ func TestRegistryRoot(t *testing.T) {
         "full_transitive"
       ]
     },
+    "encodings": [
+      "gzip",
+      "zstd"
+    ],
+    "filteroperators": [
+      "casesensitive",
+      "contains",
+      "haskey",
+      "in",
+      "prefix",
+      "regex"
+    ],
     "flags": [
       "binary",
       "collections",
       "doc",
       "epoch",
+      "facet",
+      "fields",
       "filter",
       "ignore",
       "inline",
+      "publish",
       "setdefaultversionid",
       "sort",
       "specversion"
@@ -653,6 +671,7 @@ func TestRegistryRoot(t *testing.T) {
       "readonly"
     ],
     "pagination": false,
+    "ranges": true,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -687,12 +706,15 @@ func TestRegistryRoot(t *testing.T) {
     }
   },
   "compatibilities": {},
+  "encodings": [],
+  "filteroperators": [],
   "flags": [
     "inline"
   ],
   "formats": [],
   "ignores": [],
   "pagination": false,
+  "ranges": false,
   "shortself": false,
   "specversions": [
     "`+SPECVERSION+`"
@@ -727,12 +749,15 @@ func TestRegistryRoot(t *testing.T) {
       }
     },
     "compatibilities": {},
+    "encodings": [],
+    "filteroperators": [],
     "flags": [
       "inline"
     ],
     "formats": [],
     "ignores": [],
     "pagination": false,
+    "ranges": false,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -775,12 +800,15 @@ func TestRegistryRoot(t *testing.T) {
       }
     },
     "compatibilities": {},
+    "encodings": [],
+    "filteroperators": [],
     "flags": [
       "inline"
     ],
     "formats": [],
     "ignores": [],
     "pagination": false,
+    "ranges": false,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
@@ -819,12 +847,15 @@ func TestRegistryRoot(t *testing.T) {
       }
     },
     "compatibilities": {},
+    "encodings": [],
+    "filteroperators": [],
     "flags": [
       "inline"
     ],
     "formats": [],
     "ignores": [],
     "pagination": false,
+    "ranges": false,
     "shortself": false,
     "specversions": [
       "`+SPECVERSION+`"
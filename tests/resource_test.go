@@ -2,6 +2,7 @@ package tests
 
 import (
 	"testing"
+	"time"
 
 	. "github.com/xregistry/server/common"
 	"github.com/xregistry/server/registry"
@@ -1221,6 +1222,250 @@ func TestResourceDeprecated(t *testing.T) {
 `)
 }
 
+func TestResourceDeprecationLifecycle(t *testing.T) {
+	reg := NewRegistry("TestResourceDeprecationLifecycle")
+	defer PassDeleteReg(t, reg)
+
+	XHTTP(t, reg, "PUT", "/", `{
+  "modelsource": {
+    "groups": {
+      "dirs": {
+        "singular": "dir",
+        "resources": {
+          "files": {
+            "singular": "file",
+            "hasdocument": false
+          }
+        }
+      }
+    }
+  },
+  "dirs": {
+    "d1": {
+      "deprecated": {
+        "effective": "2020-01-01T00:00:00Z",
+        "removal": "2021-01-01T00:00:00Z",
+        "alternative": "http://example.com/d2"
+      },
+      "files": { "f1": {} }
+    },
+    "d2": {
+      "files": { "f1": {}, "f2": {}, "f3": {}, "f4": {} }
+    }
+  }
+}`, 200, `*`)
+
+	XHTTP(t, reg, "PUT", "/dirs/d2/files/f1/meta",
+		`{"deprecated": {"removal": "2020-01-01T00:00:00Z"}}`, 200, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d2/files/f2/meta",
+		`{"deprecated": {"effective": "2999-01-01T00:00:00Z"}}`, 200, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d2/files/f3/meta",
+		`{"deprecated": {"documentation": "http://example.com/why"}}`, 200, `*`)
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		Name:   "deprecated group",
+		URL:    "/dirs/d1",
+		Method: "GET",
+		Code:   200,
+		ResHeaders: []string{
+			"Deprecation: @1577836800",
+			"Sunset: Fri, 01 Jan 2021 00:00:00 GMT",
+			"Link: <http://example.com/d2>; rel=\"alternate\"",
+		},
+		ResBody: `*`,
+	})
+
+	// No inheritance from the Group
+	XCheckHTTP(t, reg, &HTTPTest{
+		Name:       "resource in deprecated group",
+		URL:        "/dirs/d1/files/f1",
+		Method:     "GET",
+		Code:       200,
+		ResHeaders: []string{"-Deprecation:", "-Sunset:"},
+		ResBody:    `*`,
+	})
+
+	// Versions use their Resource's meta
+	XCheckHTTP(t, reg, &HTTPTest{
+		Name:   "version of deprecated resource",
+		URL:    "/dirs/d2/files/f3/versions/1",
+		Method: "GET",
+		Code:   200,
+		ResHeaders: []string{
+			"Deprecation: true",
+			"-Sunset:",
+			"Link: <http://example.com/why>; rel=\"deprecation\"",
+		},
+		ResBody: `*`,
+	})
+
+	XCheckHTTP(t, reg, &HTTPTest{
+		Name:       "deprecated in the future",
+		URL:        "/dirs/d2/files/f2/meta",
+		Method:     "GET",
+		Code:       200,
+		ResHeaders: []string{"Deprecation: @32472144000", "-Sunset:"},
+		ResBody:    `*`,
+	})
+
+	// Collections don't get the headers
+	XCheckHTTP(t, reg, &HTTPTest{
+		Name:       "collection",
+		URL:        "/dirs",
+		Method:     "GET",
+		Code:       200,
+		ResHeaders: []string{"-Deprecation:"},
+		ResBody:    `*`,
+	})
+
+	XCheckGet(t, reg, "dirs?oneline&filter=deprecationstate=removed",
+		`{"d1":{}}`)
+	XCheckGet(t, reg, "dirs/d2/files?oneline&filter=deprecationstate=active",
+		`{"f2":{},"f4":{}}`)
+	XCheckGet(t, reg, "dirs/d2/files?oneline&filter=deprecationstate=deprecated",
+		`{"f3":{}}`)
+	XCheckGet(t, reg, "dirs/d2/files?oneline&filter=deprecationstate!=active",
+		`{"f1":{},"f3":{}}`)
+	XCheckGet(t, reg,
+		"dirs?oneline&inline=files&filter=files.versions.deprecationstate=removed",
+		`{"d2":{"files":{"f1":{}}}}`)
+
+	// "readonly" only applies to Resources, so d1 is left alone
+	xids, xErr := registry.EnforceDeprecations(reg.UID, "readonly", time.Now())
+	XNoErr(t, xErr)
+	XEqual(t, "", xids, []string{"/dirs/d2/files/f1"})
+
+	XHTTP(t, reg, "PATCH", "/dirs/d2/files/f1", `{}`, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#readonly",
+  "title": "Updating a read-only entity (/dirs/d2/files/f1) is not allowed.",
+  "subject": "/dirs/d2/files/f1",
+  "source": "xxx"
+}
+`)
+
+	// Already readonly, so nothing to do
+	xids, xErr = registry.EnforceDeprecations(reg.UID, "readonly", time.Now())
+	XNoErr(t, xErr)
+	XEqual(t, "", xids, []string{})
+
+	// readonly Resources aren't deleted
+	xids, xErr = registry.EnforceDeprecations(reg.UID, "delete", time.Now())
+	XNoErr(t, xErr)
+	XEqual(t, "", xids, []string{"/dirs/d1"})
+
+	XCheckGet(t, reg, "dirs?oneline", `{"d2":{}}`)
+	XCheckGet(t, reg, "dirs/d2/files?oneline",
+		`{"f1":{},"f2":{},"f3":{},"f4":{}}`)
+
+	// Times with a UTC offset, not just "Z"
+	XHTTP(t, reg, "PUT", "/dirs/d3/files/f1/meta",
+		`{"deprecated": {"removal": "2020-01-01T00:00:00-05:00"}}`, 201, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d3/files/f2/meta",
+		`{"deprecated": {"effective": "2999-01-01T00:00:00+05:00"}}`, 201, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d3/files/f3/meta",
+		`{"deprecated": {"effective": "2020-01-01T00:00:00+05:00"}}`, 201, `*`)
+
+	XCheckGet(t, reg, "dirs/d3/files?oneline&filter=deprecationstate=removed",
+		`{"f1":{}}`)
+	XCheckGet(t, reg, "dirs/d3/files?oneline&filter=deprecationstate=active",
+		`{"f2":{}}`)
+	XCheckGet(t, reg,
+		"dirs/d3/files?oneline&filter=deprecationstate=deprecated",
+		`{"f3":{}}`)
+
+	xids, xErr = registry.EnforceDeprecations(reg.UID, "readonly", time.Now())
+	XNoErr(t, xErr)
+	XEqual(t, "", xids, []string{"/dirs/d3/files/f1"})
+}
+
+func TestResourceRetention(t *testing.T) {
+	reg := NewRegistry("TestResourceRetention")
+	defer PassDeleteReg(t, reg)
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": { "dirs": { "singular": "dir", "resources": { "files": {
+    "singular": "file",
+    "hasdocument": false,
+    "retention": { "keepnewerthan": "bogus" }
+  } } } }
+}`, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#model_error",
+  "title": "There was an error in the model definition provided: Resource \"files\" \"retention.keepnewerthan\" is invalid: time: invalid duration \"bogus\".",
+  "subject": "/model",
+  "args": {
+    "error_detail": "Resource \"files\" \"retention.keepnewerthan\" is invalid: time: invalid duration \"bogus\""
+  },
+  "source": "xxx"
+}
+`)
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": { "dirs": { "singular": "dir", "resources": { "files": {
+    "singular": "file",
+    "hasdocument": false,
+    "retention": { "keeplatest": 2, "protectlabels": [ "keep" ] }
+  } } } }
+}`, 200, `*`)
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v1",
+		`{"labels":{"keep":"yes"}}`, 201, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v2", `{}`, 201, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v3", `{}`, 201, `*`)
+	XCheckGet(t, reg, "dirs/d1/files/f1/versions?oneline",
+		`{"v1":{},"v2":{},"v3":{}}`)
+
+	// v2 is now the oldest unprotected Version outside of the latest 2
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v4", `{}`, 201, `*`)
+	XCheckGet(t, reg, "dirs/d1/files/f1/versions?oneline",
+		`{"v1":{},"v3":{},"v4":{}}`)
+
+	// Versions of an xref target are never removed
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f2/versions/a", `{}`, 201, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/fx/meta",
+		`{"xref":"/dirs/d1/files/f2"}`, 201, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f2/versions/b", `{}`, 201, `*`)
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f2/versions/c", `{}`, 201, `*`)
+	XCheckGet(t, reg, "dirs/d1/files/f2/versions?oneline",
+		`{"a":{},"b":{},"c":{}}`)
+
+	// Model changes re-apply the policy, but everything is still new
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": { "dirs": { "singular": "dir", "resources": { "files": {
+    "singular": "file",
+    "hasdocument": false,
+    "retention": { "keepnewerthan": "1d", "protectlabels": [ "keep" ],
+                   "archive": true }
+  } } } }
+}`, 200, `*`)
+	XCheckGet(t, reg, "dirs/d1/files/f1/versions?oneline",
+		`{"v1":{},"v3":{},"v4":{}}`)
+
+	// Pretend it's 2 days from now so only the sweeper sees the change
+	later := time.Now().Add(48 * time.Hour)
+	expected := []registry.RetentionAction{
+		{XID: "/dirs/d1/files/f1/versions/v3", Action: "archive"},
+	}
+
+	actions, xErr := registry.SweepRetention(reg.UID, later, true)
+	XNoErr(t, xErr)
+	XEqual(t, "", actions, expected)
+	XCheckGet(t, reg, "dirs/d1/files/f1/versions?oneline",
+		`{"v1":{},"v3":{},"v4":{}}`)
+
+	actions, xErr = registry.SweepRetention(reg.UID, later, false)
+	XNoErr(t, xErr)
+	XEqual(t, "", actions, expected)
+	XCheckGet(t, reg, "dirs/d1/files/f1/versions?oneline",
+		`{"v1":{},"v4":{}}`)
+	XCheckGet(t, reg, "dirs/d1/files/f2/versions?oneline",
+		`{"a":{},"b":{},"c":{}}`)
+
+	// Nothing left to do
+	actions, xErr = registry.SweepRetention(reg.UID, later, false)
+	XNoErr(t, xErr)
+	XEqual(t, "", actions, []registry.RetentionAction{})
+}
+
 func TestResourceSamples(t *testing.T) {
 	reg := NewRegistry("TestResourceSamples")
 	defer PassDeleteReg(t, reg)
@@ -86,6 +86,13 @@ func TestMain(m *testing.M) {
 	}
 	go fsServer.ListenAndServe()
 
+	// The proxyurl tests fetch from the fileserver above, which is on
+	// localhost. And don't cache so each test sees what's really there.
+	pf := registry.NewProxyFetcher()
+	pf.AllowPrivate = true
+	pf.CacheTTL = 0
+	registry.SetProxyFetcher(pf)
+
 	// Run the tests
 	rc := m.Run()
 
@@ -1523,3 +1523,156 @@ func TestVerisonCascadeDeferDeleteStickyDefaultExplicitNext(t *testing.T) {
 }
 `)
 }
+
+func TestVersionStates(t *testing.T) {
+	reg := NewRegistry("TestVersionStates")
+	defer PassDeleteReg(t, reg)
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": { "dirs": { "singular": "dir", "resources": {
+    "files": {
+      "singular": "file",
+      "hasdocument": false,
+      "versionstates": true
+    },
+    "others": {
+      "singular": "other",
+      "hasdocument": false
+    }
+  } } }
+}`, 200, `*`)
+
+	// Versions are "published" unless told otherwise
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v1", `{}`, 201, `{
+  "fileid": "f1",
+  "versionid": "v1",
+  "self": "http://localhost:8181/dirs/d1/files/f1/versions/v1",
+  "xid": "/dirs/d1/files/f1/versions/v1",
+  "epoch": 1,
+  "isdefault": true,
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:01Z",
+  "ancestorid": "v1",
+  "state": "published"
+}
+`)
+
+	// A newer draft doesn't become the default
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v2",
+		`{"state":"draft"}`, 201, `{
+  "fileid": "f1",
+  "versionid": "v2",
+  "self": "http://localhost:8181/dirs/d1/files/f1/versions/v2",
+  "xid": "/dirs/d1/files/f1/versions/v2",
+  "epoch": 1,
+  "isdefault": false,
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:01Z",
+  "ancestorid": "v1",
+  "state": "draft"
+}
+`)
+
+	// Drafts are hidden unless asked for directly
+	XCheckGet(t, reg, "dirs/d1/files/f1/versions?oneline", `{"v1":{}}`)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v1", ``, 200, `{
+  "fileid": "f1",
+  "versionid": "v1",
+  "self": "http://localhost:8181/dirs/d1/files/f1/versions/v1",
+  "xid": "/dirs/d1/files/f1/versions/v1",
+  "epoch": 1,
+  "isdefault": true,
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:01Z",
+  "ancestorid": "v1",
+  "state": "published"
+}
+`)
+
+	// Updating a draft leaves it as a draft
+	XHTTP(t, reg, "PATCH", "/dirs/d1/files/f1/versions/v2",
+		`{"description":"wip"}`, 200, `{
+  "fileid": "f1",
+  "versionid": "v2",
+  "self": "http://localhost:8181/dirs/d1/files/f1/versions/v2",
+  "xid": "/dirs/d1/files/f1/versions/v2",
+  "epoch": 2,
+  "isdefault": false,
+  "description": "wip",
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:02Z",
+  "ancestorid": "v1",
+  "state": "draft"
+}
+`)
+
+	XHTTP(t, reg, "POST", "/dirs/d1/files/f1/versions/v2?publish", ``, 200, `{
+  "fileid": "f1",
+  "versionid": "v2",
+  "self": "http://localhost:8181/dirs/d1/files/f1/versions/v2",
+  "xid": "/dirs/d1/files/f1/versions/v2",
+  "epoch": 3,
+  "isdefault": true,
+  "description": "wip",
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:02Z",
+  "ancestorid": "v1",
+  "state": "published"
+}
+`)
+	XCheckGet(t, reg, "dirs/d1/files/f1/versions?oneline",
+		`{"v1":{},"v2":{}}`)
+
+	// Publishing twice is fine
+	XHTTP(t, reg, "POST", "/dirs/d1/files/f1/versions/v2?publish", ``, 200,
+		`*`)
+
+	XHTTP(t, reg, "PATCH", "/dirs/d1/files/f1/versions/v2",
+		`{"state":"draft"}`, 400, `{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#version_state",
+  "title": "The \"state\" of Version \"/dirs/d1/files/f1/versions/v2\" can't be changed from \"published\" to \"draft\".",
+  "subject": "/dirs/d1/files/f1/versions/v2",
+  "args": {
+    "from": "published",
+    "to": "draft"
+  },
+  "source": "xxx"
+}
+`)
+
+	// Withdrawn Versions aren't the default either
+	XHTTP(t, reg, "PATCH", "/dirs/d1/files/f1/versions/v2",
+		`{"state":"withdrawn"}`, 200, `*`)
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f1/versions/v1", ``, 200, `{
+  "fileid": "f1",
+  "versionid": "v1",
+  "self": "http://localhost:8181/dirs/d1/files/f1/versions/v1",
+  "xid": "/dirs/d1/files/f1/versions/v1",
+  "epoch": 1,
+  "isdefault": true,
+  "createdat": "2024-01-01T12:00:01Z",
+  "modifiedat": "2024-01-01T12:00:01Z",
+  "ancestorid": "v1",
+  "state": "published"
+}
+`)
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1/versions/v3",
+		`{"state":"bogus"}`, 400, `*`)
+
+	XHTTP(t, reg, "POST", "/dirs/d1/files/f1/versions/v9?publish", ``, 404,
+		`*`)
+
+	XHTTP(t, reg, "PUT", "/dirs/d1/others/o1/versions/v1", `{}`, 201, `*`)
+	XHTTP(t, reg, "POST", "/dirs/d1/others/o1/versions/v1?publish", ``, 400,
+		`{
+  "type": "https://github.com/xregistry/spec/blob/main/core/spec.md#bad_request",
+  "title": "Resource model \"others\" doesn't have \"versionstates\" enabled.",
+  "subject": "/dirs/d1/others/o1/versions/v1",
+  "args": {
+    "error_detail": "Resource model \"others\" doesn't have \"versionstates\" enabled"
+  },
+  "source": "xxx"
+}
+`)
+}
@@ -5,10 +5,12 @@ package tests
 import (
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	. "github.com/xregistry/server/common"
+	"github.com/xregistry/server/registry"
 )
 
 var RepoBase = "https://raw.githubusercontent.com/xregistry/spec/main"
@@ -484,6 +486,218 @@ ATTRIBUTES:   TYPE         REQ   RO   MUT   DEFAULT
 
 }
 
+func TestXRModelLint(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "xrtest-lint")
+	XNoErr(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	XNoErr(t, os.WriteFile(tmpdir+"/m.json", []byte(`{
+  # lint-ignore-file resource-defaults
+  "labels": { "team-name": "x" },
+  "attributes": {
+    "color": {
+      "type": "string",
+      "enum": [ "red", "blue" ],
+      "strict": false
+    },
+    "size": {   # lint-ignore attribute-description
+      "type": "integer",
+      "ifvalues": {
+        "big": { "siblingattributes": {} }
+      }
+    }
+  },
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "labels": { "team_name": "y" },
+      "resources": {
+        # lint-ignore plural-singular
+        "mice": {
+          "singular": "mouse"
+        },
+        "geese": {
+          "singular": "goose"
+        }
+      }
+    }
+  }
+}`), 0644))
+
+	XCLI(t, "model lint "+tmpdir+"/m.json", "", strings.ReplaceAll(
+		`FILE:5: note: Attribute "color" has no description (attribute-description)
+FILE:5: warning: Attribute "color" has "strict": false, so its enum values are only suggestions (strict-false)
+FILE:13: warning: Attribute "size" can never have the "ifvalues" value "big", it isn't an integer (ifvalues-unreachable)
+FILE:20: warning: Label key "team_name" is also spelled "team-name" (label-keys)
+FILE:26: warning: Resource type "geese" doesn't look like the plural of "goose" (plural-singular)
+
+5 problems (errors: 0, warnings: 4, notes: 1)
+`, "FILE", tmpdir+"/m.json"), "", true)
+
+	XCLI(t, "model lint "+tmpdir+"/m.json --fail-on warning", "", "*", "",
+		false)
+	XCLI(t, "model lint "+tmpdir+"/m.json --rule strict-false=error", "",
+		"*", "", false)
+
+	XCLI(t, "model lint "+tmpdir+"/m.json -o json --rule label-keys=off "+
+		"--rule strict-false=off --rule attribute-description=off "+
+		"--rule plural-singular=off", "", `[
+  {
+    "file": "`+tmpdir+`/m.json",
+    "line": 13,
+    "path": "attributes.size.ifvalues.big",
+    "rule": "ifvalues-unreachable",
+    "level": "warning",
+    "message": "Attribute \"size\" can never have the \"ifvalues\" value \"big\", it isn't an integer"
+  }
+]
+`, "", true)
+
+	XCLI(t, "model lint "+tmpdir+"/m.json -o sarif --rule label-keys=off "+
+		"--rule strict-false=off --rule attribute-description=off "+
+		"--rule plural-singular=off --rule ifvalues-unreachable=off", "",
+		`*"results": \[\]*`, "", true)
+
+	XCLI(t, "model lint", "{}", "No problems found\n", "", true)
+	XCLI(t, "model lint --rule bogus=off", "{}", "",
+		"Unknown lint rule: bogus.\n", false)
+	XCLI(t, "model lint --rule strict-false=loud", "{}", "",
+		"Lint rule \"strict-false\" has an invalid level \"loud\", must be "+
+			"one of: off, note, warning, error.\n", false)
+
+	XNoErr(t, os.WriteFile(tmpdir+"/rules.yaml", []byte(
+		"attribute-description: off\nresource-defaults: error\n"), 0644))
+	XCLI(t, "model lint --rules "+tmpdir+"/rules.yaml --list-rules", "",
+		`RULE                    LEVEL     DESCRIPTION
+plural-singular         warning   Plural names should be the plural of their singular names
+attribute-description   off       Extension attributes should have a description
+strict-false            warning   Attributes shouldn't use "strict": false
+resource-defaults       error     Resources with documents should have default "compatibility" and "format" values
+label-keys              warning   Label keys should be spelled the same way everywhere
+ifvalues-unreachable    warning   "ifvalues" should only use values the attribute can have
+`, "", true)
+}
+
+func TestXRModelPlan(t *testing.T) {
+	reg := NewRegistry("TestXRModelPlan")
+	defer PassDeleteReg(t, reg)
+
+	os.Setenv("XR_SERVER", "localhost:8181")
+
+	model := `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "attributes": {
+        "owner": { "type": "integer" }
+      },
+      "resources": {
+        "files": { "singular": "file" }
+      }
+    },
+    "extra": { "singular": "ex" }
+  }
+}`
+	XCLI(t, "model update", model, "", "", true)
+	XHTTP(t, reg, "PUT", "/dirs/d1", "{}", 201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1", "hello", 201, "*")
+
+	XCLI(t, "model plan", model,
+		"No changes to existing data\nThe model can be applied\n", "", true)
+
+	newModel := `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "attributes": {
+        "owner": { "type": "string", "required": true, "default": "me" }
+      },
+      "resources": {
+        "files": { "singular": "file" }
+      }
+    }
+  }
+}`
+
+	XCLI(t, "model plan", newModel, `Deleted types:
+TYPE     GROUPS   RESOURCES   VERSIONS
+/extra   0        0           0
+
+Attribute changes:
+XID        ATTRIBUTE   CHANGE      OLD   NEW
+/dirs/d1   owner       defaulted   -     "me"
+
+The model can be applied
+`, "", true)
+
+	XCLI(t, "model plan -o json", newModel, `{
+  "valid": true,
+  "deletedtypes": [
+    {
+      "type": "/extra"
+    }
+  ],
+  "migrated": [],
+  "invalid": [],
+  "changes": [
+    {
+      "xid": "/dirs/d1",
+      "attribute": "owner",
+      "change": "defaulted",
+      "new": "me"
+    }
+  ]
+}
+`, "", true)
+
+	// Now with data that doesn't match the new model
+	XHTTP(t, reg, "PUT", "/extra/e1", "{}", 201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d2", `{"owner":5}`, 201, "*")
+
+	XCLI(t, "model plan", newModel, `Deleted types:
+TYPE     GROUPS   RESOURCES   VERSIONS
+/extra   1        0           0
+
+Invalid entities:
+XID        ERROR               DETAILS
+/dirs/d2   invalid_attribute   The attribute "owner" for "/dirs/d2" is not valid: must be a string.
+
+Attribute changes:
+XID        ATTRIBUTE   CHANGE      OLD   NEW
+/dirs/d1   owner       defaulted   -     "me"
+
+The model can't be applied as is
+`, "", true)
+
+	// Nothing was changed
+	XHTTP(t, reg, "GET", "/extra/e1", "", 200, "*")
+	XHTTP(t, reg, "GET", "/dirs/d2", "", 200, `*"owner": 5*`)
+
+	// A migration can fix the data
+	XCLI(t, "model plan", strings.TrimSuffix(newModel, "}")+`,
+  "$migrate": [
+    { "op": "map", "target": "/dirs", "attribute": "owner",
+      "values": { "5": "five" } }
+  ]
+}`, `Deleted types:
+TYPE     GROUPS   RESOURCES   VERSIONS
+/extra   1        0           0
+
+Migrated entities:
+XID        EPOCH   ATTRIBUTES
+/dirs/d2   2       owner
+
+Attribute changes:
+XID        ATTRIBUTE   CHANGE      OLD   NEW
+/dirs/d1   owner       defaulted   -     "me"
+
+The model can't be applied as is
+`, "", true)
+
+	XCLI(t, "model plan -o xx", newModel, "",
+		"--output must be one of: table, json\n", false)
+}
+
 func TestXRUpdateRegistry(t *testing.T) {
 	reg := NewRegistry("TestXRUpdateRegistry")
 	defer PassDeleteReg(t, reg)
@@ -1501,6 +1715,11 @@ files2 / file2   true      0
         "type": "string",
         "readonly": true
       },
+      "digest": {
+        "name": "digest",
+        "type": "string",
+        "readonly": true
+      },
       "contenttype": {
         "name": "contenttype",
         "type": "string"
@@ -2118,6 +2337,143 @@ header.bar2: foo2`
 `, true, MASK_LOGS)
 }
 
+func TestXRContext(t *testing.T) {
+	reg := NewRegistry("TestXRContext")
+	defer PassDeleteReg(t, reg)
+
+	tmphome, err := os.MkdirTemp("", "xrtest-home")
+	XNoErr(t, err)
+	defer os.RemoveAll(tmphome)
+
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	XNoErr(t, os.Setenv("HOME", tmphome))
+	os.Unsetenv("XR_SERVER")
+	os.Unsetenv("XR_CONTEXT")
+
+	// An existing config file is made private once it has credentials
+	XNoErr(t, os.WriteFile(tmphome+"/.xrconfig", []byte("# mine\n"), 0644))
+
+	XCLI(t, "context list", "", "No contexts defined\n", "", true)
+	XCLI(t, "context set bad.name --url localhost:8181", "", "",
+		"Invalid context name \"bad.name\", it can't contain '.', ':', '#' "+
+			"or spaces.\n", false)
+	XCLI(t, "context set dev", "", "",
+		"Nothing to set, use one of: --url, --registry, --header, --token, "+
+			"--user, --password, --credential-helper.\n", false)
+
+	XCLI(t, "context set dev --url localhost:8181 --header x-dev=1 "+
+		"--token abc", "", "", "", true)
+	XCLI(t, "context set prod --url localhost:8181 --registry bogus",
+		"", "", "", true)
+	XCLI(t, "context list", "", `CURRENT   NAME   SERVER           REGISTRY   AUTH
+          dev    localhost:8181   -          token
+          prod   localhost:8181   bogus      -
+`, "", true)
+
+	// Not using a context yet, so the default server is used
+	XCLI(t, "get", "", "", "*localhost:8080*", false)
+
+	XCLI(t, "context use bogus", "", "", "Unknown context: bogus.\n", false)
+	XCLI(t, "context use dev", "", "", "", true)
+	XCLI(t, "context list", "", `CURRENT   NAME   SERVER           REGISTRY   AUTH
+*         dev    localhost:8181   -          token
+          prod   localhost:8181   bogus      -
+`, "", true)
+
+	info, err := os.Stat(tmphome + "/.xrconfig")
+	XNoErr(t, err)
+	XCheck(t, info.Mode().Perm() == 0600, "Bad perms: %s", info.Mode())
+
+	buf, err := os.ReadFile(tmphome + "/.xrconfig")
+	XNoErr(t, err)
+	XEqual(t, "", string(buf), `# mine
+context.dev.server.url: localhost:8181
+context.dev.auth.token: abc
+context.dev.header.x-dev: 1
+context.prod.server.url: localhost:8181
+context.prod.registry: bogus
+context: dev
+`)
+
+	// The context's server is used, along with its headers
+	XCLI(t, "get -vv", "", `{
+  "specversion": "`+SPECVERSION+`",
+  "registryid": "TestXRContext",
+  "self": "http://localhost:8181/",
+  "xid": "/",
+  "epoch": 1,
+  "createdat": "YYYY-MM-DDTHH:MM:01Z",
+  "modifiedat": "YYYY-MM-DDTHH:MM:01Z"
+}
+`, `2026/05/19 18:01:50 Request: GET http://localhost:8181/
+2026/05/19 18:01:50 Header: "Authorization"
+2026/05/19 18:01:50 Header: "x-dev"
+2026/05/19 18:01:50 Response: 200 OK
+2026/05/19 18:01:50 access-control-allow-methods: GET, OPTIONS, PATCH, POST, PUT
+2026/05/19 18:01:50 access-control-allow-origin: *
+2026/05/19 18:01:50 content-type: application/json
+2026/05/19 18:01:50 Response Body:
+{
+  "specversion": "`+SPECVERSION+`",
+  "registryid": "TestXRContext",
+  "self": "http://localhost:8181/",
+  "xid": "/",
+  "epoch": 1,
+  "createdat": "2026-05-19T18:01:50.860438234Z",
+  "modifiedat": "2026-05-19T18:01:50.860438234Z"
+}
+2026/05/19 18:01:50 --------------------
+`, true, MASK_LOGS)
+
+	// But not when talking to some other server
+	XCLI(t, "get -vv -s localhost:9999", "", "",
+		"*Request: GET http://localhost:9999/*", false)
+	out, _ := exec.Command("../xr", "get", "-vv", "-s",
+		"localhost:9999").CombinedOutput()
+	XCheck(t, !strings.Contains(string(out), "Authorization"),
+		"Sent credentials to the wrong server: %s", out)
+
+	// Nor from a context w/o a server, since we don't know who it's for
+	XCLI(t, "context set nourl --token abc", "", "", "", true)
+	out, _ = exec.Command("../xr", "get", "-vv", "--context",
+		"nourl").CombinedOutput()
+	XCheck(t, strings.Contains(string(out), "Request: GET") &&
+		!strings.Contains(string(out), "Authorization"),
+		"Sent credentials w/o a server: %s", out)
+	XCLI(t, "context delete nourl", "", "", "", true)
+
+	// --context and XR_CONTEXT pick a different one
+	XCLI(t, "get --context bogus", "", "", "Unknown context: bogus.\n", false)
+	XCLI(t, "get --context prod", "", "", "*/reg-bogus*", false)
+	os.Setenv("XR_CONTEXT", "prod")
+	XCLI(t, "get", "", "", "*/reg-bogus*", false)
+	os.Unsetenv("XR_CONTEXT")
+
+	// Credential helpers
+	XCLI(t, "context set dev --token '' --credential-helper 'echo xyz'",
+		"", "", "", true)
+	XCLI(t, "get -vv", "", "*", "*Header: \"Authorization\"*", true)
+	XCLI(t, "context set dev --credential-helper 'echo oops >&2; exit 1'",
+		"", "", "", true)
+	XCLI(t, "get", "", "",
+		"Error running credential helper (echo oops >&2; exit 1): oops.\n",
+		false)
+
+	XCLI(t, "context delete bogus", "", "", "Unknown context: bogus.\n",
+		false)
+	XCLI(t, "context delete dev", "", "", "", true)
+	XCLI(t, "context list", "", `CURRENT   NAME   SERVER           REGISTRY   AUTH
+          prod   localhost:8181   bogus      -
+`, "", true)
+
+	buf, err = os.ReadFile(tmphome + "/.xrconfig")
+	XNoErr(t, err)
+	XEqual(t, "", string(buf), `# mine
+context.prod.server.url: localhost:8181
+context.prod.registry: bogus
+`)
+}
+
 func TestXRConformBasic(t *testing.T) {
 	reg := NewRegistry("TestXRConformBasic")
 	defer PassDeleteReg(t, reg)
@@ -2148,14 +2504,6 @@ func TestXRConformBasic(t *testing.T) {
 
 	os.Setenv("XR_SERVER", "localhost:8181")
 
-	// Make sure the minimumal looks ok
-	// Also verifies the default Registry is conformant
-	XCLI(t, "conform -d2", "", `PASS: http://localhost:8181
-└─ PASS: TestRegistry
-
-Pass: 99   Fail: 0   Warn: 0   Skip: 0
-`, ``, true, MASK_CONFORM_PASS)
-
 	XCLI(t, "conform --run TestTDAllPass", "", `PASS: http://localhost:8181
 └─ PASS: TestTDAllPass
    ├─ PASS: TestTDInit
@@ -2244,6 +2592,34 @@ Pass: 16   Fail: 17   Warn: 1   Skip: 3
 Pass: 4   Fail: 3   Warn: 0   Skip: 0
 `, ``, false)
 
+	// Machine-readable report, the console output stays the same
+	tmpdir, err := os.MkdirTemp("", "xrtest-report")
+	XNoErr(t, err)
+	defer os.RemoveAll(tmpdir)
+	reportFile := tmpdir + "/report.tap"
+
+	XCLI(t, "conform --run TestTDDepFail --report tap --report-file "+
+		reportFile, "", `FAIL: http://localhost:8181
+└─ FAIL: TestTDDepFail
+   ├─ FAIL: TestTDInitFail
+   │  └─ FAIL: Init
+   └─ Dependency "TestTDInitFail" failed, leaving
+
+Pass: 0   Fail: 4   Warn: 0   Skip: 0
+`, ``, false)
+
+	buf, err := os.ReadFile(reportFile)
+	XNoErr(t, err)
+	XCheck(t, strings.HasPrefix(string(buf), "TAP version 13\n1..1\n"+
+		"not ok 1 - TestTDDepFail/TestTDInitFail: Init\n"),
+		"Bad report:\n%s", string(buf))
+	XCheck(t, strings.Contains(string(buf),
+		"# Dependency \"TestTDInitFail\" failed, leaving\n"),
+		"Bad report:\n%s", string(buf))
+
+	XCLI(t, "conform --run TestTDDepFail --report foo", "", ``,
+		"--report must be one of: json, junit, tap.\n", false)
+
 	XCLI(t, "conform --run TestTDUtils --nowrap", "",
 		`PASS: http://localhost:8181
 └─ PASS: TestTDUtils
@@ -2563,4 +2939,437 @@ Pass: 4   Fail: 3   Warn: 0   Skip: 0
 
 Pass: 315   Fail: 0   Warn: 0   Skip: 0
 `, ``, true)
+
+	// Make sure the default Registry is conformant. W/o --write none of
+	// the tests should touch the Registry.
+	before := XHTTP(t, reg, "GET", "/", "", 200, "*").body
+
+	XCLI(t, "conform -d2", "", `PASS: http://localhost:8181 (skip:1)
+└─ PASS: TestRegistry (skip:1)
+
+Pass: 99   Fail: 0   Warn: 0   Skip: 1
+`, ``, true, MASK_CONFORM_PASS)
+
+	XHTTP(t, reg, "GET", "/", "", 200, before)
+	XHTTP(t, reg, "GET", "/xrcgroups", "", 404, "*")
+
+	// The write tests need a scratch Registry, so w/o one they're skipped
+	XCLI(t, "conform --write -d2", "", `PASS: http://localhost:8181 (skip:1)
+└─ PASS: TestRegistry (skip:1)
+
+Pass: 99   Fail: 0   Warn: 0   Skip: 1
+`, ``, true, MASK_CONFORM_PASS)
+	XHTTP(t, reg, "GET", "/", "", 200, before)
+
+	registry.ScratchRegistries = true
+	defer func() { registry.ScratchRegistries = false }()
+
+	XCLI(t, "conform --write -d2", "", `PASS: http://localhost:8181
+└─ PASS: TestRegistry
+
+Pass: 99   Fail: 0   Warn: 0   Skip: 0
+`, ``, true, MASK_CONFORM_PASS)
+
+	// Our Registry wasn't touched, and the scratch one is gone
+	XHTTP(t, reg, "GET", "/", "", 200, before)
+	XHTTP(t, reg, "GET", "/modelsource", "", 200, model+"\n")
+	names, xErr := registry.GetRegistryNames()
+	XNoErr(t, xErr)
+	for _, name := range names {
+		XCheck(t, !strings.HasPrefix(name, SCRATCH_REGISTRY_PREFIX),
+			"Scratch registry left behind: %s", name)
+	}
+}
+
+func TestXRDiff(t *testing.T) {
+	reg := NewRegistry("TestXRDiff")
+	defer PassDeleteReg(t, reg)
+
+	os.Setenv("XR_SERVER", "localhost:8181")
+
+	tmpdir, err := os.MkdirTemp("", "xrtest-diff")
+	XNoErr(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "resources": {
+        "files": {
+          "singular": "file"
+        }
+      }
+    }
+  }
+}`, 200, "*")
+
+	// Two snapshots
+	XNoErr(t, os.WriteFile(tmpdir+"/a.json", []byte(`{
+  "name": "a",
+  "epoch": 1,
+  "labels": { "x": "1", "y": "2" },
+  "list": [ 1, 2 ]
+}`), 0644))
+	XNoErr(t, os.WriteFile(tmpdir+"/b.json", []byte(`{
+  "epoch": 2,
+  "labels": { "x": "1", "y": "3" },
+  "list": [ 1, 2, 3 ],
+  "description": "new"
+}`), 0644))
+
+	XCLI(t, "diff @"+tmpdir+"/a.json @"+tmpdir+"/b.json", "",
+		`ATTRIBUTE     CHANGE   OLD   NEW
+description   add      -     "new"
+epoch         change   1     2
+labels.y      change   "2"   "3"
+list[2]       add      -     3
+name          remove   "a"   -
+`, "", true)
+
+	XCLI(t, "diff @"+tmpdir+"/a.json @"+tmpdir+"/a.json", "",
+		"No differences\n", "", true)
+
+	// Snapshot vs what's in the Registry
+	XHTTP(t, reg, "PUT", "/dirs/d1", "{}", 201, "*")
+	res := XHTTP(t, reg, "GET", "/dirs/d1", "", 200, "*")
+	XNoErr(t, os.WriteFile(tmpdir+"/d1.json", []byte(res.body), 0644))
+	XHTTP(t, reg, "PATCH", "/dirs/d1", `{"description":"new"}`, 200, "*")
+
+	XCLI(t, "diff @"+tmpdir+"/d1.json /dirs/d1 -o json", "", `{
+  "attributes": [
+    {
+      "path": "description",
+      "op": "add",
+      "new": "new"
+    },
+    {
+      "path": "epoch",
+      "op": "change",
+      "old": 1,
+      "new": 2
+    },
+    {
+      "path": "modifiedat",
+      "op": "change",
+      "old": "YYYY-MM-DDTHH:MM:01Z",
+      "new": "YYYY-MM-DDTHH:MM:02Z"
+    }
+  ]
+}
+`, "", true)
+
+	// Versions, including their documents
+	XHTTP(t, reg, "PUT", "/dirs/d2/files/f1/versions/v1",
+		"line1\nline2\nline3\n", 201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d2/files/f1/versions/v2",
+		"line1\nLINE2\nline3\n", 201, "*")
+
+	XCLI(t, "diff /dirs/d2/files/f1/versions/v1 /dirs/d2/files/f1/versions/v2"+
+		" -o json", "", `{
+  "attributes": [
+    {
+      "path": "createdat",
+      "op": "change",
+      "old": "YYYY-MM-DDTHH:MM:01Z",
+      "new": "YYYY-MM-DDTHH:MM:02Z"
+    },
+    {
+      "path": "isdefault",
+      "op": "change",
+      "old": false,
+      "new": true
+    },
+    {
+      "path": "modifiedat",
+      "op": "change",
+      "old": "YYYY-MM-DDTHH:MM:01Z",
+      "new": "YYYY-MM-DDTHH:MM:02Z"
+    },
+    {
+      "path": "self",
+      "op": "change",
+      "old": "http://localhost:8181/dirs/d2/files/f1/versions/v1$details",
+      "new": "http://localhost:8181/dirs/d2/files/f1/versions/v2$details"
+    },
+    {
+      "path": "versionid",
+      "op": "change",
+      "old": "v1",
+      "new": "v2"
+    },
+    {
+      "path": "xid",
+      "op": "change",
+      "old": "/dirs/d2/files/f1/versions/v1",
+      "new": "/dirs/d2/files/f1/versions/v2"
+    }
+  ],
+  "document": [
+    "@@ line 1",
+    " line1",
+    "-line2",
+    "+LINE2",
+    " line3"
+  ]
+}
+`, "", true)
+
+	XCLI(t, "diff /dirs/d1 --since 1 -o json", "", `[
+  {
+    "xid": "/dirs/d1",
+    "epoch": 2,
+    "modifiedat": "YYYY-MM-DDTHH:MM:01Z"
+  }
+]
+`, "", true)
+
+	XCLI(t, "diff /dirs/d1 --since 2", "",
+		"No entities with an epoch greater than 2\n", "", true)
+
+	XCLI(t, "diff /dirs/d1", "", "", "Two entities must be specified.\n",
+		false)
+	XCLI(t, "diff /dirs/d1 /dirs/d2 --since 1", "", "",
+		"Only one XID is allowed with --since.\n", false)
+}
+
+func TestXRPush(t *testing.T) {
+	reg := NewRegistry("TestXRPush")
+	defer PassDeleteReg(t, reg)
+
+	os.Setenv("XR_SERVER", "localhost:8181")
+
+	tmpdir, err := os.MkdirTemp("", "xrtest-push")
+	XNoErr(t, err)
+	defer os.RemoveAll(tmpdir)
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "resources": {
+        "files": {
+          "singular": "file"
+        }
+      }
+    }
+  }
+}`, 200, "*")
+
+	XHTTP(t, reg, "PUT", "/dirs/d1", `{"name":"old"}`, 201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1.json$details", `{
+  "contenttype": "application/json",
+  "filebase64": "eyJhIjoxfQo="
+}`, 201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d3", `{}`, 201, "*")
+
+	write := func(file string, data string) {
+		file = tmpdir + "/" + file
+		XNoErr(t, os.MkdirAll(filepath.Dir(file), 0755))
+		XNoErr(t, os.WriteFile(file, []byte(data), 0644))
+	}
+
+	write("dirs/d1/index.html", `{"dirid":"d1","name":"new","epoch":5}`)
+	write("dirs/d1/files/f1.json", "{\"a\":1}\n") // doc only, unchanged
+	write("dirs/d1/files/f1.json.hdr", "ignored")
+	write("dirs/d1/files/f2$details", `{"description":"hi"}`)
+	write("dirs/d2/files/s1.yaml/versions/v1/index.html", "schema1\n")
+	write("dirs/d2/files/s1.yaml/versions/v2/index.html", "schema2\n")
+
+	XCLI(t, "push "+tmpdir+" --dry-run", "", `ACTION   XID
+update   /dirs/d1
+create   /dirs/d1/files/f2
+create   /dirs/d2
+create   /dirs/d2/files/s1.yaml/versions/v1
+create   /dirs/d2/files/s1.yaml/versions/v2
+
+Plan: 4 to create, 1 to update, 0 to delete, 1 unchanged
+`, "", true)
+
+	// Nothing should have changed
+	XHTTP(t, reg, "GET", "/dirs/d2", "", 404, "*")
+
+	XCLI(t, "push "+tmpdir+" --prune", "", `ACTION   XID
+update   /dirs/d1
+create   /dirs/d1/files/f2
+create   /dirs/d2
+create   /dirs/d2/files/s1.yaml/versions/v1
+create   /dirs/d2/files/s1.yaml/versions/v2
+delete   /dirs/d3
+
+Plan: 4 to create, 1 to update, 1 to delete, 1 unchanged
+`, "", true)
+
+	XCLI(t, "push "+tmpdir+" --prune", "", "No changes, 6 unchanged\n", "",
+		true)
+
+	XHTTP(t, reg, "GET", "/dirs/d3", "", 404, "*")
+	XHTTP(t, reg, "GET", "/dirs/d2/files/s1.yaml", "", 200, "schema2\n")
+	XHTTP(t, reg, "GET", "/dirs/d2/files/s1.yaml/versions/v1", "", 200,
+		"schema1\n")
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:     "/dirs/d2/files/s1.yaml/versions/v1$details",
+		Method:  "GET",
+		Code:    200,
+		ResBody: `^"contenttype": "application/yaml"`,
+	})
+	XCheckHTTP(t, reg, &HTTPTest{
+		URL:     "/dirs/d1",
+		Method:  "GET",
+		Code:    200,
+		ResBody: `^"name": "new"`,
+	})
+
+	// Change a doc, remove a Version and only push one Group
+	write("dirs/d2/files/s1.yaml/versions/v1/index.html", "schema1.1\n")
+	XNoErr(t, os.RemoveAll(tmpdir+"/dirs/d2/files/s1.yaml/versions/v2"))
+	XNoErr(t, os.Remove(tmpdir+"/dirs/d1/files/f2$details"))
+
+	XCLI(t, "push "+tmpdir+" /dirs/d2 --prune", "", `ACTION   XID
+update   /dirs/d2/files/s1.yaml/versions/v1
+delete   /dirs/d2/files/s1.yaml/versions/v2
+
+Plan: 0 to create, 1 to update, 1 to delete, 1 unchanged
+`, "", true)
+
+	XHTTP(t, reg, "GET", "/dirs/d2/files/s1.yaml", "", 200, "schema1.1\n")
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f2$details", "", 200, "*")
+
+	XCLI(t, "push", "", "", "Missing the DIR argument.\n", false)
+	XCLI(t, "push "+tmpdir+" /dirs/d2/files/s1.yaml/versions/v1", "", "",
+		"Using 'push' on Versions or 'meta' isn't allowed. Try using it "+
+			"on the Resource instead.\n", false)
+}
+
+func TestXRShell(t *testing.T) {
+	reg := NewRegistry("TestXRShell")
+	defer PassDeleteReg(t, reg)
+
+	os.Setenv("XR_SERVER", "localhost:8181")
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "resources": {
+        "files": {
+          "singular": "file"
+        }
+      }
+    }
+  }
+}`, 200, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f1", "hello", 201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d1/files/f2", "world", 201, "*")
+
+	// stdin isn't a tty so each line is just run, no prompts
+	XCLI(t, "shell", `pwd
+ls
+cd dirs/d1
+pwd
+ls
+ls files
+cd files/f1
+ls
+ls versions
+cd ..
+pwd
+delete f2
+ls
+bogus
+# a comment
+cd
+pwd
+exit
+pwd
+`, `/
+dirs/
+/dirs/d1
+files/
+f1/
+f2/
+meta
+versions/
+1
+/dirs/d1/files
+f1/
+/
+`, "Unknown command: bogus.\n", true)
+
+	XHTTP(t, reg, "GET", "/dirs/d1/files/f2", "", 404, "*")
+
+	// Start somewhere other than the root
+	XCLI(t, "shell /dirs/d1/files", "pwd\nls\n", "/dirs/d1/files\nf1/\n", "",
+		true)
+	XCLI(t, "shell /dirs/d1/files/f1/versions/1", "ls ..\n", "1\n", "",
+		true)
+	XCLI(t, "shell /dirs/d1 /dirs/d2", "", "",
+		"Only one XID is allowed to be specified.\n", false)
+}
+
+func TestXROutput(t *testing.T) {
+	reg := NewRegistry("TestXROutput")
+	defer PassDeleteReg(t, reg)
+
+	os.Setenv("XR_SERVER", "localhost:8181")
+
+	XHTTP(t, reg, "PUT", "/modelsource", `{
+  "groups": {
+    "dirs": {
+      "singular": "dir",
+      "resources": {
+        "files": {
+          "singular": "file"
+        }
+      }
+    }
+  }
+}`, 200, "*")
+
+	XHTTP(t, reg, "PUT", "/dirs/d1", `{"name":"one","labels":{"a":"b"}}`,
+		201, "*")
+	XHTTP(t, reg, "PUT", "/dirs/d2", `{"name":"two"}`, 201, "*")
+
+	XCLI(t, "get /dirs -o bogus", "", "",
+		"--output must be one of: json, table, yaml, jsonl, csv, "+
+			"template=TMPL, jsonpath=EXPR.\n", false)
+
+	// Collections are shown one entity at a time, sorted by ID
+	XCLI(t, "get /dirs -o 'template={{.dirid}} {{.name}} {{.labels.a}}'", "",
+		"d1 one b\nd2 two \n", "", true)
+	XCLI(t, "get /dirs/d1 -o template={{.xid}}", "", "/dirs/d1\n", "", true)
+
+	XCLI(t, "get /dirs -o jsonpath=$.name", "", "one\ntwo\n", "", true)
+	XCLI(t, "get /dirs/d1 -o jsonpath={.labels}", "", "{\"a\":\"b\"}\n",
+		"", true)
+	XCLI(t, "get / -o jsonpath=..dirs", "", "",
+		"jsonpath \"..dirs\": recursive descent (..) isn't supported.\n",
+		false)
+
+	XCLI(t, "get /dirs -o jsonl", "",
+		`*{"createdat":*,"dirid":"d1",*}
+{"createdat":*,"dirid":"d2",*}
+*`, "", true)
+
+	XCLI(t, "get /dirs -o csv", "",
+		`*createdat,dirid,epoch,filescount,filesurl,labels,modifiedat,name,self,xid
+*,d1,1,0,http://localhost:8181/dirs/d1/files,"{""a"":""b""}",*,one,http://localhost:8181/dirs/d1,/dirs/d1
+*,d2,1,0,http://localhost:8181/dirs/d2/files,,*,two,http://localhost:8181/dirs/d2,/dirs/d2
+*`, "", true)
+
+	XCLI(t, "get /dirs/d2 -o yaml", "",
+		`*createdat: *
+dirid: d2
+epoch: 1
+filescount: 0
+filesurl: http://localhost:8181/dirs/d2/files
+modifiedat: *
+name: two
+self: http://localhost:8181/dirs/d2
+xid: /dirs/d2
+*`, "", true)
+
+	// Commands that write show their results the same way
+	XCLI(t, "update /dirs/d2 --set name=three -o template={{.name}}", "",
+		"three\n", "", true)
 }